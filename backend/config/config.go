@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -35,6 +37,22 @@ type Config struct {
 	// Encryption (for API keys and secrets)
 	EncryptionKey string
 
+	// Secret cho webhook không có prefix (/signals/webhook/tradingview, /webhook/tradingview)
+	WebhookSecret string
+	// IP allowlist cho webhook không có prefix (IP/CIDR/"tradingview"; rỗng = mọi IP)
+	WebhookIPAllowlist string
+
+	// Proxy được tin cậy để đọc X-Forwarded-For (rỗng = dùng IP kết nối trực tiếp)
+	TrustedProxies []string
+
+	// Signal guards: tuổi tối đa của signal (0 = tắt) và cửa sổ chống trùng theo nội dung
+	SignalMaxAge      time.Duration
+	SignalDedupWindow time.Duration
+
+	// Đối soát sàn ↔ DB: chu kỳ (0 = tắt) và có tự đặt lại SL bị mất hay không
+	ReconcileInterval     time.Duration
+	ReconcileReplaceStops bool
+
 	// Exchange Configurations
 	Exchanges ExchangeConfig
 }
@@ -126,6 +144,17 @@ func Load() *Config {
 		// Encryption key must be 32 bytes for AES-256
 		EncryptionKey: getEnv("ENCRYPTION_KEY", "your-32-byte-encryption-key-1234"),
 
+		// Empty = webhook không prefix bị từ chối
+		WebhookSecret:      getEnv("WEBHOOK_SECRET", ""),
+		WebhookIPAllowlist: getEnv("WEBHOOK_IP_ALLOWLIST", ""),
+		TrustedProxies:     splitEnvList(getEnv("TRUSTED_PROXIES", "127.0.0.1,::1")),
+
+		SignalMaxAge:      getEnvSeconds("SIGNAL_MAX_AGE_SECONDS", 300),
+		SignalDedupWindow: getEnvSeconds("SIGNAL_DEDUP_WINDOW_SECONDS", 60),
+
+		ReconcileInterval:     getEnvSeconds("RECONCILE_INTERVAL_SECONDS", 300),
+		ReconcileReplaceStops: getEnvBool("RECONCILE_REPLACE_STOPS", false),
+
 		// Exchange Configurations
 		Exchanges: ExchangeConfig{
 			Binance: BinanceConfig{
@@ -167,3 +196,32 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// splitEnvList splits a comma-separated env value, bỏ phần tử rỗng
+func splitEnvList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvSeconds reads a duration in seconds from env
+func getEnvSeconds(key string, defaultSeconds int) time.Duration {
+	seconds, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil || seconds < 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getEnvBool reads a boolean (true/false/1/0) from env
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -1,14 +1,18 @@
 package controllers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"tradercoin/backend/models"
 	"tradercoin/backend/services"
+	tradingservice "tradercoin/backend/services"
 	"tradercoin/backend/utils"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // CreateBotConfig - Tạo bot configuration mới
@@ -61,6 +65,11 @@ func CreateBotConfig(services *services.Services) gin.HandlerFunc {
 			IPWhitelist           []string                 `json:"ip_whitelist"`
 			MaxOpenPositions      int                      `json:"max_open_positions"`
 			EnableNotifications   bool                     `json:"enable_notifications"`
+			AutoExecute           bool                     `json:"auto_execute"`
+			AutoExecutePrefix     string                   `json:"auto_execute_prefix"`
+			AutoExecuteStrategy   string                   `json:"auto_execute_strategy"`
+			signalOverrideLimitsInput
+			signalFiltersInput
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -176,6 +185,20 @@ func CreateBotConfig(services *services.Services) gin.HandlerFunc {
 			log.Printf("⚠️  Step 7b: No API Secret provided")
 		}
 
+		if !userOwnsWebhookPrefix(services.DB, user.ID, strings.TrimSpace(input.AutoExecutePrefix)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook prefix not found"})
+			return
+		}
+		if err := input.signalOverrideLimitsInput.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ipWhitelist, err := tradingservice.NormalizeIPAllowlist(input.IPWhitelist)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		// Create trading config
 		log.Printf("💾 Step 8: Creating bot config in database...")
 		config := models.TradingConfig{
@@ -196,6 +219,16 @@ func CreateBotConfig(services *services.Services) gin.HandlerFunc {
 			ActivationPrice:     input.ActivationPrice,
 			CallbackRate:        input.CallbackRate,
 			IsActive:            true, // Active by default
+			AutoExecute:         input.AutoExecute,
+			AutoExecutePrefix:   strings.TrimSpace(input.AutoExecutePrefix),
+			AutoExecuteStrategy: strings.TrimSpace(input.AutoExecuteStrategy),
+			IPWhitelist:         ipWhitelist,
+		}
+		input.signalOverrideLimitsInput.applyTo(&config)
+		input.signalFiltersInput.applyTo(&config)
+		if err := tradingservice.ValidateSignalFilters(&config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
 		if err := services.DB.Create(&config).Error; err != nil {
@@ -337,6 +370,12 @@ func UpdateBotConfig(services *services.Services) gin.HandlerFunc {
 			ActivationPrice     *float64 `json:"activation_price"`
 			CallbackRate        *float64 `json:"callback_rate"`
 			IsActive            *bool    `json:"is_active"`
+			AutoExecute         *bool    `json:"auto_execute"`
+			AutoExecutePrefix   *string  `json:"auto_execute_prefix"`
+			AutoExecuteStrategy *string  `json:"auto_execute_strategy"`
+			IPWhitelist         []string `json:"ip_whitelist"` // null = giữ nguyên, [] = bỏ whitelist
+			signalOverrideLimitsInput
+			signalFiltersInput
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -438,6 +477,38 @@ func UpdateBotConfig(services *services.Services) gin.HandlerFunc {
 		if input.IsActive != nil {
 			config.IsActive = *input.IsActive
 		}
+		if input.AutoExecute != nil {
+			config.AutoExecute = *input.AutoExecute
+		}
+		if input.AutoExecutePrefix != nil {
+			prefix := strings.TrimSpace(*input.AutoExecutePrefix)
+			if !userOwnsWebhookPrefix(services.DB, config.UserID, prefix) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook prefix not found"})
+				return
+			}
+			config.AutoExecutePrefix = prefix
+		}
+		if input.AutoExecuteStrategy != nil {
+			config.AutoExecuteStrategy = strings.TrimSpace(*input.AutoExecuteStrategy)
+		}
+		if input.IPWhitelist != nil {
+			ipWhitelist, err := tradingservice.NormalizeIPAllowlist(input.IPWhitelist)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			config.IPWhitelist = ipWhitelist
+		}
+		if err := input.signalOverrideLimitsInput.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		input.signalOverrideLimitsInput.applyTo(&config)
+		input.signalFiltersInput.applyTo(&config)
+		if err := tradingservice.ValidateSignalFilters(&config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// Save updates
 		if err := services.DB.Save(&config).Error; err != nil {
@@ -555,3 +626,106 @@ func SetDefaultBotConfig(services *services.Services) gin.HandlerFunc {
 		})
 	}
 }
+
+// userOwnsWebhookPrefix kiểm tra prefix auto-execute thuộc về user (rỗng = mọi prefix của user)
+func userOwnsWebhookPrefix(db *gorm.DB, userID uint, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	var count int64
+	db.Model(&models.WebhookPrefix{}).Where("user_id = ? AND prefix = ?", userID, prefix).Count(&count)
+	return count > 0
+}
+
+// signalOverrideLimitsInput là các field cấu hình override từ payload signal (dùng chung cho create/update)
+type signalOverrideLimitsInput struct {
+	AllowSignalOverrides       *bool    `json:"allow_signal_overrides"`
+	SignalMaxQuantity          *float64 `json:"signal_max_quantity"`
+	SignalMaxQuoteSize         *float64 `json:"signal_max_quote_size"`
+	SignalMaxLeverage          *int     `json:"signal_max_leverage"`
+	SignalMaxStopLossPercent   *float64 `json:"signal_max_stop_loss_percent"`
+	SignalMaxTakeProfitPercent *float64 `json:"signal_max_take_profit_percent"`
+	SignalAllowLimitOrders     *bool    `json:"signal_allow_limit_orders"`
+}
+
+func (in signalOverrideLimitsInput) validate() error {
+	if in.SignalMaxQuantity != nil && *in.SignalMaxQuantity < 0 {
+		return errors.New("signal_max_quantity must be greater than or equal to 0")
+	}
+	if in.SignalMaxQuoteSize != nil && *in.SignalMaxQuoteSize < 0 {
+		return errors.New("signal_max_quote_size must be greater than or equal to 0")
+	}
+	if in.SignalMaxLeverage != nil && (*in.SignalMaxLeverage < 0 || *in.SignalMaxLeverage > 125) {
+		return errors.New("signal_max_leverage must be between 0 and 125")
+	}
+	if in.SignalMaxStopLossPercent != nil && (*in.SignalMaxStopLossPercent < 0 || *in.SignalMaxStopLossPercent > 100) {
+		return errors.New("signal_max_stop_loss_percent must be between 0 and 100")
+	}
+	if in.SignalMaxTakeProfitPercent != nil && (*in.SignalMaxTakeProfitPercent < 0 || *in.SignalMaxTakeProfitPercent > 1000) {
+		return errors.New("signal_max_take_profit_percent must be between 0 and 1000")
+	}
+	return nil
+}
+
+func (in signalOverrideLimitsInput) applyTo(config *models.TradingConfig) {
+	if in.AllowSignalOverrides != nil {
+		config.AllowSignalOverrides = *in.AllowSignalOverrides
+	}
+	if in.SignalMaxQuantity != nil {
+		config.SignalMaxQuantity = *in.SignalMaxQuantity
+	}
+	if in.SignalMaxQuoteSize != nil {
+		config.SignalMaxQuoteSize = *in.SignalMaxQuoteSize
+	}
+	if in.SignalMaxLeverage != nil {
+		config.SignalMaxLeverage = *in.SignalMaxLeverage
+	}
+	if in.SignalMaxStopLossPercent != nil {
+		config.SignalMaxStopLossPercent = *in.SignalMaxStopLossPercent
+	}
+	if in.SignalMaxTakeProfitPercent != nil {
+		config.SignalMaxTakeProfitPercent = *in.SignalMaxTakeProfitPercent
+	}
+	if in.SignalAllowLimitOrders != nil {
+		config.SignalAllowLimitOrders = *in.SignalAllowLimitOrders
+	}
+}
+
+// signalFiltersInput là các filter chặn signal của bot (dùng chung cho create/update, nil = giữ nguyên)
+type signalFiltersInput struct {
+	SignalTradingDays             *string  `json:"signal_trading_days"`
+	SignalTradingHours            *string  `json:"signal_trading_hours"`
+	SignalCooldownMinutes         *int     `json:"signal_cooldown_minutes"`
+	SignalStopLossCooldownMinutes *int     `json:"signal_stop_loss_cooldown_minutes"`
+	SignalMaxPerHour              *int     `json:"signal_max_per_hour"`
+	SignalMaxPerDay               *int     `json:"signal_max_per_day"`
+	SignalMinPriceDistancePercent *float64 `json:"signal_min_price_distance_percent"`
+	SignalMaxPriceDistancePercent *float64 `json:"signal_max_price_distance_percent"`
+}
+
+func (in signalFiltersInput) applyTo(config *models.TradingConfig) {
+	if in.SignalTradingDays != nil {
+		config.SignalTradingDays = strings.ToLower(strings.ReplaceAll(*in.SignalTradingDays, " ", ""))
+	}
+	if in.SignalTradingHours != nil {
+		config.SignalTradingHours = strings.ReplaceAll(*in.SignalTradingHours, " ", "")
+	}
+	if in.SignalCooldownMinutes != nil {
+		config.SignalCooldownMinutes = *in.SignalCooldownMinutes
+	}
+	if in.SignalStopLossCooldownMinutes != nil {
+		config.SignalStopLossCooldownMinutes = *in.SignalStopLossCooldownMinutes
+	}
+	if in.SignalMaxPerHour != nil {
+		config.SignalMaxPerHour = *in.SignalMaxPerHour
+	}
+	if in.SignalMaxPerDay != nil {
+		config.SignalMaxPerDay = *in.SignalMaxPerDay
+	}
+	if in.SignalMinPriceDistancePercent != nil {
+		config.SignalMinPriceDistancePercent = *in.SignalMinPriceDistancePercent
+	}
+	if in.SignalMaxPriceDistancePercent != nil {
+		config.SignalMaxPriceDistancePercent = *in.SignalMaxPriceDistancePercent
+	}
+}
@@ -210,3 +210,126 @@ func MarkAlertRead(services *services.Services) gin.HandlerFunc {
 		})
 	}
 }
+
+// GetExecutionLocks - Admin: danh sách execution lock đang giữ (theo exchange key + symbol)
+func GetExecutionLocks(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locks := services.ExecutionLocks().HeldLocks()
+
+		c.JSON(http.StatusOK, gin.H{
+			"locks":       locks,
+			"total":       len(locks),
+			"distributed": svc.Redis != nil,
+			"timestamp":   time.Now(),
+		})
+	}
+}
+
+// GetOrderMonitorMetrics - Admin: metrics của order monitor (lần chạy gần nhất + tổng cộng)
+func GetOrderMonitorMetrics(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.OrderMonitor == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Order monitor is not running"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"metrics":   svc.OrderMonitor.Metrics(),
+			"timestamp": time.Now(),
+		})
+	}
+}
+
+// GetUserStreamHealth - Trạng thái user data stream (server-side) của các account của user
+func GetUserStreamHealth(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		if svc.StreamManager == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stream manager is not running"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"streams":   svc.StreamManager.Health(userID.(uint)),
+			"timestamp": time.Now(),
+		})
+	}
+}
+
+// GetUserStreamsAdmin - Trạng thái tất cả user data stream (admin)
+func GetUserStreamsAdmin(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.StreamManager == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stream manager is not running"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"streams":   svc.StreamManager.Health(0),
+			"timestamp": time.Now(),
+		})
+	}
+}
+
+// GetMarketDataStatus - Trạng thái market data stream và price cache (admin)
+func GetMarketDataStatus(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"market_data": services.MarketData().Status(),
+			"timestamp":   time.Now(),
+		})
+	}
+}
+
+// GetReconciliationReports - Kết quả đối soát sàn ↔ DB gần nhất của các account của user
+func GetReconciliationReports(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		if svc.Reconciliation == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Reconciliation is not running"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"reports":   svc.Reconciliation.Reports(userID.(uint)),
+			"timestamp": time.Now(),
+		})
+	}
+}
+
+// GetReconciliationReportsAdmin - Kết quả đối soát gần nhất của tất cả account (admin)
+func GetReconciliationReportsAdmin(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.Reconciliation == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Reconciliation is not running"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"reports":   svc.Reconciliation.Reports(0),
+			"interval":  svc.Reconciliation.Interval.String(),
+			"timestamp": time.Now(),
+		})
+	}
+}
+
+// RunReconciliation - Chạy đối soát ngay (admin), chạy nền; xem kết quả qua GET /reconciliation
+func RunReconciliation(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.Reconciliation == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Reconciliation is not running"})
+			return
+		}
+
+		go svc.Reconciliation.RunAll()
+		c.JSON(http.StatusAccepted, gin.H{"message": "Reconciliation started"})
+	}
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,6 +12,7 @@ import (
 	"tradercoin/backend/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -21,6 +24,23 @@ type OrderResponse struct {
 	TakeProfitPercent float64 `json:"take_profit_percent,omitempty"`
 }
 
+// ProtectionLegRequest - 1 leg SL/TP: price (giá tuyệt đối), percent (% từ giá vào) hoặc remove
+type ProtectionLegRequest struct {
+	Price   decimal.Decimal `json:"price"`
+	Percent decimal.Decimal `json:"percent"`
+	Remove  bool            `json:"remove"`
+}
+
+// AmendProtectionRequest represents the request body to amend stop loss / take profit of an open order
+type AmendProtectionRequest struct {
+	StopLoss     *ProtectionLegRequest `json:"stop_loss"`
+	TakeProfit   *ProtectionLegRequest `json:"take_profit"`
+	TrailingStop *struct {
+		CallbackRate      decimal.Decimal `json:"callback_rate"`      // 0.1 - 10 (%)
+		ActivationPercent decimal.Decimal `json:"activation_percent"` // % từ giá vào, 0 = kích hoạt ngay
+	} `json:"trailing_stop"` // Thay SL cố định bằng trailing stop
+}
+
 // GetOrderHistory - Lấy danh sách order history với filtering
 // Status updates are handled by background worker, not here
 func GetOrderHistory(services *services.Services) gin.HandlerFunc {
@@ -372,6 +392,16 @@ func CloseOrdersBySymbol(svc *services.Services) gin.HandlerFunc {
 			}())
 		log.Printf("🔐 Decrypted API Secret length: %d", len(apiSecret))
 
+		// Serialize với các lệnh đang đặt trên cùng API key + symbol
+		lockKey := services.ExecutionLockKey(config.Exchange, apiKey, order.Symbol)
+		release, err := services.ExecutionLocks().Acquire(lockKey, fmt.Sprintf("close:order:%d", order.ID), services.DefaultLockWaitTimeout)
+		if err != nil {
+			log.Printf("⏳ CloseOrdersBySymbol: %v", err)
+			c.JSON(http.StatusConflict, gin.H{"error": "Another order operation is in progress for this symbol, please retry"})
+			return
+		}
+		defer release()
+
 		// Create trading service with decrypted credentials
 		tradingService := services.NewTradingService(apiKey, apiSecret, config.Exchange, svc.DB, userID.(uint))
 
@@ -397,6 +427,116 @@ func CloseOrdersBySymbol(svc *services.Services) gin.HandlerFunc {
 	}
 }
 
+// GetOrderTimeline - Lịch sử thay đổi của 1 order (OrderEvent, cũ → mới)
+func GetOrderTimeline(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		orderID := c.Param("id")
+
+		var order models.Order
+		err := services.DB.Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching order %s: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+			return
+		}
+
+		var events []models.OrderEvent
+		if err := services.DB.Where("order_id = ?", order.ID).Order("created_at asc, id asc").Find(&events).Error; err != nil {
+			log.Printf("Error fetching events of order %d: %v", order.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order timeline"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"order_id": order.ID,
+			"status":   order.Status,
+			"events":   events,
+		})
+	}
+}
+
+// AmendOrderProtection - Sửa SL/TP của lệnh futures đang mở (giá / %), chuyển SL sang trailing stop hoặc huỷ 1 leg;
+// algo order trên Binance được đặt lại, thay đổi được đẩy qua WebSocket (order_update)
+func AmendOrderProtection(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var request AmendProtectionRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		orderID := c.Param("id")
+
+		var order models.Order
+		err := svc.DB.Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching order %s: %v", orderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+			return
+		}
+
+		var params services.AmendProtectionParams
+		if request.StopLoss != nil {
+			params.StopLoss = &services.ProtectionLeg{Price: request.StopLoss.Price, Percent: request.StopLoss.Percent, Remove: request.StopLoss.Remove}
+		}
+		if request.TakeProfit != nil {
+			params.TakeProfit = &services.ProtectionLeg{Price: request.TakeProfit.Price, Percent: request.TakeProfit.Percent, Remove: request.TakeProfit.Remove}
+		}
+		if request.TrailingStop != nil {
+			params.TrailingStop = &services.TrailingStopParams{
+				CallbackRate:      request.TrailingStop.CallbackRate,
+				ActivationPercent: request.TrailingStop.ActivationPercent,
+			}
+		}
+
+		result, err := services.AmendOrderProtection(svc.DB, &order, params, services.OrderSourceManual, services.OrderActorUser(userID.(uint)))
+		switch {
+		case errors.Is(err, services.ErrLockTimeout):
+			c.JSON(http.StatusConflict, gin.H{"error": "Another order operation is in progress for this symbol, please retry"})
+			return
+		case err != nil && result != nil:
+			// Leg SL đã đổi (đã lưu DB), leg TP lỗi → báo rõ phần đã áp dụng
+			log.Printf("⚠️  Amending SL/TP of order %d partially failed: %v", order.ID, err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"success": false,
+				"error":   "Partially updated " + order.Symbol + ": " + strings.Join(result.Changes, ", ") + "; " + err.Error(),
+				"result":  result,
+			})
+			return
+		case err != nil:
+			log.Printf("❌ Amending SL/TP of order %d failed: %v", order.ID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Updated " + order.Symbol + ": " + strings.Join(result.Changes, ", "),
+			"result":  result,
+		})
+	}
+}
+
 // GetAllOrdersAdmin - Admin endpoint to get all orders from all users
 func GetAllOrdersAdmin(services *services.Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
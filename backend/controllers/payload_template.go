@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"tradercoin/backend/models"
+	"tradercoin/backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// payloadTemplateResponse trả về template với các cột JSON đã parse
+func payloadTemplateResponse(t *models.WebhookPayloadTemplate) gin.H {
+	spec, _ := services.PayloadTemplateSpecFromModel(t)
+	return gin.H{
+		"id":             t.ID,
+		"prefix":         t.Prefix,
+		"name":           t.Name,
+		"format":         t.Format,
+		"field_mappings": spec.FieldMappings,
+		"value_mappings": spec.ValueMappings,
+		"text_patterns":  spec.TextPatterns,
+		"defaults":       spec.Defaults,
+		"active":         t.Active,
+		"created_at":     t.CreatedAt,
+		"updated_at":     t.UpdatedAt,
+	}
+}
+
+// ListPayloadTemplates returns the user's webhook payload templates
+func ListPayloadTemplates(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var templates []models.WebhookPayloadTemplate
+		if err := svc.DB.Where("user_id = ?", userID).Order("id ASC").Find(&templates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payload templates"})
+			return
+		}
+
+		result := make([]gin.H, 0, len(templates))
+		for i := range templates {
+			result = append(result, payloadTemplateResponse(&templates[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"templates": result,
+			"fields":    services.PayloadTemplateFields,
+		})
+	}
+}
+
+// UpsertPayloadTemplate creates or replaces the payload template of a prefix
+func UpsertPayloadTemplate(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDVal, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		userID := userIDVal.(uint)
+
+		var input struct {
+			Prefix string `json:"prefix" binding:"required"`
+			Name   string `json:"name"`
+			Active *bool  `json:"active"`
+			services.PayloadTemplateSpec
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		prefix := strings.ToLower(strings.TrimSpace(input.Prefix))
+		if !userOwnsWebhookPrefix(svc.DB, userID, prefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook prefix not found"})
+			return
+		}
+		if err := input.PayloadTemplateSpec.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var t models.WebhookPayloadTemplate
+		if err := svc.DB.Where("prefix = ?", prefix).First(&t).Error; err != nil {
+			t = models.WebhookPayloadTemplate{UserID: userID, Prefix: prefix, Active: true}
+		}
+		t.Name = strings.TrimSpace(input.Name)
+		if input.Active != nil {
+			t.Active = *input.Active
+		}
+		input.PayloadTemplateSpec.ApplyTo(&t)
+
+		if err := svc.DB.Save(&t).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payload template"})
+			return
+		}
+
+		c.JSON(http.StatusOK, payloadTemplateResponse(&t))
+	}
+}
+
+// DeletePayloadTemplate removes a payload template (prefix quay về format TradingView mặc định)
+func DeletePayloadTemplate(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		result := services.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.WebhookPayloadTemplate{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payload template"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payload template not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Payload template deleted"})
+	}
+}
+
+// TestPayloadTemplate parses a sample body with a template and returns the resulting signal (không lưu gì)
+func TestPayloadTemplate(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDVal, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		userID := userIDVal.(uint)
+
+		var input struct {
+			Prefix   string                        `json:"prefix"`                  // Dùng template đã lưu của prefix
+			Template *services.PayloadTemplateSpec `json:"template"`                // Hoặc template chưa lưu
+			Body     json.RawMessage               `json:"body" binding:"required"` // JSON object hoặc string (body dạng text)
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		prefix := strings.ToLower(strings.TrimSpace(input.Prefix))
+		var spec services.PayloadTemplateSpec
+		switch {
+		case input.Template != nil:
+			spec = *input.Template
+		case prefix != "":
+			var t models.WebhookPayloadTemplate
+			if err := svc.DB.Where("prefix = ? AND user_id = ?", prefix, userID).First(&t).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Payload template not found"})
+				return
+			}
+			parsed, err := services.PayloadTemplateSpecFromModel(&t)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			spec = parsed
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "prefix or template is required"})
+			return
+		}
+
+		if err := spec.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Body là JSON string → coi như body dạng text
+		raw := []byte(input.Body)
+		var text string
+		if err := json.Unmarshal(input.Body, &text); err == nil {
+			raw = []byte(text)
+		}
+
+		mapped, err := spec.Apply(raw)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "error": err.Error()})
+			return
+		}
+
+		body, _ := json.Marshal(mapped)
+		var payload tradingViewPayload
+		if err := binding.JSON.BindBody(body, &payload); err != nil {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "mapped": mapped, "error": err.Error()})
+			return
+		}
+
+		if _, err := services.ParseSignalAction(payload.Action, payload.Percent); err != nil {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "mapped": mapped, "error": err.Error()})
+			return
+		}
+		payload.PayloadOverrides.Normalize()
+		if err := payload.PayloadOverrides.Validate(); err != nil {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "mapped": mapped, "error": err.Error()})
+			return
+		}
+
+		signal := payload.toSignal(prefix)
+		signal.RawPayload = sanitizeRawPayload(raw)
+		if _, ok := mapped["passphrase"]; ok {
+			mapped["passphrase"] = "***"
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"valid":  true,
+			"mapped": mapped,
+			"signal": signal,
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// PartialCloseRequest - Đóng một phần vị thế: percent (0-100) hoặc quantity
+type PartialCloseRequest struct {
+	Percent   decimal.Decimal `json:"percent"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	OrderType string          `json:"order_type" binding:"omitempty,oneof=market limit"` // Mặc định market
+	Price     decimal.Decimal `json:"price"`                                             // Bắt buộc với limit
+	RequestID string          `json:"request_id"`                                        // Optional idempotency key from client, reuse it when retrying
+}
+
+// GetPositions - Lấy danh sách vị thế (futures) với filtering
+// Vị thế được cập nhật bởi OrderMonitor / user data stream, không cập nhật ở đây
+func GetPositions(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		botConfigIDStr := c.Query("bot_config_id")
+		symbol := c.Query("symbol")
+		status := c.Query("status") // open, scaling, closing, closed hoặc "active" (chưa đóng)
+		limitStr := c.DefaultQuery("limit", "20")
+		offsetStr := c.DefaultQuery("offset", "0")
+
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 1000 {
+			limit = 20
+		}
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+
+		query := services.DB.Where("user_id = ?", userID)
+
+		if botConfigIDStr != "" {
+			botConfigID, err := strconv.Atoi(botConfigIDStr)
+			if err == nil {
+				query = query.Where("bot_config_id = ?", botConfigID)
+			}
+		}
+
+		if symbol != "" {
+			query = query.Where("symbol = ?", strings.ToUpper(symbol))
+		}
+
+		switch strings.ToLower(status) {
+		case "":
+		case "active":
+			query = query.Where("status <> ?", "closed")
+		default:
+			query = query.Where("status = ?", strings.ToLower(status))
+		}
+
+		var positions []models.Position
+		if err := query.Order("opened_at desc").Offset(offset).Limit(limit).Find(&positions).Error; err != nil {
+			log.Printf("Error fetching positions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch positions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, positions)
+	}
+}
+
+// GetPosition - Lấy 1 vị thế kèm các lệnh và fill liên kết
+func GetPosition(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		positionID := c.Param("id")
+
+		var position models.Position
+		err := services.DB.
+			Preload("Orders", func(db *gorm.DB) *gorm.DB { return db.Order("created_at asc") }).
+			Preload("Fills", func(db *gorm.DB) *gorm.DB { return db.Order("filled_at asc") }).
+			Where("id = ? AND user_id = ?", positionID, userID).
+			First(&position).Error
+
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Position not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching position %s: %v", positionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch position"})
+			return
+		}
+
+		c.JSON(http.StatusOK, position)
+	}
+}
+
+// PartialClosePosition - Đóng một phần vị thế bằng lệnh reduce-only (market / limit),
+// SL/TP còn lại được đổi khối lượng theo phần còn lại của vị thế
+func PartialClosePosition(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var request PartialCloseRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		positionID := c.Param("id")
+
+		var position models.Position
+		err := svc.DB.Where("id = ? AND user_id = ?", positionID, userID).First(&position).Error
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Position not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching position %s: %v", positionID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch position"})
+			return
+		}
+
+		// Không có request_id: gom các request giống hệt nhau trong cùng cửa sổ 10s (double-click, retry) về cùng một lệnh
+		requestRef := request.RequestID
+		if requestRef == "" {
+			requestRef = fmt.Sprintf("%s|%s|%s|%s|%d", request.Percent, request.Quantity, request.OrderType, request.Price, time.Now().Unix()/10)
+		}
+
+		result, err := services.PartialClosePosition(svc.DB, &position, services.PartialCloseParams{
+			Percent:   request.Percent,
+			Quantity:  request.Quantity,
+			OrderType: request.OrderType,
+			Price:     request.Price,
+		}, services.OrderSourceManual, services.OrderActorUser(userID.(uint)), requestRef)
+		switch {
+		case errors.Is(err, services.ErrLockTimeout):
+			c.JSON(http.StatusConflict, gin.H{"error": "Another order operation is in progress for this symbol, please retry"})
+			return
+		case err != nil:
+			log.Printf("❌ Partial close of position %d failed: %v", position.ID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": fmt.Sprintf("Placed %s reduce-only order for %s %s", strings.ToLower(result.Order.Type), result.Order.Quantity, position.Symbol),
+			"result":  result,
+		})
+	}
+}
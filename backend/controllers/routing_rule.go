@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"tradercoin/backend/models"
+	"tradercoin/backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// routingRuleInput is the request body for creating/updating a routing rule
+type routingRuleInput struct {
+	Name              string            `json:"name"`
+	Priority          int               `json:"priority"`
+	Active            *bool             `json:"active"`
+	StopOnMatch       bool              `json:"stop_on_match"`
+	MatchPrefix       string            `json:"match_prefix"`
+	MatchStrategy     string            `json:"match_strategy"`
+	MatchSymbol       string            `json:"match_symbol"`
+	MatchAction       string            `json:"match_action"`
+	MessageRegex      string            `json:"message_regex"`
+	CustomFields      map[string]string `json:"custom_fields"`
+	BotConfigIDs      []uint            `json:"bot_config_ids" binding:"required"`
+	SizeMultiplier    float64           `json:"size_multiplier"`
+	Leverage          int               `json:"leverage"`
+	StopLossPercent   *float64          `json:"stop_loss_percent"`
+	TakeProfitPercent *float64          `json:"take_profit_percent"`
+}
+
+// applyTo copies input fields onto a rule (BotConfigIDs/CustomFields được lưu dạng JSON)
+func (input *routingRuleInput) applyTo(rule *models.SignalRoutingRule) {
+	rule.Name = strings.TrimSpace(input.Name)
+	rule.Priority = input.Priority
+	if input.Active != nil {
+		rule.Active = *input.Active
+	}
+	rule.StopOnMatch = input.StopOnMatch
+	rule.MatchPrefix = strings.ToLower(strings.TrimSpace(input.MatchPrefix))
+	rule.MatchStrategy = strings.TrimSpace(input.MatchStrategy)
+	rule.MatchSymbol = strings.TrimSpace(input.MatchSymbol)
+	rule.MatchAction = strings.TrimSpace(input.MatchAction)
+	rule.MessageRegex = input.MessageRegex
+	rule.SizeMultiplier = input.SizeMultiplier
+	rule.Leverage = input.Leverage
+	rule.StopLossPercent = input.StopLossPercent
+	rule.TakeProfitPercent = input.TakeProfitPercent
+
+	ids, _ := json.Marshal(input.BotConfigIDs)
+	rule.BotConfigIDs = string(ids)
+	rule.CustomFields = ""
+	if len(input.CustomFields) > 0 {
+		fields, _ := json.Marshal(input.CustomFields)
+		rule.CustomFields = string(fields)
+	}
+}
+
+// validateRoutingRuleInput kiểm tra rule hợp lệ và bot/prefix thuộc về user
+func validateRoutingRuleInput(db *gorm.DB, userID uint, rule *models.SignalRoutingRule) (int, string) {
+	if err := services.ValidateRoutingRule(rule); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	ids, _ := services.RuleBotConfigIDs(rule)
+	var count int64
+	db.Model(&models.TradingConfig{}).Where("id IN ? AND user_id = ?", ids, userID).Count(&count)
+	if int(count) != len(uniqueIDs(ids)) {
+		return http.StatusBadRequest, "One or more bot configs not found"
+	}
+
+	if !userOwnsWebhookPrefix(db, userID, rule.MatchPrefix) {
+		return http.StatusBadRequest, "Webhook prefix not found"
+	}
+	return 0, ""
+}
+
+// routingRuleResponse trả về rule với bot_config_ids / custom_fields đã parse
+func routingRuleResponse(rule *models.SignalRoutingRule) gin.H {
+	ids, _ := services.RuleBotConfigIDs(rule)
+	fields, _ := services.RuleCustomFields(rule)
+	if ids == nil {
+		ids = []uint{}
+	}
+	return gin.H{
+		"id":                  rule.ID,
+		"name":                rule.Name,
+		"priority":            rule.Priority,
+		"active":              rule.Active,
+		"stop_on_match":       rule.StopOnMatch,
+		"match_prefix":        rule.MatchPrefix,
+		"match_strategy":      rule.MatchStrategy,
+		"match_symbol":        rule.MatchSymbol,
+		"match_action":        rule.MatchAction,
+		"message_regex":       rule.MessageRegex,
+		"custom_fields":       fields,
+		"bot_config_ids":      ids,
+		"size_multiplier":     rule.SizeMultiplier,
+		"leverage":            rule.Leverage,
+		"stop_loss_percent":   rule.StopLossPercent,
+		"take_profit_percent": rule.TakeProfitPercent,
+		"created_at":          rule.CreatedAt,
+		"updated_at":          rule.UpdatedAt,
+	}
+}
+
+// ListRoutingRules returns the user's routing rules ordered by priority
+func ListRoutingRules(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var rules []models.SignalRoutingRule
+		if err := services.DB.Where("user_id = ?", userID).
+			Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch routing rules"})
+			return
+		}
+
+		result := make([]gin.H, 0, len(rules))
+		for i := range rules {
+			result = append(result, routingRuleResponse(&rules[i]))
+		}
+		c.JSON(http.StatusOK, gin.H{"rules": result})
+	}
+}
+
+// CreateRoutingRule creates a new routing rule for the user
+func CreateRoutingRule(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var input routingRuleInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rule := models.SignalRoutingRule{UserID: userID.(uint), Active: true}
+		input.applyTo(&rule)
+
+		if status, msg := validateRoutingRuleInput(services.DB, rule.UserID, &rule); status != 0 {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if err := services.DB.Create(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create routing rule"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, routingRuleResponse(&rule))
+	}
+}
+
+// UpdateRoutingRule replaces a routing rule
+func UpdateRoutingRule(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var rule models.SignalRoutingRule
+		if err := services.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&rule).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Routing rule not found"})
+			return
+		}
+
+		var input routingRuleInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		input.applyTo(&rule)
+
+		if status, msg := validateRoutingRuleInput(services.DB, rule.UserID, &rule); status != 0 {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if err := services.DB.Save(&rule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update routing rule"})
+			return
+		}
+
+		c.JSON(http.StatusOK, routingRuleResponse(&rule))
+	}
+}
+
+// DeleteRoutingRule deletes a routing rule
+func DeleteRoutingRule(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		result := services.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.SignalRoutingRule{})
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete routing rule"})
+			return
+		}
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Routing rule not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Routing rule deleted"})
+	}
+}
+
+// DryRunRoutingRules evaluates the user's rules against a sample payload without placing orders
+func DryRunRoutingRules(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDVal, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		userID := userIDVal.(uint)
+
+		var input struct {
+			Prefix  string                 `json:"prefix"`
+			Payload map[string]interface{} `json:"payload" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Parse sample payload giống hệt webhook thật
+		raw, _ := json.Marshal(input.Payload)
+		var payload tradingViewPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload: " + err.Error()})
+			return
+		}
+		if payload.Symbol == "" || payload.Action == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Payload must contain symbol and action"})
+			return
+		}
+
+		// Không truyền prefix → dùng prefix active mới nhất của user
+		prefix := strings.ToLower(strings.TrimSpace(input.Prefix))
+		if prefix == "" {
+			var wp models.WebhookPrefix
+			if err := svc.DB.Where("user_id = ? AND active = ?", userID, true).
+				Order("created_at DESC").First(&wp).Error; err == nil {
+				prefix = wp.Prefix
+			}
+		} else if !userOwnsWebhookPrefix(svc.DB, userID, prefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook prefix not found"})
+			return
+		}
+
+		signal := payload.toSignal(prefix)
+		signal.RawPayload = sanitizeRawPayload(raw)
+
+		targets, evaluations, err := services.ResolveSignalTargets(svc.DB, userID, &signal)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate routing rules"})
+			return
+		}
+
+		bots := make([]gin.H, 0, len(targets))
+		for _, target := range targets {
+			effective := target.Overrides.Apply(target.Config)
+			bots = append(bots, gin.H{
+				"bot_config_id": target.Config.ID,
+				"bot_name":      target.Config.Name,
+				"exchange":      target.Config.Exchange,
+				"trading_mode":  target.Config.TradingMode,
+				"source":        target.Source,
+				"rule_id":       target.RuleID,
+				"rule_name":     target.RuleName,
+				"overrides":     target.Overrides,
+				"effective": gin.H{
+					"amount":              effective.Amount,
+					"leverage":            effective.Leverage,
+					"stop_loss_percent":   effective.StopLossPercent,
+					"take_profit_percent": effective.TakeProfitPercent,
+				},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"signal":      signal,
+			"rules":       evaluations,
+			"bots":        bots,
+			"total_bots":  len(bots),
+			"would_place": len(bots) > 0,
+		})
+	}
+}
+
+// uniqueIDs removes duplicate IDs
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	result := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -1,10 +1,14 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 	"tradercoin/backend/models"
 	"tradercoin/backend/services"
@@ -12,44 +16,156 @@ import (
 	"tradercoin/backend/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
 )
 
+// tradingViewPayload is the JSON body sent by TradingView alerts
+type tradingViewPayload struct {
+	Symbol     string          `json:"symbol" binding:"required"`
+	Action     string          `json:"action" binding:"required"` // buy, sell, close_long, close_short, close, reverse, partial_close
+	Price      decimal.Decimal `json:"price"`                     // Nhận cả number lẫn string ("{{close}}")
+	StopLoss   decimal.Decimal `json:"stopLoss"`
+	TakeProfit decimal.Decimal `json:"takeProfit"`
+	Percent    decimal.Decimal `json:"percent"` // % vị thế cần đóng cho partial_close
+	Message    string          `json:"message"`
+	Timestamp  json.RawMessage `json:"timestamp"` // Unix giây/mili giây hoặc RFC3339 ({{timenow}})
+	Strategy   string          `json:"strategy"`
+	Passphrase string          `json:"passphrase"` // Secret của prefix (TradingView không gửi được header HMAC)
+	Secret     string          `json:"secret"`     // Alias của passphrase
+	SignalID   interface{}     `json:"signal_id"`  // ID do vendor gửi, dùng làm idempotency key
+
+	// Override size/leverage/order type/SL-TP %/trailing cho từng alert (bot phải bật allow_signal_overrides)
+	services.PayloadOverrides
+}
+
+// toSignal builds a TradingSignal from the webhook payload
+func (p tradingViewPayload) toSignal(prefix string) models.TradingSignal {
+	signal := models.TradingSignal{
+		Symbol:        p.Symbol,
+		Action:        p.Action,
+		Price:         p.Price,
+		StopLoss:      p.StopLoss,
+		TakeProfit:    p.TakeProfit,
+		ClosePercent:  p.Percent,
+		Message:       p.Message,
+		Strategy:      p.Strategy,
+		ReceivedAt:    time.Now(),
+		WebhookPrefix: prefix,
+	}
+
+	if p.SignalID != nil {
+		signal.ExternalID = strings.TrimSpace(fmt.Sprint(p.SignalID))
+		if len(signal.ExternalID) > 128 {
+			signal.ExternalID = signal.ExternalID[:128]
+		}
+	}
+	if signalTime, err := services.ParseSignalTimestamp(p.Timestamp); err != nil {
+		log.Printf("⚠️  Ignoring signal timestamp: %v", err)
+	} else {
+		signal.SignalTime = signalTime
+	}
+	p.PayloadOverrides.Normalize()
+	signal.Overrides = services.MarshalPayloadOverrides(p.PayloadOverrides)
+	signal.DedupKey = services.SignalDedupKey(&signal)
+	return signal
+}
+
 // TradingViewWebhook handles incoming signals from TradingView
 func TradingViewWebhook(svcs *services.Services, wsHub *services.WebSocketHub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Optional URL path prefix to identify user webhook
-		prefix := c.Param("prefix")
-		var payload struct {
-			Symbol     string  `json:"symbol" binding:"required"`
-			Action     string  `json:"action" binding:"required"` // buy, sell, close
-			Price      float64 `json:"price"`
-			StopLoss   float64 `json:"stopLoss"`
-			TakeProfit float64 `json:"takeProfit"`
-			Message    string  `json:"message"`
-			Timestamp  int64   `json:"timestamp"`
-			Strategy   string  `json:"strategy"`
+		prefix := strings.ToLower(c.Param("prefix"))
+		raw, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+			return
 		}
+		// Cache raw body (HMAC được tính trên body gốc)
+		c.Set(gin.BodyBytesKey, raw)
 
-		if err := c.ShouldBindJSON(&payload); err != nil {
+		// Xác thực secret của prefix trước khi map / parse payload
+		passphrase, err := services.WebhookPassphrase(svcs.DB, prefix, raw)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("❌ Failed to read webhook passphrase (prefix=%s): %v", prefix, err))
+		}
+		if !authenticateWebhook(c, svcs, prefix, passphrase) {
+			return
+		}
+
+		// Prefix có payload template → map body của vendor về format TradingView
+		body, err := services.MapWebhookPayload(svcs.DB, prefix, raw)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("❌ Failed to map webhook payload (prefix=%s): %v", prefix, err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+			return
+		}
+
+		var payload tradingViewPayload
+		if err := binding.JSON.BindBody(body, &payload); err != nil {
 			utils.LogError(fmt.Sprintf("❌ Invalid webhook payload: %v", err))
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
 			return
 		}
 
-		utils.LogInfo(fmt.Sprintf("📡 TradingView Signal Received: %s %s @ %.2f",
+		// Action không hợp lệ (hoặc partial close thiếu percent) → từ chối ngay, không lưu signal
+		if _, err := services.ParseSignalAction(payload.Action, payload.Percent); err != nil {
+			utils.LogError(fmt.Sprintf("❌ Invalid webhook action: %v", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		payload.PayloadOverrides.Normalize()
+		if err := payload.PayloadOverrides.Validate(); err != nil {
+			utils.LogError(fmt.Sprintf("❌ Invalid webhook overrides: %v", err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		utils.LogInfo(fmt.Sprintf("📡 TradingView Signal Received: %s %s @ %s",
 			payload.Action, payload.Symbol, payload.Price))
 
 		// Create signal record (NO STATUS - shared by all users)
-		signal := models.TradingSignal{
-			Symbol:        payload.Symbol,
-			Action:        payload.Action,
-			Price:         payload.Price,
-			StopLoss:      payload.StopLoss,
-			TakeProfit:    payload.TakeProfit,
-			Message:       payload.Message,
-			Strategy:      payload.Strategy,
-			ReceivedAt:    time.Now(),
-			WebhookPrefix: prefix,
+		signal := payload.toSignal(prefix)
+		signal.SourceIP = c.ClientIP()
+		// Giữ JSON gốc để routing rules match custom fields
+		signal.RawPayload = sanitizeRawPayload(raw)
+		if signal.RawPayload == "" {
+			// Body dạng text → lưu kết quả đã map
+			signal.RawPayload = sanitizeRawPayload(body)
+		}
+
+		// Khoá theo dedup key để 2 lần retry đồng thời không cùng lọt qua kiểm tra trùng.
+		// Không lấy được lock thì không xử lý tiếp (sender retry sau); job queue dedupe theo DedupKey là lớp chặn cuối.
+		release, err := services.ExecutionLocks().Acquire("signal-dedup:"+signal.DedupKey, "webhook", 5*time.Second)
+		if err != nil {
+			utils.LogWarn(fmt.Sprintf("⏳ Signal dedup lock not acquired (prefix=%s): %v", prefix, err))
+			if errors.Is(err, services.ErrLockTimeout) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Same signal is being processed, please retry"})
+			} else {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to lock signal for deduplication, please retry"})
+			}
+			return
+		}
+		defer release()
+
+		duplicate, err := services.FindDuplicateSignal(svcs.DB, &signal)
+		if err != nil {
+			utils.LogError(fmt.Sprintf("❌ Failed to check duplicate signal: %v", err))
+		}
+		if duplicate != nil {
+			utils.LogWarn(fmt.Sprintf("⛔ Duplicate signal ignored (same as #%d, prefix=%s)", duplicate.ID, prefix))
+			c.JSON(http.StatusOK, gin.H{
+				"status":    "duplicate",
+				"signal_id": duplicate.ID,
+				"message":   "Duplicate signal ignored",
+			})
+			return
+		}
+
+		// Signal quá cũ vẫn được lưu (kèm lý do) nhưng không broadcast / thực thi
+		if err := services.CheckSignalAge(&signal, time.Now()); err != nil {
+			signal.RejectReason = err.Error()
 		}
 
 		if err := svcs.DB.Create(&signal).Error; err != nil {
@@ -58,6 +174,16 @@ func TradingViewWebhook(svcs *services.Services, wsHub *services.WebSocketHub) g
 			return
 		}
 
+		if signal.RejectReason != "" {
+			utils.LogWarn(fmt.Sprintf("⛔ Signal #%d rejected: %s", signal.ID, signal.RejectReason))
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"status":    "rejected",
+				"signal_id": signal.ID,
+				"reason":    signal.RejectReason,
+			})
+			return
+		}
+
 		utils.LogInfo(fmt.Sprintf("✅ Signal saved with ID: %d", signal.ID))
 
 		// 🔔 Broadcast signal_new event to all connected WebSocket clients
@@ -83,25 +209,11 @@ func TradingViewWebhook(svcs *services.Services, wsHub *services.WebSocketHub) g
 			utils.LogError("❌ wsHub is NIL - cannot broadcast signal!")
 		}
 
-		// Broadcast signal to all users via Telegram
-		telegramService := services.NewTelegramService(svcs.DB)
-
-		// Get all active Telegram configs with bot tokens
-		var telegramConfigs []models.TelegramConfig
-		if err := svcs.DB.Where("is_enabled = ? AND bot_token != ''", true).Find(&telegramConfigs).Error; err != nil {
-			log.Printf("⚠️ Failed to query Telegram configs: %v", err)
-		} else if len(telegramConfigs) > 0 {
-			// Use the first active bot token (or implement logic to choose specific bot)
-			botToken := telegramConfigs[0].BotToken
-			go func() {
-				if err := telegramService.BroadcastTestConnectionToAllUsers(botToken, signal.Symbol, signal.Action); err != nil {
-					log.Printf("❌ Failed to broadcast Telegram notification: %v", err)
-				} else {
-					log.Printf("✅ Telegram notification sent to all users with bot: %s", telegramConfigs[0].BotName)
-				}
-			}()
-		} else {
-			log.Printf("⚠️ No active Telegram bot configs found, skipping Telegram broadcast")
+		// Telegram broadcast + thực thi theo routing rules / auto_execute chạy qua signal queue (lưu DB, có retry)
+		if svcs.SignalExecutor != nil {
+			if err := svcs.SignalExecutor.Enqueue(signal.ID); err != nil {
+				utils.LogError(fmt.Sprintf("❌ Failed to enqueue signal %d: %v", signal.ID, err))
+			}
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -130,6 +242,8 @@ func ListSignals(services *services.Services) gin.HandlerFunc {
 			ExecutedByUserID *uint      `json:"executed_by_user_id"` // From user_signals.user_id
 			ExecutedAt       *time.Time `json:"executed_at"`         // From user_signals
 			ErrorMessage     string     `json:"error_message"`       // From user_signals
+			ExecutedCount    int        `json:"executed_count"`      // Số bot đã thực thi signal
+			FailedCount      int        `json:"failed_count"`        // Số bot thực thi thất bại
 		}
 
 		// Query params
@@ -141,19 +255,38 @@ func ListSignals(services *services.Services) gin.HandlerFunc {
 		sinceHoursStr := c.Query("since_hours")
 		sinceTsStr := c.Query("since_ts")
 
+		// Gộp record của từng bot thành trạng thái của user: executed nếu có bot đã thực thi,
+		// rồi failed, pending, cuối cùng ignored; order/lỗi lấy từ record đại diện (rep_id)
+		userStatus := services.DB.Table("user_signals").
+			Select(`signal_id,
+				CASE
+					WHEN SUM(CASE WHEN status = 'executed' THEN 1 ELSE 0 END) > 0 THEN 'executed'
+					WHEN SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) > 0 THEN 'failed'
+					WHEN SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) > 0 THEN 'pending'
+					ELSE MAX(status)
+				END AS status,
+				COALESCE(MAX(CASE WHEN status = 'executed' THEN id END), MAX(id)) AS rep_id,
+				SUM(CASE WHEN status = 'executed' THEN 1 ELSE 0 END) AS executed_count,
+				SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) AS failed_count`).
+			Where("user_id = ?", userID).
+			Group("signal_id")
+
 		// Base query: LEFT JOIN to get all signals + user status if exists
 		query := services.DB.Table("trading_signals").
 			Select(`trading_signals.*, 
-				COALESCE(user_signals.status, 'pending') as status,
-				user_signals.order_id,
-				user_signals.user_id as executed_by_user_id,
-				user_signals.executed_at,
-				user_signals.error_msg as error_message`).
-			Joins("LEFT JOIN user_signals ON user_signals.signal_id = trading_signals.id AND user_signals.user_id = ?", userID).
+				COALESCE(us.status, 'pending') as status,
+				rep.order_id,
+				rep.user_id as executed_by_user_id,
+				rep.executed_at,
+				rep.error_msg as error_message,
+				COALESCE(us.executed_count, 0) as executed_count,
+				COALESCE(us.failed_count, 0) as failed_count`).
+			Joins("LEFT JOIN (?) AS us ON us.signal_id = trading_signals.id", userStatus).
+			Joins("LEFT JOIN user_signals AS rep ON rep.id = us.rep_id").
 			Order("trading_signals.received_at DESC")
 
 		if status != "" {
-			query = query.Where("COALESCE(user_signals.status, 'pending') = ?", status)
+			query = query.Where("COALESCE(us.status, 'pending') = ?", status)
 		}
 		if symbol != "" {
 			query = query.Where("trading_signals.symbol = ?", symbol)
@@ -263,7 +396,42 @@ func GetSignal(services *services.Services) gin.HandlerFunc {
 	}
 }
 
-// ExecuteSignal places an order based on a signal and bot config
+// maxBulkExecuteBots giới hạn số bot trong 1 lần execute nhiều bot
+const maxBulkExecuteBots = 20
+
+// botExecutionResult is the per-bot outcome of a (bulk) signal execution
+type botExecutionResult struct {
+	BotConfigID uint            `json:"bot_config_id"`
+	BotName     string          `json:"bot_name"`
+	Exchange    string          `json:"exchange"`
+	Success     bool            `json:"success"`
+	Action      string          `json:"action,omitempty"`
+	Order       *models.Order   `json:"order,omitempty"`
+	Orders      []*models.Order `json:"orders,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	ErrorCode   string          `json:"error_code,omitempty"`
+	HTTPStatus  int             `json:"http_status"`
+	Details     interface{}     `json:"details,omitempty"`
+	// Override trong payload bị bỏ qua vì bot không cho phép
+	IgnoredOverrides []string `json:"ignored_overrides,omitempty"`
+}
+
+// signalExecutionHTTPStatus maps an execution error code to the HTTP status of the API
+func signalExecutionHTTPStatus(code string) int {
+	switch code {
+	case "":
+		return http.StatusOK
+	case tradingservice.SignalErrInvalidAmount, tradingservice.SignalErrInvalidAction, tradingservice.SignalErrOverride, tradingservice.SignalErrStale:
+		return http.StatusBadRequest
+	case tradingservice.SignalErrLocked, tradingservice.SignalErrDuplicate, tradingservice.SignalErrNoPosition, tradingservice.SignalErrFiltered:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ExecuteSignal places an order based on a signal and one bot config (bot_config_id)
+// or several bot configs (bot_config_ids, thực thi song song, kết quả riêng cho từng bot)
 func ExecuteSignal(services *services.Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
@@ -280,7 +448,8 @@ func ExecuteSignal(services *services.Services) gin.HandlerFunc {
 		}
 
 		var payload struct {
-			BotConfigID int `json:"bot_config_id" binding:"required"`
+			BotConfigID  uint   `json:"bot_config_id"`
+			BotConfigIDs []uint `json:"bot_config_ids"`
 		}
 
 		if err := c.ShouldBindJSON(&payload); err != nil {
@@ -288,6 +457,34 @@ func ExecuteSignal(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
+		bulk := len(payload.BotConfigIDs) > 0
+		botConfigIDs := payload.BotConfigIDs
+		if !bulk {
+			if payload.BotConfigID == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bot_config_id or bot_config_ids is required"})
+				return
+			}
+			botConfigIDs = []uint{payload.BotConfigID}
+		}
+
+		// Bỏ ID trùng, giữ thứ tự
+		seen := make(map[uint]bool, len(botConfigIDs))
+		uniqueIDs := make([]uint, 0, len(botConfigIDs))
+		for _, id := range botConfigIDs {
+			if id != 0 && !seen[id] {
+				seen[id] = true
+				uniqueIDs = append(uniqueIDs, id)
+			}
+		}
+		if len(uniqueIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bot_config_ids must contain at least one bot config"})
+			return
+		}
+		if len(uniqueIDs) > maxBulkExecuteBots {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d bot configs can be executed at once", maxBulkExecuteBots)})
+			return
+		}
+
 		// Get signal
 		var signal models.TradingSignal
 		if err := services.DB.First(&signal, signalID).Error; err != nil {
@@ -295,188 +492,134 @@ func ExecuteSignal(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
-		// Get bot config
-		var config models.TradingConfig
-		if err := services.DB.Where("id = ? AND user_id = ?", payload.BotConfigID, userID).First(&config).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Bot config not found"})
+		// Get bot configs (tất cả phải thuộc user)
+		var configs []models.TradingConfig
+		if err := services.DB.Where("id IN ? AND user_id = ?", uniqueIDs, userID).Find(&configs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load bot configs"})
 			return
 		}
-
-		// Check if user already executed this signal
-		var existingUserSignal models.UserSignal
-		if err := services.DB.Where("user_id = ? AND signal_id = ?", userID, signalID).First(&existingUserSignal).Error; err == nil {
-			// User already has a record for this signal
-			if existingUserSignal.Status == "executed" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "You already executed this signal"})
-				return
+		if len(configs) != len(uniqueIDs) {
+			found := make(map[uint]bool, len(configs))
+			for _, cfg := range configs {
+				found[cfg.ID] = true
 			}
-		}
-
-		utils.LogInfo(fmt.Sprintf("🎯 Executing signal %d with bot config %d", signalID, payload.BotConfigID))
-
-		// Determine side from action
-		side := "buy"
-		if signal.Action == "sell" || signal.Action == "short" {
-			side = "sell"
-		}
-
-		// Use signal price if available, otherwise use market price
-		orderType := "market"
-		var price float64
-		// if signal.Price > 0 {
-		// 	orderType = "limit"
-		// 	price = signal.Price
-		// }
-
-		// Use config amount
-		amount := config.Amount
-		if amount <= 0 {
-			// Create failed UserSignal record
-			now := time.Now()
-			userSignal := models.UserSignal{
-				UserID:      userID.(uint),
-				SignalID:    uint(signalID),
-				Status:      "failed",
-				BotConfigID: &config.ID,
-				ExecutedAt:  &now,
-				ErrorMsg:    "Bot config amount must be greater than 0",
+			var missing []uint
+			for _, id := range uniqueIDs {
+				if !found[id] {
+					missing = append(missing, id)
+				}
 			}
-			services.DB.Create(&userSignal)
-
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Bot config amount must be greater than 0",
-			})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bot config not found", "missing_bot_config_ids": missing})
 			return
 		}
-
-		////////////// Decrypt API credentials //////////////
-		apiKey, apiSecret, err := GetDecryptedAPICredentials(&config)
-		if err != nil {
-			log.Printf("Failed to decrypt API credentials: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt API credentials"})
-			return
+		configByID := make(map[uint]models.TradingConfig, len(configs))
+		for _, cfg := range configs {
+			configByID[cfg.ID] = cfg
 		}
 
-		// Place order on exchange (LIVE MODE)
-		utils.LogInfo(fmt.Sprintf("🔍 DEBUG PlaceOrder params: side=%s, orderType=%s, symbol=%s, amount=%.8f, price=%.8f",
-			side, orderType, signal.Symbol, amount, price))
+		// Bot đã thực thi signal này thì bỏ qua (mỗi bot 1 record UserSignal)
+		var executedIDs []uint
+		services.DB.Model(&models.UserSignal{}).
+			Where("user_id = ? AND signal_id = ? AND bot_config_id IN ? AND status = ?", userID, signalID, uniqueIDs, "executed").
+			Pluck("bot_config_id", &executedIDs)
+		executed := make(map[uint]bool, len(executedIDs))
+		for _, id := range executedIDs {
+			executed[id] = true
+		}
 
-		tradingService := tradingservice.NewTradingService(apiKey, apiSecret, config.Exchange, services.DB, userID.(uint))
-		orderResult := tradingService.PlaceOrder(&config, side, orderType, signal.Symbol, amount, price)
+		if !bulk && executed[payload.BotConfigID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You already executed this signal with this bot"})
+			return
+		}
 
-		if !orderResult.Success {
-			utils.LogError(fmt.Sprintf("❌ Failed to execute signal: %v", orderResult.Error))
+		utils.LogInfo(fmt.Sprintf("🎯 Executing signal %d with bot config(s) %v", signalID, uniqueIDs))
 
-			// Create failed UserSignal record
-			now := time.Now()
-			userSignal := models.UserSignal{
-				UserID:      userID.(uint),
-				SignalID:    uint(signalID),
-				Status:      "failed",
-				BotConfigID: &config.ID,
-				ExecutedAt:  &now,
-				ErrorMsg:    orderResult.Error,
+		// Thực thi song song; lệnh cùng API key + symbol vẫn tuần tự nhờ execution lock
+		results := make([]botExecutionResult, len(uniqueIDs))
+		var wg sync.WaitGroup
+		for i, id := range uniqueIDs {
+			config := configByID[id]
+			results[i] = botExecutionResult{BotConfigID: config.ID, BotName: config.Name, Exchange: config.Exchange}
+			if executed[id] {
+				results[i].Error = "Signal already executed with this bot"
+				results[i].ErrorCode = tradingservice.SignalErrDuplicate
+				results[i].HTTPStatus = http.StatusConflict
+				continue
 			}
-			services.DB.Create(&userSignal)
 
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to place order",
-				"details": orderResult.ErrorDetails,
-			})
-			return
+			wg.Add(1)
+			go func(res *botExecutionResult, config models.TradingConfig) {
+				defer wg.Done()
+				result := tradingservice.ExecuteSignalForBot(services.DB, &signal, &config, "manual")
+				res.Success = result.Success
+				res.Action = result.Action
+				res.Order = result.Order
+				res.Orders = result.Orders
+				res.Error = result.Error
+				res.ErrorCode = result.ErrorCode
+				res.HTTPStatus = signalExecutionHTTPStatus(result.ErrorCode)
+				res.Details = result.Details
+				res.IgnoredOverrides = result.IgnoredOverrides
+				if result.ErrorCode == tradingservice.SignalErrOrderFailed {
+					res.Error = "Failed to place order: " + result.Error
+				}
+			}(&results[i], config)
 		}
+		wg.Wait()
 
-		// Calculate SL/TP prices
-		var stopLoss, takeProfit float64
-		filledPrice := orderResult.FilledPrice
-		if filledPrice > 0 {
-			// Use signal SL/TP if provided, otherwise use config
-			if signal.StopLoss > 0 {
-				stopLoss = signal.StopLoss
-			} else if config.StopLossPercent > 0 {
-				if side == "buy" {
-					stopLoss = filledPrice * (1 - config.StopLossPercent/100)
-				} else {
-					stopLoss = filledPrice * (1 + config.StopLossPercent/100)
+		if !bulk {
+			result := results[0]
+			if !result.Success {
+				errorMsg := result.Error
+				if result.ErrorCode == tradingservice.SignalErrOrderFailed {
+					errorMsg = "Failed to place order"
 				}
+				c.JSON(result.HTTPStatus, gin.H{
+					"error":   errorMsg,
+					"details": result.Details,
+				})
+				return
 			}
 
-			if signal.TakeProfit > 0 {
-				takeProfit = signal.TakeProfit
-			} else if config.TakeProfitPercent > 0 {
-				if side == "buy" {
-					takeProfit = filledPrice * (1 + config.TakeProfitPercent/100)
-				} else {
-					takeProfit = filledPrice * (1 - config.TakeProfitPercent/100)
-				}
+			response := gin.H{
+				"status":  "success",
+				"signal":  signal,
+				"action":  result.Action,
+				"order":   result.Order,
+				"orders":  result.Orders,
+				"message": "Order placed successfully",
 			}
-		}
-
-		// Create order record using Algo IDs from orderResult
-		// Service has already placed SL/TP orders and returned their IDs
-		order := models.Order{
-			UserID:           userID.(uint),
-			BotConfigID:      config.ID,
-			Exchange:         config.Exchange,
-			Symbol:           orderResult.Symbol,
-			OrderID:          orderResult.OrderID, // Exchange order ID
-			Side:             orderResult.Side,
-			Type:             orderResult.Type,
-			Quantity:         orderResult.Quantity,
-			Price:            orderResult.Price,
-			FilledPrice:      orderResult.FilledPrice,
-			Status:           orderResult.Status,
-			TradingMode:      config.TradingMode,
-			Leverage:         config.Leverage,
-			StopLossPrice:    stopLoss,
-			TakeProfitPrice:  takeProfit,
-			AlgoIDStopLoss:   orderResult.AlgoIDStopLoss,   // Use from service
-			AlgoIDTakeProfit: orderResult.AlgoIDTakeProfit, // Use from service
-			PnL:              0,
-			PnLPercent:       0,
-		}
-
-		if err := services.DB.Create(&order).Error; err != nil {
-			now := time.Now()
-			userSignal := models.UserSignal{
-				UserID:      userID.(uint),
-				SignalID:    uint(signalID),
-				Status:      "failed",
-				BotConfigID: &config.ID,
-				ExecutedAt:  &now,
-				ErrorMsg:    fmt.Sprintf("Failed to create order record: %v", err),
+			if len(result.IgnoredOverrides) > 0 {
+				response["ignored_overrides"] = result.IgnoredOverrides
+				response["message"] = "Order placed successfully (bot does not allow signal overrides: " +
+					strings.Join(result.IgnoredOverrides, ", ") + " ignored)"
 			}
-			services.DB.Create(&userSignal)
-
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to create order record",
-			})
+			c.JSON(http.StatusOK, response)
 			return
 		}
 
-		//////////////////////////////////////////////////////////////////////
-
-		// Create UserSignal record for this user
-		now := time.Now()
-		userSignal := models.UserSignal{
-			UserID:      userID.(uint),
-			SignalID:    uint(signalID),
-			Status:      "executed",
-			BotConfigID: &config.ID,
-			OrderID:     &order.ID,
-			ExecutedAt:  &now,
+		succeeded := 0
+		for _, r := range results {
+			if r.Success {
+				succeeded++
+			}
 		}
-		if err := services.DB.Create(&userSignal).Error; err != nil {
-			utils.LogError(fmt.Sprintf("❌ Failed to create UserSignal: %v", err))
+		status := "success"
+		switch {
+		case succeeded == 0:
+			status = "failed"
+		case succeeded < len(results):
+			status = "partial"
 		}
 
-		utils.LogInfo(fmt.Sprintf("✅ Signal %d executed successfully by user %d, Order ID: %d", signalID, userID, order.ID))
-
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "success",
-			"signal":  signal,
-			"order":   order,
-			"message": "Order placed successfully",
+			"status":    status,
+			"signal":    signal,
+			"results":   results,
+			"total":     len(results),
+			"succeeded": succeeded,
+			"failed":    len(results) - succeeded,
+			"message":   fmt.Sprintf("Signal executed on %d/%d bot(s)", succeeded, len(results)),
 		})
 	}
 }
@@ -515,16 +658,20 @@ func UpdateSignalStatus(services *services.Services) gin.HandlerFunc {
 
 		// Create or update UserSignal record for this user
 		var userSignal models.UserSignal
-		result := services.DB.Where("user_id = ? AND signal_id = ?", userID, signalID).First(&userSignal)
+		// Trạng thái chung của user (không gắn bot) — kết quả thực thi từng bot giữ nguyên
+		result := services.DB.Where("user_id = ? AND signal_id = ? AND bot_key = 0", userID, signalID).First(&userSignal)
 
 		if result.Error != nil {
-			// Create new UserSignal
+			// Create new UserSignal (request song song đã tạo trước → cập nhật status của record đó)
 			userSignal = models.UserSignal{
 				UserID:   userID.(uint),
 				SignalID: uint(signalID),
 				Status:   payload.Status,
 			}
-			if err := services.DB.Create(&userSignal).Error; err != nil {
+			conflict := tradingservice.UserSignalConflict()
+			conflict.DoNothing = false
+			conflict.DoUpdates = clause.AssignmentColumns([]string{"status", "updated_at"})
+			if err := services.DB.Clauses(conflict).Create(&userSignal).Error; err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user signal"})
 				return
 			}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"tradercoin/backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAnalyticsDays = 365
+
+// parseSignalAnalyticsQuery đọc query params: since_days (30), prefix, strategy, shadow, horizon_hours (24), shadow_limit (20, tối đa 30)
+func parseSignalAnalyticsQuery(c *gin.Context) (services.SignalAnalyticsQuery, bool) {
+	days, err := strconv.Atoi(c.DefaultQuery("since_days", "30"))
+	if err != nil || days <= 0 || days > maxAnalyticsDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since_days must be between 1 and 365"})
+		return services.SignalAnalyticsQuery{}, false
+	}
+	horizon, err := strconv.ParseFloat(c.DefaultQuery("horizon_hours", "24"), 64)
+	if err != nil || horizon <= 0 || horizon > 24*30 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "horizon_hours must be between 0 and 720"})
+		return services.SignalAnalyticsQuery{}, false
+	}
+	shadowLimit, err := strconv.Atoi(c.DefaultQuery("shadow_limit", strconv.Itoa(services.DefaultMaxShadowSignals)))
+	if err != nil || shadowLimit <= 0 || shadowLimit > services.MaxShadowSignalsPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shadow_limit must be between 1 and " + strconv.Itoa(services.MaxShadowSignalsPerRequest)})
+		return services.SignalAnalyticsQuery{}, false
+	}
+
+	return services.SignalAnalyticsQuery{
+		Since:            time.Now().AddDate(0, 0, -days),
+		Prefix:           c.Query("prefix"),
+		Strategy:         c.Query("strategy"),
+		Shadow:           c.Query("shadow") == "true" || c.Query("shadow") == "1",
+		Horizon:          time.Duration(horizon * float64(time.Hour)),
+		MaxShadowSignals: shadowLimit,
+	}, true
+}
+
+// GetSignalAnalytics - User: hiệu suất theo strategy / webhook prefix của signal user nhận được
+func GetSignalAnalytics(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		query, ok := parseSignalAnalyticsQuery(c)
+		if !ok {
+			return
+		}
+		query.UserID = userID.(uint)
+
+		report, err := services.BuildSignalAnalytics(svc.DB, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// GetSignalAnalyticsAdmin - Admin: hiệu suất theo strategy / webhook prefix trên toàn hệ thống
+func GetSignalAnalyticsAdmin(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, ok := parseSignalAnalyticsQuery(c)
+		if !ok {
+			return
+		}
+
+		report, err := services.BuildSignalAnalytics(svc.DB, query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tradercoin/backend/models"
+	"tradercoin/backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetSignalJobs - Admin: danh sách job của signal queue (lọc theo status, kind, signal_id) + thống kê theo status
+func GetSignalJobs(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.SignalExecutor == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Signal queue is not running"})
+			return
+		}
+
+		query := svc.DB.Model(&models.SignalJob{})
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		if kind := c.Query("kind"); kind != "" {
+			query = query.Where("kind = ?", kind)
+		}
+		if signalID, err := strconv.Atoi(c.Query("signal_id")); err == nil && signalID > 0 {
+			query = query.Where("signal_id = ?", signalID)
+		}
+
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit <= 0 || limit > 200 {
+			limit = 50
+		}
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var jobs []models.SignalJob
+		if err := query.Order("updated_at DESC").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch signal jobs"})
+			return
+		}
+
+		stats, err := svc.SignalExecutor.Queue.CountByStatus()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count signal jobs"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"jobs":    jobs,
+			"total":   total,
+			"stats":   stats,
+			"backend": svc.SignalExecutor.Queue.Backend(),
+		})
+	}
+}
+
+// RedriveSignalJob - Admin: chạy lại một job dead-letter
+func RedriveSignalJob(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.SignalExecutor == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Signal queue is not running"})
+			return
+		}
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+			return
+		}
+
+		job, err := svc.SignalExecutor.Queue.Redrive(uint(id))
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "Signal job not found"})
+			case errors.Is(err, services.ErrSignalJobNotDead):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Signal job re-queued",
+			"job":     job,
+		})
+	}
+}
+
+// GetFailedUserSignals - Admin: các lần thực thi signal thất bại (UserSignal status = failed)
+func GetFailedUserSignals(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limit <= 0 || limit > 200 {
+			limit = 50
+		}
+
+		var userSignals []models.UserSignal
+		query := svc.DB.Preload("Signal").Where("status = ?", "failed")
+		if userID, err := strconv.Atoi(c.Query("user_id")); err == nil && userID > 0 {
+			query = query.Where("user_id = ?", userID)
+		}
+		if err := query.Order("updated_at DESC").Limit(limit).Find(&userSignals).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch failed executions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_signals": userSignals,
+			"total":        len(userSignals),
+		})
+	}
+}
+
+// RedriveUserSignalAdmin - Admin: chạy lại lần thực thi thất bại của một UserSignal
+func RedriveUserSignalAdmin(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if svc.SignalExecutor == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Signal queue is not running"})
+			return
+		}
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user signal ID"})
+			return
+		}
+
+		var userSignal models.UserSignal
+		if err := svc.DB.First(&userSignal, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User signal not found"})
+			return
+		}
+		if userSignal.Status != "failed" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only failed executions can be re-driven"})
+			return
+		}
+		if userSignal.BotConfigID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User signal has no bot config to re-drive with"})
+			return
+		}
+
+		redriveExecution(c, svc, userSignal.UserID, userSignal.SignalID, *userSignal.BotConfigID)
+	}
+}
+
+// RedriveUserSignal - User: chạy lại signal đã thực thi thất bại (bot_config_id tuỳ chọn, mặc định mọi bot thất bại)
+func RedriveUserSignal(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+		if svc.SignalExecutor == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Signal queue is not running"})
+			return
+		}
+
+		signalID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid signal ID"})
+			return
+		}
+
+		var payload struct {
+			BotConfigID uint `json:"bot_config_id"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&payload); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+				return
+			}
+		}
+
+		// Mỗi bot có 1 record riêng: re-drive bot được chỉ định, hoặc tất cả bot thất bại của signal
+		query := svc.DB.Where("user_id = ? AND signal_id = ? AND status = ?", userID, signalID, "failed").
+			Where("bot_config_id IS NOT NULL")
+		if payload.BotConfigID != 0 {
+			query = query.Where("bot_config_id = ?", payload.BotConfigID)
+		}
+		var userSignals []models.UserSignal
+		if err := query.Find(&userSignals).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load executions"})
+			return
+		}
+		if len(userSignals) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No failed execution found for this signal"})
+			return
+		}
+
+		if len(userSignals) == 1 {
+			us := userSignals[0]
+			redriveExecution(c, svc, us.UserID, us.SignalID, *us.BotConfigID)
+			return
+		}
+
+		var jobs []*models.SignalJob
+		for _, us := range userSignals {
+			job, err := svc.SignalExecutor.RedriveExecution(us.UserID, us.SignalID, *us.BotConfigID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "jobs": jobs})
+				return
+			}
+			jobs = append(jobs, job)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": fmt.Sprintf("Signal execution re-queued for %d bot(s)", len(jobs)),
+			"jobs":    jobs,
+		})
+	}
+}
+
+func redriveExecution(c *gin.Context, svc *services.Services, userID, signalID, botConfigID uint) {
+	job, err := svc.SignalExecutor.RedriveExecution(userID, signalID, botConfigID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Signal or bot config not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Signal execution re-queued",
+		"job":     job,
+	})
+}
@@ -5,11 +5,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"tradercoin/backend/config"
 	"tradercoin/backend/models"
@@ -17,6 +19,7 @@ import (
 	tradingservice "tradercoin/backend/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -26,28 +29,29 @@ type UnregisterRequest = services.UnregisterRequest
 
 // PlaceOrderRequest represents the request body for placing an order
 type PlaceOrderRequest struct {
-	BotConfigID int     `json:"bot_config_id" binding:"required"`
-	Symbol      string  `json:"symbol"`
-	Side        string  `json:"side" binding:"required,oneof=buy sell"`
-	OrderType   string  `json:"order_type" binding:"required,oneof=market limit"`
-	Amount      float64 `json:"amount"`
-	Price       float64 `json:"price"`
+	BotConfigID int             `json:"bot_config_id" binding:"required"`
+	Symbol      string          `json:"symbol"`
+	Side        string          `json:"side" binding:"required,oneof=buy sell"`
+	OrderType   string          `json:"order_type" binding:"required,oneof=market limit"`
+	Amount      decimal.Decimal `json:"amount"`
+	Price       decimal.Decimal `json:"price"`
+	RequestID   string          `json:"request_id" binding:"required,max=64"` // Idempotency key from client, reuse it when retrying
 }
 
 // PlaceOrderResponse represents the response after placing an order
 type PlaceOrderResponse struct {
-	Status          string  `json:"status"`
-	OrderID         uint    `json:"order_id"`
-	ExchangeOrderID string  `json:"exchange_order_id"`
-	Symbol          string  `json:"symbol"`
-	Side            string  `json:"side"`
-	OrderType       string  `json:"order_type"`
-	Amount          float64 `json:"amount"`
-	Price           float64 `json:"price"`
-	FilledPrice     float64 `json:"filled_price"`
-	StopLoss        float64 `json:"stop_loss"`
-	TakeProfit      float64 `json:"take_profit"`
-	OrderStatus     string  `json:"order_status"`
+	Status          string          `json:"status"`
+	OrderID         uint            `json:"order_id"`
+	ExchangeOrderID string          `json:"exchange_order_id"`
+	Symbol          string          `json:"symbol"`
+	Side            string          `json:"side"`
+	OrderType       string          `json:"order_type"`
+	Amount          decimal.Decimal `json:"amount"`
+	Price           decimal.Decimal `json:"price"`
+	FilledPrice     decimal.Decimal `json:"filled_price"`
+	StopLoss        decimal.Decimal `json:"stop_loss"`
+	TakeProfit      decimal.Decimal `json:"take_profit"`
+	OrderStatus     string          `json:"order_status"`
 }
 
 // PlaceOrderDirect - Đặt lệnh trực tiếp lên sàn giao dịch (không qua webhook)
@@ -92,11 +96,11 @@ func PlaceOrderDirect(services *services.Services) gin.HandlerFunc {
 
 		// Use provided amount or config amount
 		amount := request.Amount
-		if amount <= 0 {
-			amount = config.Amount
+		if !amount.IsPositive() {
+			amount = decimal.NewFromFloat(config.Amount)
 		}
 
-		if amount <= 0 {
+		if !amount.IsPositive() {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "Amount must be greater than 0. Please provide amount in request or configure it in bot config.",
 			})
@@ -112,7 +116,7 @@ func PlaceOrderDirect(services *services.Services) gin.HandlerFunc {
 		// Validate price for limit orders
 		orderType := request.OrderType
 		price := request.Price
-		if orderType == "limit" && price <= 0 {
+		if orderType == "limit" && !price.IsPositive() {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "Price is required for limit orders",
 			})
@@ -127,9 +131,30 @@ func PlaceOrderDirect(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
+		// Client order ID sinh từ request_id của client: gửi lại cùng request_id (retry sau timeout) không đặt lệnh thứ hai
+		clientOrderID := tradingservice.GenerateClientOrderID(userID.(uint), config.ID, tradingservice.OrderSourceManual, request.RequestID)
+
+		// Serialize với các lệnh khác trên cùng API key + symbol
+		lockKey := tradingservice.ExecutionLockKey(config.Exchange, apiKey, symbol)
+		release, err := tradingservice.ExecutionLocks().Acquire(lockKey, fmt.Sprintf("manual:user:%d", userID.(uint)), tradingservice.DefaultLockWaitTimeout)
+		if err != nil {
+			log.Printf("⏳ PlaceOrderDirect: %v", err)
+			c.JSON(http.StatusConflict, gin.H{"error": "Another order operation is in progress for this symbol, please retry"})
+			return
+		}
+		defer release()
+
 		// Place order on exchange
 		tradingService := tradingservice.NewTradingService(apiKey, apiSecret, config.Exchange, services.DB, userID.(uint))
-		orderResult := tradingService.PlaceOrder(&config, request.Side, orderType, symbol, amount, price)
+		orderResult := tradingService.PlaceOrder(&config, request.Side, orderType, symbol, amount, price, clientOrderID)
+
+		if orderResult.Status == "duplicate" {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   orderResult.Error,
+				"details": orderResult.ErrorDetails,
+			})
+			return
+		}
 
 		if !orderResult.Success {
 			errorMsg := orderResult.Error
@@ -149,29 +174,12 @@ func PlaceOrderDirect(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
-		log.Printf("Order placed successfully on %s: OrderID=%s, Symbol=%s, Side=%s, Amount=%f",
+		log.Printf("Order placed successfully on %s: OrderID=%s, Symbol=%s, Side=%s, Amount=%s",
 			config.Exchange, orderResult.OrderID, orderResult.Symbol, orderResult.Side, orderResult.Quantity)
 
 		// Calculate SL/TP prices for response (service already handled placing SL/TP orders)
-		var stopLoss, takeProfit float64
-		filledPrice := orderResult.FilledPrice
-		if filledPrice > 0 {
-			if config.StopLossPercent > 0 {
-				if request.Side == "buy" {
-					stopLoss = filledPrice * (1 - config.StopLossPercent/100)
-				} else {
-					stopLoss = filledPrice * (1 + config.StopLossPercent/100)
-				}
-			}
-
-			if config.TakeProfitPercent > 0 {
-				if request.Side == "buy" {
-					takeProfit = filledPrice * (1 + config.TakeProfitPercent/100)
-				} else {
-					takeProfit = filledPrice * (1 - config.TakeProfitPercent/100)
-				}
-			}
-		}
+		stopLoss := tradingservice.CalculateStopLossPrice(request.Side, orderResult.FilledPrice, config.StopLossPercent)
+		takeProfit := tradingservice.CalculateTakeProfitPrice(request.Side, orderResult.FilledPrice, config.TakeProfitPercent)
 
 		// Create order record using Algo IDs from orderResult
 		// Service has already placed SL/TP orders and returned their IDs
@@ -181,20 +189,19 @@ func PlaceOrderDirect(services *services.Services) gin.HandlerFunc {
 			Exchange:         config.Exchange,
 			Symbol:           orderResult.Symbol,
 			OrderID:          orderResult.OrderID, // Exchange order ID
+			ClientOrderID:    clientOrderID,
 			Side:             orderResult.Side,
 			Type:             orderResult.Type,
 			Quantity:         orderResult.Quantity,
 			Price:            orderResult.Price,
 			FilledPrice:      orderResult.FilledPrice,
-			Status:           orderResult.Status,
+			Status:           tradingservice.NormalizeOrderStatus(orderResult.Status),
 			TradingMode:      config.TradingMode,
 			Leverage:         config.Leverage,
 			StopLossPrice:    stopLoss,
 			TakeProfitPrice:  takeProfit,
 			AlgoIDStopLoss:   orderResult.AlgoIDStopLoss,   // Use from service
 			AlgoIDTakeProfit: orderResult.AlgoIDTakeProfit, // Use from service
-			PnL:              0,
-			PnLPercent:       0,
 		}
 
 		if err := services.DB.Create(&order).Error; err != nil {
@@ -203,6 +210,13 @@ func PlaceOrderDirect(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
+		tradingservice.RecordOrderCreated(services.DB, &order, tradingservice.OrderSourceManual, tradingservice.OrderActorUser(order.UserID), orderResult)
+
+		// Gắn lệnh futures vào vị thế (mở mới hoặc nhồi thêm)
+		if _, err := tradingservice.LinkEntryOrder(services.DB, &order); err != nil {
+			log.Printf("⚠️  Order %d: failed to link position: %v", order.ID, err)
+		}
+
 		//////////////////////////////////////////////////////////////////////
 
 		// Log Algo IDs if they exist (indicating SL/TP orders were placed by service)
@@ -271,13 +285,33 @@ func CloseOrder(services *services.Services) gin.HandlerFunc {
 		// TODO: Implement actual position closing through trading service
 		log.Printf("Closing order: ID=%d, Symbol=%s", order.ID, order.Symbol)
 
-		// Update order status
-		order.Status = "closed"
-		if err := services.DB.Save(&order).Error; err != nil {
+		// Update order status (qua state machine, ghi OrderEvent)
+		now := time.Now()
+		_, err = tradingservice.ApplyOrderChange(services.DB, &order, tradingservice.OrderChange{
+			Status: tradingservice.OrderStatusClosed,
+			Updates: map[string]interface{}{
+				"exit_reason": tradingservice.ExitReasonManual,
+				"closed_at":   &now,
+			},
+			Source: tradingservice.OrderSourceManual,
+			Actor:  tradingservice.OrderActorUser(order.UserID),
+		})
+		if errors.Is(err, tradingservice.ErrInvalidOrderTransition) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Order cannot be closed from status " + order.Status})
+			return
+		}
+		if err != nil {
 			log.Printf("Error updating order: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close order"})
 			return
 		}
+		order.ExitReason = tradingservice.ExitReasonManual
+		order.ClosedAt = &now
+		if order.PositionID != nil {
+			if err := tradingservice.SyncPositionWithOrders(services.DB, *order.PositionID); err != nil {
+				log.Printf("Error syncing position %d: %v", *order.PositionID, err)
+			}
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "success",
@@ -462,40 +496,51 @@ func RefreshPnL(services *services.Services) gin.HandlerFunc {
 			}
 		}
 
-		// TODO: Implement actual PnL fetching from exchange
-		// For now, simulate PnL calculation based on price difference
-		currentPrice := order.Price * 1.02 // Simulate 2% price change
-		var pnl, pnlPercent float64
+		// Giá hiện tại từ price cache (market data stream), fallback REST; futures dùng mark price như sàn
+		priceConfig := &config
+		if order.BotConfigID == 0 {
+			priceConfig = &models.TradingConfig{TradingMode: order.TradingMode}
+		}
+		ts := tradingservice.NewTradingService("", "", order.Exchange, services.DB, order.UserID)
+		var currentPrice decimal.Decimal
+		if strings.EqualFold(order.TradingMode, "futures") {
+			currentPrice, err = ts.GetMarkPrice(order.Symbol)
+		}
+		if !currentPrice.IsPositive() {
+			currentPrice, err = ts.GetCurrentPrice(priceConfig, order.Symbol)
+		}
+		if err != nil || !currentPrice.IsPositive() {
+			log.Printf("Error fetching current price for %s: %v", order.Symbol, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get current price"})
+			return
+		}
 
-		if order.FilledPrice > 0 {
-			if order.Side == "buy" {
-				pnl = (currentPrice - order.FilledPrice) * order.Quantity
-				pnlPercent = ((currentPrice - order.FilledPrice) / order.FilledPrice) * 100
-			} else {
-				pnl = (order.FilledPrice - currentPrice) * order.Quantity
-				pnlPercent = ((order.FilledPrice - currentPrice) / order.FilledPrice) * 100
-			}
-		} else if order.Price > 0 {
-			if order.Side == "buy" {
-				pnl = (currentPrice - order.Price) * order.Quantity
-				pnlPercent = ((currentPrice - order.Price) / order.Price) * 100
-			} else {
-				pnl = (order.Price - currentPrice) * order.Quantity
-				pnlPercent = ((order.Price - currentPrice) / order.Price) * 100
-			}
+		entryPrice := order.FilledPrice
+		if !entryPrice.IsPositive() {
+			entryPrice = order.Price
 		}
+		quantity := order.FilledQuantity
+		if !quantity.IsPositive() {
+			quantity = order.Quantity
+		}
+		pnl, pnlPercent := tradingservice.CalculatePnL(order.Side, entryPrice, currentPrice, quantity)
 
-		// Update PnL in database
+		// Update PnL in database: chỉ ghi 3 cột giá / PnL, không ghi đè trạng thái mà stream / monitor vừa đổi
+		order.CurrentPrice = currentPrice
 		order.PnL = pnl
 		order.PnLPercent = pnlPercent
 
-		if err := services.DB.Save(&order).Error; err != nil {
+		if err := services.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+			"current_price": currentPrice,
+			"pn_l":          pnl,
+			"pn_l_percent":  pnlPercent,
+		}).Error; err != nil {
 			log.Printf("Error updating order PnL: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update PnL"})
 			return
 		}
 
-		log.Printf("PnL refreshed: Order %d, PnL=%f, PnL%%=%f", order.ID, pnl, pnlPercent)
+		log.Printf("PnL refreshed: Order %d, PnL=%s, PnL%%=%s", order.ID, pnl, pnlPercent)
 
 		c.JSON(http.StatusOK, gin.H{
 			"status":        "success",
@@ -795,6 +840,11 @@ func ConnectWebSocket(services *services.Services, hub *services.WebSocketHub) g
 			hub.AddGlobalUserTab(userID.(uint), sessionID, conn)
 		}
 
+		// Stream đã do StreamManager phía server giữ: tab chỉ nhận broadcast qua global tab
+		if hub != nil && hub.UsesServerStreams() {
+			exchangeKeys = nil
+		}
+
 		// Register each exchange key with the hub (for order_update etc.)
 		for _, key := range exchangeKeys {
 			// Create or get listen key
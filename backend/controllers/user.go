@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"net/http"
+	"strings"
 	"tradercoin/backend/models"
 	"tradercoin/backend/services"
+	tradingservice "tradercoin/backend/services"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -39,6 +41,7 @@ func GetProfile(services *services.Services) gin.HandlerFunc {
 			"full_name":  user.FullName,
 			"phone":      user.Phone,
 			"chat_id":    user.ChatID,
+			"timezone":   user.Timezone,
 			"is_active":  user.Status == "active",
 			"created_at": user.CreatedAt,
 		})
@@ -60,6 +63,7 @@ func UpdateProfile(services *services.Services) gin.HandlerFunc {
 			FullName string `json:"full_name"`
 			Phone    string `json:"phone"`
 			ChatID   string `json:"chat_id"`
+			Timezone string `json:"timezone"` // IANA timezone cho khung giờ giao dịch của bot
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -91,6 +95,14 @@ func UpdateProfile(services *services.Services) gin.HandlerFunc {
 			updates["phone"] = input.Phone
 		}
 
+		if input.Timezone != "" {
+			if _, err := tradingservice.LoadUserLocation(input.Timezone); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			updates["timezone"] = strings.TrimSpace(input.Timezone)
+		}
+
 		// Always update ChatID even if empty (user might want to clear it)
 		updates["chat_id"] = input.ChatID
 
@@ -118,6 +130,7 @@ func UpdateProfile(services *services.Services) gin.HandlerFunc {
 			"full_name":  user.FullName,
 			"phone":      user.Phone,
 			"chat_id":    user.ChatID,
+			"timezone":   user.Timezone,
 			"is_active":  user.Status == "active",
 			"created_at": user.CreatedAt,
 		})
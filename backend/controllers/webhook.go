@@ -1,11 +1,16 @@
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
+	"tradercoin/backend/models"
 	"tradercoin/backend/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 // HandleBinanceWebhook - Xử lý webhook từ Binance
@@ -39,12 +44,16 @@ func HandleTradingViewWebhook(services *services.Services) gin.HandlerFunc {
 			Secret   string  `json:"secret" binding:"required"`
 		}
 
-		if err := c.ShouldBindJSON(&input); err != nil {
+		if err := c.ShouldBindBodyWith(&input, binding.JSON); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// TODO: Verify secret key
+		// Verify secret key (WEBHOOK_SECRET, hoặc HMAC header)
+		if !authenticateWebhook(c, services, "", input.Secret) {
+			return
+		}
+
 		// TODO: Execute trading action
 
 		c.JSON(http.StatusOK, gin.H{
@@ -82,28 +91,60 @@ func HandlePriceAlert(services *services.Services) gin.HandlerFunc {
 	}
 }
 
-// GetWebhookLogs - Lấy logs của webhooks
+// GetWebhookLogs - Lấy logs của webhooks (signal đã nhận + request bị chặn) của các prefix của user
 func GetWebhookLogs(services *services.Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
 		if !exists {
-			userID = uint(1)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
 		}
 
-		// TODO: Lấy webhook logs từ database
-		logs := []gin.H{
-			{
-				"id":        1,
-				"type":      "binance",
-				"status":    "success",
-				"timestamp": time.Now().Add(-1 * time.Hour),
-			},
-			{
-				"id":        2,
+		limit := 50
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+
+		var prefixes []string
+		services.DB.Model(&models.WebhookPrefix{}).Unscoped().Where("user_id = ?", userID).Pluck("prefix", &prefixes)
+
+		var signals []models.TradingSignal
+		if len(prefixes) > 0 {
+			services.DB.Where("webhook_prefix IN ?", prefixes).
+				Order("received_at DESC").Limit(limit).Find(&signals)
+		}
+
+		var rejections []models.WebhookRejection
+		services.DB.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&rejections)
+
+		logs := make([]gin.H, 0, len(signals)+len(rejections))
+		for _, signal := range signals {
+			logs = append(logs, gin.H{
+				"id":        signal.ID,
 				"type":      "tradingview",
 				"status":    "success",
-				"timestamp": time.Now().Add(-30 * time.Minute),
-			},
+				"prefix":    signal.WebhookPrefix,
+				"message":   fmt.Sprintf("%s %s", signal.Action, signal.Symbol),
+				"timestamp": signal.ReceivedAt,
+			})
+		}
+		for _, rejection := range rejections {
+			logs = append(logs, gin.H{
+				"id":         rejection.ID,
+				"type":       "tradingview",
+				"status":     "rejected",
+				"prefix":     rejection.Prefix,
+				"ip_address": rejection.IPAddress,
+				"message":    rejection.Reason,
+				"timestamp":  rejection.CreatedAt,
+			})
+		}
+
+		sort.Slice(logs, func(i, j int) bool {
+			return logs[i]["timestamp"].(time.Time).After(logs[j]["timestamp"].(time.Time))
+		})
+		if len(logs) > limit {
+			logs = logs[:limit]
 		}
 
 		c.JSON(http.StatusOK, gin.H{
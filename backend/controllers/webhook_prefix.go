@@ -1,20 +1,26 @@
 package controllers
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"tradercoin/backend/models"
 	"tradercoin/backend/services"
+	"tradercoin/backend/utils"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // GetWebhookPrefix returns the latest active webhook prefix for the authenticated user
-func GetWebhookPrefix(services *services.Services) gin.HandlerFunc {
+func GetWebhookPrefix(svc *services.Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
 		if !exists {
@@ -23,23 +29,20 @@ func GetWebhookPrefix(services *services.Services) gin.HandlerFunc {
 		}
 
 		var wp models.WebhookPrefix
-		if err := services.DB.Where("user_id = ? AND active = ?", userID, true).
+		if err := svc.DB.Where("user_id = ? AND active = ?", userID, true).
 			Order("created_at DESC").First(&wp).Error; err != nil {
 			// Not found is OK; return empty
 			c.JSON(http.StatusOK, gin.H{"prefix": "", "url": ""})
 			return
 		}
 
-		base := c.Request.Host
-		scheme := "http"
-		if strings.HasPrefix(base, "localhost:") || strings.HasPrefix(base, "127.0.0.1:") {
-			scheme = "http"
-		} else if c.Request.TLS != nil {
-			scheme = "https"
-		}
-		fullURL := fmt.Sprintf("%s://%s/api/v1/signals/webhook/tradingview/%s", scheme, base, wp.Prefix)
-
-		c.JSON(http.StatusOK, gin.H{"prefix": wp.Prefix, "url": fullURL})
+		c.JSON(http.StatusOK, gin.H{
+			"prefix":       wp.Prefix,
+			"url":          webhookURL(c, wp.Prefix),
+			"has_secret":   wp.Secret != "", // false → webhook bị từ chối, cần rotate để lấy secret
+			"expires_at":   wp.ExpiresAt,
+			"ip_allowlist": services.SplitIPAllowlist(wp.IPAllowlist),
+		})
 	}
 }
 
@@ -83,9 +86,17 @@ func CreateWebhookPrefix(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
+		// Secret chỉ trả về 1 lần, DB lưu bản mã hoá
+		secret, encryptedSecret, err := newWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+			return
+		}
+
 		wp := models.WebhookPrefix{
 			UserID:    userID,
 			Prefix:    prefix,
+			Secret:    encryptedSecret,
 			Active:    true,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
@@ -95,19 +106,332 @@ func CreateWebhookPrefix(services *services.Services) gin.HandlerFunc {
 			return
 		}
 
-		base := c.Request.Host
-		scheme := "http"
-		if strings.HasPrefix(base, "localhost:") || strings.HasPrefix(base, "127.0.0.1:") {
-			scheme = "http"
-		} else if c.Request.TLS != nil {
-			scheme = "https"
-		}
-		fullURL := fmt.Sprintf("%s://%s/api/v1/signals/webhook/tradingview/%s", scheme, base, prefix)
-
 		c.JSON(http.StatusCreated, gin.H{
 			"message": "Webhook prefix created",
 			"prefix":  prefix,
-			"url":     fullURL,
+			"url":     webhookURL(c, prefix),
+			"secret":  secret,
 		})
 	}
 }
+
+// RotateWebhookPrefix tạo prefix + secret mới; prefix cũ vẫn nhận signal tới hết grace period
+func RotateWebhookPrefix(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIDVal, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		userID := userIDVal.(uint)
+
+		var input struct {
+			Prefix     string `json:"prefix"`      // Prefix cần rotate (mặc định: prefix active mới nhất)
+			GraceHours *int   `json:"grace_hours"` // Default 24h, 0 = vô hiệu ngay
+		}
+		_ = c.ShouldBindJSON(&input)
+
+		grace := services.DefaultWebhookRotationGrace
+		if input.GraceHours != nil {
+			if *input.GraceHours < 0 || *input.GraceHours > 168 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "grace_hours must be between 0 and 168"})
+				return
+			}
+			grace = time.Duration(*input.GraceHours) * time.Hour
+		}
+
+		var old models.WebhookPrefix
+		query := svc.DB.Where("user_id = ? AND active = ?", userID, true).
+			Where("expires_at IS NULL OR expires_at > ?", time.Now())
+		if p := strings.ToLower(strings.TrimSpace(input.Prefix)); p != "" {
+			query = query.Where("prefix = ?", p)
+		}
+		if err := query.Order("created_at DESC").First(&old).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "prefix not found"})
+			return
+		}
+
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate prefix"})
+			return
+		}
+		secret, encryptedSecret, err := newWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+			return
+		}
+
+		now := time.Now()
+		expiresAt := now.Add(grace)
+		wp := models.WebhookPrefix{
+			UserID:      userID,
+			Prefix:      hex.EncodeToString(b),
+			Secret:      encryptedSecret,
+			Active:      true,
+			IPAllowlist: old.IPAllowlist,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		err = svc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&wp).Error; err != nil {
+				return err
+			}
+			updates := map[string]interface{}{"expires_at": expiresAt, "replaced_by": wp.Prefix}
+			if grace == 0 {
+				updates["active"] = false
+			}
+			if err := tx.Model(&old).Updates(updates).Error; err != nil {
+				return err
+			}
+
+			// Routing rules / bot auto-execute đang gắn prefix cũ → chuyển sang prefix mới
+			// (signal tới prefix cũ trong grace period được route theo replaced_by)
+			if err := tx.Model(&models.SignalRoutingRule{}).Where("user_id = ? AND match_prefix = ?", userID, old.Prefix).
+				Update("match_prefix", wp.Prefix).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.TradingConfig{}).Where("user_id = ? AND auto_execute_prefix = ?", userID, old.Prefix).
+				Update("auto_execute_prefix", wp.Prefix).Error; err != nil {
+				return err
+			}
+
+			// Payload template gắn theo prefix: copy sang prefix mới, prefix cũ giữ template tới hết grace period
+			var template models.WebhookPayloadTemplate
+			err := tx.Where("user_id = ? AND prefix = ?", userID, old.Prefix).First(&template).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			template.ID = 0
+			template.Prefix = wp.Prefix
+			template.CreatedAt = now
+			template.UpdatedAt = now
+			return tx.Create(&template).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate prefix"})
+			return
+		}
+
+		utils.CreateSystemLog(svc.DB, userID, utils.LogLevelInfo, "WEBHOOK_PREFIX_ROTATED",
+			fmt.Sprintf("Webhook prefix %s rotated to %s (old prefix valid until %s)", old.Prefix, wp.Prefix, expiresAt.Format(time.RFC3339)),
+			map[string]interface{}{"ip_address": c.ClientIP()})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"message":        "Webhook prefix rotated",
+			"prefix":         wp.Prefix,
+			"url":            webhookURL(c, wp.Prefix),
+			"secret":         secret,
+			"old_prefix":     old.Prefix,
+			"old_expires_at": expiresAt,
+		})
+	}
+}
+
+// UpdateWebhookIPAllowlist sets the source IP allowlist of a webhook prefix
+func UpdateWebhookIPAllowlist(svc *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var input struct {
+			Prefix      string   `json:"prefix"`       // Mặc định: prefix active mới nhất
+			IPAllowlist []string `json:"ip_allowlist"` // IP, CIDR hoặc "tradingview"; rỗng = mọi IP
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		allowlist, err := services.NormalizeIPAllowlist(input.IPAllowlist)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var wp models.WebhookPrefix
+		query := svc.DB.Where("user_id = ? AND active = ?", userID, true)
+		if p := strings.ToLower(strings.TrimSpace(input.Prefix)); p != "" {
+			query = query.Where("prefix = ?", p)
+		}
+		if err := query.Order("created_at DESC").First(&wp).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "prefix not found"})
+			return
+		}
+
+		if err := svc.DB.Model(&wp).Update("ip_allowlist", allowlist).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update IP allowlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "IP allowlist updated",
+			"prefix":       wp.Prefix,
+			"ip_allowlist": services.SplitIPAllowlist(allowlist),
+			"presets":      gin.H{services.IPAllowlistPresetTradingView: services.TradingViewWebhookIPs},
+		})
+	}
+}
+
+// GetWebhookRejections lists rejected webhook attempts against the user's prefixes
+func GetWebhookRejections(services *services.Services) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		limit := 50
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 && l <= 500 {
+			limit = l
+		}
+
+		var rejections []models.WebhookRejection
+		if err := services.DB.Where("user_id = ?", userID).
+			Order("created_at DESC").Limit(limit).Find(&rejections).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook rejections"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"rejections": rejections, "total": len(rejections)})
+	}
+}
+
+// newWebhookSecret returns a new secret and its encrypted form for storage
+func newWebhookSecret() (string, string, error) {
+	secret, err := services.GenerateWebhookSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := utils.EncryptString(secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, encrypted, nil
+}
+
+// webhookURL builds the public webhook URL of a prefix
+func webhookURL(c *gin.Context, prefix string) string {
+	base := c.Request.Host
+	scheme := "http"
+	if strings.HasPrefix(base, "localhost:") || strings.HasPrefix(base, "127.0.0.1:") {
+		scheme = "http"
+	} else if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1/signals/webhook/%s", scheme, base, prefix)
+}
+
+// authenticateWebhook xác thực request webhook bằng secret của prefix (hoặc WEBHOOK_SECRET nếu không có prefix).
+// Trả về false nếu bị từ chối (response đã được ghi).
+func authenticateWebhook(c *gin.Context, svcs *services.Services, prefix, passphrase string) bool {
+	ip := c.ClientIP()
+	rawBody := webhookRawBody(c)
+	signature := c.GetHeader(services.WebhookSignatureHeader)
+
+	var wp *models.WebhookPrefix
+	var authErr error
+	if prefix != "" {
+		found, err := services.FindActiveWebhookPrefix(svcs.DB, prefix)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify webhook"})
+			return false
+		}
+		wp = found
+		// Chỉ chặn sau khi prefix hợp lệ: bộ đếm theo prefix + IP nên không ảnh hưởng user khác dùng chung IP
+		if wp != nil && webhookBlocked(c, prefix, ip) {
+			return false
+		}
+		if wp == nil {
+			authErr = services.ErrWebhookUnknownPrefix
+		} else if !services.IsIPAllowed(wp.IPAllowlist, ip) {
+			authErr = services.ErrWebhookIPNotAllowed
+		} else {
+			authErr = services.VerifyWebhookPrefix(wp, rawBody, signature, passphrase)
+		}
+	} else {
+		if webhookBlocked(c, "", ip) {
+			return false
+		}
+		secret, allowlist := "", ""
+		if svcs.Config != nil {
+			secret = svcs.Config.WebhookSecret
+			allowlist = svcs.Config.WebhookIPAllowlist
+		}
+		if secret == "" {
+			authErr = services.ErrWebhookNotConfigured
+		} else if !services.IsIPAllowed(allowlist, ip) {
+			authErr = services.ErrWebhookIPNotAllowed
+		} else {
+			authErr = services.VerifyWebhookSecret(secret, rawBody, signature, passphrase)
+		}
+	}
+
+	if authErr == nil {
+		return true
+	}
+
+	utils.LogWarn(fmt.Sprintf("🚫 Webhook rejected (prefix=%q, ip=%s): %v", prefix, ip, authErr))
+	services.RecordWebhookRejection(svcs.DB, wp, prefix, ip, c.Request.UserAgent(), authErr)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized webhook"})
+	return false
+}
+
+// webhookBlocked trả về true (và ghi 429) nếu prefix + IP vượt quá số lần xác thực lỗi
+func webhookBlocked(c *gin.Context, prefix, ip string) bool {
+	if !services.WebhookFailures().IsBlocked(services.WebhookFailureKey(prefix, ip)) {
+		return false
+	}
+	utils.LogWarn(fmt.Sprintf("🚫 Webhook from %s (prefix=%q) blocked: %v", ip, prefix, services.ErrWebhookTooManyFailures))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+	return true
+}
+
+// webhookRawBody returns the body cached by ShouldBindBodyWith
+func webhookRawBody(c *gin.Context) []byte {
+	if body, ok := c.Get(gin.BodyBytesKey); ok {
+		if raw, ok := body.([]byte); ok {
+			return raw
+		}
+	}
+	return nil
+}
+
+// sanitizeRawPayload bỏ passphrase/secret (ở mọi cấp) khỏi JSON trước khi lưu vào DB; số giữ nguyên độ chính xác
+func sanitizeRawPayload(raw []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return ""
+	}
+	redactWebhookSecrets(payload)
+	sanitized, _ := json.Marshal(payload)
+	return string(sanitized)
+}
+
+// redactWebhookSecrets xoá các key passphrase/secret trong object / array lồng nhau
+func redactWebhookSecrets(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if k := strings.ToLower(key); k == "passphrase" || k == "secret" {
+				delete(v, key)
+				continue
+			}
+			redactWebhookSecrets(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactWebhookSecrets(child)
+		}
+	}
+}
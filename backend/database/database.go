@@ -98,17 +98,31 @@ func InitRedis() *redis.Client {
 }
 
 func RunMigrations(db *gorm.DB) error {
+	// UserSignal: unique (user_id, signal_id) cũ chặn ghi nhiều bot cho cùng 1 signal → bỏ để dùng idx_user_signal_bot
+	if db.Migrator().HasIndex(&models.UserSignal{}, "idx_user_signal") {
+		if err := db.Migrator().DropIndex(&models.UserSignal{}, "idx_user_signal"); err != nil {
+			return fmt.Errorf("failed to drop old user signal index: %w", err)
+		}
+	}
+
 	// Auto migrate all models
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.ExchangeKey{},
 		&models.TradingConfig{},
 		&models.Order{},
+		&models.OrderEvent{},
+		&models.Position{},
+		&models.PositionFill{},
 		&models.Transaction{},
 		&models.Admin{},
 		&models.TradingSignal{},
 		&models.UserSignal{}, // 🆕 New table for user-specific signal status
 		&models.WebhookPrefix{},
+		&models.WebhookRejection{},
+		&models.WebhookPayloadTemplate{},
+		&models.SignalRoutingRule{},
+		&models.SignalJob{},
 		&models.SystemLog{},
 		&models.ExchangeAPIConfig{},
 		&models.TelegramConfig{},
@@ -118,6 +132,19 @@ func RunMigrations(db *gorm.DB) error {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	// UserSignal: bot_key (thay bot_config_id trong unique index) cho các record có trước cột này
+	if err := db.Exec("UPDATE user_signals SET bot_key = bot_config_id WHERE bot_key = 0 AND bot_config_id IS NOT NULL").Error; err != nil {
+		return fmt.Errorf("failed to backfill user signal bot keys: %w", err)
+	}
+
+	// Order status: chuẩn hoá dữ liệu cũ (FILLED, Canceled...) về chữ thường, "canceled" → "cancelled"
+	if err := db.Exec("UPDATE orders SET status = LOWER(status) WHERE status <> LOWER(status)").Error; err != nil {
+		return fmt.Errorf("failed to normalize order statuses: %w", err)
+	}
+	if err := db.Exec("UPDATE orders SET status = ? WHERE status = ?", "cancelled", "canceled").Error; err != nil {
+		return fmt.Errorf("failed to normalize order statuses: %w", err)
+	}
+
 	log.Println("✅ Database migrations completed")
 	return nil
 }
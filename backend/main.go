@@ -58,10 +58,20 @@ func main() {
 		log.Println("Warning: Failed to seed sample data:", err)
 	}
 
+	// Execution locks per exchange key + symbol (distributed when Redis is available)
+	services.InitExecutionLocks(redisClient)
+	// Rate limit webhook requests that fail authentication (per IP)
+	services.InitWebhookFailureLimiter(redisClient)
+	// Stale-signal rejection + dedup window
+	services.ConfigureSignalGuards(cfg.SignalMaxAge, cfg.SignalDedupWindow)
+	// Price cache từ Binance bookTicker/markPrice streams (chia sẻ qua Redis nếu có)
+	services.InitMarketData(db, redisClient).Start()
+
 	// Initialize services
 	svcs := &services.Services{
-		DB:    db,
-		Redis: redisClient,
+		Config: cfg,
+		DB:     db,
+		Redis:  redisClient,
 	}
 
 	// Initialize Telegram Service
@@ -92,14 +102,41 @@ func main() {
 	go wsHub.Run() // Run hub in background
 	log.Println("WebSocket Hub initialized")
 
+	// Vị thế futures: đẩy position_update qua hub, gắn các lệnh đang mở từ trước khi có bảng positions
+	services.SetPositionEventHub(wsHub)
+	services.BackfillPositions(db)
+
 	// Initialize Order Monitor Service (background worker)
 	orderMonitor := services.NewOrderMonitorService(db, wsHub)
 	svcs.OrderMonitor = orderMonitor
-	// orderMonitor.Start() // Start background monitoring
-	log.Println("✅ Order Monitor Service started (checking every 5 seconds)")
+
+	// User data streams do server giữ (không phụ thuộc tab trình duyệt): renew listen key, reconnect, resync
+	streamManager := services.NewStreamManager(db, wsHub)
+	streamManager.SetResync(orderMonitor.ResyncAccount)
+	wsHub.SetUserStreams(streamManager)
+	orderMonitor.SetStreamCoverage(streamManager)
+	svcs.StreamManager = streamManager
+	streamManager.Start()
+	log.Println("User stream manager started")
+
+	orderMonitor.Start() // Start background monitoring (bỏ qua account đã có user data stream đang kết nối)
+
+	// Initialize Signal Executor (auto-execute webhook signals for subscribed bots)
+	signalExecutor := services.NewSignalExecutor(db, wsHub, redisClient)
+	svcs.SignalExecutor = signalExecutor
+	signalExecutor.Start()
+
+	// Đối soát định kỳ sàn ↔ DB (vị thế, lệnh mở, SL/TP algo)
+	reconciliation := services.NewReconciliationService(db, cfg.ReconcileInterval, cfg.ReconcileReplaceStops)
+	svcs.Reconciliation = reconciliation
+	reconciliation.Start()
 
 	// Setup Gin router
 	router := gin.Default()
+	// Chỉ tin X-Forwarded-For từ proxy cấu hình sẵn → c.ClientIP() không bị giả mạo
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	// Middleware
 	router.Use(middleware.CORS())
@@ -0,0 +1,10 @@
+package models
+
+import "github.com/shopspring/decimal"
+
+// Giá, khối lượng và PnL của Order/TradingSignal dùng decimal.Decimal để tránh sai số float64.
+// decimal.Decimal implement sẵn sql.Scanner / driver.Valuer nên map thẳng vào các cột decimal(20,8) hiện có.
+func init() {
+	// Giữ JSON dạng number (không có dấu ngoặc kép) để frontend không phải thay đổi
+	decimal.MarshalJSONWithoutQuotes = true
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -12,7 +13,8 @@ type User struct {
 	PasswordHash    string         `gorm:"not null;size:255" json:"-"`
 	FullName        string         `gorm:"size:255" json:"full_name"`
 	Phone           string         `gorm:"size:50" json:"phone"`
-	ChatID          string         `gorm:"size:100" json:"chat_id"` // Telegram Chat ID
+	ChatID          string         `gorm:"size:100" json:"chat_id"`             // Telegram Chat ID
+	Timezone        string         `gorm:"size:64;default:UTC" json:"timezone"` // IANA timezone (vd Asia/Ho_Chi_Minh), dùng cho khung giờ giao dịch
 	Status          string         `gorm:"size:50;default:active" json:"status"`
 	SubscriptionEnd *time.Time     `json:"subscription_end"`
 	CreatedAt       time.Time      `json:"created_at"`
@@ -65,45 +67,81 @@ type TradingConfig struct {
 	CallbackRate        float64        `gorm:"type:decimal(10,2);default:1" json:"callback_rate"`         // Callback rate for trailing stop (0.1-5%)
 	IsDefault           bool           `gorm:"default:false" json:"is_default"`                           // Only one default bot per user
 	IsActive            bool           `gorm:"default:true" json:"is_active"`
+	AutoExecute         bool           `gorm:"default:false;index" json:"auto_execute"` // Tự đặt lệnh khi signal khớp prefix/strategy + symbol của bot
+	AutoExecutePrefix   string         `gorm:"size:64" json:"auto_execute_prefix"`      // Empty = any webhook prefix of the user
+	AutoExecuteStrategy string         `gorm:"size:100" json:"auto_execute_strategy"`   // Empty = any strategy
+	IPWhitelist         string         `gorm:"type:text" json:"ip_whitelist"`           // IP/CIDR/"tradingview" được gửi signal cho bot (cùng format IPAllowlist); rỗng = mọi IP
 	CreatedAt           time.Time      `json:"created_at"`
 	UpdatedAt           time.Time      `json:"updated_at"`
 	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 
+	// Override từ payload signal (size, leverage, order type, SL/TP %, trailing); giới hạn 0 = không giới hạn thêm
+	AllowSignalOverrides       bool    `gorm:"default:false" json:"allow_signal_overrides"`
+	SignalMaxQuantity          float64 `gorm:"type:decimal(20,8);default:0" json:"signal_max_quantity"`
+	SignalMaxQuoteSize         float64 `gorm:"type:decimal(20,2);default:0" json:"signal_max_quote_size"`
+	SignalMaxLeverage          int     `gorm:"default:0" json:"signal_max_leverage"`
+	SignalMaxStopLossPercent   float64 `gorm:"type:decimal(10,2);default:0" json:"signal_max_stop_loss_percent"`
+	SignalMaxTakeProfitPercent float64 `gorm:"type:decimal(10,2);default:0" json:"signal_max_take_profit_percent"`
+	SignalAllowLimitOrders     bool    `gorm:"default:false" json:"signal_allow_limit_orders"`
+
+	// Signal filters: kiểm tra trước khi mở vị thế theo signal (0/rỗng = tắt), giờ tính theo timezone của user
+	SignalTradingDays             string  `gorm:"size:50" json:"signal_trading_days"`                                    // vd "mon,tue,wed,thu,fri"
+	SignalTradingHours            string  `gorm:"size:100" json:"signal_trading_hours"`                                  // vd "08:00-12:00,22:00-02:00"
+	SignalCooldownMinutes         int     `gorm:"default:0" json:"signal_cooldown_minutes"`                              // Sau mỗi lệnh cùng symbol
+	SignalStopLossCooldownMinutes int     `gorm:"default:0" json:"signal_stop_loss_cooldown_minutes"`                    // Sau khi vị thế cùng symbol đóng lỗ
+	SignalMaxPerHour              int     `gorm:"default:0" json:"signal_max_per_hour"`                                  // Số signal thực thi tối đa trong 1 giờ
+	SignalMaxPerDay               int     `gorm:"default:0" json:"signal_max_per_day"`                                   // Số signal thực thi tối đa trong 24 giờ
+	SignalMinPriceDistancePercent float64 `gorm:"type:decimal(10,4);default:0" json:"signal_min_price_distance_percent"` // Khoảng cách tối thiểu giữa giá signal và giá hiện tại
+	SignalMaxPriceDistancePercent float64 `gorm:"type:decimal(10,4);default:0" json:"signal_max_price_distance_percent"` // Khoảng cách tối đa (chống vào lệnh khi giá đã chạy xa)
+
 	// Relationships
 	User User `gorm:"foreignKey:UserID" json:"-"`
 }
 
 type Order struct {
-	ID               uint    `gorm:"primaryKey" json:"id"`
-	UserID           uint    `gorm:"not null;index" json:"user_id"`
-	ExchangeKeyID    uint    `gorm:"index" json:"exchange_key_id"` // Link to ExchangeKey (API Key)
-	BotConfigID      uint    `gorm:"index" json:"bot_config_id"`   // Link to TradingConfig
-	Exchange         string  `gorm:"not null;size:50" json:"exchange"`
-	Symbol           string  `gorm:"not null;size:50" json:"symbol"`
-	OrderID          string  `gorm:"size:255;index" json:"order_id"`  // Exchange's order ID
-	ClientOrderID    string  `gorm:"size:255" json:"client_order_id"` // Our generated order ID
-	Side             string  `gorm:"not null;size:10" json:"side"`
-	Type             string  `gorm:"not null;size:20" json:"type"`
-	Quantity         float64 `gorm:"type:decimal(20,8)" json:"quantity"`
-	Price            float64 `gorm:"type:decimal(20,8)" json:"price"`
-	FilledPrice      float64 `gorm:"type:decimal(20,8)" json:"filled_price"`
-	FilledQuantity   float64 `gorm:"type:decimal(20,8)" json:"filled_quantity"` // Executed quantity
-	CurrentPrice     float64 `gorm:"type:decimal(20,8)" json:"current_price"`   // Current market price from exchange
-	Status           string  `gorm:"size:50;default:pending" json:"status"`
-	TradingMode      string  `gorm:"size:20;default:spot" json:"trading_mode"` // spot, futures, margin
-	Leverage         int     `gorm:"default:1" json:"leverage"`
-	StopLossPrice    float64 `gorm:"type:decimal(20,8)" json:"stop_loss_price"`
-	TakeProfitPrice  float64 `gorm:"type:decimal(20,8)" json:"take_profit_price"`
-	AlgoIDStopLoss   string  `gorm:"size:100" json:"algo_id_stop_loss"`   // Binance Algo Order ID for Stop Loss
-	AlgoIDTakeProfit string  `gorm:"size:100" json:"algo_id_take_profit"` // Binance Algo Order ID for Take Profit
-	PnL              float64 `gorm:"type:decimal(20,8)" json:"pnl"`
-	PnLPercent       float64 `gorm:"type:decimal(10,2)" json:"pnl_percent"`
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	UserID           uint            `gorm:"not null;index" json:"user_id"`
+	ExchangeKeyID    uint            `gorm:"index" json:"exchange_key_id"` // Link to ExchangeKey (API Key)
+	BotConfigID      uint            `gorm:"index" json:"bot_config_id"`   // Link to TradingConfig
+	SignalID         *uint           `gorm:"index" json:"signal_id"`       // Link to TradingSignal (nil for manual orders)
+	PositionID       *uint           `gorm:"index" json:"position_id"`     // Link to Position (futures: lệnh vào, thêm, đóng của cùng vị thế)
+	Exchange         string          `gorm:"not null;size:50" json:"exchange"`
+	Symbol           string          `gorm:"not null;size:50" json:"symbol"`
+	OrderID          string          `gorm:"size:255;index" json:"order_id"`        // Exchange's order ID
+	ClientOrderID    string          `gorm:"size:255;index" json:"client_order_id"` // Our generated order ID (idempotency key sent as newClientOrderId)
+	Side             string          `gorm:"not null;size:10" json:"side"`
+	Type             string          `gorm:"not null;size:20" json:"type"`
+	Quantity         decimal.Decimal `gorm:"type:decimal(20,8)" json:"quantity"`
+	Price            decimal.Decimal `gorm:"type:decimal(20,8)" json:"price"`
+	FilledPrice      decimal.Decimal `gorm:"type:decimal(20,8)" json:"filled_price"`
+	FilledQuantity   decimal.Decimal `gorm:"type:decimal(20,8)" json:"filled_quantity"` // Executed quantity
+	CurrentPrice     decimal.Decimal `gorm:"type:decimal(20,8)" json:"current_price"`   // Current market price from exchange
+	Status           string          `gorm:"size:50;default:pending" json:"status"`
+	TradingMode      string          `gorm:"size:20;default:spot" json:"trading_mode"` // spot, futures, margin
+	Leverage         int             `gorm:"default:1" json:"leverage"`
+	StopLossPrice    decimal.Decimal `gorm:"type:decimal(20,8)" json:"stop_loss_price"`
+	TakeProfitPrice  decimal.Decimal `gorm:"type:decimal(20,8)" json:"take_profit_price"`
+	AlgoIDStopLoss   string          `gorm:"size:100" json:"algo_id_stop_loss"`   // Binance Algo Order ID for Stop Loss
+	AlgoIDTakeProfit string          `gorm:"size:100" json:"algo_id_take_profit"` // Binance Algo Order ID for Take Profit
+	PnL              decimal.Decimal `gorm:"type:decimal(20,8)" json:"pnl"`
+	PnLPercent       decimal.Decimal `gorm:"type:decimal(10,2)" json:"pnl_percent"`
 
 	// Position Info (for Futures) - Not storing position_amt and mark_price as they change constantly
-	PositionSide     string  `gorm:"size:20" json:"position_side"`                // LONG/SHORT/BOTH
-	LiquidationPrice float64 `gorm:"type:decimal(20,8)" json:"liquidation_price"` // Liquidation price
-	MarginType       string  `gorm:"size:20" json:"margin_type"`                  // isolated/cross
-	IsolatedMargin   float64 `gorm:"type:decimal(20,8)" json:"isolated_margin"`   // Margin for isolated mode
+	PositionSide     string          `gorm:"size:20" json:"position_side"`                // LONG/SHORT/BOTH
+	LiquidationPrice decimal.Decimal `gorm:"type:decimal(20,8)" json:"liquidation_price"` // Liquidation price
+	MarginType       string          `gorm:"size:20" json:"margin_type"`                  // isolated/cross
+	IsolatedMargin   decimal.Decimal `gorm:"type:decimal(20,8)" json:"isolated_margin"`   // Margin for isolated mode
+
+	// Fills from the futures user data stream (cộng dồn theo từng trade)
+	RealizedPnL     decimal.Decimal `gorm:"type:decimal(20,8)" json:"realized_pnl"`
+	Commission      decimal.Decimal `gorm:"type:decimal(20,8)" json:"commission"`
+	CommissionAsset string          `gorm:"size:20" json:"commission_asset"`
+
+	// Close accounting: ghi lúc vị thế đóng (userTrades / income hoặc stream event)
+	FundingFee decimal.Decimal `gorm:"type:decimal(20,8)" json:"funding_fee"`
+	ExitPrice  decimal.Decimal `gorm:"type:decimal(20,8)" json:"exit_price"`
+	ExitReason string          `gorm:"size:20" json:"exit_reason"` // stop_loss, take_profit, trailing_stop, manual, liquidation, signal
+	ClosedAt   *time.Time      `gorm:"index" json:"closed_at"`
 
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -113,6 +151,77 @@ type Order struct {
 	User User `gorm:"foreignKey:UserID" json:"-"`
 }
 
+// OrderEvent is an append-only history entry of an order: trạng thái cũ/mới, fill, nguồn thay đổi và payload thô của sàn
+type OrderEvent struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	OrderID        uint            `gorm:"not null;index" json:"order_id"`
+	UserID         uint            `gorm:"not null;index" json:"user_id"`
+	Event          string          `gorm:"size:30;not null" json:"event"`  // created, status_changed, fill, protection_changed
+	Source         string          `gorm:"size:30;not null" json:"source"` // manual, telegram, signal, order_monitor, user_stream, ...
+	Actor          string          `gorm:"size:100" json:"actor"`          // user:5, bot:12, system
+	OldStatus      string          `gorm:"size:50" json:"old_status"`
+	NewStatus      string          `gorm:"size:50" json:"new_status"`
+	FilledQtyDelta decimal.Decimal `gorm:"type:decimal(20,8)" json:"filled_qty_delta"`
+	FilledQuantity decimal.Decimal `gorm:"type:decimal(20,8)" json:"filled_quantity"`
+	FilledPrice    decimal.Decimal `gorm:"type:decimal(20,8)" json:"filled_price"`
+	Message        string          `gorm:"type:text" json:"message"`
+	Payload        string          `gorm:"type:text" json:"payload,omitempty"` // Raw exchange payload (JSON)
+	CreatedAt      time.Time       `gorm:"index" json:"created_at"`
+}
+
+// Position is one futures position; có thể gồm nhiều lệnh (vào, nhồi thêm, đóng một phần, SL/TP)
+type Position struct {
+	ID               uint            `gorm:"primaryKey" json:"id"`
+	UserID           uint            `gorm:"not null;index" json:"user_id"`
+	BotConfigID      uint            `gorm:"index" json:"bot_config_id"`
+	ExchangeKeyID    uint            `gorm:"index" json:"exchange_key_id"`
+	Exchange         string          `gorm:"not null;size:50" json:"exchange"`
+	Symbol           string          `gorm:"not null;size:50;index" json:"symbol"`
+	TradingMode      string          `gorm:"size:20;default:futures" json:"trading_mode"`
+	Side             string          `gorm:"not null;size:10" json:"side"`                // LONG / SHORT
+	Status           string          `gorm:"size:20;default:open;index" json:"status"`    // open, scaling, closing, closed
+	Quantity         decimal.Decimal `gorm:"type:decimal(20,8)" json:"quantity"`          // Khối lượng đang mở
+	MaxQuantity      decimal.Decimal `gorm:"type:decimal(20,8)" json:"max_quantity"`      // Khối lượng lớn nhất từng đạt
+	EntryPrice       decimal.Decimal `gorm:"type:decimal(20,8)" json:"entry_price"`       // Giá vào trung bình
+	ExitPrice        decimal.Decimal `gorm:"type:decimal(20,8)" json:"exit_price"`        // Giá thoát trung bình
+	MarkPrice        decimal.Decimal `gorm:"type:decimal(20,8)" json:"mark_price"`        // Lần đồng bộ gần nhất
+	LiquidationPrice decimal.Decimal `gorm:"type:decimal(20,8)" json:"liquidation_price"` // Liquidation price
+	Leverage         int             `gorm:"default:1" json:"leverage"`
+	MarginType       string          `gorm:"size:20" json:"margin_type"` // isolated/cross
+	IsolatedMargin   decimal.Decimal `gorm:"type:decimal(20,8)" json:"isolated_margin"`
+	UnrealizedPnL    decimal.Decimal `gorm:"type:decimal(20,8)" json:"unrealized_pnl"`
+	RealizedPnL      decimal.Decimal `gorm:"type:decimal(20,8)" json:"realized_pnl"`
+	Commission       decimal.Decimal `gorm:"type:decimal(20,8)" json:"commission"`
+	FundingFee       decimal.Decimal `gorm:"type:decimal(20,8)" json:"funding_fee"`
+	ExitReason       string          `gorm:"size:20" json:"exit_reason"`
+	OpenedAt         time.Time       `gorm:"index" json:"opened_at"`
+	ClosedAt         *time.Time      `gorm:"index" json:"closed_at"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+
+	// Relationships
+	User   User           `gorm:"foreignKey:UserID" json:"-"`
+	Orders []Order        `gorm:"foreignKey:PositionID" json:"orders,omitempty"`
+	Fills  []PositionFill `gorm:"foreignKey:PositionID" json:"fills,omitempty"`
+}
+
+// PositionFill is one exchange trade that changed a position (từ user data stream hoặc userTrades lúc đối soát)
+type PositionFill struct {
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	PositionID      uint            `gorm:"not null;uniqueIndex:idx_position_fill_trade" json:"position_id"`
+	OrderID         *uint           `gorm:"index" json:"order_id"`                               // Lệnh trong DB (nil: SL/TP đã kích hoạt, đóng tay trên sàn)
+	ExchangeOrderID string          `gorm:"size:255" json:"exchange_order_id"`                   // Order ID trên sàn
+	TradeID         int64           `gorm:"uniqueIndex:idx_position_fill_trade" json:"trade_id"` // Trade ID của sàn (chống ghi trùng)
+	Side            string          `gorm:"size:10" json:"side"`                                 // BUY / SELL
+	Quantity        decimal.Decimal `gorm:"type:decimal(20,8)" json:"quantity"`
+	Price           decimal.Decimal `gorm:"type:decimal(20,8)" json:"price"`
+	RealizedPnL     decimal.Decimal `gorm:"type:decimal(20,8)" json:"realized_pnl"`
+	Commission      decimal.Decimal `gorm:"type:decimal(20,8)" json:"commission"`
+	CommissionAsset string          `gorm:"size:20" json:"commission_asset"`
+	FilledAt        time.Time       `gorm:"index" json:"filled_at"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
 type Transaction struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
 	UserID      uint           `gorm:"not null;index" json:"user_id"`
@@ -138,36 +247,50 @@ type Admin struct {
 }
 
 type TradingSignal struct {
-	ID            uint      `gorm:"primaryKey" json:"id"`
-	Symbol        string    `gorm:"not null;size:50;index" json:"symbol"`
-	Action        string    `gorm:"not null;size:20" json:"action"` // buy, sell, close
-	Price         float64   `gorm:"type:decimal(20,8)" json:"price"`
-	StopLoss      float64   `gorm:"type:decimal(20,8)" json:"stop_loss"`
-	TakeProfit    float64   `gorm:"type:decimal(20,8)" json:"take_profit"`
-	Message       string    `gorm:"type:text" json:"message"`
-	Strategy      string    `gorm:"size:100" json:"strategy"`
-	WebhookPrefix string    `gorm:"size:64;index" json:"webhook_prefix"`
-	ReceivedAt    time.Time `gorm:"not null;index" json:"received_at"`
-	RawPayload    string    `gorm:"type:text" json:"raw_payload"` // Store original webhook JSON
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	Symbol        string          `gorm:"not null;size:50;index" json:"symbol"`
+	Action        string          `gorm:"not null;size:20" json:"action"` // buy, sell, close_long, close_short, close, reverse, partial_close
+	Price         decimal.Decimal `gorm:"type:decimal(20,8)" json:"price"`
+	StopLoss      decimal.Decimal `gorm:"type:decimal(20,8)" json:"stop_loss"`
+	TakeProfit    decimal.Decimal `gorm:"type:decimal(20,8)" json:"take_profit"`
+	ClosePercent  decimal.Decimal `gorm:"type:decimal(10,4)" json:"close_percent"` // % vị thế cần đóng (partial_close)
+	Overrides     string          `gorm:"type:text" json:"overrides"`              // JSON PayloadOverrides (size, leverage, order type, SL/TP %, trailing)
+	Message       string          `gorm:"type:text" json:"message"`
+	Strategy      string          `gorm:"size:100" json:"strategy"`
+	WebhookPrefix string          `gorm:"size:64;index" json:"webhook_prefix"`
+	ReceivedAt    time.Time       `gorm:"not null;index" json:"received_at"`
+	RawPayload    string          `gorm:"type:text" json:"raw_payload"`      // Store original webhook JSON
+	ExternalID    string          `gorm:"size:128;index" json:"external_id"` // signal_id do vendor gửi (idempotency key)
+	DedupKey      string          `gorm:"size:64;index" json:"-"`            // Hash dùng để chống signal trùng
+	SignalTime    *time.Time      `json:"signal_time"`                       // Timestamp trong payload
+	RejectReason  string          `gorm:"size:255" json:"reject_reason"`     // Lý do bị từ chối (rỗng = hợp lệ)
+	SourceIP      string          `gorm:"size:64" json:"source_ip"`          // IP gửi webhook (sau khi xử lý X-Forwarded-For của proxy tin cậy)
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
 
 	// Relationships
 	UserSignals []UserSignal `gorm:"foreignKey:SignalID;constraint:OnDelete:CASCADE" json:"user_signals,omitempty"`
 }
 
-// UserSignal tracks each user's interaction with a signal (many-to-many with status)
+// UserSignal tracks each user's interaction with a signal (many-to-many with status).
+// Mỗi bot thực thi signal có 1 record riêng (unique theo user_id + signal_id + bot_key);
+// record không có bot_config_id (bot_key = 0) là trạng thái chung của user (vd. ignored).
 type UserSignal struct {
-	ID          uint       `gorm:"primaryKey" json:"id"`
-	UserID      uint       `gorm:"not null;index:idx_user_signal,unique" json:"user_id"`
-	SignalID    uint       `gorm:"not null;index:idx_user_signal,unique;index" json:"signal_id"`
-	Status      string     `gorm:"size:20;default:pending;index" json:"status"` // pending, executed, failed, ignored
-	BotConfigID *uint      `gorm:"index" json:"bot_config_id"`                  // Which bot config was used
-	OrderID     *uint      `gorm:"index" json:"order_id"`                       // Link to order if executed
-	ExecutedAt  *time.Time `json:"executed_at"`
-	ErrorMsg    string     `gorm:"type:text" json:"error_msg"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	UserID      uint   `gorm:"not null;index:idx_user_signal_bot,unique" json:"user_id"`
+	SignalID    uint   `gorm:"not null;index:idx_user_signal_bot,unique;index" json:"signal_id"`
+	Status      string `gorm:"size:20;default:pending;index" json:"status"` // pending, executed, failed, ignored
+	BotConfigID *uint  `gorm:"index" json:"bot_config_id"`                  // Which bot config was used
+	OrderID     *uint  `gorm:"index" json:"order_id"`                       // Link to order if executed
+	// bot_config_id hoặc 0: NULL không bao giờ trùng trong unique index (MySQL/Postgres) nên cần cột NOT NULL để dedupe
+	BotKey     uint       `gorm:"not null;default:0;index:idx_user_signal_bot,unique" json:"-"`
+	ExecutedAt *time.Time `json:"executed_at"`
+	ErrorMsg   string     `gorm:"type:text" json:"error_msg"`
+	BlockedBy  string     `gorm:"size:50" json:"blocked_by,omitempty"` // Filter của bot đã chặn signal (status ignored)
+	// Override trong payload bị bỏ qua (bot không bật allow_signal_overrides), cách nhau bởi dấu phẩy
+	IgnoredOverrides string    `gorm:"size:255" json:"ignored_overrides,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 
 	// Relationships
 	User   User           `gorm:"foreignKey:UserID" json:"-"`
@@ -178,18 +301,97 @@ type UserSignal struct {
 
 // WebhookPrefix associates a unique webhook prefix with a user
 type WebhookPrefix struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	UserID    uint           `gorm:"not null;index" json:"user_id"`
-	Prefix    string         `gorm:"uniqueIndex;size:64;not null" json:"prefix"`
-	Active    bool           `gorm:"default:true" json:"active"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	UserID      uint           `gorm:"not null;index" json:"user_id"`
+	Prefix      string         `gorm:"uniqueIndex;size:64;not null" json:"prefix"`
+	Secret      string         `gorm:"size:255" json:"-"` // Encrypted; passphrase trong body hoặc key ký HMAC (rỗng = prefix cũ, bị từ chối tới khi rotate)
+	Active      bool           `gorm:"default:true" json:"active"`
+	ExpiresAt   *time.Time     `gorm:"index" json:"expires_at"`       // Set khi rotate: prefix cũ còn hiệu lực tới thời điểm này
+	ReplacedBy  string         `gorm:"size:64" json:"replaced_by"`    // Set khi rotate: prefix mới thay thế (signal qua prefix cũ được route như prefix mới)
+	IPAllowlist string         `gorm:"type:text" json:"ip_allowlist"` // IP/CIDR phân cách bởi dấu phẩy, "tradingview" = IP của TradingView; rỗng = mọi IP
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Relationships
 	User User `gorm:"foreignKey:UserID" json:"-"`
 }
 
+// WebhookPayloadTemplate maps a third-party webhook payload onto the signal fields (1 template / prefix)
+type WebhookPayloadTemplate struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	UserID        uint      `gorm:"not null;index" json:"user_id"`
+	Prefix        string    `gorm:"uniqueIndex;size:64;not null" json:"prefix"`
+	Name          string    `gorm:"size:100" json:"name"`
+	Format        string    `gorm:"size:10;default:'json'" json:"format"` // json, text
+	FieldMappings string    `gorm:"type:text" json:"field_mappings"`      // JSON: field → JSONPath (vd: {"symbol": "$.data.ticker"})
+	ValueMappings string    `gorm:"type:text" json:"value_mappings"`      // JSON: field → {giá trị gốc: giá trị mới} (vd: {"action": {"long": "buy"}})
+	TextPatterns  string    `gorm:"type:text" json:"text_patterns"`       // JSON: field → regex trên body (group 1 hoặc (?P<value>...))
+	Defaults      string    `gorm:"type:text" json:"defaults"`            // JSON: field → giá trị mặc định
+	Active        bool      `gorm:"default:true" json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// WebhookRejection records a webhook request rejected by authentication
+type WebhookRejection struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    *uint     `gorm:"index" json:"user_id"` // Owner of the prefix (nil nếu prefix không tồn tại)
+	Prefix    string    `gorm:"size:64;index" json:"prefix"`
+	IPAddress string    `gorm:"size:45;index" json:"ip_address"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	Reason    string    `gorm:"size:255" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// SignalRoutingRule maps incoming signals to bot configs of the rule owner (evaluated on receipt, by priority)
+type SignalRoutingRule struct {
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	UserID            uint           `gorm:"not null;index" json:"user_id"`
+	Name              string         `gorm:"size:100" json:"name"`
+	Priority          int            `gorm:"default:0;index" json:"priority"` // Nhỏ hơn = xét trước
+	Active            bool           `gorm:"default:true" json:"active"`
+	StopOnMatch       bool           `gorm:"default:false" json:"stop_on_match"`                  // Khớp rule này thì không xét các rule sau
+	MatchPrefix       string         `gorm:"size:64" json:"match_prefix"`                         // Empty = any webhook prefix of the user
+	MatchStrategy     string         `gorm:"size:100" json:"match_strategy"`                      // Case-insensitive, empty = any
+	MatchSymbol       string         `gorm:"size:255" json:"match_symbol"`                        // Comma-separated, wildcard allowed (vd: "BTC*,ETHUSDT")
+	MatchAction       string         `gorm:"size:100" json:"match_action"`                        // Comma-separated (vd: "buy,long")
+	MessageRegex      string         `gorm:"size:255" json:"message_regex"`                       // Regex trên message của signal
+	CustomFields      string         `gorm:"type:text" json:"custom_fields"`                      // JSON object: field (dot path) → expected value
+	BotConfigIDs      string         `gorm:"type:text;not null" json:"bot_config_ids"`            // JSON array of TradingConfig IDs
+	SizeMultiplier    float64        `gorm:"type:decimal(10,4);default:0" json:"size_multiplier"` // 0 = giữ amount của bot
+	Leverage          int            `gorm:"default:0" json:"leverage"`                           // 0 = giữ leverage của bot
+	StopLossPercent   *float64       `gorm:"type:decimal(10,2)" json:"stop_loss_percent"`         // nil = giữ SL của bot
+	TakeProfitPercent *float64       `gorm:"type:decimal(10,2)" json:"take_profit_percent"`       // nil = giữ TP của bot
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// SignalJob is a durable unit of signal work (fan-out, Telegram broadcast, bot execution) processed by the signal queue
+type SignalJob struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Kind        string     `gorm:"size:20;not null;index" json:"kind"`    // fanout, telegram, execute
+	DedupKey    string     `gorm:"size:128;uniqueIndex" json:"dedup_key"` // Chống tạo trùng job (vd: execute:<signal>:<bot>)
+	SignalID    uint       `gorm:"not null;index" json:"signal_id"`
+	UserID      *uint      `gorm:"index" json:"user_id"`
+	BotConfigID *uint      `gorm:"index" json:"bot_config_id"`
+	Payload     string     `gorm:"type:text" json:"payload"`                    // JSON theo kind (execute: SignalTarget)
+	Status      string     `gorm:"size:20;default:pending;index" json:"status"` // pending, running, done, dead
+	Attempts    int        `gorm:"default:0" json:"attempts"`
+	MaxAttempts int        `gorm:"default:5" json:"max_attempts"`
+	NextRunAt   time.Time  `gorm:"index" json:"next_run_at"`
+	LastError   string     `gorm:"type:text" json:"last_error"`
+	LockedBy    string     `gorm:"size:100" json:"locked_by"` // Worker đang chạy job (hostname:pid)
+	LockedAt    *time.Time `json:"locked_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
 // SystemLog stores system activity logs for user actions
 type SystemLog struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
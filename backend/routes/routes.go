@@ -79,11 +79,11 @@ func SetupRoutes(router *gin.Engine, services *services.Services, wsHub *service
 		// Prefix: /api/v1/webhook
 		webhook := v1.Group("/webhook")
 		{
-			webhook.POST("/binance", controllers.HandleBinanceWebhook(services))         // Binance webhook
-			webhook.POST("/tradingview", controllers.HandleTradingViewWebhook(services)) // TradingView alerts
-			webhook.POST("/price-alert", controllers.HandlePriceAlert(services))         // Price alerts
-			webhook.GET("/logs", controllers.GetWebhookLogs(services))                   // Get webhook logs
-			webhook.POST("/create", controllers.CreateWebhook(services))                 // Create webhook URL
+			webhook.POST("/binance", controllers.HandleBinanceWebhook(services))                    // Binance webhook
+			webhook.POST("/tradingview", controllers.HandleTradingViewWebhook(services))            // TradingView alerts
+			webhook.POST("/price-alert", controllers.HandlePriceAlert(services))                    // Price alerts
+			webhook.GET("/logs", middleware.AuthMiddleware(), controllers.GetWebhookLogs(services)) // Get webhook logs (received + blocked)
+			webhook.POST("/create", controllers.CreateWebhook(services))                            // Create webhook URL
 		}
 
 		// ============ ORDERS ROUTES ============
@@ -91,11 +91,23 @@ func SetupRoutes(router *gin.Engine, services *services.Services, wsHub *service
 		orders := v1.Group("/orders")
 		orders.Use(middleware.AuthMiddleware())
 		{
-			orders.GET("", controllers.GetOrders(services))                      // List all orders
-			orders.GET("/history", controllers.GetOrderHistory(services))        // Get order history with filtering
-			orders.GET("/completed", controllers.GetCompletedOrders(services))   // Get completed orders (filled/closed)
-			orders.GET("/:id", controllers.GetOrder(services))                   // Get single order
-			orders.POST("/close/:id", controllers.CloseOrdersBySymbol(services)) // Close all orders and position by symbol
+			orders.GET("", controllers.GetOrders(services))                           // List all orders
+			orders.GET("/history", controllers.GetOrderHistory(services))             // Get order history with filtering
+			orders.GET("/completed", controllers.GetCompletedOrders(services))        // Get completed orders (filled/closed)
+			orders.GET("/:id", controllers.GetOrder(services))                        // Get single order
+			orders.GET("/:id/timeline", controllers.GetOrderTimeline(services))       // Get order status / fill history
+			orders.PUT("/:id/protection", controllers.AmendOrderProtection(services)) // Amend / trail / remove SL-TP algo orders
+			orders.POST("/close/:id", controllers.CloseOrdersBySymbol(services))      // Close all orders and position by symbol
+		}
+
+		// ============ POSITIONS ROUTES ============
+		// Prefix: /api/v1/positions
+		positions := v1.Group("/positions")
+		positions.Use(middleware.AuthMiddleware())
+		{
+			positions.GET("", controllers.GetPositions(services))                    // List positions (filter: status, symbol, bot_config_id)
+			positions.GET("/:id", controllers.GetPosition(services))                 // Get position with linked orders and fills
+			positions.POST("/:id/close", controllers.PartialClosePosition(services)) // Close part of a position (reduce-only market/limit)
 		}
 
 		// ============ MONITORING ROUTES ============
@@ -132,6 +144,8 @@ func SetupRoutes(router *gin.Engine, services *services.Services, wsHub *service
 			trading.GET("/ws", controllers.ConnectWebSocket(services, wsHub))                     // WebSocket upgrade
 			trading.POST("/listen-key/:exchange_key_id", controllers.CreateListenKey(services))   // Create listen key
 			trading.PUT("/listen-key/:exchange_key_id", controllers.KeepAliveListenKey(services)) // Keep alive listen key
+			trading.GET("/streams", controllers.GetUserStreamHealth(services))                    // Server-side user data stream health
+			trading.GET("/reconciliation", controllers.GetReconciliationReports(services))        // Latest exchange ↔ DB reconciliation reports
 
 			// Legacy config routes (kept for backward compatibility)
 			trading.GET("/configs", controllers.GetTradingConfigs(services))
@@ -197,9 +211,20 @@ func SetupRoutes(router *gin.Engine, services *services.Services, wsHub *service
 			adminAuth := admin.Group("")
 			adminAuth.Use(middleware.AdminAuthMiddleware())
 			{
-				adminAuth.GET("/profile", controllers.GetAdminProfile(services))      // Get admin profile
-				adminAuth.PUT("/profile", controllers.UpdateAdminProfile(services))   // Update admin profile
-				adminAuth.PUT("/password", controllers.ChangeAdminPassword(services)) // Change admin password
+				adminAuth.GET("/profile", controllers.GetAdminProfile(services))                          // Get admin profile
+				adminAuth.PUT("/profile", controllers.UpdateAdminProfile(services))                       // Update admin profile
+				adminAuth.PUT("/password", controllers.ChangeAdminPassword(services))                     // Change admin password
+				adminAuth.GET("/execution-locks", controllers.GetExecutionLocks(services))                // Held per-symbol execution locks
+				adminAuth.GET("/order-monitor", controllers.GetOrderMonitorMetrics(services))             // Order monitor run metrics
+				adminAuth.GET("/user-streams", controllers.GetUserStreamsAdmin(services))                 // Server-side user data stream health
+				adminAuth.GET("/market-data", controllers.GetMarketDataStatus(services))                  // Market data streams + price cache
+				adminAuth.GET("/reconciliation", controllers.GetReconciliationReportsAdmin(services))     // Exchange ↔ DB reconciliation reports
+				adminAuth.POST("/reconciliation/run", controllers.RunReconciliation(services))            // Run reconciliation now
+				adminAuth.GET("/signal-jobs", controllers.GetSignalJobs(services))                        // Signal queue jobs + stats (dead-letter: ?status=dead)
+				adminAuth.POST("/signal-jobs/:id/redrive", controllers.RedriveSignalJob(services))        // Re-queue a dead job
+				adminAuth.GET("/user-signals/failed", controllers.GetFailedUserSignals(services))         // Failed signal executions
+				adminAuth.POST("/user-signals/:id/redrive", controllers.RedriveUserSignalAdmin(services)) // Re-drive a failed execution
+				adminAuth.GET("/analytics/signals", controllers.GetSignalAnalyticsAdmin(services))        // Strategy / prefix performance (all users)
 			}
 		}
 
@@ -216,18 +241,41 @@ func SetupRoutes(router *gin.Engine, services *services.Services, wsHub *service
 			signalsAuth := signals.Group("")
 			signalsAuth.Use(middleware.AuthMiddleware())
 			{
-				signalsAuth.GET("", controllers.ListSignals(services))                   // List signals with user-specific status
-				signalsAuth.GET("/:id", controllers.GetSignal(services))                 // Get single signal
-				signalsAuth.POST("/:id/execute", controllers.ExecuteSignal(services))    // Execute signal with bot config
-				signalsAuth.PUT("/:id/status", controllers.UpdateSignalStatus(services)) // Update signal status (for current user)
-				signalsAuth.DELETE("/:id", controllers.DeleteSignal(services))           // Delete signal
+				signalsAuth.GET("", controllers.ListSignals(services))                    // List signals with user-specific status
+				signalsAuth.GET("/analytics", controllers.GetSignalAnalytics(services))   // Strategy / prefix performance (+ shadow PnL: ?shadow=true)
+				signalsAuth.GET("/:id", controllers.GetSignal(services))                  // Get single signal
+				signalsAuth.POST("/:id/execute", controllers.ExecuteSignal(services))     // Execute signal with bot config
+				signalsAuth.POST("/:id/redrive", controllers.RedriveUserSignal(services)) // Re-drive a failed execution through the signal queue
+				signalsAuth.PUT("/:id/status", controllers.UpdateSignalStatus(services))  // Update signal status (for current user)
+				signalsAuth.DELETE("/:id", controllers.DeleteSignal(services))            // Delete signal
 
 				// Webhook prefix management
-				signalsAuth.GET("/webhook/prefix", controllers.GetWebhookPrefix(services))     // Get latest active prefix
-				signalsAuth.POST("/webhook/prefix", controllers.CreateWebhookPrefix(services)) // Create new prefix
+				signalsAuth.GET("/webhook/prefix", controllers.GetWebhookPrefix(services))                      // Get latest active prefix
+				signalsAuth.POST("/webhook/prefix", controllers.CreateWebhookPrefix(services))                  // Create new prefix
+				signalsAuth.POST("/webhook/prefix/rotate", controllers.RotateWebhookPrefix(services))           // Rotate prefix + secret (old one valid for a grace period)
+				signalsAuth.GET("/webhook/rejections", controllers.GetWebhookRejections(services))              // Rejected webhook attempts
+				signalsAuth.PUT("/webhook/prefix/ip-allowlist", controllers.UpdateWebhookIPAllowlist(services)) // Source IP allowlist (IP/CIDR/"tradingview")
+
+				// Payload mapping templates (onboard vendor khác format TradingView)
+				signalsAuth.GET("/webhook/templates", controllers.ListPayloadTemplates(services))         // List templates
+				signalsAuth.PUT("/webhook/templates", controllers.UpsertPayloadTemplate(services))        // Create/replace template of a prefix
+				signalsAuth.DELETE("/webhook/templates/:id", controllers.DeletePayloadTemplate(services)) // Delete template
+				signalsAuth.POST("/webhook/templates/test", controllers.TestPayloadTemplate(services))    // Parse a sample body → TradingSignal
 			}
 		}
 
+		// ============ SIGNAL ROUTING RULES ROUTES ============
+		// Prefix: /api/v1/routing-rules
+		routingRules := v1.Group("/routing-rules")
+		routingRules.Use(middleware.AuthMiddleware())
+		{
+			routingRules.GET("", controllers.ListRoutingRules(services))            // List user's routing rules
+			routingRules.POST("", controllers.CreateRoutingRule(services))          // Create routing rule
+			routingRules.PUT("/:id", controllers.UpdateRoutingRule(services))       // Update routing rule
+			routingRules.DELETE("/:id", controllers.DeleteRoutingRule(services))    // Delete routing rule
+			routingRules.POST("/dry-run", controllers.DryRunRoutingRules(services)) // Preview which bots a sample payload would hit
+		}
+
 		// ============ SYSTEM LOGS ROUTES ============
 		// Prefix: /api/v1/logs
 		logs := v1.Group("/logs")
@@ -10,13 +10,15 @@ import (
 	"strings"
 	"time"
 	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
 )
 
 // PlaceAlgoStopLoss places a stop loss via standard Binance /fapi/v1/order endpoint
 func (ts *TradingService) PlaceAlgoStopLoss(
 	config *models.TradingConfig,
 	symbol string,
-	stopPrice float64,
+	stopPrice decimal.Decimal,
 	side string, // closing side: SELL để đóng LONG, BUY để đóng SHORT
 	positionSide string, // LONG / SHORT (bắt buộc nếu Hedge Mode) HOẶC "BOTH" nếu One-way Mode
 ) OrderResult {
@@ -34,7 +36,7 @@ func (ts *TradingService) PlaceAlgoStopLoss(
 	params.Set("side", strings.ToUpper(side)) // SELL cho đóng LONG
 
 	// ⭐ Format triggerPrice với tickSize phù hợp cho từng symbol
-	stopPriceStr := ts.FormatPriceByTickSize(symbol, stopPrice)
+	stopPriceStr := ts.FormatPriceByTickSize("futures", symbol, stopPrice)
 	params.Set("triggerPrice", stopPriceStr)
 	params.Set("type", "STOP_MARKET") // type=STOP_MARKET
 
@@ -130,7 +132,7 @@ func (ts *TradingService) PlaceAlgoStopLoss(
 func (ts *TradingService) PlaceAlgoTakeProfit(
 	config *models.TradingConfig,
 	symbol string,
-	takeProfitPrice float64,
+	takeProfitPrice decimal.Decimal,
 	side string, // closing side: SELL để đóng LONG, BUY để đóng SHORT
 	positionSide string, // LONG / SHORT (bắt buộc nếu Hedge Mode) HOẶC "BOTH" nếu One-way Mode
 ) OrderResult {
@@ -149,7 +151,7 @@ func (ts *TradingService) PlaceAlgoTakeProfit(
 	params.Set("type", "TAKE_PROFIT_MARKET")  // type=TAKE_PROFIT_MARKET
 
 	// ⭐ Format triggerPrice với tickSize phù hợp cho từng symbol
-	takeProfitPriceStr := ts.FormatPriceByTickSize(symbol, takeProfitPrice)
+	takeProfitPriceStr := ts.FormatPriceByTickSize("futures", symbol, takeProfitPrice)
 	params.Set("triggerPrice", takeProfitPriceStr)
 
 	params.Set("closePosition", "true") // Đóng toàn bộ vị thế khi trigger
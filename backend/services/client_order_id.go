@@ -0,0 +1,268 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tradercoin/backend/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// clientOrderIDPrefix giúp nhận diện lệnh do TraderCoin đặt trên sàn
+	clientOrderIDPrefix = "tc_"
+	// Binance giới hạn newClientOrderId tối đa 36 ký tự
+	clientOrderIDHashLen = 32
+
+	// Order source dùng để sinh ClientOrderID
+	OrderSourceSignal   = "signal"
+	OrderSourceTelegram = "telegram"
+	OrderSourceManual   = "manual"
+)
+
+// unresolvedClientOrderTTL là thời gian giữ client order ID chưa xác định (đủ cho mọi lần retry của signal queue)
+const unresolvedClientOrderTTL = 24 * time.Hour
+
+// unresolvedClientOrderIDs: client order ID có lần đặt trước bị timeout / lỗi mơ hồ mà chưa xác định được trên sàn.
+// Chỉ những ID này mới phải hỏi sàn (origClientOrderId) trước khi đặt lại; lệnh bình thường không tốn thêm request.
+var unresolvedClientOrderIDs sync.Map // clientOrderID → time.Time
+
+// markClientOrderUnresolved ghi nhận lần đặt lệnh chưa rõ kết quả (và dọn các ID đã hết hạn)
+func markClientOrderUnresolved(clientOrderID string) {
+	if clientOrderID == "" {
+		return
+	}
+	now := time.Now()
+	unresolvedClientOrderIDs.Range(func(key, value interface{}) bool {
+		if now.Sub(value.(time.Time)) > unresolvedClientOrderTTL {
+			unresolvedClientOrderIDs.Delete(key)
+		}
+		return true
+	})
+	unresolvedClientOrderIDs.Store(clientOrderID, now)
+}
+
+// isClientOrderUnresolved returns true if an earlier placement with this client order ID may have reached the exchange
+func isClientOrderUnresolved(clientOrderID string) bool {
+	if clientOrderID == "" {
+		return false
+	}
+	markedAt, ok := unresolvedClientOrderIDs.Load(clientOrderID)
+	if !ok {
+		return false
+	}
+	if time.Since(markedAt.(time.Time)) > unresolvedClientOrderTTL {
+		unresolvedClientOrderIDs.Delete(clientOrderID)
+		return false
+	}
+	return true
+}
+
+// resolveClientOrder xoá client order ID khỏi danh sách chưa xác định (đã tìm thấy trên sàn hoặc chắc chắn chưa có)
+func resolveClientOrder(clientOrderID string) {
+	unresolvedClientOrderIDs.Delete(clientOrderID)
+}
+
+// errOrderNotFoundOnExchange is returned when Binance has no order for the given client order ID
+var errOrderNotFoundOnExchange = errors.New("order does not exist on exchange")
+
+// GenerateClientOrderID sinh client order ID cố định từ user, bot và nguồn lệnh.
+// Cùng input luôn ra cùng ID nên webhook/callback bị gửi lại sẽ không tạo lệnh thứ hai.
+func GenerateClientOrderID(userID, botConfigID uint, source, ref string) string {
+	raw := fmt.Sprintf("%d|%d|%s|%s", userID, botConfigID, source, ref)
+	sum := sha256.Sum256([]byte(raw))
+	return clientOrderIDPrefix + hex.EncodeToString(sum[:])[:clientOrderIDHashLen]
+}
+
+// findExistingOrderByClientID kiểm tra DB xem client order ID đã được dùng chưa
+func (ts *TradingService) findExistingOrderByClientID(clientOrderID string) (*models.Order, bool) {
+	if ts.DB == nil || clientOrderID == "" {
+		return nil, false
+	}
+
+	var existing models.Order
+	err := ts.DB.Where("user_id = ? AND client_order_id = ?", ts.UserID, clientOrderID).First(&existing).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			fmt.Printf("⚠️  Failed to check duplicate client order ID %s: %v\n", clientOrderID, err)
+		}
+		return nil, false
+	}
+	return &existing, true
+}
+
+// duplicateOrderResult builds the refusal returned when a client order ID was already used
+func duplicateOrderResult(existing *models.Order) OrderResult {
+	return OrderResult{
+		Success:       false,
+		Status:        "duplicate",
+		ClientOrderID: existing.ClientOrderID,
+		Error: fmt.Sprintf("Duplicate order: client order ID %s already used by order #%d (%s)",
+			existing.ClientOrderID, existing.ID, existing.OrderID),
+		ErrorDetails: map[string]interface{}{
+			"client_order_id":   existing.ClientOrderID,
+			"existing_order_id": existing.ID,
+			"exchange_order_id": existing.OrderID,
+		},
+	}
+}
+
+// queryBinanceOrderByClientID lấy lệnh trên Binance theo origClientOrderId.
+// adapter phải là adapter đã dùng để đặt lệnh (cùng host mainnet/testnet).
+// Trả về raw body (cùng format với response đặt lệnh) để parse lại.
+func (ts *TradingService) queryBinanceOrderByClientID(adapter *BinanceAdapter, tradingMode, symbol, clientOrderID string) ([]byte, error) {
+	var baseURL, endpoint string
+	if tradingMode == "futures" {
+		baseURL = adapter.FuturesAPIURL
+		endpoint = "/fapi/v1/order"
+	} else {
+		baseURL = adapter.SpotAPIURL
+		endpoint = "/api/v3/order"
+	}
+
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("origClientOrderId", clientOrderID)
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	params.Set("recvWindow", "5000")
+	params.Set("signature", ts.sign(params.Encode()))
+
+	fullURL := fmt.Sprintf("%s%s?%s", baseURL, endpoint, params.Encode())
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", ts.APIKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		var errorResp map[string]interface{}
+		json.Unmarshal(body, &errorResp)
+		// -2013: Order does not exist
+		if code, ok := errorResp["code"].(float64); ok && code == -2013 {
+			return nil, errOrderNotFoundOnExchange
+		}
+		return nil, fmt.Errorf("binance API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// recoverOrderByClientID hỏi lại sàn sau khi đặt lệnh bị lỗi mơ hồ (timeout, 5xx).
+// Thử vài lần vì lệnh có thể chưa kịp xuất hiện trên sàn; vẫn không thấy thì đánh dấu ID là chưa xác định
+// để lần đặt lại (retry) hỏi sàn trước.
+func (ts *TradingService) recoverOrderByClientID(adapter *BinanceAdapter, tradingMode, symbol, clientOrderID string) ([]byte, bool) {
+	if clientOrderID == "" {
+		return nil, false
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		body, err := ts.queryBinanceOrderByClientID(adapter, tradingMode, symbol, clientOrderID)
+		if err == nil {
+			fmt.Printf("✅ Recovered order %s from exchange after ambiguous failure (attempt %d)\n", clientOrderID, attempt)
+			resolveClientOrder(clientOrderID)
+			return body, true
+		}
+		if errors.Is(err, errOrderNotFoundOnExchange) {
+			fmt.Printf("ℹ️  Order %s not found on exchange (attempt %d)\n", clientOrderID, attempt)
+		} else {
+			fmt.Printf("⚠️  Failed to query order %s (attempt %d): %v\n", clientOrderID, attempt, err)
+		}
+	}
+	markClientOrderUnresolved(clientOrderID)
+	return nil, false
+}
+
+// lookupUnresolvedOrder hỏi sàn lệnh của client order ID mà lần đặt trước chưa rõ kết quả.
+// found = lệnh đã lên sàn (dùng lại, không đặt lại); err != nil = chưa xác định được, không được đặt lại.
+func (ts *TradingService) lookupUnresolvedOrder(adapter *BinanceAdapter, tradingMode, symbol, clientOrderID string) ([]byte, bool, error) {
+	if !isClientOrderUnresolved(clientOrderID) {
+		return nil, false, nil
+	}
+	body, err := ts.queryBinanceOrderByClientID(adapter, tradingMode, symbol, clientOrderID)
+	switch {
+	case err == nil:
+		resolveClientOrder(clientOrderID)
+		return body, true, nil
+	case errors.Is(err, errOrderNotFoundOnExchange):
+		resolveClientOrder(clientOrderID)
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("failed to send request to verify earlier attempt of order %s: %w", clientOrderID, err)
+	}
+}
+
+// isAmbiguousOrderFailure trả về true nếu không chắc lệnh đã được sàn nhận hay chưa
+func isAmbiguousOrderFailure(statusCode int, errorResp map[string]interface{}) bool {
+	if statusCode >= http.StatusInternalServerError {
+		return true
+	}
+	// -1006: Unexpected response, -1007: Timeout waiting for response from backend server
+	if code, ok := errorResp["code"].(float64); ok && (code == -1006 || code == -1007) {
+		return true
+	}
+	return false
+}
+
+// orderResultFromQuery converts a GET order response into an OrderResult
+func orderResultFromQuery(body []byte) (OrderResult, error) {
+	var queryResp struct {
+		OrderID             int64  `json:"orderId"`
+		ClientOrderID       string `json:"clientOrderId"`
+		Symbol              string `json:"symbol"`
+		Side                string `json:"side"`
+		Type                string `json:"type"`
+		OrigQty             string `json:"origQty"`
+		Price               string `json:"price"`
+		ExecutedQty         string `json:"executedQty"`
+		CummulativeQuoteQty string `json:"cummulativeQuoteQty"`
+		Status              string `json:"status"`
+		AvgPrice            string `json:"avgPrice"`
+	}
+	if err := json.Unmarshal(body, &queryResp); err != nil {
+		return OrderResult{}, err
+	}
+
+	quantity := ParseDecimal(queryResp.OrigQty)
+	orderPrice := ParseDecimal(queryResp.Price)
+	filledPrice := ParseDecimal(queryResp.AvgPrice)
+	if filledPrice.IsZero() {
+		// Spot không có avgPrice → tính từ cummulativeQuoteQty / executedQty
+		executedQty := ParseDecimal(queryResp.ExecutedQty)
+		if executedQty.IsPositive() {
+			filledPrice = ParseDecimal(queryResp.CummulativeQuoteQty).Div(executedQty)
+		}
+	}
+
+	return OrderResult{
+		Success:       true,
+		OrderID:       strconv.FormatInt(queryResp.OrderID, 10),
+		ClientOrderID: queryResp.ClientOrderID,
+		Symbol:        queryResp.Symbol,
+		Side:          queryResp.Side,
+		Type:          queryResp.Type,
+		Quantity:      quantity,
+		Price:         orderPrice,
+		FilledPrice:   filledPrice,
+		Status:        strings.ToLower(queryResp.Status),
+	}, nil
+}
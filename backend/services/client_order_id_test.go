@@ -0,0 +1,42 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateClientOrderID(t *testing.T) {
+	base := GenerateClientOrderID(1, 2, OrderSourceSignal, "signal:10")
+
+	tests := []struct {
+		name        string
+		userID      uint
+		botConfigID uint
+		source      string
+		ref         string
+		wantSame    bool
+	}{
+		{"same input", 1, 2, OrderSourceSignal, "signal:10", true},
+		{"different user", 3, 2, OrderSourceSignal, "signal:10", false},
+		{"different bot", 1, 4, OrderSourceSignal, "signal:10", false},
+		{"different source", 1, 2, OrderSourceTelegram, "signal:10", false},
+		{"different ref", 1, 2, OrderSourceSignal, "signal:11", false},
+		// Dấu phân cách không được làm 2 input khác nhau ra cùng ID
+		{"ref shifted into source", 1, 2, OrderSourceSignal + "|signal", "10", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := GenerateClientOrderID(tt.userID, tt.botConfigID, tt.source, tt.ref)
+			if !strings.HasPrefix(id, clientOrderIDPrefix) {
+				t.Errorf("id %q has no %q prefix", id, clientOrderIDPrefix)
+			}
+			if len(id) > 36 {
+				t.Errorf("id %q is %d chars, Binance allows at most 36", id, len(id))
+			}
+			if got := id == base; got != tt.wantSame {
+				t.Errorf("id %q == %q is %v, want %v", id, base, got, tt.wantSame)
+			}
+		})
+	}
+}
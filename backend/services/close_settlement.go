@@ -0,0 +1,510 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Lý do đóng vị thế (Order.ExitReason)
+const (
+	ExitReasonStopLoss     = "stop_loss"
+	ExitReasonTakeProfit   = "take_profit"
+	ExitReasonTrailingStop = "trailing_stop"
+	ExitReasonManual       = "manual"
+	ExitReasonLiquidation  = "liquidation"
+	ExitReasonSignal       = "signal"
+)
+
+// Đợi các fill cuối (ORDER_TRADE_UPDATE đến sau ACCOUNT_UPDATE) rồi mới đối soát qua REST
+const closeSettlementDelay = 5 * time.Second
+
+// closeSettlementRetryDelays: đối soát lỗi (REST lỗi, trade chưa xuất hiện) được thử lại sau các khoảng này
+var closeSettlementRetryDelays = []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute}
+
+const (
+	// userTradesPageLimit là limit tối đa của /fapi/v1/userTrades
+	userTradesPageLimit = 1000
+	// maxUserTradesPages giới hạn số trang userTrades đọc cho 1 lệnh
+	maxUserTradesPages = 20
+	// userTradesWindow: startTime không kèm endTime chỉ trả về trade trong 7 ngày
+	userTradesWindow = 7 * 24 * time.Hour
+)
+
+// CloseSettlement is what actually hit the account when a futures position closed
+type CloseSettlement struct {
+	RealizedPnL     decimal.Decimal `json:"realized_pnl"`
+	Commission      decimal.Decimal `json:"commission"`
+	CommissionAsset string          `json:"commission_asset"`
+	FundingFee      decimal.Decimal `json:"funding_fee"`
+	ExitPrice       decimal.Decimal `json:"exit_price"`
+	ExitReason      string          `json:"exit_reason"`
+	ClosedAt        time.Time       `json:"closed_at"`
+	Trades          int             `json:"trades"`
+
+	fills []models.PositionFill // Trade của lệnh vào + trade đóng, ghi vào vị thế của lệnh
+}
+
+// binanceUserTrade là 1 trade của /fapi/v1/userTrades
+type binanceUserTrade struct {
+	ID              int64           `json:"id"`
+	Symbol          string          `json:"symbol"`
+	OrderID         int64           `json:"orderId"`
+	Side            string          `json:"side"`
+	PositionSide    string          `json:"positionSide"`
+	Price           decimal.Decimal `json:"price"`
+	Qty             decimal.Decimal `json:"qty"`
+	RealizedPnl     decimal.Decimal `json:"realizedPnl"`
+	Commission      decimal.Decimal `json:"commission"`
+	CommissionAsset string          `json:"commissionAsset"`
+	Time            int64           `json:"time"`
+}
+
+// binanceIncome là 1 bản ghi của /fapi/v1/income
+type binanceIncome struct {
+	Symbol     string          `json:"symbol"`
+	IncomeType string          `json:"incomeType"`
+	Income     decimal.Decimal `json:"income"`
+	Asset      string          `json:"asset"`
+	Time       int64           `json:"time"`
+}
+
+// FuturesExitReason maps the type / client ID of the order that closed a position to an exit reason
+func FuturesExitReason(orderType, origType, clientOrderID string) string {
+	client := strings.ToLower(clientOrderID)
+	if strings.HasPrefix(client, "autoclose-") || strings.HasPrefix(client, "adl_autoclose") ||
+		strings.EqualFold(orderType, "LIQUIDATION") {
+		return ExitReasonLiquidation
+	}
+
+	// Lệnh điều kiện đã kích hoạt thành MARKET: origType giữ loại ban đầu
+	kind := strings.ToUpper(origType)
+	if kind == "" {
+		kind = strings.ToUpper(orderType)
+	}
+	switch kind {
+	case "TRAILING_STOP_MARKET":
+		return ExitReasonTrailingStop
+	case "STOP", "STOP_MARKET":
+		return ExitReasonStopLoss
+	case "TAKE_PROFIT", "TAKE_PROFIT_MARKET":
+		return ExitReasonTakeProfit
+	}
+	return ExitReasonManual
+}
+
+// signedFuturesGet gọi 1 endpoint GET có chữ ký của Binance Futures và decode JSON vào out
+func (ts *TradingService) signedFuturesGet(endpoint string, params url.Values, out interface{}) error {
+	return ts.signedFuturesRequest("GET", endpoint, params, out)
+}
+
+// signedFuturesRequest gọi 1 endpoint có chữ ký của Binance Futures (GET / POST / DELETE); out nil thì bỏ qua body
+func (ts *TradingService) signedFuturesRequest(method, endpoint string, params url.Values, out interface{}) error {
+	adapter := GetExchangeAdapter("binance", false).(*BinanceAdapter)
+
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	params.Set("recvWindow", "5000")
+	params.Set("signature", ts.sign(params.Encode()))
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s?%s", adapter.FuturesAPIURL, endpoint, params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-MBX-APIKEY", ts.APIKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed (status %d): %s", endpoint, resp.StatusCode, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// fetchUserTradesSince đọc userTrades của symbol từ start: trang đầu theo startTime, các trang sau theo fromId
+// (fromId không bị giới hạn cửa sổ 7 ngày) cho tới khi hết trade
+func (ts *TradingService) fetchUserTradesSince(symbol string, start time.Time) ([]binanceUserTrade, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("startTime", strconv.FormatInt(start.UnixMilli(), 10))
+	params.Set("limit", strconv.Itoa(userTradesPageLimit))
+	var trades []binanceUserTrade
+	if err := ts.signedFuturesGet("/fapi/v1/userTrades", params, &trades); err != nil {
+		return nil, err
+	}
+	if len(trades) == 0 || (len(trades) < userTradesPageLimit && time.Since(start) < userTradesWindow) {
+		return trades, nil
+	}
+
+	for page := 1; page < maxUserTradesPages; page++ {
+		lastID := trades[0].ID
+		for _, t := range trades {
+			if t.ID > lastID {
+				lastID = t.ID
+			}
+		}
+		params := url.Values{}
+		params.Set("symbol", symbol)
+		params.Set("fromId", strconv.FormatInt(lastID+1, 10))
+		params.Set("limit", strconv.Itoa(userTradesPageLimit))
+		var next []binanceUserTrade
+		if err := ts.signedFuturesGet("/fapi/v1/userTrades", params, &next); err != nil {
+			return nil, err
+		}
+		trades = append(trades, next...)
+		if len(next) < userTradesPageLimit {
+			return trades, nil
+		}
+	}
+	return nil, fmt.Errorf("more than %d pages of trades for %s since %s", maxUserTradesPages, symbol, start.Format(time.RFC3339))
+}
+
+// SettleFuturesClose đối soát vị thế đã đóng của lệnh vào futures từ userTrades + income (funding):
+// realized PnL, phí, giá thoát trung bình, lý do và thời điểm đóng.
+// Chỉ tính các trade đóng theo thứ tự thời gian cho tới khi đủ khối lượng của lệnh.
+// Funding của symbol được chia cho các lệnh vào đang mở tại mỗi kỳ funding theo khối lượng (fundingShare).
+func (ts *TradingService) SettleFuturesClose(order *models.Order) (*CloseSettlement, error) {
+	trades, err := ts.fetchUserTradesSince(order.Symbol, order.CreatedAt.Add(-time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(trades, func(i, j int) bool { return trades[i].Time < trades[j].Time })
+
+	entrySide := strings.ToUpper(order.Side)
+	positionSide := "LONG"
+	closeSide := "SELL"
+	if entrySide == "SELL" || entrySide == "SHORT" {
+		positionSide, closeSide = "SHORT", "BUY"
+	}
+	target := order.FilledQuantity
+	if !target.IsPositive() {
+		target = order.Quantity
+	}
+
+	s := &CloseSettlement{}
+	closedQty, exitNotional := decimal.Zero, decimal.Zero
+	closingQtyByOrder := make(map[int64]decimal.Decimal)
+	var lastClose int64
+	// Trade đóng chỉ tính sau khi lệnh vào khớp (trước đó có thể là lệnh đóng của vị thế cũ)
+	entered := false
+	for _, t := range trades {
+		if ps := strings.ToUpper(t.PositionSide); ps != "" && ps != "BOTH" && ps != positionSide {
+			continue // Hedge mode: trade của vị thế chiều kia
+		}
+		switch {
+		case strconv.FormatInt(t.OrderID, 10) == order.OrderID:
+			// Phí của lệnh vào
+			entered = true
+			s.Commission = s.Commission.Add(t.Commission)
+			s.CommissionAsset = t.CommissionAsset
+			s.Trades++
+			s.addFill(order, t, true)
+		case strings.ToUpper(t.Side) == closeSide && closedQty.LessThan(target) && t.Qty.IsPositive() &&
+			(entered || t.Time >= order.CreatedAt.UnixMilli()):
+			// Trade đóng chung cho nhiều lệnh vào (DCA): chỉ lấy phần tương ứng khối lượng còn lại của lệnh này
+			qty := decimal.Min(t.Qty, target.Sub(closedQty))
+			share := qty.Div(t.Qty)
+			s.RealizedPnL = s.RealizedPnL.Add(t.RealizedPnl.Mul(share))
+			s.Commission = s.Commission.Add(t.Commission.Mul(share))
+			s.CommissionAsset = t.CommissionAsset
+			s.Trades++
+			closedQty = closedQty.Add(qty)
+			exitNotional = exitNotional.Add(t.Price.Mul(qty))
+			closingQtyByOrder[t.OrderID] = closingQtyByOrder[t.OrderID].Add(qty)
+			lastClose = t.Time
+			s.addFill(order, t, false)
+		}
+	}
+	if closedQty.IsZero() {
+		return nil, errors.New("no closing trades found")
+	}
+	s.ExitPrice = exitNotional.Div(closedQty)
+	s.ClosedAt = time.UnixMilli(lastClose)
+
+	// Funding trả / nhận trong thời gian giữ vị thế
+	params := url.Values{}
+	params.Set("symbol", order.Symbol)
+	params.Set("incomeType", "FUNDING_FEE")
+	params.Set("startTime", strconv.FormatInt(order.CreatedAt.UnixMilli(), 10))
+	params.Set("endTime", strconv.FormatInt(lastClose, 10))
+	params.Set("limit", "1000")
+	var incomes []binanceIncome
+	if err := ts.signedFuturesGet("/fapi/v1/income", params, &incomes); err != nil {
+		log.Printf("⚠️  Order %d: failed to load funding history: %v", order.ID, err)
+	}
+	if len(incomes) > 0 {
+		share := ts.fundingShare(order, target, time.UnixMilli(lastClose))
+		for _, inc := range incomes {
+			s.FundingFee = s.FundingFee.Add(inc.Income.Mul(share(time.UnixMilli(inc.Time))))
+		}
+		s.FundingFee = s.FundingFee.Round(8)
+	}
+
+	// Lý do đóng: lệnh đóng nhiều khối lượng nhất
+	var mainOrderID int64
+	for id, qty := range closingQtyByOrder {
+		if mainOrderID == 0 || qty.GreaterThan(closingQtyByOrder[mainOrderID]) {
+			mainOrderID = id
+		}
+	}
+	s.ExitReason = ts.closingOrderReason(order.Symbol, mainOrderID)
+	return s, nil
+}
+
+// fundingShare trả về hàm tính phần funding của lệnh tại thời điểm t: khối lượng lệnh / tổng khối lượng các lệnh vào
+// cùng symbol (cùng vị thế nếu lệnh gắn Position) đang mở tại t. Funding của symbol không tách theo lệnh trên sàn.
+func (ts *TradingService) fundingShare(order *models.Order, quantity decimal.Decimal, until time.Time) func(t time.Time) decimal.Decimal {
+	full := func(time.Time) decimal.Decimal { return decimal.NewFromInt(1) }
+	if ts.DB == nil || !quantity.IsPositive() {
+		return full
+	}
+
+	query := ts.DB.Where("user_id = ? AND symbol = ? AND LOWER(trading_mode) IN (?, ?) AND created_at <= ?",
+		order.UserID, order.Symbol, "futures", "future", until).
+		Where("closed_at IS NULL OR closed_at >= ?", order.CreatedAt).
+		Where("LOWER(status) NOT IN ?", []string{OrderStatusCancelled, OrderStatusRejected, OrderStatusFailed, OrderStatusExpired})
+	if order.PositionID != nil {
+		query = query.Where("position_id = ?", *order.PositionID)
+	}
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
+		log.Printf("⚠️  Order %d: failed to load orders sharing funding: %v", order.ID, err)
+		return full
+	}
+
+	return func(t time.Time) decimal.Decimal {
+		total := decimal.Zero
+		for i := range orders {
+			o := &orders[i]
+			if isClosingOrder(o) || o.CreatedAt.After(t) || (o.ClosedAt != nil && o.ClosedAt.Before(t)) {
+				continue
+			}
+			qty := o.FilledQuantity
+			if !qty.IsPositive() {
+				qty = o.Quantity
+			}
+			total = total.Add(qty)
+		}
+		if !total.GreaterThan(quantity) {
+			return decimal.NewFromInt(1)
+		}
+		return quantity.Div(total)
+	}
+}
+
+// addFill ghi nguyên trade (không chia theo lệnh vào) cho vị thế; trade dùng chung giữa các lệnh vào chỉ lưu 1 lần theo trade ID
+func (s *CloseSettlement) addFill(order *models.Order, t binanceUserTrade, entry bool) {
+	if order.PositionID == nil {
+		return
+	}
+	fill := models.PositionFill{
+		PositionID:      *order.PositionID,
+		TradeID:         t.ID,
+		ExchangeOrderID: strconv.FormatInt(t.OrderID, 10),
+		Side:            strings.ToUpper(t.Side),
+		Quantity:        t.Qty,
+		Price:           t.Price,
+		RealizedPnL:     t.RealizedPnl,
+		Commission:      t.Commission,
+		CommissionAsset: t.CommissionAsset,
+		FilledAt:        time.UnixMilli(t.Time),
+	}
+	if entry {
+		orderID := order.ID
+		fill.OrderID = &orderID
+	}
+	s.fills = append(s.fills, fill)
+}
+
+// closingOrderReason xác định lý do từ lệnh đóng: lệnh do signal đặt (có trong DB), SL/TP/trailing, thanh lý hay đóng tay
+func (ts *TradingService) closingOrderReason(symbol string, orderID int64) string {
+	exchangeOrderID := strconv.FormatInt(orderID, 10)
+	if ts.DB != nil {
+		var local models.Order
+		if err := ts.DB.Select("id", "signal_id").Where("symbol = ? AND order_id = ?", symbol, exchangeOrderID).
+			First(&local).Error; err == nil && local.SignalID != nil {
+			return ExitReasonSignal
+		}
+	}
+
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("orderId", exchangeOrderID)
+	var info struct {
+		Type          string `json:"type"`
+		OrigType      string `json:"origType"`
+		ClientOrderID string `json:"clientOrderId"`
+	}
+	if err := ts.signedFuturesGet("/fapi/v1/order", params, &info); err != nil {
+		log.Printf("⚠️  Failed to load closing order %s: %v", exchangeOrderID, err)
+		return ExitReasonManual
+	}
+	return FuturesExitReason(info.Type, info.OrigType, info.ClientOrderID)
+}
+
+// closeSettlementUpdates là các cột ghi vào lệnh khi đã đối soát; PnL = realized + funding − phí (khi phí tính bằng quote asset)
+func closeSettlementUpdates(order *models.Order, s *CloseSettlement) map[string]interface{} {
+	net := s.RealizedPnL.Add(s.FundingFee)
+	if s.CommissionAsset == "" || strings.HasSuffix(strings.ToUpper(order.Symbol), strings.ToUpper(s.CommissionAsset)) {
+		net = net.Sub(s.Commission)
+	}
+
+	entry := order.FilledPrice
+	if !entry.IsPositive() {
+		entry = order.Price
+	}
+	quantity := order.FilledQuantity
+	if !quantity.IsPositive() {
+		quantity = order.Quantity
+	}
+	pnlPercent := decimal.Zero
+	if notional := entry.Mul(quantity.Abs()); notional.IsPositive() {
+		pnlPercent = net.Div(notional).Mul(hundred).Round(2)
+	}
+
+	closedAt := s.ClosedAt
+	return map[string]interface{}{
+		"realized_pn_l":    s.RealizedPnL,
+		"commission":       s.Commission,
+		"commission_asset": s.CommissionAsset,
+		"funding_fee":      s.FundingFee,
+		"exit_price":       s.ExitPrice,
+		"current_price":    s.ExitPrice,
+		"exit_reason":      s.ExitReason,
+		"closed_at":        &closedAt,
+		"pn_l":             net,
+		"pn_l_percent":     pnlPercent,
+	}
+}
+
+// ApplyCloseSettlement đối soát lệnh futures vừa đóng và lưu kết quả; lỗi thì vẫn ghi closed_at để báo cáo có thời điểm đóng
+func ApplyCloseSettlement(db *gorm.DB, ts *TradingService, order *models.Order) (*CloseSettlement, error) {
+	var s *CloseSettlement
+	var err error
+	if isClosingOrder(order) {
+		// Lệnh đóng một phần (reduce-only): chỉ tính trade của chính lệnh
+		err = ApplyClosingOrderSettlement(db, ts, order)
+	} else {
+		s, err = ts.SettleFuturesClose(order)
+	}
+	if err != nil {
+		db.Model(&models.Order{}).Where("id = ? AND closed_at IS NULL", order.ID).Update("closed_at", time.Now())
+		syncOrderPosition(db, order)
+		return nil, err
+	}
+	if s == nil {
+		return nil, nil
+	}
+	if err := db.Model(&models.Order{}).Where("id = ?", order.ID).Updates(closeSettlementUpdates(order, s)).Error; err != nil {
+		return nil, err
+	}
+	if err := RecordPositionFills(db, s.fills); err != nil {
+		log.Printf("⚠️  Order %d: failed to record position fills: %v", order.ID, err)
+	}
+	syncOrderPosition(db, order)
+	log.Printf("💰 Order %d settled: realized %s, commission %s %s, funding %s, exit %s (%s)",
+		order.ID, s.RealizedPnL, s.Commission, s.CommissionAsset, s.FundingFee, s.ExitPrice, s.ExitReason)
+	return s, nil
+}
+
+// orderTradingService tạo TradingService với API key của lệnh (bot config, hoặc exchange key nếu lệnh không gắn bot)
+func orderTradingService(db *gorm.DB, order *models.Order) (*TradingService, error) {
+	if order.BotConfigID > 0 {
+		var config models.TradingConfig
+		if err := db.First(&config, order.BotConfigID).Error; err != nil {
+			return nil, fmt.Errorf("bot config %d not found: %w", order.BotConfigID, err)
+		}
+		apiKey, err := utils.DecryptString(config.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt API key: %w", err)
+		}
+		apiSecret, err := utils.DecryptString(config.APISecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt API secret: %w", err)
+		}
+		return NewTradingService(apiKey, apiSecret, config.Exchange, db, order.UserID), nil
+	}
+
+	var key models.ExchangeKey
+	if err := db.First(&key, order.ExchangeKeyID).Error; err != nil {
+		return nil, fmt.Errorf("exchange key %d not found: %w", order.ExchangeKeyID, err)
+	}
+	apiKey, apiSecret := exchangeKeyCredentials(&key)
+	return NewTradingService(apiKey, apiSecret, key.Exchange, db, order.UserID), nil
+}
+
+// SettleClosedFuturesOrders đối soát (bất đồng bộ, sau closeSettlementDelay) các lệnh futures vừa đóng;
+// lệnh đối soát lỗi được thử lại theo closeSettlementRetryDelays
+func SettleClosedFuturesOrders(db *gorm.DB, orderIDs []uint) {
+	scheduleCloseSettlement(db, orderIDs, closeSettlementDelay, 0)
+}
+
+// RetryCloseSettlement lên lịch đối soát lại các lệnh vừa đối soát lỗi (vd: OrderMonitor đối soát trực tiếp)
+func RetryCloseSettlement(db *gorm.DB, orderIDs []uint) {
+	scheduleCloseSettlement(db, orderIDs, closeSettlementRetryDelays[0], 1)
+}
+
+func scheduleCloseSettlement(db *gorm.DB, orderIDs []uint, delay time.Duration, attempt int) {
+	if len(orderIDs) == 0 {
+		return
+	}
+	time.AfterFunc(delay, func() {
+		failed := settleFuturesOrders(db, orderIDs)
+		if len(failed) == 0 {
+			return
+		}
+		if attempt >= len(closeSettlementRetryDelays) {
+			log.Printf("❌ Close settlement of order(s) %v failed after %d attempts", failed, attempt+1)
+			return
+		}
+		next := closeSettlementRetryDelays[attempt]
+		log.Printf("🔁 Retrying close settlement of order(s) %v in %s", failed, next)
+		scheduleCloseSettlement(db, failed, next, attempt+1)
+	})
+}
+
+// settleFuturesOrders đối soát các lệnh futures và trả về ID các lệnh đối soát lỗi
+func settleFuturesOrders(db *gorm.DB, orderIDs []uint) []uint {
+	var orders []models.Order
+	if err := db.Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+		log.Printf("⚠️  Failed to load closed orders for settlement: %v", err)
+		return orderIDs
+	}
+	var failed []uint
+	for i := range orders {
+		order := &orders[i]
+		if marketOf(order.TradingMode) != "futures" || order.OrderID == "" {
+			continue
+		}
+		ts, err := orderTradingService(db, order)
+		if err == nil {
+			_, err = ApplyCloseSettlement(db, ts, order)
+		}
+		if err != nil {
+			log.Printf("⚠️  Order %d: close settlement failed: %v", order.ID, err)
+			failed = append(failed, order.ID)
+		}
+	}
+	return failed
+}
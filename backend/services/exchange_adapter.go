@@ -158,6 +158,70 @@ func (b *BinanceAdapter) CloseListenKey(apiKey, apiSecret, listenKey string) err
 	return nil
 }
 
+// userStreamRequest gọi endpoint listen key của spot (/api/v3/userDataStream) hoặc futures (/fapi/v1/listenKey)
+func (b *BinanceAdapter) userStreamRequest(method, tradingMode, apiKey, listenKey string) ([]byte, error) {
+	fullURL := b.SpotAPIURL + "/api/v3/userDataStream"
+	if tradingMode == "futures" {
+		fullURL = b.FuturesAPIURL + "/fapi/v1/listenKey"
+	}
+	if listenKey != "" && tradingMode != "futures" {
+		// Futures xác định listen key theo API key, spot cần truyền listenKey
+		params := url.Values{}
+		params.Set("listenKey", listenKey)
+		fullURL += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(method, fullURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-MBX-APIKEY", apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// CreateUserStreamKey creates a listen key for the spot or futures user data stream
+func (b *BinanceAdapter) CreateUserStreamKey(apiKey, tradingMode string) (string, error) {
+	body, err := b.userStreamRequest("POST", tradingMode, apiKey, "")
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		ListenKey string `json:"listenKey"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return result.ListenKey, nil
+}
+
+// KeepAliveUserStreamKey extends a spot or futures listen key (hết hạn sau 60 phút nếu không gia hạn)
+func (b *BinanceAdapter) KeepAliveUserStreamKey(apiKey, tradingMode, listenKey string) error {
+	_, err := b.userStreamRequest("PUT", tradingMode, apiKey, listenKey)
+	return err
+}
+
+// CloseUserStreamKey closes a spot or futures listen key
+func (b *BinanceAdapter) CloseUserStreamKey(apiKey, tradingMode, listenKey string) error {
+	_, err := b.userStreamRequest("DELETE", tradingMode, apiKey, listenKey)
+	return err
+}
+
 // GetWSURL returns WebSocket URL for Binance
 func (b *BinanceAdapter) GetWSURL(tradingMode, listenKey string) string {
 	if tradingMode == "futures" {
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultLockWaitTimeout là thời gian tối đa chờ lấy lock trước khi bỏ cuộc
+	DefaultLockWaitTimeout = 30 * time.Second
+	// DefaultLockTTL là thời hạn lock trên Redis, phòng trường hợp process chết khi đang giữ lock
+	DefaultLockTTL = 2 * time.Minute
+
+	executionLockRedisPrefix = "tradercoin:lock:"
+	lockRetryInterval        = 100 * time.Millisecond
+	// lockRenewDivisor: lock Redis được gia hạn mỗi TTL/3 khi còn đang giữ
+	lockRenewDivisor = 3
+)
+
+// ErrLockTimeout is returned when a lock could not be acquired within the wait timeout
+var ErrLockTimeout = errors.New("timed out waiting for execution lock")
+
+// releaseLockScript chỉ xoá key nếu token khớp (không xoá lock của instance khác)
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// renewLockScript gia hạn TTL nếu lock vẫn thuộc về token này
+var renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// LockInfo describes a held execution lock
+type LockInfo struct {
+	Key        string    `json:"key"`
+	Owner      string    `json:"owner"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Source     string    `json:"source"` // "local" hoặc "redis"
+}
+
+type redisLockValue struct {
+	Token      string    `json:"token"`
+	Owner      string    `json:"owner"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+type localLock struct {
+	sem  chan struct{}
+	info *LockInfo
+	refs int // Số goroutine đang giữ / chờ lock; về 0 thì xoá khỏi map
+}
+
+// ExecutionLockManager serializes order operations per exchange key and symbol.
+// Luôn có lock trong process; nếu có Redis thì lấy thêm lock phân tán để nhiều instance không chạy chồng nhau.
+type ExecutionLockManager struct {
+	redis    *redis.Client
+	hostname string
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*localLock
+}
+
+var (
+	executionLockManager     *ExecutionLockManager
+	executionLockManagerOnce sync.Once
+)
+
+// NewExecutionLockManager creates a lock manager; redisClient may be nil
+func NewExecutionLockManager(redisClient *redis.Client) *ExecutionLockManager {
+	hostname, _ := os.Hostname()
+	return &ExecutionLockManager{
+		redis:    redisClient,
+		hostname: hostname,
+		ttl:      DefaultLockTTL,
+		locks:    make(map[string]*localLock),
+	}
+}
+
+// InitExecutionLocks khởi tạo lock manager dùng chung (gọi 1 lần trong main sau khi có Redis)
+func InitExecutionLocks(redisClient *redis.Client) *ExecutionLockManager {
+	executionLockManagerOnce.Do(func() {
+		executionLockManager = NewExecutionLockManager(redisClient)
+		if redisClient != nil {
+			log.Println("🔒 Execution locks: in-process + Redis distributed")
+		} else {
+			log.Println("🔒 Execution locks: in-process only (Redis not configured)")
+		}
+	})
+	return executionLockManager
+}
+
+// ExecutionLocks returns the shared lock manager (in-process only if InitExecutionLocks was not called)
+func ExecutionLocks() *ExecutionLockManager {
+	return InitExecutionLocks(nil)
+}
+
+// ExecutionLockKey builds the lock key for an exchange API key and symbol.
+// API key được hash để không lộ ra trong Redis / admin API.
+func ExecutionLockKey(exchange, apiKey, symbol string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return fmt.Sprintf("%s:%s:%s", strings.ToLower(exchange), hex.EncodeToString(sum[:])[:16], strings.ToUpper(symbol))
+}
+
+// Acquire waits up to timeout for the lock and returns a release func.
+// owner mô tả ai đang giữ lock (vd: "signal:12", "telegram", "manual") để hiển thị trên admin.
+func (m *ExecutionLockManager) Acquire(key, owner string, timeout time.Duration) (func(), error) {
+	if timeout <= 0 {
+		timeout = DefaultLockWaitTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	// ====== LOCAL LOCK ======
+	m.mu.Lock()
+	entry, ok := m.locks[key]
+	if !ok {
+		entry = &localLock{sem: make(chan struct{}, 1)}
+		m.locks[key] = entry
+	}
+	entry.refs++
+	m.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case entry.sem <- struct{}{}:
+	case <-timer.C:
+		holder := m.holderOf(key)
+		m.unref(key, entry)
+		return nil, fmt.Errorf("%w: %s (held by %s)", ErrLockTimeout, key, holder)
+	}
+
+	now := time.Now()
+	info := &LockInfo{
+		Key:        key,
+		Owner:      owner,
+		Host:       m.hostname,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(m.ttl),
+		Source:     "local",
+	}
+
+	// ====== REDIS LOCK ======
+	token := ""
+	if m.redis != nil {
+		var err error
+		token, err = m.acquireRedis(key, owner, deadline)
+		if err != nil {
+			<-entry.sem
+			m.unref(key, entry)
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	entry.info = info
+	m.mu.Unlock()
+
+	// Watchdog: lệnh chạy lâu hơn TTL vẫn giữ lock cho tới khi release
+	stopRenew := make(chan struct{})
+	go m.keepAlive(key, token, info, stopRenew)
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(stopRenew)
+			if token != "" {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := releaseLockScript.Run(ctx, m.redis, []string{executionLockRedisPrefix + key}, token).Err(); err != nil {
+					log.Printf("⚠️  Failed to release Redis lock %s: %v", key, err)
+				}
+				cancel()
+			}
+
+			m.mu.Lock()
+			entry.info = nil
+			m.mu.Unlock()
+			<-entry.sem
+			m.unref(key, entry)
+		})
+	}
+
+	return release, nil
+}
+
+// unref bỏ 1 tham chiếu tới lock; không còn ai giữ / chờ thì xoá entry khỏi map
+func (m *ExecutionLockManager) unref(key string, entry *localLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry.refs--
+	if entry.refs <= 0 && m.locks[key] == entry {
+		delete(m.locks, key)
+	}
+}
+
+// keepAlive gia hạn lock (Redis TTL + ExpiresAt hiển thị trên admin) mỗi TTL/3 cho tới khi stop bị đóng
+func (m *ExecutionLockManager) keepAlive(key, token string, info *LockInfo, stop <-chan struct{}) {
+	ticker := time.NewTicker(m.ttl / lockRenewDivisor)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if token != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			renewed, err := renewLockScript.Run(ctx, m.redis, []string{executionLockRedisPrefix + key}, token, m.ttl.Milliseconds()).Int()
+			cancel()
+			if err != nil {
+				log.Printf("⚠️  Failed to renew Redis lock %s: %v", key, err)
+				continue
+			}
+			if renewed == 0 {
+				log.Printf("⚠️  Redis lock %s (%s) expired while still held", key, info.Owner)
+				token = ""
+			}
+		}
+
+		m.mu.Lock()
+		info.ExpiresAt = time.Now().Add(m.ttl)
+		m.mu.Unlock()
+	}
+}
+
+// acquireRedis polls SET NX until deadline
+func (m *ExecutionLockManager) acquireRedis(key, owner string, deadline time.Time) (string, error) {
+	token := newLockToken()
+	value, _ := json.Marshal(redisLockValue{
+		Token:      token,
+		Owner:      owner,
+		Host:       m.hostname,
+		AcquiredAt: time.Now(),
+	})
+
+	// Lưu cả JSON làm value, token nằm trong JSON nên so sánh cả chuỗi khi release
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ok, err := m.redis.SetNX(ctx, executionLockRedisPrefix+key, string(value), m.ttl).Result()
+		cancel()
+		if err != nil {
+			// Redis lỗi → không chặn giao dịch, chỉ dựa vào lock trong process
+			log.Printf("⚠️  Redis lock unavailable for %s, falling back to local lock: %v", key, err)
+			return "", nil
+		}
+		if ok {
+			return string(value), nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%w: %s (held by another instance)", ErrLockTimeout, key)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// holderOf returns a short description of who holds a local lock
+func (m *ExecutionLockManager) holderOf(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.locks[key]; ok && entry.info != nil {
+		return fmt.Sprintf("%s since %s", entry.info.Owner, entry.info.AcquiredAt.Format(time.RFC3339))
+	}
+	return "unknown"
+}
+
+// HeldLocks lists locks held in this process and, when Redis is configured, across all instances
+func (m *ExecutionLockManager) HeldLocks() []LockInfo {
+	result := []LockInfo{}
+	seen := make(map[string]bool)
+
+	m.mu.Lock()
+	for _, entry := range m.locks {
+		if entry.info != nil {
+			result = append(result, *entry.info)
+			seen[entry.info.Key] = true
+		}
+	}
+	m.mu.Unlock()
+
+	if m.redis != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		iter := m.redis.Scan(ctx, 0, executionLockRedisPrefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			redisKey := iter.Val()
+			key := strings.TrimPrefix(redisKey, executionLockRedisPrefix)
+			if seen[key] {
+				continue
+			}
+
+			raw, err := m.redis.Get(ctx, redisKey).Result()
+			if err != nil {
+				continue
+			}
+			var value redisLockValue
+			json.Unmarshal([]byte(raw), &value)
+
+			ttl, _ := m.redis.TTL(ctx, redisKey).Result()
+			result = append(result, LockInfo{
+				Key:        key,
+				Owner:      value.Owner,
+				Host:       value.Host,
+				AcquiredAt: value.AcquiredAt,
+				ExpiresAt:  time.Now().Add(ttl),
+				Source:     "redis",
+			})
+		}
+		if err := iter.Err(); err != nil {
+			log.Printf("⚠️  Failed to scan Redis locks: %v", err)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AcquiredAt.Before(result[j].AcquiredAt)
+	})
+	return result
+}
+
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecutionLockAcquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		heldKey  string // key đang bị giữ ("" = không có)
+		key      string
+		wantErr  error
+		wantHeld int // số lock đang giữ sau khi Acquire
+	}{
+		{"free key", "", "binance:a:BTCUSDT", nil, 1},
+		{"other symbol is independent", "binance:a:BTCUSDT", "binance:a:ETHUSDT", nil, 2},
+		{"same key times out", "binance:a:BTCUSDT", "binance:a:BTCUSDT", ErrLockTimeout, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewExecutionLockManager(nil)
+			if tt.heldKey != "" {
+				release, err := m.Acquire(tt.heldKey, "holder", time.Second)
+				if err != nil {
+					t.Fatalf("failed to acquire held key: %v", err)
+				}
+				defer release()
+			}
+
+			release, err := m.Acquire(tt.key, "test", 50*time.Millisecond)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Acquire() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer release()
+			}
+			if got := len(m.HeldLocks()); got != tt.wantHeld {
+				t.Errorf("%d lock(s) held, want %d", got, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestExecutionLockRelease(t *testing.T) {
+	m := NewExecutionLockManager(nil)
+	key := ExecutionLockKey("binance", "api-key", "btcusdt")
+
+	release, err := m.Acquire(key, "first", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := m.Acquire(key, "second", time.Second)
+		if err != nil {
+			t.Errorf("second Acquire() error = %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- next
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second Acquire() succeeded while the lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	release() // Gọi release 2 lần không được mở lock của người giữ sau
+
+	next, ok := <-acquired
+	if !ok {
+		return
+	}
+	if _, err := m.Acquire(key, "third", 50*time.Millisecond); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("third Acquire() error = %v, want ErrLockTimeout (lock held by second)", err)
+	}
+	next()
+
+	m.mu.Lock()
+	remaining := len(m.locks)
+	m.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d lock entries left after release, want 0", remaining)
+	}
+}
+
+func TestExecutionLockKey(t *testing.T) {
+	key := ExecutionLockKey("Binance", "secret-api-key", "btcusdt")
+	if key != ExecutionLockKey("binance", "secret-api-key", "BTCUSDT") {
+		t.Error("ExecutionLockKey is not case insensitive on exchange and symbol")
+	}
+	if key == ExecutionLockKey("binance", "other-api-key", "BTCUSDT") {
+		t.Error("different API keys share a lock key")
+	}
+	if strings.Contains(key, "secret-api-key") {
+		t.Errorf("lock key %q exposes the API key", key)
+	}
+}
@@ -0,0 +1,736 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	// Giá cũ hơn khoảng này coi như không có → gọi REST
+	marketPriceMaxAge           = 30 * time.Second
+	marketDataRefreshInterval   = 30 * time.Second
+	marketDataWatchTTL          = 10 * time.Minute // Symbol hỏi lẻ (không có lệnh / bot) được giữ subscribe bao lâu
+	marketDataRedisFlush        = time.Second
+	marketDataRedisTTL          = time.Minute
+	marketDataRedisPrefix       = "market:price:"
+	marketStreamsPerConnection  = 200 // Giới hạn stream / kết nối của Binance futures (spot là 1024)
+	marketSubscribeBatch        = 100
+	marketDataReadTimeout       = 5 * time.Minute
+	marketDataMarkStreamSuffix  = "@markPrice@1s"
+	marketDataQuoteStreamSuffix = "@bookTicker"
+)
+
+// errNoMarketSymbols: không còn symbol nào cần theo dõi → đóng kết nối, không tính là lỗi
+var errNoMarketSymbols = errors.New("no symbols to watch")
+
+// PriceTick is the last known price of a symbol on one market (spot / futures)
+type PriceTick struct {
+	Market        string          `json:"market"`
+	Symbol        string          `json:"symbol"`
+	Bid           decimal.Decimal `json:"bid"`
+	Ask           decimal.Decimal `json:"ask"`
+	Last          decimal.Decimal `json:"last"`   // Mid bid/ask từ bookTicker (hoặc giá REST)
+	Mark          decimal.Decimal `json:"mark"`   // Futures mark price
+	Source        string          `json:"source"` // stream, rest, redis
+	UpdatedAt     time.Time       `json:"updated_at"`
+	MarkUpdatedAt time.Time       `json:"mark_updated_at,omitempty"`
+}
+
+// MarketFeedStatus is the connection status of one public market data stream
+type MarketFeedStatus struct {
+	Market      string     `json:"market"`
+	State       string     `json:"state"`
+	Symbols     int        `json:"symbols"`
+	Subscribed  int        `json:"subscribed"`
+	Dropped     int        `json:"dropped"` // Symbol vượt giới hạn stream → dùng REST
+	Messages    int64      `json:"messages"`
+	Reconnects  int        `json:"reconnects"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// MarketDataStatus summarises the price cache (admin)
+type MarketDataStatus struct {
+	Feeds       []MarketFeedStatus `json:"feeds"`
+	CachedTicks int                `json:"cached_ticks"`
+	Watched     int                `json:"watched"`
+	Hits        int64              `json:"hits"`
+	Misses      int64              `json:"misses"`
+	Redis       bool               `json:"redis"`
+}
+
+// MarketDataService subscribes to Binance bookTicker / markPrice streams for symbols with open orders or active bots
+// và giữ giá mới nhất trong bộ nhớ (đồng bộ qua Redis nếu có) để hub, order monitor, PnL và SL/TP không phải gọi REST.
+type MarketDataService struct {
+	DB    *gorm.DB
+	redis *redis.Client
+
+	mu      sync.RWMutex
+	ticks   map[string]*PriceTick
+	dirty   map[string]bool
+	watched map[string]time.Time // market|symbol → lần cuối được hỏi
+	feeds   map[string]*marketFeed
+
+	hits   int64
+	misses int64
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stopChan  chan struct{}
+}
+
+type marketFeed struct {
+	svc    *MarketDataService
+	market string
+	url    string
+	notify chan struct{}
+
+	mu          sync.Mutex
+	wanted      map[string]bool // stream name → cần subscribe
+	dropped     int
+	state       string
+	messages    int64
+	reconnects  int
+	connectedAt *time.Time
+	lastError   string
+}
+
+var (
+	marketDataService     *MarketDataService
+	marketDataServiceOnce sync.Once
+)
+
+// InitMarketData khởi tạo price cache dùng chung (gọi 1 lần trong main sau khi có DB / Redis)
+func InitMarketData(db *gorm.DB, redisClient *redis.Client) *MarketDataService {
+	marketDataServiceOnce.Do(func() {
+		marketDataService = &MarketDataService{
+			DB:       db,
+			redis:    redisClient,
+			ticks:    make(map[string]*PriceTick),
+			dirty:    make(map[string]bool),
+			watched:  make(map[string]time.Time),
+			feeds:    make(map[string]*marketFeed),
+			stopChan: make(chan struct{}),
+		}
+	})
+	return marketDataService
+}
+
+// MarketData returns the shared price cache (in-process only, no streams, if InitMarketData was not called)
+func MarketData() *MarketDataService {
+	return InitMarketData(nil, nil)
+}
+
+// marketOf maps a trading mode to the market whose price applies (margin dùng giá spot)
+func marketOf(tradingMode string) string {
+	switch strings.ToLower(tradingMode) {
+	case "futures", "future":
+		return "futures"
+	default:
+		return "spot"
+	}
+}
+
+func marketTickKey(market, symbol string) string {
+	return market + "|" + strings.ToUpper(symbol)
+}
+
+// Start connects the spot / futures streams and keeps their subscriptions in sync with open orders and bots
+func (m *MarketDataService) Start() {
+	if m.DB == nil {
+		return
+	}
+	m.startOnce.Do(func() {
+		adapter := GetExchangeAdapter("binance", false).(*BinanceAdapter)
+		m.mu.Lock()
+		m.feeds["spot"] = m.newFeed("spot", adapter.SpotWSURL)
+		m.feeds["futures"] = m.newFeed("futures", adapter.FuturesWSURL)
+		feeds := []*marketFeed{m.feeds["spot"], m.feeds["futures"]}
+		m.mu.Unlock()
+
+		for _, feed := range feeds {
+			go feed.run()
+		}
+		if m.redis != nil {
+			go m.flushLoop()
+		}
+		go func() {
+			m.refresh()
+			ticker := time.NewTicker(marketDataRefreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					m.refresh()
+				case <-m.stopChan:
+					return
+				}
+			}
+		}()
+		log.Printf("📈 Market data started - bookTicker/markPrice streams (Redis: %v)", m.redis != nil)
+	})
+}
+
+// Stop closes the market data streams
+func (m *MarketDataService) Stop() {
+	m.stopOnce.Do(func() { close(m.stopChan) })
+}
+
+// Price returns a fresh last price of symbol (bộ nhớ → Redis). Symbol chưa có sẽ được subscribe cho lần sau.
+func (m *MarketDataService) Price(tradingMode, symbol string) (decimal.Decimal, bool) {
+	tick, ok := m.Quote(tradingMode, symbol)
+	if !ok || !tick.Last.IsPositive() || time.Since(tick.UpdatedAt) > marketPriceMaxAge {
+		atomic.AddInt64(&m.misses, 1)
+		m.Watch(tradingMode, symbol)
+		return decimal.Zero, false
+	}
+	atomic.AddInt64(&m.hits, 1)
+	return tick.Last, true
+}
+
+// MarkPrice returns a fresh futures mark price of symbol
+func (m *MarketDataService) MarkPrice(symbol string) (decimal.Decimal, bool) {
+	tick, ok := m.Quote("futures", symbol)
+	if !ok || !tick.Mark.IsPositive() || time.Since(tick.MarkUpdatedAt) > marketPriceMaxAge {
+		atomic.AddInt64(&m.misses, 1)
+		m.Watch("futures", symbol)
+		return decimal.Zero, false
+	}
+	atomic.AddInt64(&m.hits, 1)
+	return tick.Mark, true
+}
+
+// Quote returns the cached tick of symbol without freshness checks
+func (m *MarketDataService) Quote(tradingMode, symbol string) (PriceTick, bool) {
+	if symbol == "" {
+		return PriceTick{}, false
+	}
+	key := marketTickKey(marketOf(tradingMode), symbol)
+
+	m.mu.RLock()
+	tick, ok := m.ticks[key]
+	var cached PriceTick
+	if ok {
+		cached = *tick
+	}
+	m.mu.RUnlock()
+	if ok && time.Since(cached.UpdatedAt) <= marketPriceMaxAge {
+		return cached, true
+	}
+
+	// Instance khác có thể đang giữ stream của symbol này
+	if remote, found := m.loadRedis(key); found && remote.UpdatedAt.After(cached.UpdatedAt) {
+		m.mu.Lock()
+		m.ticks[key] = &remote
+		m.mu.Unlock()
+		return remote, true
+	}
+	return cached, ok
+}
+
+// Observe stores a price fetched via REST so other callers can reuse it
+func (m *MarketDataService) Observe(tradingMode, symbol string, price decimal.Decimal) {
+	if !price.IsPositive() || symbol == "" {
+		return
+	}
+	m.store(marketOf(tradingMode), symbol, func(t *PriceTick) {
+		t.Last = price
+		t.Source = "rest"
+		t.UpdatedAt = time.Now()
+	})
+}
+
+// ObserveMark stores a futures mark price fetched via REST
+func (m *MarketDataService) ObserveMark(symbol string, mark decimal.Decimal) {
+	if !mark.IsPositive() || symbol == "" {
+		return
+	}
+	m.store("futures", symbol, func(t *PriceTick) {
+		t.Mark = mark
+		t.MarkUpdatedAt = time.Now()
+		if t.Source == "" {
+			t.Source = "rest"
+		}
+	})
+}
+
+// Watch subscribes symbol for a while even without open orders / bots (vd: vừa bị hỏi giá qua REST)
+func (m *MarketDataService) Watch(tradingMode, symbol string) {
+	if symbol == "" {
+		return
+	}
+	key := marketTickKey(marketOf(tradingMode), symbol)
+	m.mu.Lock()
+	_, known := m.watched[key]
+	m.watched[key] = time.Now()
+	feeds := len(m.feeds)
+	m.mu.Unlock()
+
+	// Symbol mới: subscribe ngay thay vì đợi lần refresh sau
+	if !known && feeds > 0 {
+		go m.refresh()
+	}
+}
+
+// Status returns the feed and cache status
+func (m *MarketDataService) Status() MarketDataStatus {
+	m.mu.RLock()
+	status := MarketDataStatus{
+		CachedTicks: len(m.ticks),
+		Watched:     len(m.watched),
+		Redis:       m.redis != nil,
+	}
+	feeds := make([]*marketFeed, 0, len(m.feeds))
+	for _, feed := range m.feeds {
+		feeds = append(feeds, feed)
+	}
+	m.mu.RUnlock()
+
+	for _, feed := range feeds {
+		status.Feeds = append(status.Feeds, feed.status())
+	}
+	sort.Slice(status.Feeds, func(i, j int) bool { return status.Feeds[i].Market < status.Feeds[j].Market })
+	status.Hits = atomic.LoadInt64(&m.hits)
+	status.Misses = atomic.LoadInt64(&m.misses)
+	return status
+}
+
+func (m *MarketDataService) store(market, symbol string, apply func(t *PriceTick)) {
+	key := marketTickKey(market, symbol)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tick, ok := m.ticks[key]
+	if !ok {
+		tick = &PriceTick{Market: market, Symbol: strings.ToUpper(symbol)}
+		m.ticks[key] = tick
+	}
+	apply(tick)
+	if m.redis != nil {
+		m.dirty[key] = true
+	}
+}
+
+// refresh tính lại danh sách symbol cần subscribe: lệnh còn mở, bot đang bật và symbol hỏi lẻ gần đây
+func (m *MarketDataService) refresh() {
+	symbols := map[string]map[string]bool{"spot": {}, "futures": {}}
+	add := func(tradingMode, symbol string) {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if symbol == "" || strings.ContainsAny(symbol, "*?/ ") {
+			return
+		}
+		symbols[marketOf(tradingMode)][symbol] = true
+	}
+
+	var orders []models.Order
+	if err := m.DB.Model(&models.Order{}).Distinct("symbol", "trading_mode").
+		Where("LOWER(status) NOT IN ?", terminalOrderStatuses).
+		Find(&orders).Error; err != nil {
+		log.Printf("⚠️  Market data: failed to load open order symbols: %v", err)
+	}
+	for _, o := range orders {
+		add(o.TradingMode, o.Symbol)
+	}
+
+	var configs []models.TradingConfig
+	if err := m.DB.Model(&models.TradingConfig{}).Distinct("symbol", "trading_mode").
+		Where("is_active = ?", true).
+		Find(&configs).Error; err != nil {
+		log.Printf("⚠️  Market data: failed to load bot symbols: %v", err)
+	}
+	for _, cfg := range configs {
+		// Bot có thể khai báo nhiều symbol, cách nhau bởi dấu phẩy
+		for _, symbol := range strings.Split(cfg.Symbol, ",") {
+			add(cfg.TradingMode, symbol)
+		}
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	for key, lastUsed := range m.watched {
+		if now.Sub(lastUsed) > marketDataWatchTTL {
+			delete(m.watched, key)
+			continue
+		}
+		if parts := strings.SplitN(key, "|", 2); len(parts) == 2 {
+			symbols[parts[0]][parts[1]] = true
+		}
+	}
+	// Tick không còn được cập nhật thì bỏ cho map không phình ra
+	for key, tick := range m.ticks {
+		if now.Sub(tick.UpdatedAt) > marketDataWatchTTL && now.Sub(tick.MarkUpdatedAt) > marketDataWatchTTL {
+			delete(m.ticks, key)
+		}
+	}
+	feeds := make(map[string]*marketFeed, len(m.feeds))
+	for market, feed := range m.feeds {
+		feeds[market] = feed
+	}
+	m.mu.Unlock()
+
+	for market, feed := range feeds {
+		feed.setSymbols(symbols[market])
+	}
+}
+
+// flushLoop ghi các tick đã đổi lên Redis (tối đa 1 lần / giây / symbol) cho các instance khác dùng chung
+func (m *MarketDataService) flushLoop() {
+	ticker := time.NewTicker(marketDataRedisFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+
+		m.mu.Lock()
+		if len(m.dirty) == 0 {
+			m.mu.Unlock()
+			continue
+		}
+		batch := make(map[string][]byte, len(m.dirty))
+		for key := range m.dirty {
+			if tick, ok := m.ticks[key]; ok {
+				if data, err := json.Marshal(tick); err == nil {
+					batch[key] = data
+				}
+			}
+		}
+		m.dirty = make(map[string]bool)
+		m.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		pipe := m.redis.Pipeline()
+		for key, data := range batch {
+			pipe.Set(ctx, marketDataRedisPrefix+key, data, marketDataRedisTTL)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("⚠️  Market data: failed to write prices to Redis: %v", err)
+		}
+		cancel()
+	}
+}
+
+func (m *MarketDataService) loadRedis(key string) (PriceTick, bool) {
+	var tick PriceTick
+	if m.redis == nil {
+		return tick, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	data, err := m.redis.Get(ctx, marketDataRedisPrefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("⚠️  Market data: Redis unavailable: %v", err)
+		}
+		return tick, false
+	}
+	if err := json.Unmarshal(data, &tick); err != nil || time.Since(tick.UpdatedAt) > marketPriceMaxAge {
+		return tick, false
+	}
+	tick.Source = "redis"
+	return tick, true
+}
+
+// marketStreamEvent gồm field của bookTicker và markPriceUpdate (spot bookTicker không có "e").
+// Khai báo cả key hoa / thường vì encoding/json so khớp không phân biệt hoa thường.
+type marketStreamEvent struct {
+	Event     string `json:"e"`
+	EventTime int64  `json:"E"`
+	Symbol    string `json:"s"`
+	Bid       string `json:"b"`
+	BidQty    string `json:"B"`
+	Ask       string `json:"a"`
+	AskQty    string `json:"A"`
+	Mark      string `json:"p"`
+	Settle    string `json:"P"`
+	ID        *int64 `json:"id"` // Phản hồi SUBSCRIBE / UNSUBSCRIBE
+}
+
+func (m *MarketDataService) handleStreamMessage(market string, data []byte) {
+	var e marketStreamEvent
+	if err := json.Unmarshal(data, &e); err != nil || e.Symbol == "" {
+		return
+	}
+
+	if e.Event == "markPriceUpdate" {
+		mark := ParseDecimal(e.Mark)
+		if !mark.IsPositive() {
+			return
+		}
+		m.store(market, e.Symbol, func(t *PriceTick) {
+			t.Mark = mark
+			t.MarkUpdatedAt = time.Now()
+		})
+		return
+	}
+
+	bid, ask := ParseDecimal(e.Bid), ParseDecimal(e.Ask)
+	if !bid.IsPositive() || !ask.IsPositive() {
+		return
+	}
+	m.store(market, e.Symbol, func(t *PriceTick) {
+		t.Bid = bid
+		t.Ask = ask
+		t.Last = bid.Add(ask).Div(decimal.NewFromInt(2))
+		t.Source = "stream"
+		t.UpdatedAt = time.Now()
+	})
+}
+
+func (m *MarketDataService) newFeed(market, url string) *marketFeed {
+	return &marketFeed{
+		svc:    m,
+		market: market,
+		url:    url,
+		notify: make(chan struct{}, 1),
+		wanted: make(map[string]bool),
+		state:  StreamStateStopped,
+	}
+}
+
+// marketStreamsFor trả về tên stream của symbol (futures thêm mark price)
+func marketStreamsFor(market, symbol string) []string {
+	lower := strings.ToLower(symbol)
+	streams := []string{lower + marketDataQuoteStreamSuffix}
+	if market == "futures" {
+		streams = append(streams, lower+marketDataMarkStreamSuffix)
+	}
+	return streams
+}
+
+// setSymbols thay danh sách symbol cần subscribe; symbol vượt giới hạn stream bị bỏ (dùng REST)
+func (f *marketFeed) setSymbols(symbols map[string]bool) {
+	sorted := make([]string, 0, len(symbols))
+	for symbol := range symbols {
+		sorted = append(sorted, symbol)
+	}
+	sort.Strings(sorted)
+
+	wanted := make(map[string]bool)
+	dropped := 0
+	for _, symbol := range sorted {
+		streams := marketStreamsFor(f.market, symbol)
+		if len(wanted)+len(streams) > marketStreamsPerConnection {
+			dropped++
+			continue
+		}
+		for _, stream := range streams {
+			wanted[stream] = true
+		}
+	}
+	if dropped > 0 {
+		log.Printf("⚠️  Market data %s: %d symbols over the stream limit, using REST for them", f.market, dropped)
+	}
+
+	f.mu.Lock()
+	f.wanted = wanted
+	f.dropped = dropped
+	f.mu.Unlock()
+
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (f *marketFeed) wantedStreams() map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wanted := make(map[string]bool, len(f.wanted))
+	for stream := range f.wanted {
+		wanted[stream] = true
+	}
+	return wanted
+}
+
+func (f *marketFeed) setState(state, lastError string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = state
+	if lastError != "" {
+		f.lastError = lastError
+	}
+	if state == StreamStateConnected {
+		now := time.Now()
+		f.connectedAt = &now
+	}
+}
+
+func (f *marketFeed) status() MarketFeedStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	symbols := len(f.wanted)
+	if f.market == "futures" {
+		symbols /= 2
+	}
+	return MarketFeedStatus{
+		Market:      f.market,
+		State:       f.state,
+		Symbols:     symbols,
+		Subscribed:  len(f.wanted),
+		Dropped:     f.dropped,
+		Messages:    f.messages,
+		Reconnects:  f.reconnects,
+		ConnectedAt: f.connectedAt,
+		LastError:   f.lastError,
+	}
+}
+
+// run giữ kết nối tới stream công khai; chỉ kết nối khi có symbol cần theo dõi
+func (f *marketFeed) run() {
+	failures := 0
+	for {
+		if len(f.wantedStreams()) == 0 {
+			f.setState(StreamStateStopped, "")
+			select {
+			case <-f.notify:
+				continue
+			case <-f.svc.stopChan:
+				return
+			}
+		}
+
+		f.setState(StreamStateConnecting, "")
+		connected, err := f.session()
+		if err == nil {
+			return // Stop
+		}
+		if errors.Is(err, errNoMarketSymbols) {
+			failures = 0
+			continue
+		}
+		if connected {
+			failures = 0
+		}
+		failures++
+
+		delay := userStreamBackoff(failures)
+		log.Printf("⚠️  Market data %s stream: %v (retry in %s)", f.market, err, delay.Round(time.Second))
+		f.mu.Lock()
+		f.reconnects++
+		f.mu.Unlock()
+		f.setState(StreamStateBackoff, err.Error())
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-f.svc.stopChan:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// session trả về err == nil khi dừng do Stop
+func (f *marketFeed) session() (connected bool, err error) {
+	conn, _, err := websocket.DefaultDialer.Dial(f.url, nil)
+	if err != nil {
+		return false, fmt.Errorf("dial failed: %w", err)
+	}
+	defer conn.Close()
+	f.setState(StreamStateConnected, "")
+
+	conn.SetReadDeadline(time.Now().Add(marketDataReadTimeout))
+	conn.SetPingHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(marketDataReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(10*time.Second))
+	})
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(marketDataReadTimeout))
+			f.mu.Lock()
+			f.messages++
+			f.mu.Unlock()
+			f.svc.handleStreamMessage(f.market, message)
+		}
+	}()
+
+	// Chỉ goroutine này ghi vào conn (SUBSCRIBE / UNSUBSCRIBE)
+	subscribed := make(map[string]bool)
+	requestID := int64(0)
+	syncSubscriptions := func() error {
+		wanted := f.wantedStreams()
+		var add, remove []string
+		for stream := range wanted {
+			if !subscribed[stream] {
+				add = append(add, stream)
+			}
+		}
+		for stream := range subscribed {
+			if !wanted[stream] {
+				remove = append(remove, stream)
+			}
+		}
+		send := func(method string, streams []string) error {
+			sort.Strings(streams)
+			for start := 0; start < len(streams); start += marketSubscribeBatch {
+				end := start + marketSubscribeBatch
+				if end > len(streams) {
+					end = len(streams)
+				}
+				requestID++
+				req := map[string]interface{}{"method": method, "params": streams[start:end], "id": requestID}
+				if err := conn.WriteJSON(req); err != nil {
+					return fmt.Errorf("%s failed: %w", strings.ToLower(method), err)
+				}
+			}
+			return nil
+		}
+		if err := send("UNSUBSCRIBE", remove); err != nil {
+			return err
+		}
+		if err := send("SUBSCRIBE", add); err != nil {
+			return err
+		}
+		for _, stream := range remove {
+			delete(subscribed, stream)
+		}
+		for _, stream := range add {
+			subscribed[stream] = true
+		}
+		return nil
+	}
+	if err := syncSubscriptions(); err != nil {
+		return true, err
+	}
+
+	for {
+		select {
+		case <-f.notify:
+			if err := syncSubscriptions(); err != nil {
+				return true, err
+			}
+			if len(subscribed) == 0 {
+				// Không còn symbol nào: đóng kết nối, run() chờ tới khi có symbol mới
+				return true, errNoMarketSymbols
+			}
+		case err := <-readErr:
+			return true, fmt.Errorf("read failed: %w", err)
+		case <-f.svc.stopChan:
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return true, nil
+		}
+	}
+}
@@ -1,43 +1,161 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"tradercoin/backend/models"
 	"tradercoin/backend/utils"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
+const (
+	// DefaultOrderMonitorWorkers là số account (exchange key) được kiểm tra song song
+	DefaultOrderMonitorWorkers = 8
+	// DefaultOrderMonitorRatePerKey là số request REST mỗi giây cho 1 exchange key
+	DefaultOrderMonitorRatePerKey = 5
+	// DefaultOrderMonitorBurstPerKey là số request được phép dồn ngay khi key rảnh
+	DefaultOrderMonitorBurstPerKey = 10
+)
+
+// UserStreamCoverage reports whether an account already receives order updates from a live user data stream.
+// Order monitor bỏ qua các key này để không poll REST trùng với stream.
+type UserStreamCoverage interface {
+	IsStreamLive(accountKey string) bool
+}
+
+// AccountStreamKey identifies an exchange account (exchange + trading mode + API key).
+// API key được hash để không lộ ra trong metrics / log.
+func AccountStreamKey(exchange, tradingMode, apiKey string) string {
+	mode := strings.ToLower(tradingMode)
+	switch mode {
+	case "future":
+		mode = "futures"
+	case "":
+		mode = "spot"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return fmt.Sprintf("%s:%s:%s", strings.ToLower(exchange), mode, hex.EncodeToString(sum[:])[:16])
+}
+
+// OrderMonitorRun is the result of one monitoring pass
+type OrderMonitorRun struct {
+	StartedAt       time.Time `json:"started_at"`
+	DurationMs      int64     `json:"duration_ms"`
+	Orders          int       `json:"orders"`           // Lệnh cần theo dõi
+	Accounts        int       `json:"accounts"`         // Số exchange key
+	Checked         int       `json:"checked"`          // Lệnh đã hỏi trạng thái trên sàn
+	Updated         int       `json:"updated"`          // Lệnh đổi trạng thái
+	Errors          int       `json:"errors"`           // Lỗi giải mã key / gọi API / ghi DB
+	StreamSkipped   int       `json:"stream_skipped"`   // Lệnh bỏ qua vì key đang có user data stream
+	PositionBatches int       `json:"position_batches"` // Số lần gọi positionRisk (1 lần / account futures)
+	RateLimitWaitMs int64     `json:"rate_limit_wait_ms"`
+	PriceUpdates    int       `json:"price_updates"` // Lệnh cập nhật giá / PnL tạm tính từ price cache
+}
+
+// OrderMonitorMetrics are the cumulative metrics of the order monitor
+type OrderMonitorMetrics struct {
+	Started            bool             `json:"started"`
+	Running            bool             `json:"running"` // Đang có lần chạy dở
+	IntervalSeconds    float64          `json:"interval_seconds"`
+	Workers            int              `json:"workers"`
+	RatePerKey         float64          `json:"rate_per_key"`
+	BurstPerKey        int              `json:"burst_per_key"`
+	Runs               int64            `json:"runs"`
+	SkippedTicks       int64            `json:"skipped_ticks"` // Tick bị bỏ vì lần chạy trước chưa xong
+	TotalChecked       int64            `json:"total_checked"`
+	TotalUpdated       int64            `json:"total_updated"`
+	TotalErrors        int64            `json:"total_errors"`
+	TotalStreamSkipped int64            `json:"total_stream_skipped"`
+	LastRun            *OrderMonitorRun `json:"last_run,omitempty"`
+}
+
 // OrderMonitorService monitors pending orders and updates their status
 type OrderMonitorService struct {
 	DB             *gorm.DB
 	WebSocketHub   *WebSocketHub
 	tickerInterval time.Duration
-	stopChan       chan bool
+	workers        int
+	ratePerKey     float64
+	burstPerKey    int
+	limiter        *KeyRateLimiter
+	coverage       UserStreamCoverage
+
+	running  atomic.Bool
+	started  atomic.Bool
+	stopOnce sync.Once
+	stopChan chan struct{}
+
+	metricsMu sync.RWMutex
+	metrics   OrderMonitorMetrics
+}
+
+// monitorAccount là các lệnh cần kiểm tra của cùng 1 exchange key
+type monitorAccount struct {
+	key       string
+	exchange  string
+	userID    uint
+	apiKey    string
+	apiSecret string
+	orders    []models.Order
+	configs   map[uint]*models.TradingConfig
 }
 
 // NewOrderMonitorService creates a new order monitor service
 func NewOrderMonitorService(db *gorm.DB, wsHub *WebSocketHub) *OrderMonitorService {
-	return &OrderMonitorService{
+	oms := &OrderMonitorService{
 		DB:             db,
 		WebSocketHub:   wsHub,
 		tickerInterval: 5 * time.Second, // Check every 5 seconds
-		stopChan:       make(chan bool),
+		workers:        DefaultOrderMonitorWorkers,
+		ratePerKey:     DefaultOrderMonitorRatePerKey,
+		burstPerKey:    DefaultOrderMonitorBurstPerKey,
+		stopChan:       make(chan struct{}),
+	}
+	oms.limiter = NewKeyRateLimiter(oms.ratePerKey, oms.burstPerKey)
+	if wsHub != nil {
+		oms.coverage = wsHub
 	}
+	return oms
+}
+
+// SetStreamCoverage replaces the source used to skip accounts covered by a live user data stream (nil = không bỏ qua)
+func (oms *OrderMonitorService) SetStreamCoverage(coverage UserStreamCoverage) {
+	oms.coverage = coverage
 }
 
 // Start begins the background monitoring process
 func (oms *OrderMonitorService) Start() {
-	log.Println("🔄 Order Monitor Service started - checking every 5 seconds")
+	if !oms.started.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("🔄 Order Monitor Service started - checking every %s (%d workers, %.0f req/s per key)",
+		oms.tickerInterval, oms.workers, oms.ratePerKey)
 
 	ticker := time.NewTicker(oms.tickerInterval)
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				oms.checkPendingOrders()
+				// Lần chạy trước chưa xong (nhiều lệnh / bị rate limit) → bỏ tick này thay vì chạy chồng
+				if !oms.running.CompareAndSwap(false, true) {
+					oms.metricsMu.Lock()
+					oms.metrics.SkippedTicks++
+					oms.metricsMu.Unlock()
+					continue
+				}
+				go func() {
+					defer oms.running.Store(false)
+					oms.checkPendingOrders()
+				}()
 			case <-oms.stopChan:
 				ticker.Stop()
 				log.Println("⏹️  Order Monitor Service stopped")
@@ -47,254 +165,524 @@ func (oms *OrderMonitorService) Start() {
 	}()
 }
 
-// Stop stops the monitoring service
+// Stop stops the monitoring service (lần chạy dở dừng ở lệnh kế tiếp)
 func (oms *OrderMonitorService) Stop() {
-	oms.stopChan <- true
+	oms.stopOnce.Do(func() { close(oms.stopChan) })
 }
 
-// checkPendingOrders checks all pending orders from exchange
+// Metrics returns a snapshot of the monitor metrics
+func (oms *OrderMonitorService) Metrics() OrderMonitorMetrics {
+	oms.metricsMu.RLock()
+	defer oms.metricsMu.RUnlock()
+
+	m := oms.metrics
+	if m.LastRun != nil {
+		last := *m.LastRun
+		m.LastRun = &last
+	}
+	m.Started = oms.started.Load()
+	m.Running = oms.running.Load()
+	m.IntervalSeconds = oms.tickerInterval.Seconds()
+	m.Workers = oms.workers
+	m.RatePerKey = oms.ratePerKey
+	m.BurstPerKey = oms.burstPerKey
+	return m
+}
+
+func (oms *OrderMonitorService) stopped() bool {
+	select {
+	case <-oms.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkPendingOrders checks all pending orders from exchange, grouped by exchange key
 func (oms *OrderMonitorService) checkPendingOrders() {
-	// Query orders to monitor:
-	// - Spot: new, pending, partially_filled
-	// - Futures: all except 'closed' (including 'filled' because position is still open)
-	var orders []models.Order
-	err := oms.DB.Where(
-		"(LOWER(trading_mode) IN (?, ?) AND LOWER(status) != ?) OR "+
-			"((trading_mode IS NULL OR LOWER(trading_mode) = ?) AND LOWER(status) IN (?, ?, ?))",
-		"futures", "future", "closed", // Futures: monitor all except closed
-		"spot", "new", "pending", "partially_filled", // Spot: only monitor pending statuses
-	).Preload("User"). // Load user info
-				Find(&orders).Error
+	run := OrderMonitorRun{StartedAt: time.Now()}
+	defer oms.recordRun(&run)
+
+	// Lệnh không cần poll trạng thái (spot đang giữ, futures có user stream) vẫn được cập nhật PnL từ price cache
+	priced := oms.loadHeldSpotOrders()
+	defer func() { run.PriceUpdates = oms.markToMarket(priced) }()
 
+	orders, err := oms.loadMonitoredOrders()
 	if err != nil {
 		log.Printf("❌ Failed to query pending orders: %v", err)
+		run.Errors++
 		return
 	}
-
+	run.Orders = len(orders)
 	if len(orders) == 0 {
-		log.Println("📊 No pending orders to check")
 		return
 	}
 
-	log.Printf("\n🔍 ===== ORDER MONITOR - Checking %d pending orders =====", len(orders))
-
-	// Group orders by bot_config_id to batch load configs
-	configIDs := make(map[uint]bool)
-	for _, order := range orders {
-		if order.BotConfigID > 0 {
-			configIDs[order.BotConfigID] = true
+	accounts, errCount := oms.groupByAccount(orders)
+	run.Errors += errCount
+	run.Accounts = len(accounts)
+
+	// Account đã có user data stream thì sàn tự đẩy cập nhật → không poll
+	pending := make([]*monitorAccount, 0, len(accounts))
+	for _, account := range accounts {
+		if oms.coverage != nil && oms.coverage.IsStreamLive(account.key) {
+			run.StreamSkipped += len(account.orders)
+			for _, order := range account.orders {
+				if strings.EqualFold(order.Status, "filled") {
+					priced = append(priced, order)
+				}
+			}
+			continue
 		}
+		pending = append(pending, account)
 	}
 
-	// Load all bot configs in one query
-	configIDList := make([]uint, 0, len(configIDs))
-	for id := range configIDs {
-		configIDList = append(configIDList, id)
-	}
+	log.Printf("🔍 ORDER MONITOR - %d orders on %d accounts (%d accounts covered by user stream)",
+		len(orders), len(accounts), len(accounts)-len(pending))
 
-	var configs []models.TradingConfig
-	configMap := make(map[uint]models.TradingConfig)
-	if len(configIDList) > 0 {
-		oms.DB.Where("id IN ?", configIDList).Find(&configs)
-		for _, config := range configs {
-			configMap[config.ID] = config
+	workers := oms.workers
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+	jobs := make(chan *monitorAccount)
+	results := make(chan OrderMonitorRun, len(pending))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for account := range jobs {
+				results <- oms.checkAccount(account)
+			}
+		}()
+	}
+	for _, account := range pending {
+		if oms.stopped() {
+			break
 		}
+		jobs <- account
 	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	for r := range results {
+		run.Checked += r.Checked
+		run.Updated += r.Updated
+		run.Errors += r.Errors
+		run.PositionBatches += r.PositionBatches
+		run.RateLimitWaitMs += r.RateLimitWaitMs
+	}
+
+	// Key không còn lệnh thì bỏ bucket cho gọn
+	oms.limiter.Prune(10 * time.Minute)
+}
 
-	log.Printf("📦 Loaded %d bot configs", len(configMap))
+// loadMonitoredOrders trả về các lệnh cần theo dõi trạng thái:
+// - Spot: new, pending, partially_filled
+// - Futures: all except 'closed' (including 'filled' because position is still open)
+func (oms *OrderMonitorService) loadMonitoredOrders() ([]models.Order, error) {
+	var orders []models.Order
+	err := oms.DB.Where(
+		"(LOWER(trading_mode) IN (?, ?) AND LOWER(status) != ?) OR "+
+			"((trading_mode IS NULL OR LOWER(trading_mode) = ?) AND LOWER(status) IN (?, ?, ?))",
+		"futures", "future", "closed", // Futures: monitor all except closed
+		"spot", "new", "pending", "partially_filled", // Spot: only monitor pending statuses
+	).Where("order_id <> '' AND bot_config_id > 0").
+		Find(&orders).Error
+	return orders, err
+}
 
-	// Check each order
-	updatedCount := 0
-	errorCount := 0
+// loadHeldSpotOrders trả về các lệnh spot BUY đã khớp (coin đang giữ)
+func (oms *OrderMonitorService) loadHeldSpotOrders() []models.Order {
+	var orders []models.Order
+	if err := oms.DB.Where("(trading_mode IS NULL OR LOWER(trading_mode) = ?) AND LOWER(status) = ? AND UPPER(side) = ?",
+		"spot", "filled", "BUY").
+		Where("bot_config_id > 0").
+		Find(&orders).Error; err != nil {
+		log.Printf("⚠️  Failed to query held spot orders: %v", err)
+	}
+	return orders
+}
 
-	for _, order := range orders {
-		// Get bot config
-		config, exists := configMap[order.BotConfigID]
-		if !exists {
-			log.Printf("⚠️  Order %d: Bot config %d not found", order.ID, order.BotConfigID)
+// markToMarket cập nhật current_price và PnL tạm tính của lệnh từ price cache (không gọi REST).
+// Futures dùng mark price như sàn; symbol chưa có giá trong cache thì bỏ qua lần này.
+func (oms *OrderMonitorService) markToMarket(orders []models.Order) int {
+	updated := 0
+	for i := range orders {
+		order := &orders[i]
+		isFutures := marketOf(order.TradingMode) == "futures"
+
+		var current decimal.Decimal
+		var ok bool
+		if isFutures {
+			current, ok = MarketData().MarkPrice(order.Symbol)
+		} else {
+			current, ok = MarketData().Price(order.TradingMode, order.Symbol)
+		}
+		if !ok {
+			continue
+		}
+		if current.Equal(order.CurrentPrice) {
+			continue
+		}
+
+		entry := order.FilledPrice
+		if !entry.IsPositive() {
+			entry = order.Price
+		}
+		quantity := order.FilledQuantity
+		if !quantity.IsPositive() {
+			quantity = order.Quantity
+		}
+		if !entry.IsPositive() || !quantity.IsPositive() {
+			continue
+		}
+		pnl, pnlPercent := CalculatePnL(order.Side, entry, current, quantity)
+
+		if err := oms.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+			"current_price": current,
+			"pn_l":          pnl,
+			"pn_l_percent":  pnlPercent,
+		}).Error; err != nil {
+			log.Printf("⚠️  Order %d: Failed to update market price: %v", order.ID, err)
 			continue
 		}
+		updated++
+	}
+	return updated
+}
 
-		// Skip if no order ID
-		if order.OrderID == "" {
+// ResyncAccount checks the orders of one account via REST, kể cả khi account đang có user data stream.
+// Dùng sau khi stream bị gián đoạn để bắt kịp các sự kiện bị lỡ.
+func (oms *OrderMonitorService) ResyncAccount(accountKey string) (OrderMonitorRun, error) {
+	run := OrderMonitorRun{StartedAt: time.Now()}
+	orders, err := oms.loadMonitoredOrders()
+	if err != nil {
+		return run, err
+	}
+	accounts, errCount := oms.groupByAccount(orders)
+	run.Errors += errCount
+	for _, account := range accounts {
+		if account.key != accountKey {
 			continue
 		}
+		r := oms.checkAccount(account)
+		r.StartedAt = run.StartedAt
+		r.Orders = len(account.orders)
+		r.Accounts = 1
+		r.Errors += run.Errors
+		r.DurationMs = time.Since(run.StartedAt).Milliseconds()
+		return r, nil
+	}
+	return run, nil
+}
+
+// groupByAccount decrypts credentials once per bot config and groups orders by exchange key
+func (oms *OrderMonitorService) groupByAccount(orders []models.Order) ([]*monitorAccount, int) {
+	configIDs := make([]uint, 0)
+	seen := make(map[uint]bool)
+	for _, order := range orders {
+		if !seen[order.BotConfigID] {
+			seen[order.BotConfigID] = true
+			configIDs = append(configIDs, order.BotConfigID)
+		}
+	}
 
-		// Decrypt API credentials
+	var configs []models.TradingConfig
+	if err := oms.DB.Where("id IN ?", configIDs).Find(&configs).Error; err != nil {
+		log.Printf("❌ Failed to load bot configs: %v", err)
+		return nil, 1
+	}
+
+	type credentials struct {
+		apiKey    string
+		apiSecret string
+	}
+	configMap := make(map[uint]*models.TradingConfig, len(configs))
+	creds := make(map[uint]credentials, len(configs))
+	errCount := 0
+	for i := range configs {
+		config := &configs[i]
 		apiKey, err := utils.DecryptString(config.APIKey)
 		if err != nil {
-			log.Printf("⚠️  Order %d: Failed to decrypt API key: %v", order.ID, err)
-			errorCount++
+			log.Printf("⚠️  Bot config %d: Failed to decrypt API key: %v", config.ID, err)
+			errCount++
 			continue
 		}
-
 		apiSecret, err := utils.DecryptString(config.APISecret)
 		if err != nil {
-			log.Printf("⚠️  Order %d: Failed to decrypt API secret: %v", order.ID, err)
-			errorCount++
+			log.Printf("⚠️  Bot config %d: Failed to decrypt API secret: %v", config.ID, err)
+			errCount++
+			continue
+		}
+		configMap[config.ID] = config
+		creds[config.ID] = credentials{apiKey, apiSecret}
+	}
+
+	accounts := make(map[string]*monitorAccount)
+	var ordered []*monitorAccount
+	for _, order := range orders {
+		config, exists := configMap[order.BotConfigID]
+		if !exists {
 			continue
 		}
+		c := creds[order.BotConfigID]
+		key := AccountStreamKey(order.Exchange, config.TradingMode, c.apiKey)
+		account, exists := accounts[key]
+		if !exists {
+			account = &monitorAccount{
+				key:       key,
+				exchange:  order.Exchange,
+				userID:    order.UserID,
+				apiKey:    c.apiKey,
+				apiSecret: c.apiSecret,
+				configs:   make(map[uint]*models.TradingConfig),
+			}
+			accounts[key] = account
+			ordered = append(ordered, account)
+		}
+		account.orders = append(account.orders, order)
+		account.configs[config.ID] = config
+	}
+	return ordered, errCount
+}
+
+// checkAccount checks all orders of one exchange key; mọi request REST đi qua rate limiter của key
+func (oms *OrderMonitorService) checkAccount(account *monitorAccount) OrderMonitorRun {
+	var result OrderMonitorRun
+	tradingService := NewTradingService(account.apiKey, account.apiSecret, account.exchange, oms.DB, account.userID)
+
+	wait := func() bool {
+		waited, ok := oms.limiter.Wait(account.key, oms.stopChan)
+		result.RateLimitWaitMs += waited.Milliseconds()
+		return ok
+	}
+
+	// Vị thế futures lấy 1 lần cho cả account, chỉ khi có lệnh futures còn chạy
+	var positions map[string][]FuturesPositionInfo
+	positionsLoaded := false
+	loadPositions := func(config *models.TradingConfig) map[string][]FuturesPositionInfo {
+		if positionsLoaded {
+			return positions
+		}
+		positionsLoaded = true
+		if !wait() {
+			return nil
+		}
+		result.PositionBatches++
+		var err error
+		positions, err = tradingService.getAllFuturesPositions(config)
+		if err != nil {
+			log.Printf("⚠️  Account %s: Failed to get positions: %v", account.key, err)
+			result.Errors++
+		}
+		return positions
+	}
+
+	// Mỗi vị thế chỉ đồng bộ 1 lần / lượt dù có nhiều lệnh vào (DCA)
+	syncedPositions := make(map[uint]bool)
 
-		// Check order status from exchange
-		tradingService := NewTradingService(apiKey, apiSecret, order.Exchange, oms.DB, order.UserID)
-		statusResult := tradingService.CheckOrderStatus(&config, order.OrderID, order.Symbol, order.AlgoIDStopLoss)
+	for i := range account.orders {
+		order := &account.orders[i]
+		config := account.configs[order.BotConfigID]
+		if !wait() {
+			return result
+		}
 
+		statusResult := tradingService.CheckOrderStatus(config, order.OrderID, order.Symbol, protectiveAlgoID(order))
+		result.Checked++
 		if !statusResult.Success {
 			log.Printf("⚠️  Order %d: Failed to check status - %s", order.ID, statusResult.Error)
-			errorCount++
+			result.Errors++
 			continue
 		}
 
-		// Check if status changed
-		oldStatus := order.Status
-		newStatus := statusResult.Status
-		newStatusLower := strings.ToLower(newStatus)
-		oldStatusLower := strings.ToLower(oldStatus)
-
-		// Variable to store position info for notification
-		var positionInfo *FuturesPositionInfo
-
-		// For Futures: Check if order/position is still running
-		if strings.ToLower(order.TradingMode) == "futures" || strings.ToLower(order.TradingMode) == "future" {
-			if statusResult.IsRunning {
-				// Order hoặc Algo Order vẫn đang chạy - Get position info
-				log.Printf("🔍 Order %d: Calling GetFuturesPosition for symbol=%s", order.ID, order.Symbol)
-				position, err := tradingService.GetFuturesPosition(order.Symbol)
-				if err != nil {
-					log.Printf("⚠️  Order %d: Failed to get position info: %v", order.ID, err)
-				} else if position != nil {
-					positionInfo = position // Save for WebSocket notification
-					log.Printf("🔍 Order %d (Futures): Status=%s, IsRunning=true, Type=%s, AlgoStatus=%s",
-						order.ID, statusResult.Status, statusResult.RunningType, statusResult.AlgoStatus)
-					log.Printf("   📊 Position Info:")
-					log.Printf("      Symbol: %s | Size: %.3f %s",
-						position.Symbol, position.PositionAmt, position.PositionSide)
-					log.Printf("      Entry Price: %.2f | Mark Price: %.2f | Liq.Price: %.2f",
-						position.EntryPrice, position.MarkPrice, position.LiquidationPrice)
-					log.Printf("      PnL: %.2f USDT (%.2f%%) | Margin: %.2f USDT | Leverage: %dx",
-						position.UnrealizedProfit, position.PnlPercent, position.IsolatedMargin, position.Leverage)
-
-					// Update database with position info (entry price, leverage, PnL)
-					updateFields := make(map[string]interface{})
-
-					// ⭐ LUÔN LƯU PNL MỖI LẦN CHECK (khi position còn đang mở)
-					order.PnL = position.UnrealizedProfit
-					order.PnLPercent = position.PnlPercent
-					updateFields["pn_l"] = position.UnrealizedProfit
-					updateFields["pn_l_percent"] = position.PnlPercent
-
-					if order.FilledPrice == 0 && position.EntryPrice > 0 {
-						order.FilledPrice = position.EntryPrice
-						order.Price = position.EntryPrice // Also set Price if not set
-						updateFields["filled_price"] = position.EntryPrice
-						updateFields["price"] = position.EntryPrice
-						log.Printf("   📝 Updated FilledPrice from position: %.2f", position.EntryPrice)
-					}
-
-					if order.Leverage == 0 && position.Leverage > 0 {
-						order.Leverage = position.Leverage
-						updateFields["leverage"] = position.Leverage
-						log.Printf("   📝 Updated Leverage from position: %dx", position.Leverage)
-					}
-
-					// ⭐ LƯU POSITION INFO VÀO DB (không lưu position_amt và mark_price vì thay đổi liên tục)
-					// Position Side: Tính từ side của order, không lấy từ API
-					positionSide := "LONG"
-					if strings.ToUpper(order.Side) == "SELL" {
-						positionSide = "SHORT"
-					}
-					order.PositionSide = positionSide
-					updateFields["position_side"] = positionSide
-
-					if position.LiquidationPrice > 0 {
-						order.LiquidationPrice = position.LiquidationPrice
-						updateFields["liquidation_price"] = position.LiquidationPrice
-					}
-
-					if position.MarginType != "" {
-						order.MarginType = position.MarginType
-						updateFields["margin_type"] = position.MarginType
-					}
-
-					if position.IsolatedMargin > 0 {
-						order.IsolatedMargin = position.IsolatedMargin
-						updateFields["isolated_margin"] = position.IsolatedMargin
-					}
-
-					if len(updateFields) > 0 {
-						if err := oms.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updateFields).Error; err != nil {
-							log.Printf("⚠️  Order %d: Failed to update position info: %v", order.ID, err)
-						} else {
-							log.Printf("✅ Order %d: Updated position info - PnL: %.4f USDT (%.2f%%)",
-								order.ID, position.UnrealizedProfit, position.PnlPercent)
-						}
-					}
-
-					// Send WebSocket update with position info (even if status not changed)
-					oms.notifyOrderUpdate(order.UserID, order.ID, &order, positionInfo)
-				} else {
-					log.Printf("⚠️  Order %d: GetFuturesPosition returned nil (no position or positionAmt=0)", order.ID)
-					log.Printf("🔍 Order %d (Futures): Status=%s, IsRunning=true, Type=%s, AlgoStatus=%s (No position)",
-						order.ID, statusResult.Status, statusResult.RunningType, statusResult.AlgoStatus)
+		isFutures := strings.ToLower(order.TradingMode) == "futures" || strings.ToLower(order.TradingMode) == "future"
+		if isFutures && statusResult.IsRunning {
+			// Order hoặc Algo Order vẫn đang chạy → chỉ cập nhật thông tin vị thế, order vẫn active
+			if position := matchOrderPosition(loadPositions(config), order); position != nil {
+				if err := oms.applyPosition(order, position, syncedPositions); err != nil {
+					log.Printf("⚠️  Order %d: Failed to update position info: %v", order.ID, err)
+					result.Errors++
 				}
-				// Không update gì, order vẫn active
-				continue
-			} else {
-				// Order và Algo Order đã không còn chạy → Close position
-				log.Printf("🔍 Order %d (Futures): Status=%s, IsRunning=false → Setting to CLOSED",
-					order.ID, statusResult.Status)
-				newStatus = "closed"
-				newStatusLower = "closed"
+				// Send WebSocket update with position info (even if status not changed)
+				oms.notifyOrderUpdate(order.UserID, order.ID, order, position)
 			}
-		} else {
-			// For Spot: Just log status
-			log.Printf("🔍 Order %d (Spot): Status=%s", order.ID, statusResult.Status)
+			continue
 		}
 
-		if newStatusLower != oldStatusLower {
-			// Update order in database
-			order.Status = newStatus
-
-			// Update filled price and quantity for filled orders
-			if newStatusLower == "filled" && order.TradingMode == "spot" {
-				if statusResult.AvgPrice > 0 {
-					order.FilledPrice = statusResult.AvgPrice
+		updated, err := oms.applyStatus(order, statusResult, isFutures)
+		if err != nil {
+			log.Printf("❌ Order %d (OrderID: %s): Failed to update in DB: %v", order.ID, order.OrderID, err)
+			result.Errors++
+			continue
+		}
+		if updated {
+			result.Updated++
+			// Vị thế futures vừa đóng: ghi realized PnL, phí, funding, giá / lý do đóng thay cho PnL chưa thực hiện
+			if isFutures && wait() {
+				if _, err := ApplyCloseSettlement(oms.DB, tradingService, order); err != nil {
+					log.Printf("⚠️  Order %d: close settlement failed: %v", order.ID, err)
+					RetryCloseSettlement(oms.DB, []uint{order.ID})
 				}
-				order.FilledQuantity = statusResult.Filled
-
-				log.Printf("✅ Order %d: %s → %s (Filled Price: %.8f, Qty: %.8f)",
-					order.ID, oldStatus, newStatus, order.FilledPrice, order.FilledQuantity)
-			} else {
-				log.Printf("✅ Order %d: %s → %s", order.ID, oldStatus, newStatus)
 			}
-
-			// Validate order ID before update
-			if order.ID == 0 {
-				log.Printf("⚠️  Order has invalid ID (0), skipping update. OrderID: %s", order.OrderID)
-				errorCount++
-				continue
+			if isFutures {
+				ResizeAfterPartialCloseFill(oms.DB, order) // Lệnh LIMIT đóng một phần vừa khớp
 			}
+			oms.notifyOrderUpdate(order.UserID, order.ID, order, nil)
+		}
+	}
+	return result
+}
 
-			// Save to database using primary key ID
-			updateData := map[string]interface{}{
-				"status":          newStatus,
-				"filled_price":    order.FilledPrice,
-				"filled_quantity": order.FilledQuantity,
-			}
-			if err := oms.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updateData).Error; err != nil {
-				log.Printf("❌ Order %d (OrderID: %s): Failed to update in DB: %v", order.ID, order.OrderID, err)
-				errorCount++
-				continue
+// matchOrderPosition chọn vị thế của lệnh; hedge mode có LONG/SHORT riêng, one-way mode là BOTH
+func matchOrderPosition(positions map[string][]FuturesPositionInfo, order *models.Order) *FuturesPositionInfo {
+	return matchPositionSide(positions, order.Symbol, positionSideOfOrder(order.Side))
+}
+
+// matchPositionSide chọn vị thế LONG/SHORT của symbol (hedge mode) hoặc vị thế BOTH (one-way mode)
+func matchPositionSide(positions map[string][]FuturesPositionInfo, symbol, side string) *FuturesPositionInfo {
+	candidates := positions[symbol]
+	for i := range candidates {
+		if strings.ToUpper(candidates[i].PositionSide) == side {
+			return &candidates[i]
+		}
+	}
+	for i := range candidates {
+		if ps := strings.ToUpper(candidates[i].PositionSide); ps == "BOTH" || ps == "" {
+			if positionSideOf(ps, candidates[i].PositionAmt) == side {
+				return &candidates[i]
 			}
+		}
+	}
+	return nil
+}
+
+// applyPosition cập nhật Position của lệnh (lệnh đã gắn vị thế) hoặc thông tin vị thế trên chính lệnh (lệnh cũ)
+func (oms *OrderMonitorService) applyPosition(order *models.Order, position *FuturesPositionInfo, synced map[uint]bool) error {
+	if order.PositionID == nil {
+		return oms.applyPositionInfo(order, position)
+	}
+	if synced[*order.PositionID] {
+		return nil
+	}
+	synced[*order.PositionID] = true
+
+	var pos models.Position
+	if err := oms.DB.First(&pos, *order.PositionID).Error; err != nil {
+		return err
+	}
+	if pos.Status == PositionStatusClosed {
+		return nil
+	}
+	return ApplyExchangePosition(oms.DB, &pos, position)
+}
+
+// applyPositionInfo lưu PnL và thông tin vị thế (entry price, leverage, margin) của lệnh futures đang mở
+func (oms *OrderMonitorService) applyPositionInfo(order *models.Order, position *FuturesPositionInfo) error {
+	updateFields := make(map[string]interface{})
+
+	// ⭐ LUÔN LƯU PNL MỖI LẦN CHECK (khi position còn đang mở)
+	order.PnL = position.UnrealizedProfit
+	order.PnLPercent = position.PnlPercent
+	updateFields["pn_l"] = position.UnrealizedProfit
+	updateFields["pn_l_percent"] = position.PnlPercent
+
+	if order.FilledPrice.IsZero() && position.EntryPrice.IsPositive() {
+		order.FilledPrice = position.EntryPrice
+		order.Price = position.EntryPrice // Also set Price if not set
+		updateFields["filled_price"] = position.EntryPrice
+		updateFields["price"] = position.EntryPrice
+	}
+
+	if order.Leverage == 0 && position.Leverage > 0 {
+		order.Leverage = position.Leverage
+		updateFields["leverage"] = position.Leverage
+	}
+
+	// Position Side: Tính từ side của order, không lấy từ API (không lưu position_amt và mark_price vì thay đổi liên tục)
+	positionSide := "LONG"
+	if strings.ToUpper(order.Side) == "SELL" {
+		positionSide = "SHORT"
+	}
+	order.PositionSide = positionSide
+	updateFields["position_side"] = positionSide
 
-			updatedCount++
+	if position.LiquidationPrice.IsPositive() {
+		order.LiquidationPrice = position.LiquidationPrice
+		updateFields["liquidation_price"] = position.LiquidationPrice
+	}
+
+	if position.MarginType != "" {
+		order.MarginType = position.MarginType
+		updateFields["margin_type"] = position.MarginType
+	}
+
+	if position.IsolatedMargin.IsPositive() {
+		order.IsolatedMargin = position.IsolatedMargin
+		updateFields["isolated_margin"] = position.IsolatedMargin
+	}
+
+	return oms.DB.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updateFields).Error
+}
+
+// applyStatus cập nhật trạng thái lệnh qua state machine; futures không còn lệnh / algo order chạy → vị thế đã đóng
+func (oms *OrderMonitorService) applyStatus(order *models.Order, statusResult OrderStatusResult, isFutures bool) (bool, error) {
+	oldStatus := order.Status
+	newStatus := NormalizeOrderStatus(statusResult.Status)
+	if isFutures {
+		newStatus = OrderStatusClosed
+	}
+	if newStatus == NormalizeOrderStatus(oldStatus) {
+		return false, nil
+	}
 
-			// Send WebSocket notification to user (with position info if available)
-			oms.notifyOrderUpdate(order.UserID, order.ID, &order, positionInfo)
+	updateData := map[string]interface{}{}
+	// Update filled price and quantity for filled orders
+	if newStatus == OrderStatusFilled && order.TradingMode == "spot" {
+		if statusResult.AvgPrice.IsPositive() {
+			updateData["filled_price"] = statusResult.AvgPrice
 		}
+		updateData["filled_quantity"] = statusResult.Filled
 	}
 
-	log.Printf("🔷 ===== ORDER MONITOR - Complete: %d updated, %d errors =====\n", updatedCount, errorCount)
+	changed, err := ApplyOrderChange(oms.DB, order, OrderChange{
+		Status:  newStatus,
+		Updates: updateData,
+		Source:  OrderSourceMonitor,
+		Actor:   OrderActorBot(order.BotConfigID),
+		Payload: statusResult,
+	})
+	if errors.Is(err, ErrInvalidOrderTransition) {
+		log.Printf("⚠️  %v", err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		log.Printf("✅ Order %d: %s → %s", order.ID, oldStatus, order.Status)
+	}
+	return changed, nil
+}
+
+func (oms *OrderMonitorService) recordRun(run *OrderMonitorRun) {
+	run.DurationMs = time.Since(run.StartedAt).Milliseconds()
+
+	oms.metricsMu.Lock()
+	oms.metrics.Runs++
+	oms.metrics.TotalChecked += int64(run.Checked)
+	oms.metrics.TotalUpdated += int64(run.Updated)
+	oms.metrics.TotalErrors += int64(run.Errors)
+	oms.metrics.TotalStreamSkipped += int64(run.StreamSkipped)
+	oms.metrics.LastRun = run
+	oms.metricsMu.Unlock()
+
+	if run.Orders > 0 {
+		log.Printf("🔷 ORDER MONITOR - Complete in %dms: %d checked, %d updated, %d errors, %d skipped (stream)",
+			run.DurationMs, run.Checked, run.Updated, run.Errors, run.StreamSkipped)
+	}
 }
 
 // notifyOrderUpdate sends WebSocket notification to user with position info
@@ -337,10 +725,4 @@ func (oms *OrderMonitorService) notifyOrderUpdate(userID uint, orderID uint, ord
 	}
 
 	oms.WebSocketHub.BroadcastToUser(userID, message)
-
-	if position != nil {
-		log.Printf("📤 WebSocket notification sent to user %d for order %d (with position info)", userID, orderID)
-	} else {
-		log.Printf("📤 WebSocket notification sent to user %d for order %d", userID, orderID)
-	}
 }
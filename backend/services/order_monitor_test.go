@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMatchPositionSide(t *testing.T) {
+	position := func(positionSide string, amount int64) FuturesPositionInfo {
+		return FuturesPositionInfo{Symbol: "BTCUSDT", PositionSide: positionSide, PositionAmt: decimal.NewFromInt(amount)}
+	}
+	hedge := map[string][]FuturesPositionInfo{"BTCUSDT": {position("LONG", 2), position("SHORT", -3)}}
+
+	tests := []struct {
+		name      string
+		positions map[string][]FuturesPositionInfo
+		symbol    string
+		side      string
+		wantAmt   int64 // PositionAmt của vị thế được chọn; 0 = không có
+	}{
+		{"hedge long", hedge, "BTCUSDT", "LONG", 2},
+		{"hedge short", hedge, "BTCUSDT", "SHORT", -3},
+		{"lowercase position side", map[string][]FuturesPositionInfo{"BTCUSDT": {position("long", 1)}}, "BTCUSDT", "LONG", 1},
+		{"one-way long", map[string][]FuturesPositionInfo{"BTCUSDT": {position("BOTH", 4)}}, "BTCUSDT", "LONG", 4},
+		{"one-way short", map[string][]FuturesPositionInfo{"BTCUSDT": {position("BOTH", -4)}}, "BTCUSDT", "SHORT", -4},
+		{"one-way opposite side", map[string][]FuturesPositionInfo{"BTCUSDT": {position("BOTH", 4)}}, "BTCUSDT", "SHORT", 0},
+		{"empty position side", map[string][]FuturesPositionInfo{"BTCUSDT": {position("", -1)}}, "BTCUSDT", "SHORT", -1},
+		{"hedge side missing", map[string][]FuturesPositionInfo{"BTCUSDT": {position("LONG", 2)}}, "BTCUSDT", "SHORT", 0},
+		{"other symbol", hedge, "ETHUSDT", "LONG", 0},
+		{"no positions", nil, "BTCUSDT", "LONG", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchPositionSide(tt.positions, tt.symbol, tt.side)
+			switch {
+			case tt.wantAmt == 0 && got != nil:
+				t.Errorf("matchPositionSide(%s, %s) = %s %s, want nil", tt.symbol, tt.side, got.PositionSide, got.PositionAmt)
+			case tt.wantAmt != 0 && got == nil:
+				t.Errorf("matchPositionSide(%s, %s) = nil, want amount %d", tt.symbol, tt.side, tt.wantAmt)
+			case got != nil && !got.PositionAmt.Equal(decimal.NewFromInt(tt.wantAmt)):
+				t.Errorf("matchPositionSide(%s, %s) amount = %s, want %d", tt.symbol, tt.side, got.PositionAmt, tt.wantAmt)
+			}
+		})
+	}
+}
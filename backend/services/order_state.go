@@ -0,0 +1,260 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Trạng thái lệnh (lưu chữ thường)
+const (
+	OrderStatusPending         = "pending" // Chưa gửi / chưa có phản hồi của sàn
+	OrderStatusNew             = "new"
+	OrderStatusPartiallyFilled = "partially_filled"
+	OrderStatusFilled          = "filled"
+	OrderStatusClosed          = "closed" // Vị thế của lệnh đã đóng
+	OrderStatusCancelled       = "cancelled"
+	OrderStatusExpired         = "expired"
+	OrderStatusRejected        = "rejected"
+	OrderStatusFailed          = "failed"
+)
+
+// Loại OrderEvent
+const (
+	OrderEventCreated       = "created"
+	OrderEventStatusChanged = "status_changed"
+	OrderEventFill          = "fill"
+	OrderEventProtection    = "protection_changed" // SL/TP được sửa / chuyển trailing / huỷ
+)
+
+// Nguồn thay đổi lệnh (ngoài OrderSourceSignal / Telegram / Manual của client order ID)
+const (
+	OrderSourceMonitor    = "order_monitor"
+	OrderSourceUserStream = "user_stream"
+	OrderSourceSystem     = "system"
+	OrderSourceReconcile  = "reconcile"
+)
+
+// ErrInvalidOrderTransition is returned when a status change is not allowed by the order state machine
+var ErrInvalidOrderTransition = errors.New("invalid order status transition")
+
+// orderTransitions: trạng thái → các trạng thái được phép chuyển tới. Trạng thái cuối không có chuyển tiếp.
+var orderTransitions = map[string][]string{
+	OrderStatusPending: {OrderStatusNew, OrderStatusPartiallyFilled, OrderStatusFilled, OrderStatusClosed,
+		OrderStatusCancelled, OrderStatusExpired, OrderStatusRejected, OrderStatusFailed},
+	OrderStatusNew: {OrderStatusPartiallyFilled, OrderStatusFilled, OrderStatusClosed,
+		OrderStatusCancelled, OrderStatusExpired, OrderStatusRejected},
+	OrderStatusPartiallyFilled: {OrderStatusFilled, OrderStatusClosed, OrderStatusCancelled, OrderStatusExpired},
+	OrderStatusFilled:          {OrderStatusClosed},
+	OrderStatusClosed:          {},
+	OrderStatusCancelled:       {},
+	OrderStatusExpired:         {},
+	OrderStatusRejected:        {},
+	OrderStatusFailed:          {},
+}
+
+// NormalizeOrderStatus chuẩn hoá trạng thái của sàn / code cũ: FILLED → filled, CANCELED → cancelled
+func NormalizeOrderStatus(status string) string {
+	status = strings.ToLower(strings.TrimSpace(status))
+	switch status {
+	case "canceled":
+		return OrderStatusCancelled
+	case "expired_in_match":
+		return OrderStatusExpired
+	}
+	return status
+}
+
+// CanTransitionOrder reports whether an order may move from one status to another.
+// Trạng thái cũ không nằm trong state machine (dữ liệu cũ) được phép chuyển sang bất kỳ trạng thái hợp lệ nào.
+func CanTransitionOrder(from, to string) bool {
+	from, to = NormalizeOrderStatus(from), NormalizeOrderStatus(to)
+	if _, known := orderTransitions[to]; !known {
+		return false
+	}
+	next, known := orderTransitions[from]
+	if !known {
+		return true
+	}
+	for _, s := range next {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderChange là 1 lần cập nhật lệnh: trạng thái mới (tuỳ chọn), các cột khác và ai / cái gì gây ra thay đổi
+type OrderChange struct {
+	Status  string                 // "" = giữ trạng thái
+	Updates map[string]interface{} // Cột khác ghi cùng lúc (filled_quantity, filled_price, ...)
+	Source  string                 // manual, telegram, signal, order_monitor, user_stream, system
+	Actor   string                 // user:5, bot:12, ...
+	Message string
+	Payload interface{} // Payload thô của sàn (lưu dạng JSON)
+}
+
+// OrderActorUser / OrderActorBot là Actor của OrderEvent
+func OrderActorUser(userID uint) string { return fmt.Sprintf("user:%d", userID) }
+func OrderActorBot(botConfigID uint) string {
+	if botConfigID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("bot:%d", botConfigID)
+}
+
+// ApplyOrderChange ghi thay đổi của lệnh qua state machine và thêm OrderEvent (đổi trạng thái hoặc có fill mới).
+// Chuyển trạng thái không hợp lệ: bỏ phần trạng thái, các cột khác vẫn ghi, trả về ErrInvalidOrderTransition.
+// Trạng thái chỉ ghi khi trong DB vẫn là trạng thái cũ (luồng khác đổi trước thì đọc lại và kiểm tra lại).
+// Trả về true khi trạng thái đã đổi.
+func ApplyOrderChange(db *gorm.DB, order *models.Order, change OrderChange) (bool, error) {
+	return applyOrderChange(db, order, change, true)
+}
+
+func applyOrderChange(db *gorm.DB, order *models.Order, change OrderChange, retry bool) (bool, error) {
+	from := NormalizeOrderStatus(order.Status)
+	to := NormalizeOrderStatus(change.Status)
+
+	updates := make(map[string]interface{}, len(change.Updates)+1)
+	for k, v := range change.Updates {
+		updates[k] = v
+	}
+
+	var transitionErr error
+	statusChanged := false
+	switch {
+	case to == "":
+	case to == from:
+		if order.Status != to {
+			updates["status"] = to // Cùng trạng thái, chỉ chuẩn hoá chữ hoa/thường
+		}
+	case CanTransitionOrder(from, to):
+		updates["status"] = to
+		statusChanged = true
+	default:
+		transitionErr = fmt.Errorf("%w: order %d %s → %s", ErrInvalidOrderTransition, order.ID, from, to)
+	}
+	if len(updates) == 0 {
+		return false, transitionErr
+	}
+
+	query := db.Model(&models.Order{}).Where("id = ?", order.ID)
+	if statusChanged {
+		query = query.Where("status = ?", order.Status)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if statusChanged && result.RowsAffected == 0 {
+		var current models.Order
+		if err := db.First(&current, order.ID).Error; err != nil {
+			return false, err
+		}
+		if !retry {
+			return false, fmt.Errorf("order %d status changed concurrently to %s", order.ID, current.Status)
+		}
+		*order = current
+		return applyOrderChange(db, order, change, false)
+	}
+
+	oldFilled := order.FilledQuantity
+	if s, ok := updates["status"].(string); ok {
+		order.Status = s
+	}
+	if v, ok := updates["filled_quantity"].(decimal.Decimal); ok {
+		order.FilledQuantity = v
+	}
+	if v, ok := updates["filled_price"].(decimal.Decimal); ok {
+		order.FilledPrice = v
+	}
+
+	delta := order.FilledQuantity.Sub(oldFilled)
+	event := OrderEventStatusChanged
+	if !statusChanged {
+		if delta.IsZero() {
+			return false, transitionErr
+		}
+		event = OrderEventFill
+	}
+	recordOrderEvent(db, order, models.OrderEvent{
+		Event:          event,
+		OldStatus:      from,
+		NewStatus:      NormalizeOrderStatus(order.Status),
+		FilledQtyDelta: delta,
+	}, change)
+	return statusChanged, transitionErr
+}
+
+// ApplyOrderChanges áp dụng cùng 1 thay đổi cho nhiều lệnh; trả về ID các lệnh đã đổi trạng thái
+func ApplyOrderChanges(db *gorm.DB, orderIDs []uint, change OrderChange) ([]uint, error) {
+	if len(orderIDs) == 0 {
+		return nil, nil
+	}
+	var orders []models.Order
+	if err := db.Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	changed := make([]uint, 0, len(orders))
+	for i := range orders {
+		ok, err := ApplyOrderChange(db, &orders[i], change)
+		if err != nil {
+			log.Printf("⚠️  Order %d: %v", orders[i].ID, err)
+		}
+		if ok {
+			changed = append(changed, orders[i].ID)
+		}
+	}
+	return changed, nil
+}
+
+// RecordOrderCreated ghi event đầu tiên của lệnh (sau khi tạo record)
+func RecordOrderCreated(db *gorm.DB, order *models.Order, source, actor string, payload interface{}) {
+	recordOrderEvent(db, order, models.OrderEvent{
+		Event:          OrderEventCreated,
+		NewStatus:      NormalizeOrderStatus(order.Status),
+		FilledQtyDelta: order.FilledQuantity,
+	}, OrderChange{Source: source, Actor: actor, Payload: payload})
+}
+
+func recordOrderEvent(db *gorm.DB, order *models.Order, event models.OrderEvent, change OrderChange) {
+	if order.ID == 0 {
+		return
+	}
+	event.OrderID = order.ID
+	event.UserID = order.UserID
+	event.FilledQuantity = order.FilledQuantity
+	event.FilledPrice = order.FilledPrice
+	event.Source = change.Source
+	if event.Source == "" {
+		event.Source = OrderSourceSystem
+	}
+	event.Actor = change.Actor
+	event.Message = change.Message
+	event.Payload = orderEventPayload(change.Payload)
+	if err := db.Create(&event).Error; err != nil {
+		log.Printf("⚠️  Order %d: failed to record %s event: %v", order.ID, event.Event, err)
+	}
+}
+
+func orderEventPayload(payload interface{}) string {
+	switch p := payload.(type) {
+	case nil:
+		return ""
+	case string:
+		return p
+	case []byte:
+		return string(p)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCanTransitionOrder(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{OrderStatusPending, OrderStatusNew, true},
+		{OrderStatusPending, OrderStatusFailed, true},
+		{OrderStatusNew, OrderStatusPartiallyFilled, true},
+		{OrderStatusNew, OrderStatusFailed, false},
+		{OrderStatusPartiallyFilled, OrderStatusFilled, true},
+		{OrderStatusPartiallyFilled, OrderStatusNew, false},
+		{OrderStatusFilled, OrderStatusClosed, true},
+		{OrderStatusFilled, OrderStatusCancelled, false},
+		{OrderStatusClosed, OrderStatusFilled, false},
+		{OrderStatusCancelled, OrderStatusFilled, false},
+		{OrderStatusFailed, OrderStatusNew, false},
+		// Trạng thái của sàn (chữ hoa, CANCELED) được chuẩn hoá
+		{"NEW", "FILLED", true},
+		{"NEW", "CANCELED", true},
+		{"PARTIALLY_FILLED", "EXPIRED_IN_MATCH", true},
+		// Trạng thái cũ ngoài state machine được chuyển sang trạng thái hợp lệ
+		{"open", OrderStatusFilled, true},
+		{"", OrderStatusNew, true},
+		// Trạng thái đích phải thuộc state machine
+		{OrderStatusNew, "open", false},
+		{"open", "active", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanTransitionOrder(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransitionOrder(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrderChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string // trạng thái lệnh đang giữ trong bộ nhớ
+		dbStatus    string // trạng thái trong DB ("" = giống status)
+		change      OrderChange
+		wantChanged bool
+		wantErr     error
+		wantStatus  string
+		wantFilled  string
+		wantEvents  []string
+	}{
+		{
+			name:   "valid transition",
+			status: OrderStatusPending, change: OrderChange{Status: "NEW"},
+			wantChanged: true, wantStatus: OrderStatusNew, wantFilled: "0", wantEvents: []string{OrderEventStatusChanged},
+		},
+		{
+			name:        "transition with fill",
+			status:      OrderStatusNew,
+			change:      OrderChange{Status: "FILLED", Updates: map[string]interface{}{"filled_quantity": decimal.NewFromInt(2)}},
+			wantChanged: true, wantStatus: OrderStatusFilled, wantFilled: "2", wantEvents: []string{OrderEventStatusChanged},
+		},
+		{
+			name:       "fill without status change",
+			status:     OrderStatusNew,
+			change:     OrderChange{Updates: map[string]interface{}{"filled_quantity": decimal.NewFromInt(1)}},
+			wantStatus: OrderStatusNew, wantFilled: "1", wantEvents: []string{OrderEventFill},
+		},
+		{
+			name:   "same status only normalizes case",
+			status: "FILLED", change: OrderChange{Status: "FILLED"},
+			wantStatus: OrderStatusFilled, wantFilled: "0",
+		},
+		{
+			name:    "invalid transition keeps status but writes other columns",
+			status:  OrderStatusClosed,
+			change:  OrderChange{Status: OrderStatusNew, Updates: map[string]interface{}{"filled_quantity": decimal.NewFromInt(3)}},
+			wantErr: ErrInvalidOrderTransition, wantStatus: OrderStatusClosed, wantFilled: "3", wantEvents: []string{OrderEventFill},
+		},
+		{
+			name:   "legacy status",
+			status: "open", change: OrderChange{Status: OrderStatusFilled},
+			wantChanged: true, wantStatus: OrderStatusFilled, wantFilled: "0", wantEvents: []string{OrderEventStatusChanged},
+		},
+		{
+			name:   "concurrent change is re-checked",
+			status: OrderStatusNew, dbStatus: OrderStatusCancelled, change: OrderChange{Status: OrderStatusFilled},
+			wantErr: ErrInvalidOrderTransition, wantStatus: OrderStatusCancelled, wantFilled: "0",
+		},
+		{
+			name:   "concurrent change still valid",
+			status: OrderStatusNew, dbStatus: OrderStatusPartiallyFilled, change: OrderChange{Status: OrderStatusFilled},
+			wantChanged: true, wantStatus: OrderStatusFilled, wantFilled: "0", wantEvents: []string{OrderEventStatusChanged},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			dbStatus := tt.dbStatus
+			if dbStatus == "" {
+				dbStatus = tt.status
+			}
+			order := models.Order{UserID: 1, Exchange: "binance", Symbol: "BTCUSDT", Side: "buy", Type: "market",
+				Quantity: decimal.NewFromInt(5), Status: dbStatus}
+			if err := db.Create(&order).Error; err != nil {
+				t.Fatalf("failed to create order: %v", err)
+			}
+			order.Status = tt.status
+
+			changed, err := ApplyOrderChange(db, &order, tt.change)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ApplyOrderChange error = %v, want %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+
+			var saved models.Order
+			db.First(&saved, order.ID)
+			if saved.Status != tt.wantStatus || order.Status != tt.wantStatus {
+				t.Errorf("status = %q (in memory %q), want %q", saved.Status, order.Status, tt.wantStatus)
+			}
+			if want := decimal.RequireFromString(tt.wantFilled); !saved.FilledQuantity.Equal(want) {
+				t.Errorf("filled_quantity = %s, want %s", saved.FilledQuantity, want)
+			}
+
+			var events []models.OrderEvent
+			db.Where("order_id = ?", order.ID).Order("id ASC").Find(&events)
+			if len(events) != len(tt.wantEvents) {
+				t.Fatalf("%d event(s) recorded, want %v", len(events), tt.wantEvents)
+			}
+			for i, e := range events {
+				if e.Event != tt.wantEvents[i] {
+					t.Errorf("event %d = %q, want %q", i, e.Event, tt.wantEvents[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidPartialClose is returned when a partial close request cannot be applied to the position
+var ErrInvalidPartialClose = errors.New("invalid partial close")
+
+// PartialCloseParams mô tả phần vị thế cần đóng: Quantity cố định hoặc Percent của khối lượng hiện tại trên sàn
+type PartialCloseParams struct {
+	Percent   decimal.Decimal
+	Quantity  decimal.Decimal
+	OrderType string          // market (mặc định) hoặc limit
+	Price     decimal.Decimal // Giá limit
+}
+
+// PartialCloseResult is the outcome of a partial close
+type PartialCloseResult struct {
+	Order             *models.Order    `json:"order"`
+	Position          *models.Position `json:"position"`
+	ClosedQuantity    decimal.Decimal  `json:"closed_quantity"`
+	RemainingQuantity decimal.Decimal  `json:"remaining_quantity"`
+	ResizedAlgoOrders []string         `json:"resized_algo_orders,omitempty"` // algoId mới của SL/TP đã đổi khối lượng
+}
+
+// isClosingOrder: lệnh đóng (reduce-only) ghi PositionSide là chiều vị thế nó giảm, ngược với chiều của Side
+func isClosingOrder(order *models.Order) bool {
+	ps := strings.ToUpper(order.PositionSide)
+	return (ps == "LONG" || ps == "SHORT") && positionSideOfOrder(order.Side) != ps
+}
+
+// positionTradingService tạo TradingService + config futures cho vị thế (bot config, hoặc exchange key nếu vị thế không gắn bot)
+func positionTradingService(db *gorm.DB, pos *models.Position) (*TradingService, *models.TradingConfig, error) {
+	if pos.BotConfigID > 0 {
+		var config models.TradingConfig
+		if err := db.First(&config, pos.BotConfigID).Error; err != nil {
+			return nil, nil, fmt.Errorf("bot config %d not found: %w", pos.BotConfigID, err)
+		}
+		apiKey, err := utils.DecryptString(config.APIKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt API key: %w", err)
+		}
+		apiSecret, err := utils.DecryptString(config.APISecret)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt API secret: %w", err)
+		}
+		config.TradingMode = "futures"
+		return NewTradingService(apiKey, apiSecret, config.Exchange, db, pos.UserID), &config, nil
+	}
+
+	var key models.ExchangeKey
+	if err := db.First(&key, pos.ExchangeKeyID).Error; err != nil {
+		return nil, nil, fmt.Errorf("exchange key %d not found: %w", pos.ExchangeKeyID, err)
+	}
+	apiKey, apiSecret := exchangeKeyCredentials(&key)
+	config := &models.TradingConfig{UserID: pos.UserID, Exchange: key.Exchange, TradingMode: "futures"}
+	return NewTradingService(apiKey, apiSecret, key.Exchange, db, pos.UserID), config, nil
+}
+
+// validate kiểm tra tham số (chưa cần vị thế trên sàn) và trả về order type đã chuẩn hoá
+func (p PartialCloseParams) validate() (string, error) {
+	orderType := strings.ToLower(p.OrderType)
+	if orderType == "" {
+		orderType = "market"
+	}
+	if orderType != "market" && orderType != "limit" {
+		return "", fmt.Errorf("%w: unsupported order type %s", ErrInvalidPartialClose, p.OrderType)
+	}
+	if orderType == "limit" && !p.Price.IsPositive() {
+		return "", fmt.Errorf("%w: price is required for limit orders", ErrInvalidPartialClose)
+	}
+	if p.Quantity.IsPositive() == p.Percent.IsPositive() {
+		return "", fmt.Errorf("%w: set either quantity or percent", ErrInvalidPartialClose)
+	}
+	if p.Percent.IsPositive() && !p.Percent.LessThan(hundred) {
+		return "", fmt.Errorf("%w: percent must be below 100, close the whole position instead", ErrInvalidPartialClose)
+	}
+	return orderType, nil
+}
+
+// closeQuantity tính khối lượng cần đóng theo khối lượng vị thế trên sàn, làm tròn xuống theo stepSize.
+// Phải nhỏ hơn khối lượng vị thế (đóng hết thì dùng close thường).
+func (p PartialCloseParams) closeQuantity(exchangeQty, step decimal.Decimal) (decimal.Decimal, error) {
+	quantity := p.Quantity
+	if p.Percent.IsPositive() {
+		quantity = exchangeQty.Mul(p.Percent).Div(hundred)
+	}
+	quantity = floorToStep(quantity, step)
+	if !quantity.IsPositive() {
+		return decimal.Zero, fmt.Errorf("%w: close quantity rounds to zero (step %s)", ErrInvalidPartialClose, step)
+	}
+	if !quantity.LessThan(exchangeQty) {
+		return decimal.Zero, fmt.Errorf("%w: quantity %s must be below the position size %s, close the whole position instead",
+			ErrInvalidPartialClose, quantity, exchangeQty)
+	}
+	return quantity, nil
+}
+
+// PartialClosePosition đóng một phần vị thế futures bằng lệnh reduce-only MARKET hoặc LIMIT.
+// MARKET: ghi lệnh đóng, giảm Position, đổi khối lượng SL/TP algo (không phải closePosition) theo phần còn lại
+// và đối soát realized PnL của phần đã đóng từ userTrades.
+// LIMIT: lệnh chờ khớp được theo dõi như các lệnh khác; fill / realized PnL ghi vào vị thế khi khớp
+// và SL/TP algo được đổi khối lượng lúc đó (ResizeAfterPartialCloseFill).
+// requestRef dùng để sinh client order ID (gửi lại cùng ref không đặt lệnh thứ hai).
+func PartialClosePosition(db *gorm.DB, pos *models.Position, params PartialCloseParams, source, actor, requestRef string) (*PartialCloseResult, error) {
+	if pos.Status == PositionStatusClosed {
+		return nil, fmt.Errorf("%w: position %d is already closed", ErrInvalidPartialClose, pos.ID)
+	}
+	orderType, err := params.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ts, config, err := positionTradingService(db, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serialize với signal / lệnh tay trên cùng API key + symbol
+	release, err := ExecutionLocks().Acquire(ExecutionLockKey(config.Exchange, ts.APIKey, pos.Symbol),
+		fmt.Sprintf("partial-close:position:%d", pos.ID), DefaultLockWaitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Kiểm tra trùng trong lock: 2 request cùng ref chạy song song không cùng lọt qua
+	clientOrderID := GenerateClientOrderID(pos.UserID, pos.BotConfigID, source, fmt.Sprintf("pclose|%d|%s", pos.ID, requestRef))
+	if existing, found := ts.findExistingOrderByClientID(clientOrderID); found {
+		return nil, fmt.Errorf("%w: partial close already placed (order %d)", ErrInvalidPartialClose, existing.ID)
+	}
+
+	positions, err := ts.getAllFuturesPositions(config)
+	if err != nil {
+		return nil, fmt.Errorf("position query failed: %w", err)
+	}
+	info := matchPositionSide(positions, pos.Symbol, pos.Side)
+	if info == nil {
+		return nil, fmt.Errorf("%w: no open %s %s position on the exchange", ErrInvalidPartialClose, pos.Symbol, strings.ToLower(pos.Side))
+	}
+	exchangeQty := info.PositionAmt.Abs()
+
+	lot, err := ts.getSymbolLotInfo("futures", pos.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	quantity, err := params.closeQuantity(exchangeQty, lot.StepSize)
+	if err != nil {
+		return nil, err
+	}
+
+	res := ts.PlaceReduceOnlyOrder(config, pos.Symbol, pos.Side, quantity, orderType, params.Price, clientOrderID)
+	if !res.Success {
+		return nil, fmt.Errorf("%s", res.Error)
+	}
+
+	closeSide := "SELL"
+	if pos.Side == "SHORT" {
+		closeSide = "BUY"
+	}
+	order := models.Order{
+		UserID:        pos.UserID,
+		BotConfigID:   pos.BotConfigID,
+		ExchangeKeyID: pos.ExchangeKeyID,
+		PositionID:    &pos.ID,
+		Exchange:      pos.Exchange,
+		Symbol:        pos.Symbol,
+		OrderID:       res.OrderID,
+		ClientOrderID: clientOrderID,
+		Side:          closeSide,
+		Type:          strings.ToUpper(orderType),
+		Quantity:      quantity,
+		Price:         res.Price,
+		Status:        NormalizeOrderStatus(res.Status),
+		TradingMode:   "futures",
+		Leverage:      pos.Leverage,
+		PositionSide:  pos.Side,
+	}
+
+	result := &PartialCloseResult{Order: &order, Position: pos, RemainingQuantity: exchangeQty}
+	if orderType == "market" {
+		exitPrice := res.FilledPrice
+		if !exitPrice.IsPositive() {
+			exitPrice = info.MarkPrice
+		}
+		entrySide := "buy"
+		if pos.Side == "SHORT" {
+			entrySide = "sell"
+		}
+		// PnL tạm tính theo giá vào trung bình, đối soát lại từ userTrades (realized, phí)
+		order.PnL, order.PnLPercent = CalculatePnL(entrySide, pos.EntryPrice, exitPrice, quantity)
+		order.Status = OrderStatusClosed
+		order.FilledQuantity = quantity
+		order.FilledPrice = exitPrice
+		order.CurrentPrice = exitPrice
+		order.ExitPrice = exitPrice
+		order.ExitReason = ExitReasonManual
+		now := time.Now()
+		order.ClosedAt = &now
+		result.ClosedQuantity = quantity
+		result.RemainingQuantity = exchangeQty.Sub(quantity)
+	}
+
+	if err := db.Create(&order).Error; err != nil {
+		// Lệnh đã đặt trên sàn: reconciliation / stream sẽ đồng bộ lại khối lượng vị thế
+		log.Printf("⚠️  Position %d: failed to record partial close order %s: %v", pos.ID, res.OrderID, err)
+		return result, nil
+	}
+	RecordOrderCreated(db, &order, source, actor, res)
+
+	if orderType == "market" {
+		if err := ReducePosition(db, pos.ID, &order, quantity, order.FilledPrice, false, ExitReasonManual); err != nil {
+			log.Printf("⚠️  Position %d: failed to reduce after partial close: %v", pos.ID, err)
+		}
+		result.ResizedAlgoOrders = ts.resizeProtectiveAlgoOrders(db, config, pos, closeSide, result.RemainingQuantity, lot.StepSize)
+		SettleClosedFuturesOrders(db, []uint{order.ID})
+	}
+	if err := db.First(pos, pos.ID).Error; err != nil {
+		log.Printf("⚠️  Position %d: failed to reload: %v", pos.ID, err)
+	}
+
+	log.Printf("✂️  Position %d (%s %s): partial close %s %s via %s order %s",
+		pos.ID, pos.Symbol, pos.Side, quantity, strings.ToUpper(orderType), source, res.OrderID)
+	return result, nil
+}
+
+// resizeProtectiveAlgoOrders đặt lại SL/TP algo có khối lượng cố định theo khối lượng còn lại của vị thế.
+// Algo closePosition (mặc định của TraderCoin) luôn đóng toàn bộ phần còn lại nên giữ nguyên.
+// Đặt algo mới trước rồi mới huỷ algo cũ để vị thế không lúc nào mất bảo vệ.
+func (ts *TradingService) resizeProtectiveAlgoOrders(db *gorm.DB, config *models.TradingConfig, pos *models.Position,
+	closeSide string, remaining, step decimal.Decimal) []string {
+
+	algoOrders, err := ts.listOpenAlgoOrders()
+	if err != nil {
+		log.Printf("⚠️  Position %d: failed to load algo orders: %v", pos.ID, err)
+		return nil
+	}
+	remaining = floorToStep(remaining, step)
+	hedge, _ := ts.isFuturesHedgeMode(config)
+
+	var resized []string
+	for _, algo := range algoOrders {
+		if algo.Symbol != pos.Symbol || !strings.EqualFold(algo.Side, closeSide) || algo.ClosePosition ||
+			!algo.Quantity.GreaterThan(remaining) {
+			continue
+		}
+		if ps := strings.ToUpper(algo.PositionSide); ps != "" && ps != "BOTH" && ps != pos.Side {
+			continue
+		}
+
+		params := url.Values{}
+		params.Set("algoType", "CONDITIONAL")
+		params.Set("symbol", algo.Symbol)
+		params.Set("side", strings.ToUpper(algo.Side))
+		params.Set("type", algo.OrderType)
+		params.Set("triggerPrice", ts.FormatPriceByTickSize("futures", algo.Symbol, algo.TriggerPrice))
+		params.Set("quantity", FormatDecimal(remaining))
+		if hedge {
+			params.Set("positionSide", pos.Side)
+		} else {
+			params.Set("reduceOnly", "true")
+		}
+		if algo.WorkingType != "" {
+			params.Set("workingType", algo.WorkingType)
+		}
+		var placed struct {
+			AlgoID int64 `json:"algoId"`
+		}
+		if err := ts.signedFuturesRequest("POST", "/fapi/v1/algoOrder", params, &placed); err != nil {
+			log.Printf("⚠️  Position %d: failed to resize %s algo %d: %v", pos.ID, algo.OrderType, algo.AlgoID, err)
+			continue
+		}
+
+		oldID, newID := strconv.FormatInt(algo.AlgoID, 10), strconv.FormatInt(placed.AlgoID, 10)
+		if err := ts.CancelAlgoOrder(oldID); err != nil {
+			log.Printf("⚠️  Position %d: failed to cancel old %s algo %s: %v", pos.ID, algo.OrderType, oldID, err)
+		}
+
+		// Lệnh của vị thế đang trỏ tới algo cũ → trỏ sang algo mới
+		db.Model(&models.Order{}).Where("user_id = ? AND symbol = ? AND algo_id_stop_loss = ?", pos.UserID, pos.Symbol, oldID).
+			Update("algo_id_stop_loss", newID)
+		db.Model(&models.Order{}).Where("user_id = ? AND symbol = ? AND algo_id_take_profit = ?", pos.UserID, pos.Symbol, oldID).
+			Update("algo_id_take_profit", newID)
+		resized = append(resized, newID)
+		log.Printf("🔁 Position %d: %s algo %s → %s (qty %s → %s)", pos.ID, algo.OrderType, oldID, newID, algo.Quantity, remaining)
+	}
+	return resized
+}
+
+// ResizeAfterPartialCloseFill đổi khối lượng SL/TP algo theo phần vị thế còn lại khi lệnh LIMIT đóng một phần khớp
+// (gọi từ user data stream / OrderMonitor). Chạy nền vì cần execution lock và REST; gọi lặp lại không sao
+// vì chỉ algo có khối lượng lớn hơn phần còn lại mới bị đặt lại.
+func ResizeAfterPartialCloseFill(db *gorm.DB, order *models.Order) {
+	if order.PositionID == nil || !isClosingOrder(order) || !strings.EqualFold(order.Type, "LIMIT") {
+		return
+	}
+	if status := NormalizeOrderStatus(order.Status); status != OrderStatusFilled && status != OrderStatusClosed {
+		return
+	}
+	orderID, positionID := order.ID, *order.PositionID
+
+	go func() {
+		var pos models.Position
+		if err := db.First(&pos, positionID).Error; err != nil {
+			log.Printf("⚠️  Order %d: failed to load position %d for SL/TP resize: %v", orderID, positionID, err)
+			return
+		}
+		ts, config, err := positionTradingService(db, &pos)
+		if err != nil {
+			log.Printf("⚠️  Position %d: %v", pos.ID, err)
+			return
+		}
+
+		release, err := ExecutionLocks().Acquire(ExecutionLockKey(config.Exchange, ts.APIKey, pos.Symbol),
+			fmt.Sprintf("partial-close-fill:order:%d", orderID), DefaultLockWaitTimeout)
+		if err != nil {
+			log.Printf("⚠️  Position %d: SL/TP resize after order %d skipped: %v", pos.ID, orderID, err)
+			return
+		}
+		defer release()
+
+		positions, err := ts.getAllFuturesPositions(config)
+		if err != nil {
+			log.Printf("⚠️  Position %d: position query failed: %v", pos.ID, err)
+			return
+		}
+		info := matchPositionSide(positions, pos.Symbol, pos.Side)
+		if info == nil || info.PositionAmt.IsZero() {
+			return // Vị thế đã đóng hết: SL/TP do luồng đóng vị thế dọn
+		}
+		lot, err := ts.getSymbolLotInfo("futures", pos.Symbol)
+		if err != nil {
+			log.Printf("⚠️  Position %d: %v", pos.ID, err)
+			return
+		}
+
+		closeSide := "SELL"
+		if pos.Side == "SHORT" {
+			closeSide = "BUY"
+		}
+		if resized := ts.resizeProtectiveAlgoOrders(db, config, &pos, closeSide, info.PositionAmt.Abs(), lot.StepSize); len(resized) > 0 {
+			log.Printf("✂️  Position %d: resized %d SL/TP algo order(s) after limit partial close %d", pos.ID, len(resized), orderID)
+		}
+	}()
+}
+
+// ApplyClosingOrderSettlement ghi realized PnL / phí / giá khớp của lệnh đóng (reduce-only) từ các trade của chính lệnh đó,
+// lưu trade làm fill của vị thế và tính lại tổng của vị thế
+func ApplyClosingOrderSettlement(db *gorm.DB, ts *TradingService, order *models.Order) error {
+	params := url.Values{}
+	params.Set("symbol", order.Symbol)
+	params.Set("orderId", order.OrderID)
+	var trades []binanceUserTrade
+	if err := ts.signedFuturesGet("/fapi/v1/userTrades", params, &trades); err != nil {
+		return err
+	}
+	if len(trades) == 0 {
+		return errors.New("no trades found for closing order")
+	}
+
+	s := &CloseSettlement{ExitReason: ExitReasonManual}
+	qty, notional := decimal.Zero, decimal.Zero
+	var lastTrade int64
+	for _, t := range trades {
+		s.RealizedPnL = s.RealizedPnL.Add(t.RealizedPnl)
+		s.Commission = s.Commission.Add(t.Commission)
+		s.CommissionAsset = t.CommissionAsset
+		s.Trades++
+		qty = qty.Add(t.Qty)
+		notional = notional.Add(t.Price.Mul(t.Qty))
+		if t.Time > lastTrade {
+			lastTrade = t.Time
+		}
+		s.addFill(order, t, true)
+	}
+	s.ExitPrice = notional.Div(qty)
+	s.ClosedAt = time.UnixMilli(lastTrade)
+	if order.ExitReason != "" {
+		s.ExitReason = order.ExitReason
+	}
+
+	updates := closeSettlementUpdates(order, s)
+	updates["filled_quantity"] = qty
+	updates["filled_price"] = s.ExitPrice
+	delete(updates, "pn_l_percent") // % tính theo giá vào lúc đặt lệnh, không theo giá thoát
+	if err := db.Model(&models.Order{}).Where("id = ?", order.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	if order.PositionID == nil {
+		return nil
+	}
+	if err := RecordPositionFills(db, s.fills); err != nil {
+		log.Printf("⚠️  Order %d: failed to record position fills: %v", order.ID, err)
+	}
+	log.Printf("💰 Closing order %d settled: realized %s, commission %s %s, exit %s",
+		order.ID, s.RealizedPnL, s.Commission, s.CommissionAsset, s.ExitPrice)
+	return RefreshPositionTotals(db, *order.PositionID)
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestPartialCloseParamsValidate(t *testing.T) {
+	d := decimal.RequireFromString
+	tests := []struct {
+		name          string
+		params        PartialCloseParams
+		wantOrderType string
+		wantErr       bool
+	}{
+		{"percent, default market", PartialCloseParams{Percent: d("50")}, "market", false},
+		{"quantity", PartialCloseParams{Quantity: d("0.01"), OrderType: "MARKET"}, "market", false},
+		{"limit with price", PartialCloseParams{Percent: d("25"), OrderType: "limit", Price: d("95000")}, "limit", false},
+		{"limit without price", PartialCloseParams{Percent: d("25"), OrderType: "limit"}, "", true},
+		{"unsupported order type", PartialCloseParams{Percent: d("25"), OrderType: "stop"}, "", true},
+		{"neither quantity nor percent", PartialCloseParams{}, "", true},
+		{"both quantity and percent", PartialCloseParams{Percent: d("25"), Quantity: d("0.01")}, "", true},
+		{"negative percent", PartialCloseParams{Percent: d("-10")}, "", true},
+		{"percent 100", PartialCloseParams{Percent: d("100")}, "", true},
+		{"percent just below 100", PartialCloseParams{Percent: d("99.9")}, "market", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderType, err := tt.params.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPartialClose) {
+				t.Errorf("validate() error = %v, want ErrInvalidPartialClose", err)
+			}
+			if orderType != tt.wantOrderType {
+				t.Errorf("validate() order type = %q, want %q", orderType, tt.wantOrderType)
+			}
+		})
+	}
+}
+
+func TestPartialCloseParamsCloseQuantity(t *testing.T) {
+	d := decimal.RequireFromString
+	tests := []struct {
+		name        string
+		params      PartialCloseParams
+		exchangeQty string
+		step        string
+		want        string
+		wantErr     bool
+	}{
+		{"percent of position", PartialCloseParams{Percent: d("50")}, "0.010", "0.001", "0.005", false},
+		{"percent rounds down to step", PartialCloseParams{Percent: d("33")}, "0.010", "0.001", "0.003", false},
+		{"quantity rounds down to step", PartialCloseParams{Quantity: d("0.0057")}, "0.010", "0.001", "0.005", false},
+		{"no step size", PartialCloseParams{Quantity: d("0.123456789")}, "1", "0", "0.12345678", false},
+		{"rounds to zero", PartialCloseParams{Percent: d("5")}, "0.010", "0.001", "", true},
+		{"quantity equal to position", PartialCloseParams{Quantity: d("0.010")}, "0.010", "0.001", "", true},
+		{"quantity above position", PartialCloseParams{Quantity: d("1")}, "0.010", "0.001", "", true},
+		{"percent near 100 stays below position", PartialCloseParams{Percent: d("99.99")}, "0.010", "0.001", "0.009", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.params.closeQuantity(d(tt.exchangeQty), d(tt.step))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("closeQuantity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidPartialClose) {
+					t.Errorf("closeQuantity() error = %v, want ErrInvalidPartialClose", err)
+				}
+				return
+			}
+			if !got.Equal(d(tt.want)) {
+				t.Errorf("closeQuantity() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
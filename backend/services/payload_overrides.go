@@ -0,0 +1,192 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+)
+
+// PayloadOverrides are optional execution parameters sent in the webhook payload.
+// Chỉ được áp dụng khi bot bật AllowSignalOverrides và nằm trong giới hạn của bot.
+type PayloadOverrides struct {
+	Quantity          *decimal.Decimal `json:"quantity,omitempty"`          // Khối lượng theo base asset
+	QuoteSize         *decimal.Decimal `json:"quoteSize,omitempty"`         // Giá trị lệnh theo quote asset (USDT), quy đổi theo giá
+	Leverage          *decimal.Decimal `json:"leverage,omitempty"`          // 1-125 (futures)
+	MarginMode        string           `json:"marginMode,omitempty"`        // ISOLATED hoặc CROSSED
+	OrderType         string           `json:"orderType,omitempty"`         // market hoặc limit
+	LimitPrice        *decimal.Decimal `json:"limitPrice,omitempty"`        // Giá limit (mặc định = price của signal)
+	StopLossPercent   *decimal.Decimal `json:"stopLossPercent,omitempty"`   // SL theo % thay cho % của bot
+	TakeProfitPercent *decimal.Decimal `json:"takeProfitPercent,omitempty"` // TP theo % thay cho % của bot
+	CallbackRate      *decimal.Decimal `json:"callbackRate,omitempty"`      // Trailing stop callback rate (0.1-5%)
+	ActivationPrice   *decimal.Decimal `json:"activationPrice,omitempty"`   // Trailing stop activation (%)
+}
+
+// ErrOverridesNotAllowed is returned when a bot does not accept payload overrides
+var ErrOverridesNotAllowed = errors.New("bot does not allow signal overrides")
+
+// IsEmpty returns true if the payload carries no override
+func (o PayloadOverrides) IsEmpty() bool {
+	return o.Quantity == nil && o.QuoteSize == nil && o.Leverage == nil && o.MarginMode == "" &&
+		o.OrderType == "" && o.LimitPrice == nil && o.StopLossPercent == nil && o.TakeProfitPercent == nil &&
+		o.CallbackRate == nil && o.ActivationPrice == nil
+}
+
+// Fields returns the JSON names of the overrides set in the payload (theo thứ tự khai báo)
+func (o PayloadOverrides) Fields() []string {
+	fields := []string{}
+	add := func(set bool, name string) {
+		if set {
+			fields = append(fields, name)
+		}
+	}
+	add(o.Quantity != nil, "quantity")
+	add(o.QuoteSize != nil, "quoteSize")
+	add(o.Leverage != nil, "leverage")
+	add(o.MarginMode != "", "marginMode")
+	add(o.OrderType != "", "orderType")
+	add(o.LimitPrice != nil, "limitPrice")
+	add(o.StopLossPercent != nil, "stopLossPercent")
+	add(o.TakeProfitPercent != nil, "takeProfitPercent")
+	add(o.CallbackRate != nil, "callbackRate")
+	add(o.ActivationPrice != nil, "activationPrice")
+	return fields
+}
+
+// HasSize returns true if the payload sets the order size
+func (o PayloadOverrides) HasSize() bool {
+	return o.Quantity != nil || o.QuoteSize != nil
+}
+
+// Normalize chuẩn hoá chữ hoa/thường của margin mode và order type
+func (o *PayloadOverrides) Normalize() {
+	o.MarginMode = strings.ToUpper(strings.TrimSpace(o.MarginMode))
+	if o.MarginMode == "CROSS" {
+		o.MarginMode = "CROSSED"
+	}
+	o.OrderType = strings.ToLower(strings.TrimSpace(o.OrderType))
+}
+
+// Validate checks the format and hard limits of the overrides (giống validate của bot config)
+func (o PayloadOverrides) Validate() error {
+	if o.Quantity != nil && o.QuoteSize != nil {
+		return errors.New("quantity and quoteSize are mutually exclusive")
+	}
+	if o.Quantity != nil && !o.Quantity.IsPositive() {
+		return errors.New("quantity must be greater than 0")
+	}
+	if o.QuoteSize != nil && !o.QuoteSize.IsPositive() {
+		return errors.New("quoteSize must be greater than 0")
+	}
+	if o.Leverage != nil && (!o.Leverage.IsInteger() || o.Leverage.LessThan(decimal.NewFromInt(1)) || o.Leverage.GreaterThan(decimal.NewFromInt(125))) {
+		return errors.New("leverage must be an integer between 1 and 125")
+	}
+	if o.MarginMode != "" && o.MarginMode != "ISOLATED" && o.MarginMode != "CROSSED" {
+		return errors.New("marginMode must be 'ISOLATED' or 'CROSSED'")
+	}
+	if o.OrderType != "" && o.OrderType != "market" && o.OrderType != "limit" {
+		return errors.New("orderType must be 'market' or 'limit'")
+	}
+	if o.LimitPrice != nil && !o.LimitPrice.IsPositive() {
+		return errors.New("limitPrice must be greater than 0")
+	}
+	if o.StopLossPercent != nil && (o.StopLossPercent.IsNegative() || o.StopLossPercent.GreaterThan(hundred)) {
+		return errors.New("stopLossPercent must be between 0 and 100")
+	}
+	if o.TakeProfitPercent != nil && (o.TakeProfitPercent.IsNegative() || o.TakeProfitPercent.GreaterThan(decimal.NewFromInt(1000))) {
+		return errors.New("takeProfitPercent must be between 0 and 1000")
+	}
+	if o.CallbackRate != nil && (o.CallbackRate.LessThan(decimal.NewFromFloat(0.1)) || o.CallbackRate.GreaterThan(decimal.NewFromInt(5))) {
+		return errors.New("callbackRate must be between 0.1 and 5")
+	}
+	if o.ActivationPrice != nil && o.ActivationPrice.IsNegative() {
+		return errors.New("activationPrice must be greater than or equal to 0")
+	}
+	return nil
+}
+
+// CheckBounds checks the overrides against the per-bot limits (0 = không giới hạn thêm).
+// refPrice dùng để tính giá trị lệnh khi payload gửi quantity (bỏ qua nếu chưa biết giá).
+func (o PayloadOverrides) CheckBounds(config *models.TradingConfig, refPrice decimal.Decimal) error {
+	if !config.AllowSignalOverrides {
+		return ErrOverridesNotAllowed
+	}
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	if o.Quantity != nil && config.SignalMaxQuantity > 0 && o.Quantity.GreaterThan(decimal.NewFromFloat(config.SignalMaxQuantity)) {
+		return fmt.Errorf("quantity %s exceeds bot limit %v", o.Quantity, config.SignalMaxQuantity)
+	}
+	if config.SignalMaxQuoteSize > 0 {
+		maxQuote := decimal.NewFromFloat(config.SignalMaxQuoteSize)
+		if o.QuoteSize != nil && o.QuoteSize.GreaterThan(maxQuote) {
+			return fmt.Errorf("quoteSize %s exceeds bot limit %v", o.QuoteSize, config.SignalMaxQuoteSize)
+		}
+		if o.Quantity != nil && refPrice.IsPositive() && o.Quantity.Mul(refPrice).GreaterThan(maxQuote) {
+			return fmt.Errorf("order value %s exceeds bot limit %v", o.Quantity.Mul(refPrice).Round(2), config.SignalMaxQuoteSize)
+		}
+	}
+	if o.Leverage != nil && config.SignalMaxLeverage > 0 && o.Leverage.IntPart() > int64(config.SignalMaxLeverage) {
+		return fmt.Errorf("leverage %s exceeds bot limit %d", o.Leverage, config.SignalMaxLeverage)
+	}
+	if o.OrderType == "limit" && !config.SignalAllowLimitOrders {
+		return errors.New("bot does not allow limit orders from signals")
+	}
+	if o.StopLossPercent != nil && config.SignalMaxStopLossPercent > 0 && o.StopLossPercent.GreaterThan(decimal.NewFromFloat(config.SignalMaxStopLossPercent)) {
+		return fmt.Errorf("stopLossPercent %s exceeds bot limit %v", o.StopLossPercent, config.SignalMaxStopLossPercent)
+	}
+	if o.TakeProfitPercent != nil && config.SignalMaxTakeProfitPercent > 0 && o.TakeProfitPercent.GreaterThan(decimal.NewFromFloat(config.SignalMaxTakeProfitPercent)) {
+		return fmt.Errorf("takeProfitPercent %s exceeds bot limit %v", o.TakeProfitPercent, config.SignalMaxTakeProfitPercent)
+	}
+	return nil
+}
+
+// ApplyTo trả về bản sao config đã áp leverage, margin mode, SL/TP % và trailing từ payload
+// (size và order type được xử lý lúc đặt lệnh)
+func (o PayloadOverrides) ApplyTo(config models.TradingConfig) models.TradingConfig {
+	if o.Leverage != nil {
+		config.Leverage = int(o.Leverage.IntPart())
+	}
+	if o.MarginMode != "" {
+		config.MarginMode = o.MarginMode
+	}
+	if o.StopLossPercent != nil {
+		config.StopLossPercent = o.StopLossPercent.InexactFloat64()
+	}
+	if o.TakeProfitPercent != nil {
+		config.TakeProfitPercent = o.TakeProfitPercent.InexactFloat64()
+	}
+	if o.CallbackRate != nil {
+		config.CallbackRate = o.CallbackRate.InexactFloat64()
+	}
+	if o.ActivationPrice != nil {
+		config.ActivationPrice = o.ActivationPrice.InexactFloat64()
+	}
+	return config
+}
+
+// MarshalPayloadOverrides returns the stored form of the overrides (rỗng nếu không có override)
+func MarshalPayloadOverrides(o PayloadOverrides) string {
+	if o.IsEmpty() {
+		return ""
+	}
+	b, _ := json.Marshal(o)
+	return string(b)
+}
+
+// ParsePayloadOverrides parses TradingSignal.Overrides
+func ParsePayloadOverrides(raw string) (PayloadOverrides, error) {
+	var o PayloadOverrides
+	if strings.TrimSpace(raw) == "" {
+		return o, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &o); err != nil {
+		return o, fmt.Errorf("invalid overrides: %w", err)
+	}
+	o.Normalize()
+	return o, nil
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+)
+
+func dec(s string) *decimal.Decimal {
+	d := decimal.RequireFromString(s)
+	return &d
+}
+
+func TestPayloadOverridesCheckBounds(t *testing.T) {
+	limits := models.TradingConfig{
+		AllowSignalOverrides:       true,
+		SignalMaxQuantity:          0.5,
+		SignalMaxQuoteSize:         1000,
+		SignalMaxLeverage:          10,
+		SignalMaxStopLossPercent:   5,
+		SignalMaxTakeProfitPercent: 20,
+	}
+	price := decimal.NewFromInt(1000) // quantity 0.5 → giá trị lệnh 500
+
+	tests := []struct {
+		name      string
+		overrides PayloadOverrides
+		config    models.TradingConfig
+		wantErr   bool
+	}{
+		{"within limits", PayloadOverrides{Quantity: dec("0.5"), Leverage: dec("10"), StopLossPercent: dec("5")}, limits, false},
+		{"not allowed", PayloadOverrides{Leverage: dec("2")}, models.TradingConfig{}, true},
+		{"quantity over limit", PayloadOverrides{Quantity: dec("0.51")}, limits, true},
+		{"quantity value over quote limit", PayloadOverrides{Quantity: dec("0.5")}, models.TradingConfig{AllowSignalOverrides: true, SignalMaxQuoteSize: 400}, true},
+		{"quoteSize over limit", PayloadOverrides{QuoteSize: dec("1000.01")}, limits, true},
+		{"quoteSize at limit", PayloadOverrides{QuoteSize: dec("1000")}, limits, false},
+		{"leverage over limit", PayloadOverrides{Leverage: dec("11")}, limits, true},
+		{"leverage not an integer", PayloadOverrides{Leverage: dec("2.5")}, limits, true},
+		{"leverage over 125 without bot limit", PayloadOverrides{Leverage: dec("126")}, models.TradingConfig{AllowSignalOverrides: true}, true},
+		{"stop loss over limit", PayloadOverrides{StopLossPercent: dec("5.1")}, limits, true},
+		{"take profit over limit", PayloadOverrides{TakeProfitPercent: dec("21")}, limits, true},
+		{"no bot limit", PayloadOverrides{Quantity: dec("100"), TakeProfitPercent: dec("500")}, models.TradingConfig{AllowSignalOverrides: true}, false},
+		{"limit order not allowed", PayloadOverrides{OrderType: "limit"}, limits, true},
+		{"limit order allowed", PayloadOverrides{OrderType: "limit"}, models.TradingConfig{AllowSignalOverrides: true, SignalAllowLimitOrders: true}, false},
+		{"quantity and quoteSize", PayloadOverrides{Quantity: dec("0.1"), QuoteSize: dec("100")}, limits, true},
+		{"negative quantity", PayloadOverrides{Quantity: dec("-1")}, limits, true},
+		{"callback rate out of range", PayloadOverrides{CallbackRate: dec("6")}, limits, true},
+		{"invalid margin mode", PayloadOverrides{MarginMode: "PORTFOLIO"}, limits, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.overrides.CheckBounds(&tt.config, price)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckBounds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := (PayloadOverrides{}).CheckBounds(&models.TradingConfig{}, price); !errors.Is(err, ErrOverridesNotAllowed) {
+		t.Errorf("CheckBounds() on a bot without overrides = %v, want ErrOverridesNotAllowed", err)
+	}
+}
+
+func TestParsePayloadOverrides(t *testing.T) {
+	tests := []struct {
+		name       string
+		raw        string
+		wantFields []string
+		wantErr    bool
+	}{
+		{"empty", "", []string{}, false},
+		{"size and leverage", `{"quantity":"0.1","leverage":5}`, []string{"quantity", "leverage"}, false},
+		{"normalized", `{"marginMode":"cross","orderType":" LIMIT "}`, []string{"marginMode", "orderType"}, false},
+		{"invalid json", `{"quantity":`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := ParsePayloadOverrides(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePayloadOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := o.Fields(); !reflect.DeepEqual(got, tt.wantFields) {
+				t.Errorf("Fields() = %v, want %v", got, tt.wantFields)
+			}
+			if err := o.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPayloadOverridesApplyTo(t *testing.T) {
+	config := models.TradingConfig{Leverage: 3, MarginMode: "ISOLATED", StopLossPercent: 2, TakeProfitPercent: 4, Amount: 100}
+	o := PayloadOverrides{Leverage: dec("7"), MarginMode: "CROSSED", StopLossPercent: dec("1.5"), Quantity: dec("1")}
+
+	got := o.ApplyTo(config)
+	if got.Leverage != 7 || got.MarginMode != "CROSSED" || got.StopLossPercent != 1.5 {
+		t.Errorf("ApplyTo() = leverage %d, margin %s, SL %v; want 7, CROSSED, 1.5", got.Leverage, got.MarginMode, got.StopLossPercent)
+	}
+	if got.TakeProfitPercent != 4 || got.Amount != 100 {
+		t.Errorf("ApplyTo() changed fields without override: TP %v, amount %v", got.TakeProfitPercent, got.Amount)
+	}
+	if config.Leverage != 3 {
+		t.Error("ApplyTo() modified the original config")
+	}
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"tradercoin/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// PayloadTemplateFields are the signal fields a template can fill (cùng tên JSON với payload TradingView)
+var PayloadTemplateFields = []string{
+	"symbol", "action", "price", "stopLoss", "takeProfit", "percent", "message", "timestamp", "strategy", "passphrase", "signal_id",
+	"quantity", "quoteSize", "leverage", "marginMode", "orderType", "limitPrice",
+	"stopLossPercent", "takeProfitPercent", "callbackRate", "activationPrice",
+}
+
+// PayloadTemplateSpec is the parsed form of a WebhookPayloadTemplate
+type PayloadTemplateSpec struct {
+	Format        string                       `json:"format"`
+	FieldMappings map[string]string            `json:"field_mappings"`
+	ValueMappings map[string]map[string]string `json:"value_mappings"`
+	TextPatterns  map[string]string            `json:"text_patterns"`
+	Defaults      map[string]string            `json:"defaults"`
+}
+
+// PayloadTemplateSpecFromModel parses the JSON columns of a stored template
+func PayloadTemplateSpecFromModel(t *models.WebhookPayloadTemplate) (PayloadTemplateSpec, error) {
+	spec := PayloadTemplateSpec{Format: t.Format}
+	columns := []struct {
+		name  string
+		value string
+		dest  interface{}
+	}{
+		{"field_mappings", t.FieldMappings, &spec.FieldMappings},
+		{"value_mappings", t.ValueMappings, &spec.ValueMappings},
+		{"text_patterns", t.TextPatterns, &spec.TextPatterns},
+		{"defaults", t.Defaults, &spec.Defaults},
+	}
+	for _, col := range columns {
+		if strings.TrimSpace(col.value) == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(col.value), col.dest); err != nil {
+			return spec, fmt.Errorf("invalid %s: %w", col.name, err)
+		}
+	}
+	return spec, nil
+}
+
+// ApplyTo stores the spec into the JSON columns of a template
+func (spec PayloadTemplateSpec) ApplyTo(t *models.WebhookPayloadTemplate) {
+	t.Format = spec.Format
+	if t.Format == "" {
+		t.Format = "json"
+	}
+	t.FieldMappings = marshalIfNotEmpty(spec.FieldMappings, len(spec.FieldMappings))
+	t.ValueMappings = marshalIfNotEmpty(spec.ValueMappings, len(spec.ValueMappings))
+	t.TextPatterns = marshalIfNotEmpty(spec.TextPatterns, len(spec.TextPatterns))
+	t.Defaults = marshalIfNotEmpty(spec.Defaults, len(spec.Defaults))
+}
+
+// Validate checks field names, format and regexes
+func (spec PayloadTemplateSpec) Validate() error {
+	if spec.Format != "" && spec.Format != "json" && spec.Format != "text" {
+		return errors.New("format must be 'json' or 'text'")
+	}
+	if spec.Format == "text" && len(spec.FieldMappings) > 0 {
+		return errors.New("field_mappings require format 'json'")
+	}
+
+	groups := []map[string]string{spec.FieldMappings, spec.TextPatterns, spec.Defaults}
+	for _, group := range groups {
+		for field := range group {
+			if !isTemplateField(field) {
+				return fmt.Errorf("unknown field %q (allowed: %s)", field, strings.Join(PayloadTemplateFields, ", "))
+			}
+		}
+	}
+	for field := range spec.ValueMappings {
+		if !isTemplateField(field) {
+			return fmt.Errorf("unknown field %q in value_mappings", field)
+		}
+	}
+	for field, pattern := range spec.TextPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid text pattern for %q: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// Apply maps a raw webhook body onto the TradingView payload fields.
+// Thứ tự: defaults → regex trên body → JSONPath (hoặc field trùng tên) → value mapping → chuẩn hoá.
+func (spec PayloadTemplateSpec) Apply(raw []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+
+	for field, value := range spec.Defaults {
+		result[field] = value
+	}
+
+	text := string(raw)
+	for field, pattern := range spec.TextPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid text pattern for %q: %w", field, err)
+		}
+		if value, ok := regexSubmatch(re, text); ok {
+			result[field] = value
+		}
+	}
+
+	if spec.Format != "text" {
+		// UseNumber giữ nguyên độ chính xác của giá
+		var doc interface{}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+
+		for _, field := range PayloadTemplateFields {
+			path, mapped := spec.FieldMappings[field]
+			if !mapped {
+				// Không có mapping → lấy field cùng tên nếu vendor gửi đúng tên
+				path = field
+			}
+			if value, ok := ExtractJSONPath(doc, path); ok && isScalar(value) {
+				result[field] = value
+			}
+		}
+	}
+
+	for field, mapping := range spec.ValueMappings {
+		value, ok := result[field]
+		if !ok {
+			continue
+		}
+		current := strings.TrimSpace(fmt.Sprint(value))
+		for from, to := range mapping {
+			if strings.EqualFold(from, current) {
+				result[field] = to
+				break
+			}
+		}
+	}
+
+	normalizeTemplateResult(result)
+	return result, nil
+}
+
+// FindPayloadTemplate returns the active template of a prefix (nil if none)
+func FindPayloadTemplate(db *gorm.DB, prefix string) (*models.WebhookPayloadTemplate, error) {
+	if prefix == "" {
+		return nil, nil
+	}
+	var t models.WebhookPayloadTemplate
+	if err := db.Where("prefix = ? AND active = ?", strings.ToLower(prefix), true).First(&t).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// WebhookPassphrase lấy passphrase từ body gốc để xác thực trước khi map payload:
+// prefix có template → chỉ theo mapping / regex của field passphrase, không có → field passphrase (hoặc secret) của JSON.
+// Không dùng defaults của template (passphrase cố định trong template không phải là bí mật của người gửi).
+func WebhookPassphrase(db *gorm.DB, prefix string, raw []byte) (string, error) {
+	t, err := FindPayloadTemplate(db, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	passphrasePath := "passphrase"
+	format := ""
+	if t != nil {
+		spec, err := PayloadTemplateSpecFromModel(t)
+		if err != nil {
+			return "", err
+		}
+		if pattern, ok := spec.TextPatterns["passphrase"]; ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", fmt.Errorf("invalid text pattern for \"passphrase\": %w", err)
+			}
+			if value, ok := regexSubmatch(re, string(raw)); ok {
+				return value, nil
+			}
+		}
+		if path, ok := spec.FieldMappings["passphrase"]; ok {
+			passphrasePath = path
+		}
+		format = spec.Format
+	}
+	if format == "text" {
+		return "", nil
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return "", nil // Body không phải JSON: chỉ xác thực được bằng chữ ký HMAC
+	}
+	if value, ok := ExtractJSONPath(doc, passphrasePath); ok && isScalar(value) {
+		return fmt.Sprint(value), nil
+	}
+	if t == nil {
+		if value, ok := ExtractJSONPath(doc, "secret"); ok && isScalar(value) {
+			return fmt.Sprint(value), nil
+		}
+	}
+	return "", nil
+}
+
+// MapWebhookPayload trả về body ở dạng JSON chuẩn của TradingView; không có template → giữ nguyên body
+func MapWebhookPayload(db *gorm.DB, prefix string, raw []byte) ([]byte, error) {
+	t, err := FindPayloadTemplate(db, prefix)
+	if err != nil || t == nil {
+		return raw, err
+	}
+
+	spec, err := PayloadTemplateSpecFromModel(t)
+	if err != nil {
+		return nil, err
+	}
+	mapped, err := spec.Apply(raw)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mapped)
+}
+
+// ExtractJSONPath reads a value using a JSONPath-style path: "$.data.items[0].price" hoặc "data.ticker"
+func ExtractJSONPath(doc interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return doc, doc != nil
+	}
+
+	current := doc
+	for _, part := range strings.Split(path, ".") {
+		// Tách "items[0][1]" thành key "items" và các index
+		key := part
+		var indexes []string
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			for _, idx := range strings.Split(part[i:], "[") {
+				if idx = strings.TrimSuffix(idx, "]"); idx != "" {
+					indexes = append(indexes, idx)
+				}
+			}
+		}
+
+		if key != "" {
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if current, ok = m[key]; !ok {
+				return nil, false
+			}
+		}
+
+		for _, idx := range indexes {
+			arr, ok := current.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			n, err := strconv.Atoi(idx)
+			if err != nil || n < 0 || n >= len(arr) {
+				return nil, false
+			}
+			current = arr[n]
+		}
+	}
+
+	return current, current != nil
+}
+
+// normalizeTemplateResult chuẩn hoá symbol/action/timestamp sau khi map
+func normalizeTemplateResult(result map[string]interface{}) {
+	if symbol, ok := result["symbol"]; ok {
+		s := strings.ToUpper(strings.TrimSpace(fmt.Sprint(symbol)))
+		// "BINANCE:BTCUSDT.P" → "BTCUSDT", "BTC/USDT" → "BTCUSDT"
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			s = s[i+1:]
+		}
+		s = strings.TrimSuffix(s, ".P")
+		s = strings.NewReplacer("/", "", "-", "", "_", "").Replace(s)
+		result["symbol"] = s
+	}
+
+	if action, ok := result["action"]; ok {
+		result["action"] = strings.ToLower(strings.TrimSpace(fmt.Sprint(action)))
+	}
+
+	// Timestamp không parse được → bỏ (giống payload không có timestamp)
+	if ts, ok := result["timestamp"]; ok {
+		raw, _ := json.Marshal(ts)
+		if t, err := ParseSignalTimestamp(raw); err != nil || t == nil {
+			delete(result, "timestamp")
+		}
+	}
+
+	for _, field := range []string{"message", "strategy", "passphrase", "signal_id", "marginMode", "orderType"} {
+		if value, ok := result[field]; ok {
+			result[field] = fmt.Sprint(value)
+		}
+	}
+}
+
+// regexSubmatch returns named group "value", group 1, hoặc cả match
+func regexSubmatch(re *regexp.Regexp, text string) (string, bool) {
+	m := re.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	if i := re.SubexpIndex("value"); i > 0 && m[i] != "" {
+		return strings.TrimSpace(m[i]), true
+	}
+	if len(m) > 1 {
+		return strings.TrimSpace(m[1]), true
+	}
+	return strings.TrimSpace(m[0]), true
+}
+
+func isTemplateField(field string) bool {
+	for _, f := range PayloadTemplateFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, json.Number, float64, bool:
+		return true
+	}
+	return false
+}
+
+func marshalIfNotEmpty(value interface{}, length int) string {
+	if length == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
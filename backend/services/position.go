@@ -0,0 +1,416 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Vòng đời của Position
+const (
+	PositionStatusOpen    = "open"    // Vừa mở (1 lệnh vào)
+	PositionStatusScaling = "scaling" // Đã nhồi thêm khối lượng
+	PositionStatusClosing = "closing" // Đã đóng một phần
+	PositionStatusClosed  = "closed"
+)
+
+var (
+	positionEventHub   *WebSocketHub
+	positionEventHubMu sync.RWMutex
+)
+
+// SetPositionEventHub sets the hub used to push position_update events (gọi 1 lần trong main)
+func SetPositionEventHub(hub *WebSocketHub) {
+	positionEventHubMu.Lock()
+	positionEventHub = hub
+	positionEventHubMu.Unlock()
+}
+
+// notifyPositionUpdate gửi trạng thái mới của vị thế tới các tab của user
+func notifyPositionUpdate(pos *models.Position) {
+	positionEventHubMu.RLock()
+	hub := positionEventHub
+	positionEventHubMu.RUnlock()
+	if hub == nil || pos == nil {
+		return
+	}
+
+	hub.BroadcastToUser(pos.UserID, WebSocketMessage{
+		Type: "position_update",
+		Data: map[string]interface{}{
+			"position_id":       pos.ID,
+			"symbol":            pos.Symbol,
+			"side":              pos.Side,
+			"status":            pos.Status,
+			"quantity":          pos.Quantity,
+			"entry_price":       pos.EntryPrice,
+			"exit_price":        pos.ExitPrice,
+			"mark_price":        pos.MarkPrice,
+			"liquidation_price": pos.LiquidationPrice,
+			"leverage":          pos.Leverage,
+			"unrealized_pnl":    pos.UnrealizedPnL,
+			"realized_pnl":      pos.RealizedPnL,
+			"commission":        pos.Commission,
+			"funding_fee":       pos.FundingFee,
+			"exit_reason":       pos.ExitReason,
+			"bot_config_id":     pos.BotConfigID,
+			"timestamp":         time.Now().Unix(),
+		},
+	})
+}
+
+// positionSideOfOrder: lệnh BUY/LONG mở vị thế LONG, SELL/SHORT mở SHORT
+func positionSideOfOrder(side string) string {
+	if isLongSide(side) {
+		return "LONG"
+	}
+	return "SHORT"
+}
+
+// activePosition tìm vị thế chưa đóng của bot / exchange key + symbol + chiều
+func activePosition(db *gorm.DB, order *models.Order, side string) (models.Position, error) {
+	var pos models.Position
+	err := db.Where("user_id = ? AND bot_config_id = ? AND exchange_key_id = ? AND symbol = ? AND side = ? AND status <> ?",
+		order.UserID, order.BotConfigID, order.ExchangeKeyID, order.Symbol, side, PositionStatusClosed).
+		Order("opened_at DESC").First(&pos).Error
+	return pos, err
+}
+
+func orderFillQtyPrice(order *models.Order) (decimal.Decimal, decimal.Decimal) {
+	qty := order.FilledQuantity
+	if !qty.IsPositive() {
+		qty = order.Quantity
+	}
+	price := order.FilledPrice
+	if !price.IsPositive() {
+		price = order.Price
+	}
+	return qty, price
+}
+
+// LinkEntryOrder gắn lệnh vào futures với vị thế đang mở cùng chiều (tạo mới nếu chưa có),
+// cộng khối lượng và tính lại giá vào trung bình. Spot / lệnh lỗi không có vị thế.
+func LinkEntryOrder(db *gorm.DB, order *models.Order) (*models.Position, error) {
+	if marketOf(order.TradingMode) != "futures" || order.ID == 0 || isTerminalOrderStatus(order.Status) {
+		return nil, nil
+	}
+	side := positionSideOfOrder(order.Side)
+	qty, price := orderFillQtyPrice(order)
+
+	var pos models.Position
+	err := db.Transaction(func(tx *gorm.DB) error {
+		existing, err := activePosition(tx, order, side)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			pos = models.Position{
+				UserID:        order.UserID,
+				BotConfigID:   order.BotConfigID,
+				ExchangeKeyID: order.ExchangeKeyID,
+				Exchange:      order.Exchange,
+				Symbol:        order.Symbol,
+				TradingMode:   "futures",
+				Side:          side,
+				Status:        PositionStatusOpen,
+				Quantity:      qty,
+				MaxQuantity:   qty,
+				EntryPrice:    price,
+				Leverage:      order.Leverage,
+				OpenedAt:      order.CreatedAt,
+			}
+			if pos.OpenedAt.IsZero() {
+				pos.OpenedAt = time.Now()
+			}
+			if err := tx.Create(&pos).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			pos = existing
+			total := pos.Quantity.Add(qty)
+			if total.IsPositive() {
+				pos.EntryPrice = pos.EntryPrice.Mul(pos.Quantity).Add(price.Mul(qty)).Div(total)
+			}
+			pos.Quantity = total
+			if total.GreaterThan(pos.MaxQuantity) {
+				pos.MaxQuantity = total
+			}
+			pos.Status = PositionStatusScaling
+			if err := tx.Model(&pos).Updates(map[string]interface{}{
+				"quantity":     pos.Quantity,
+				"max_quantity": pos.MaxQuantity,
+				"entry_price":  pos.EntryPrice,
+				"status":       pos.Status,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Order{}).Where("id = ?", order.ID).Update("position_id", pos.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	order.PositionID = &pos.ID
+	notifyPositionUpdate(&pos)
+	return &pos, nil
+}
+
+// ReducePosition ghi lệnh đóng (một phần hoặc toàn bộ) của vị thế: giảm khối lượng, cập nhật giá thoát trung bình.
+// Đóng hết (full hoặc khối lượng về 0) thì chuyển vị thế sang closed.
+func ReducePosition(db *gorm.DB, positionID uint, closeOrder *models.Order, qty, exitPrice decimal.Decimal, full bool, reason string) error {
+	var pos models.Position
+	if err := db.First(&pos, positionID).Error; err != nil {
+		return err
+	}
+	if closeOrder != nil && closeOrder.ID > 0 {
+		if err := db.Model(&models.Order{}).Where("id = ?", closeOrder.ID).Update("position_id", pos.ID).Error; err != nil {
+			return err
+		}
+		closeOrder.PositionID = &pos.ID
+	}
+
+	// Giá thoát trung bình theo khối lượng đã đóng
+	closedBefore := pos.MaxQuantity.Sub(pos.Quantity)
+	if qty.IsPositive() && exitPrice.IsPositive() {
+		if closedAfter := closedBefore.Add(qty); closedAfter.IsPositive() {
+			pos.ExitPrice = pos.ExitPrice.Mul(closedBefore).Add(exitPrice.Mul(qty)).Div(closedAfter)
+		}
+	}
+	pos.Quantity = decimal.Max(pos.Quantity.Sub(qty), decimal.Zero)
+
+	if full || !pos.Quantity.IsPositive() {
+		return ClosePosition(db, pos.ID, pos.ExitPrice, reason, time.Now())
+	}
+	pos.Status = PositionStatusClosing
+	if err := db.Model(&pos).Updates(map[string]interface{}{
+		"quantity":   pos.Quantity,
+		"exit_price": pos.ExitPrice,
+		"status":     pos.Status,
+	}).Error; err != nil {
+		return err
+	}
+	notifyPositionUpdate(&pos)
+	return nil
+}
+
+// ClosePosition chuyển vị thế sang closed và tính lại realized PnL / phí / funding từ fill và lệnh liên kết
+func ClosePosition(db *gorm.DB, positionID uint, exitPrice decimal.Decimal, reason string, closedAt time.Time) error {
+	updates := map[string]interface{}{
+		"status":          PositionStatusClosed,
+		"quantity":        decimal.Zero,
+		"unrealized_pn_l": decimal.Zero,
+	}
+	if exitPrice.IsPositive() {
+		updates["exit_price"] = exitPrice
+	}
+	if reason != "" {
+		updates["exit_reason"] = reason
+	}
+	if err := db.Model(&models.Position{}).Where("id = ? AND status <> ?", positionID, PositionStatusClosed).
+		Updates(updates).Error; err != nil {
+		return err
+	}
+	db.Model(&models.Position{}).Where("id = ? AND closed_at IS NULL", positionID).Update("closed_at", closedAt)
+	return RefreshPositionTotals(db, positionID)
+}
+
+// RefreshPositionTotals tính lại realized PnL, phí và funding của vị thế.
+// Có fill (stream / userTrades) thì cộng từ fill; chưa có thì lấy từ các lệnh vào đã đối soát.
+func RefreshPositionTotals(db *gorm.DB, positionID uint) error {
+	var pos models.Position
+	if err := db.First(&pos, positionID).Error; err != nil {
+		return err
+	}
+
+	var fills []models.PositionFill
+	if err := db.Where("position_id = ?", positionID).Find(&fills).Error; err != nil {
+		return err
+	}
+	var orders []models.Order
+	if err := db.Where("position_id = ?", positionID).Order("created_at ASC").Find(&orders).Error; err != nil {
+		return err
+	}
+
+	realized, commission, funding := decimal.Zero, decimal.Zero, decimal.Zero
+	if len(fills) > 0 {
+		for _, f := range fills {
+			realized = realized.Add(f.RealizedPnL)
+			commission = commission.Add(f.Commission)
+		}
+	} else {
+		// Chưa có fill: cộng PnL / phí của mọi lệnh gắn vị thế, gồm cả lệnh đóng / chốt một phần (chiều ngược lại)
+		for i := range orders {
+			o := &orders[i]
+			if positionSideOfOrder(o.Side) == pos.Side || isClosingOrder(o) {
+				realized = realized.Add(o.RealizedPnL)
+				commission = commission.Add(o.Commission)
+			}
+		}
+	}
+
+	// Funding được chia theo lệnh vào (fundingShare) nên cộng funding của các lệnh vào
+	var exitReason string
+	var closedAt *time.Time
+	for _, o := range orders {
+		if positionSideOfOrder(o.Side) != pos.Side {
+			continue
+		}
+		funding = funding.Add(o.FundingFee)
+		if o.ExitReason != "" {
+			exitReason = o.ExitReason
+		}
+		if o.ClosedAt != nil && (closedAt == nil || o.ClosedAt.After(*closedAt)) {
+			closedAt = o.ClosedAt
+		}
+	}
+
+	updates := map[string]interface{}{
+		"realized_pn_l": realized,
+		"commission":    commission,
+		"funding_fee":   funding,
+	}
+	if pos.Status == PositionStatusClosed {
+		if pos.ExitReason == "" && exitReason != "" {
+			updates["exit_reason"] = exitReason
+		}
+		if closedAt != nil {
+			updates["closed_at"] = closedAt
+		}
+		if !pos.ExitPrice.IsPositive() {
+			for i := len(orders) - 1; i >= 0; i-- {
+				if orders[i].ExitPrice.IsPositive() {
+					updates["exit_price"] = orders[i].ExitPrice
+					break
+				}
+			}
+		}
+	}
+	if err := db.Model(&pos).Updates(updates).Error; err != nil {
+		return err
+	}
+	if err := db.First(&pos, positionID).Error; err == nil {
+		notifyPositionUpdate(&pos)
+	}
+	return nil
+}
+
+// RecordPositionFills lưu các fill của vị thế (trùng trade ID thì bỏ qua)
+func RecordPositionFills(db *gorm.DB, fills []models.PositionFill) error {
+	if len(fills) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&fills).Error
+}
+
+// ApplyExchangePosition đồng bộ vị thế với số liệu của sàn (positionRisk / ACCOUNT_UPDATE).
+// Khối lượng tăng → scaling, giảm → closing; khối lượng 0 thì đóng vị thế.
+func ApplyExchangePosition(db *gorm.DB, pos *models.Position, info *FuturesPositionInfo) error {
+	qty := info.PositionAmt.Abs()
+	if qty.IsZero() {
+		return ClosePosition(db, pos.ID, decimal.Zero, "", time.Now())
+	}
+
+	updates := map[string]interface{}{
+		"quantity":        qty,
+		"unrealized_pn_l": info.UnrealizedProfit,
+	}
+	switch {
+	case qty.GreaterThan(pos.Quantity) && pos.Quantity.IsPositive():
+		updates["status"] = PositionStatusScaling
+	case qty.LessThan(pos.Quantity):
+		updates["status"] = PositionStatusClosing
+	}
+	if qty.GreaterThan(pos.MaxQuantity) {
+		updates["max_quantity"] = qty
+	}
+	if info.EntryPrice.IsPositive() {
+		updates["entry_price"] = info.EntryPrice
+	}
+	if info.MarkPrice.IsPositive() {
+		updates["mark_price"] = info.MarkPrice
+	}
+	if info.LiquidationPrice.IsPositive() {
+		updates["liquidation_price"] = info.LiquidationPrice
+	}
+	if info.IsolatedMargin.IsPositive() {
+		updates["isolated_margin"] = info.IsolatedMargin
+	}
+	if info.Leverage > 0 {
+		updates["leverage"] = info.Leverage
+	}
+	if info.MarginType != "" {
+		updates["margin_type"] = info.MarginType
+	}
+	if err := db.Model(pos).Updates(updates).Error; err != nil {
+		return err
+	}
+	notifyPositionUpdate(pos)
+	return nil
+}
+
+// BackfillPositions tạo Position cho các lệnh futures đang mở từ trước khi có bảng positions
+func BackfillPositions(db *gorm.DB) {
+	var orders []models.Order
+	if err := db.Where("position_id IS NULL AND LOWER(trading_mode) IN (?, ?) AND LOWER(status) NOT IN ?",
+		"futures", "future", terminalOrderStatuses).
+		Find(&orders).Error; err != nil {
+		log.Printf("⚠️  Failed to load orders for position backfill: %v", err)
+		return
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+
+	linked := 0
+	for i := range orders {
+		if _, err := LinkEntryOrder(db, &orders[i]); err != nil {
+			log.Printf("⚠️  Order %d: failed to link position: %v", orders[i].ID, err)
+			continue
+		}
+		linked++
+	}
+	if linked > 0 {
+		log.Printf("📌 Linked %d open futures orders to positions", linked)
+	}
+}
+
+// isTerminalOrderStatus reports whether an order status no longer holds a position
+func isTerminalOrderStatus(status string) bool {
+	status = strings.ToLower(strings.TrimSpace(status))
+	for _, s := range terminalOrderStatuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncPositionWithOrders đóng vị thế khi không còn lệnh vào nào đang mở, ngược lại chỉ tính lại realized PnL / phí
+func SyncPositionWithOrders(db *gorm.DB, positionID uint) error {
+	var active int64
+	if err := db.Model(&models.Order{}).
+		Where("position_id = ? AND LOWER(status) NOT IN ?", positionID, terminalOrderStatuses).
+		Count(&active).Error; err != nil {
+		return err
+	}
+	if active == 0 {
+		return ClosePosition(db, positionID, decimal.Zero, "", time.Now())
+	}
+	return RefreshPositionTotals(db, positionID)
+}
+
+// syncOrderPosition cập nhật vị thế của lệnh vừa đóng / đối soát xong
+func syncOrderPosition(db *gorm.DB, order *models.Order) {
+	if order.PositionID == nil {
+		return
+	}
+	if err := SyncPositionWithOrders(db, *order.PositionID); err != nil {
+		log.Printf("⚠️  Position %d: failed to sync after order %d: %v", *order.PositionID, order.ID, err)
+	}
+}
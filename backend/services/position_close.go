@@ -0,0 +1,222 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+)
+
+// GetSpotFreeBalance returns the free (không bị khoá trong lệnh chờ) spot balance of an asset
+func (ts *TradingService) GetSpotFreeBalance(asset string) (decimal.Decimal, error) {
+	isTestnet := false
+	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
+
+	params := url.Values{}
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	params.Set("recvWindow", "5000")
+	params.Set("signature", ts.sign(params.Encode()))
+
+	fullURL := fmt.Sprintf("%s/api/v3/account?%s", adapter.SpotAPIURL, params.Encode())
+	req, err := http.NewRequest("GET", fullURL, nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	req.Header.Set("X-MBX-APIKEY", ts.APIKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return decimal.Zero, fmt.Errorf("account query failed (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var account struct {
+		Balances []struct {
+			Asset string `json:"asset"`
+			Free  string `json:"free"`
+		} `json:"balances"`
+	}
+	if err := json.Unmarshal(body, &account); err != nil {
+		return decimal.Zero, err
+	}
+	for _, b := range account.Balances {
+		if b.Asset == asset {
+			return ParseDecimal(b.Free), nil
+		}
+	}
+	return decimal.Zero, nil
+}
+
+// ReduceFuturesPositionMarket đóng percent% vị thế futures hiện tại bằng lệnh MARKET reduceOnly
+func (ts *TradingService) ReduceFuturesPositionMarket(config *models.TradingConfig, symbol string, percent decimal.Decimal, clientOrderID string) OrderResult {
+	if config.TradingMode != "futures" {
+		return OrderResult{Success: false, Error: "reduce-only close is only available in futures mode"}
+	}
+	if !percent.IsPositive() || percent.GreaterThan(hundred) {
+		return OrderResult{Success: false, Error: fmt.Sprintf("invalid close percent %s", percent)}
+	}
+
+	isTestnet := false
+	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
+
+	// Retry sau timeout: lệnh giảm vị thế lần trước có thể đã khớp → dùng lại, không tính lại khối lượng từ vị thế đã giảm
+	if body, found, err := ts.lookupUnresolvedOrder(adapter, "futures", symbol, clientOrderID); err != nil {
+		return OrderResult{Success: false, ClientOrderID: clientOrderID, Error: err.Error()}
+	} else if found {
+		fmt.Printf("♻️  Reduce order %s for %s already exists on exchange - skipping placement\n", clientOrderID, symbol)
+		result := closeOrderResult(body, symbol, "", decimal.Zero)
+		result.ClientOrderID = clientOrderID
+		return result
+	}
+
+	pos, err := ts.getFuturesPositionInfo(config, symbol)
+	if err != nil {
+		return OrderResult{Success: false, Error: fmt.Sprintf("position query failed: %v", err)}
+	}
+	if pos.Quantity.IsZero() {
+		return OrderResult{Success: false, Error: "no-position"}
+	}
+
+	lot, err := ts.getSymbolLotInfo("futures", symbol)
+	if err != nil {
+		return OrderResult{Success: false, Error: err.Error()}
+	}
+	quantity := floorToStep(pos.Quantity.Mul(percent).Div(hundred), lot.StepSize)
+	if !quantity.IsPositive() {
+		return OrderResult{Success: false, Error: fmt.Sprintf("%s%% of position %s rounds to zero (step %s)", percent, FormatDecimal(pos.Quantity), lot.StepSize)}
+	}
+
+	result := ts.PlaceReduceOnlyOrder(config, symbol, pos.Side, quantity, "market", decimal.Zero, clientOrderID)
+	if result.Success {
+		fmt.Printf("✅ Reduced %s position of %s by %s%% (qty %s)\n", pos.Side, symbol, percent, FormatDecimal(quantity))
+	}
+	return result
+}
+
+// PlaceReduceOnlyOrder đặt lệnh MARKET / LIMIT (GTC) giảm quantity của vị thế positionSide (LONG/SHORT)
+func (ts *TradingService) PlaceReduceOnlyOrder(config *models.TradingConfig, symbol, positionSide string, quantity decimal.Decimal,
+	orderType string, price decimal.Decimal, clientOrderID string) OrderResult {
+	if config.TradingMode != "futures" {
+		return OrderResult{Success: false, Error: "reduce-only close is only available in futures mode"}
+	}
+	orderType = strings.ToUpper(orderType)
+	if orderType != "MARKET" && orderType != "LIMIT" {
+		return OrderResult{Success: false, Error: fmt.Sprintf("unsupported order type %s", orderType)}
+	}
+	if orderType == "LIMIT" && !price.IsPositive() {
+		return OrderResult{Success: false, Error: "price is required for limit orders"}
+	}
+
+	isTestnet := false
+	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
+
+	oppositeSide := "SELL"
+	if positionSide == "SHORT" {
+		oppositeSide = "BUY"
+	}
+
+	// Retry sau timeout: lệnh với client order ID này có thể đã lên sàn → không đặt lần hai
+	if body, found, err := ts.lookupUnresolvedOrder(adapter, "futures", symbol, clientOrderID); err != nil {
+		return OrderResult{Success: false, ClientOrderID: clientOrderID, Error: err.Error()}
+	} else if found {
+		result := closeOrderResult(body, symbol, oppositeSide, quantity)
+		result.ClientOrderID = clientOrderID
+		return result
+	}
+
+	hedge, _ := ts.isFuturesHedgeMode(config)
+
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("side", oppositeSide)
+	params.Set("type", orderType)
+	params.Set("quantity", FormatDecimal(quantity))
+	if orderType == "LIMIT" {
+		params.Set("price", ts.FormatPriceByTickSize("futures", symbol, price))
+		params.Set("timeInForce", "GTC")
+	}
+	if hedge {
+		// Hedge Mode: positionSide xác định chiều cần giảm, Binance không nhận reduceOnly
+		params.Set("positionSide", positionSide)
+	} else {
+		params.Set("reduceOnly", "true")
+	}
+	if clientOrderID != "" {
+		params.Set("newClientOrderId", clientOrderID)
+	}
+	params.Set("newOrderRespType", "RESULT")
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	params.Set("signature", ts.sign(params.Encode()))
+
+	fullURL := fmt.Sprintf("%s/fapi/v1/order?%s", adapter.FuturesAPIURL, params.Encode())
+	req, err := http.NewRequest("POST", fullURL, nil)
+	if err != nil {
+		return OrderResult{Success: false, Error: err.Error()}
+	}
+	req.Header.Set("X-MBX-APIKEY", ts.APIKey)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	var body []byte
+	statusCode := http.StatusOK
+	resp, err := client.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		body, err = io.ReadAll(resp.Body)
+		statusCode = resp.StatusCode
+	}
+	if err != nil {
+		// Timeout/network error: lệnh có thể đã lên sàn → hỏi lại theo client order ID trước khi báo lỗi (queue sẽ retry)
+		recovered, ok := ts.recoverOrderByClientID(adapter, "futures", symbol, clientOrderID)
+		if !ok {
+			return OrderResult{Success: false, ClientOrderID: clientOrderID, Error: "Failed to send request to exchange: " + err.Error()}
+		}
+		body, statusCode = recovered, http.StatusOK
+	}
+
+	if statusCode != http.StatusOK {
+		var errorResp map[string]interface{}
+		_ = json.Unmarshal(body, &errorResp)
+		if isAmbiguousOrderFailure(statusCode, errorResp) {
+			if recovered, ok := ts.recoverOrderByClientID(adapter, "futures", symbol, clientOrderID); ok {
+				result := closeOrderResult(recovered, symbol, oppositeSide, quantity)
+				result.ClientOrderID = clientOrderID
+				return result
+			}
+		}
+		msg := fmt.Sprintf("partial close failed (status %d)", statusCode)
+		if m, ok := errorResp["msg"].(string); ok {
+			msg = fmt.Sprintf("%s: %s", msg, m)
+		}
+		return OrderResult{Success: false, ClientOrderID: clientOrderID, Error: msg, ErrorDetails: errorResp}
+	}
+
+	result := closeOrderResult(body, symbol, oppositeSide, quantity)
+	result.ClientOrderID = clientOrderID
+	return result
+}
+
+// closeOrderResult builds the OrderResult of a position-closing order from the exchange response.
+// Lệnh closePosition trả origQty = 0 nên lấy khối lượng từ vị thế đã đóng.
+func closeOrderResult(body []byte, symbol, side string, quantity decimal.Decimal) OrderResult {
+	result, err := orderResultFromQuery(body)
+	if err != nil {
+		result = OrderResult{Symbol: symbol, Side: side, Type: "MARKET", Status: "new"}
+	}
+	result.Success = true
+	if !result.Quantity.IsPositive() {
+		result.Quantity = quantity
+	}
+	return result
+}
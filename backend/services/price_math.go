@@ -0,0 +1,72 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// quantityDecimals là số chữ số thập phân tối đa gửi lên sàn (khớp cột decimal(20,8))
+const quantityDecimals = 8
+
+var hundred = decimal.NewFromInt(100)
+
+// ParseDecimal parses a numeric string from an exchange response, returning zero on error
+func ParseDecimal(value string) decimal.Decimal {
+	d, err := decimal.NewFromString(strings.TrimSpace(value))
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+// FormatDecimal formats a price/quantity for exchange requests (tối đa 8 chữ số thập phân, không có số 0 thừa)
+func FormatDecimal(value decimal.Decimal) string {
+	return value.Truncate(quantityDecimals).String()
+}
+
+// isLongSide returns true for BUY/LONG entries
+func isLongSide(side string) bool {
+	s := strings.ToUpper(side)
+	return s == "BUY" || s == "LONG"
+}
+
+// CalculateStopLossPrice tính giá SL từ entry và % (LONG: dưới entry, SHORT: trên entry)
+func CalculateStopLossPrice(side string, entryPrice decimal.Decimal, percent float64) decimal.Decimal {
+	if percent <= 0 || !entryPrice.IsPositive() {
+		return decimal.Zero
+	}
+	offset := decimal.NewFromFloat(percent).Div(hundred)
+	if isLongSide(side) {
+		return entryPrice.Mul(decimal.NewFromInt(1).Sub(offset))
+	}
+	return entryPrice.Mul(decimal.NewFromInt(1).Add(offset))
+}
+
+// CalculateTakeProfitPrice tính giá TP từ entry và % (LONG: trên entry, SHORT: dưới entry)
+func CalculateTakeProfitPrice(side string, entryPrice decimal.Decimal, percent float64) decimal.Decimal {
+	if percent <= 0 || !entryPrice.IsPositive() {
+		return decimal.Zero
+	}
+	offset := decimal.NewFromFloat(percent).Div(hundred)
+	if isLongSide(side) {
+		return entryPrice.Mul(decimal.NewFromInt(1).Add(offset))
+	}
+	return entryPrice.Mul(decimal.NewFromInt(1).Sub(offset))
+}
+
+// CalculatePnL returns absolute PnL and PnL % of a position from entry to exit price
+func CalculatePnL(side string, entryPrice, exitPrice, quantity decimal.Decimal) (pnl decimal.Decimal, pnlPercent decimal.Decimal) {
+	if !entryPrice.IsPositive() {
+		return decimal.Zero, decimal.Zero
+	}
+
+	diff := exitPrice.Sub(entryPrice)
+	if !isLongSide(side) {
+		diff = diff.Neg()
+	}
+
+	pnl = diff.Mul(quantity.Abs())
+	pnlPercent = diff.Div(entryPrice).Mul(hundred).Round(2)
+	return pnl, pnlPercent
+}
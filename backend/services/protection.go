@@ -0,0 +1,350 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidProtection is returned when a stop-loss / take-profit amendment cannot be applied to the order
+var ErrInvalidProtection = errors.New("invalid stop loss / take profit change")
+
+// ProtectionLeg là thay đổi của 1 leg (SL hoặc TP): giá tuyệt đối, % từ giá vào, hoặc huỷ
+type ProtectionLeg struct {
+	Price   decimal.Decimal
+	Percent decimal.Decimal
+	Remove  bool
+}
+
+// TrailingStopParams chuyển SL cố định sang trailing stop (ActivationPercent: % từ giá vào, 0 = kích hoạt ngay)
+type TrailingStopParams struct {
+	CallbackRate      decimal.Decimal
+	ActivationPercent decimal.Decimal
+}
+
+// AmendProtectionParams mô tả thay đổi SL/TP của lệnh; leg nil = giữ nguyên
+type AmendProtectionParams struct {
+	StopLoss     *ProtectionLeg
+	TakeProfit   *ProtectionLeg
+	TrailingStop *TrailingStopParams // Thay cho leg SL
+}
+
+// AmendProtectionResult is the outcome of an SL/TP amendment
+type AmendProtectionResult struct {
+	Order   *models.Order `json:"order"`
+	Changes []string      `json:"changes"`
+	Partial bool          `json:"partial,omitempty"` // Leg SL đã áp dụng nhưng leg TP lỗi
+	Error   string        `json:"error,omitempty"`
+}
+
+// protectiveAlgoID là algo order giữ lệnh "đang chạy" khi OrderMonitor kiểm tra: SL (hoặc trailing), không có thì TP
+func protectiveAlgoID(order *models.Order) string {
+	if order.AlgoIDStopLoss != "" {
+		return order.AlgoIDStopLoss
+	}
+	return order.AlgoIDTakeProfit
+}
+
+// CancelAlgoOrder huỷ 1 algo order (SL/TP/trailing); algo không còn trên sàn (đã kích hoạt / đã huỷ) coi như đã huỷ
+func (ts *TradingService) CancelAlgoOrder(algoID string) error {
+	if algoID == "" {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("algoId", algoID)
+	err := ts.signedFuturesRequest("DELETE", "/fapi/v1/algoOrder", params, nil)
+	if err != nil && (strings.Contains(err.Error(), "-2011") || strings.Contains(err.Error(), "Unknown order")) {
+		return nil
+	}
+	return err
+}
+
+func (leg *ProtectionLeg) validate(name string) error {
+	set := 0
+	if leg.Remove {
+		set++
+	}
+	if leg.Price.IsPositive() {
+		set++
+	}
+	if leg.Percent.IsPositive() {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("%w: %s needs exactly one of price, percent or remove", ErrInvalidProtection, name)
+	}
+	return nil
+}
+
+// AmendOrderProtection đổi SL/TP của lệnh vào futures đang mở: đặt algo mới trước rồi huỷ algo cũ,
+// ghi StopLossPrice / TakeProfitPrice / AlgoID* ngay sau từng leg (cả các lệnh khác đang dùng chung algo cũ),
+// thêm OrderEvent và đẩy order_update qua WebSocket.
+// Leg TP lỗi sau khi leg SL đã xong → trả về cả result (Partial) lẫn error.
+func AmendOrderProtection(db *gorm.DB, order *models.Order, params AmendProtectionParams, source, actor string) (*AmendProtectionResult, error) {
+	if marketOf(order.TradingMode) != "futures" {
+		return nil, fmt.Errorf("%w: only futures orders have stop loss / take profit algo orders", ErrInvalidProtection)
+	}
+	if isTerminalOrderStatus(order.Status) || isClosingOrder(order) {
+		return nil, fmt.Errorf("%w: order %d is not an open position entry", ErrInvalidProtection, order.ID)
+	}
+	if params.StopLoss == nil && params.TakeProfit == nil && params.TrailingStop == nil {
+		return nil, fmt.Errorf("%w: nothing to change", ErrInvalidProtection)
+	}
+	if params.StopLoss != nil && params.TrailingStop != nil {
+		return nil, fmt.Errorf("%w: set either stop_loss or trailing_stop", ErrInvalidProtection)
+	}
+	if params.StopLoss != nil {
+		if err := params.StopLoss.validate("stop_loss"); err != nil {
+			return nil, err
+		}
+	}
+	if params.TakeProfit != nil {
+		if err := params.TakeProfit.validate("take_profit"); err != nil {
+			return nil, err
+		}
+	}
+	if params.TrailingStop != nil {
+		rate := params.TrailingStop.CallbackRate
+		if rate.LessThan(decimal.NewFromFloat(0.1)) || rate.GreaterThan(decimal.NewFromInt(10)) {
+			return nil, fmt.Errorf("%w: callback_rate must be between 0.1 and 10", ErrInvalidProtection)
+		}
+		if params.TrailingStop.ActivationPercent.IsNegative() {
+			return nil, fmt.Errorf("%w: activation_percent must not be negative", ErrInvalidProtection)
+		}
+	}
+
+	// Giá vào: trung bình của vị thế (DCA) nếu có, không thì giá khớp của lệnh
+	_, entryPrice := orderFillQtyPrice(order)
+	if order.PositionID != nil {
+		var pos models.Position
+		if err := db.First(&pos, *order.PositionID).Error; err == nil && pos.EntryPrice.IsPositive() {
+			entryPrice = pos.EntryPrice
+		}
+	}
+	if !entryPrice.IsPositive() {
+		return nil, fmt.Errorf("%w: order %d has no entry price yet", ErrInvalidProtection, order.ID)
+	}
+
+	ts, err := orderTradingService(db, order)
+	if err != nil {
+		return nil, err
+	}
+	config := &models.TradingConfig{UserID: order.UserID, Exchange: order.Exchange, TradingMode: "futures", Leverage: order.Leverage}
+
+	// Serialize với signal / lệnh tay trên cùng API key + symbol
+	release, err := ExecutionLocks().Acquire(ExecutionLockKey(order.Exchange, ts.APIKey, order.Symbol),
+		fmt.Sprintf("protection:order:%d", order.ID), DefaultLockWaitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	positionSide := positionSideOfOrder(order.Side)
+	entrySide, closeSide := "BUY", "SELL"
+	if positionSide == "SHORT" {
+		entrySide, closeSide = "SELL", "BUY"
+	}
+
+	mark := decimal.Zero
+	if price, err := ts.GetMarkPrice(order.Symbol); err == nil {
+		mark = price
+	}
+
+	// ====== VALIDATE TRƯỚC KHI ĐỤNG VÀO SÀN ======
+	stopPrice, profitPrice := decimal.Zero, decimal.Zero
+	if params.StopLoss != nil && !params.StopLoss.Remove {
+		stopPrice = params.StopLoss.Price
+		if params.StopLoss.Percent.IsPositive() {
+			stopPrice = CalculateStopLossPrice(entrySide, entryPrice, params.StopLoss.Percent.InexactFloat64())
+		}
+		// SL không được kích hoạt ngay: LONG dưới mark, SHORT trên mark
+		if mark.IsPositive() && ((positionSide == "LONG" && !stopPrice.LessThan(mark)) || (positionSide == "SHORT" && !stopPrice.GreaterThan(mark))) {
+			return nil, fmt.Errorf("%w: stop loss %s would trigger immediately (mark price %s)", ErrInvalidProtection, stopPrice, mark)
+		}
+	}
+	if params.TakeProfit != nil && !params.TakeProfit.Remove {
+		profitPrice = params.TakeProfit.Price
+		if params.TakeProfit.Percent.IsPositive() {
+			profitPrice = CalculateTakeProfitPrice(entrySide, entryPrice, params.TakeProfit.Percent.InexactFloat64())
+		}
+		// TP không được kích hoạt ngay: LONG trên mark, SHORT dưới mark
+		if mark.IsPositive() && ((positionSide == "LONG" && !profitPrice.GreaterThan(mark)) || (positionSide == "SHORT" && !profitPrice.LessThan(mark))) {
+			return nil, fmt.Errorf("%w: take profit %s would trigger immediately (mark price %s)", ErrInvalidProtection, profitPrice, mark)
+		}
+	}
+
+	// OrderMonitor coi lệnh futures đã khớp mà không còn algo bảo vệ nào là đã đóng → không cho huỷ leg cuối cùng
+	oldStopLoss, oldTakeProfit := order.AlgoIDStopLoss, order.AlgoIDTakeProfit
+	removeStop := params.StopLoss != nil && params.StopLoss.Remove
+	removeProfit := params.TakeProfit != nil && params.TakeProfit.Remove
+	keepsStop := !removeStop && (params.StopLoss != nil || params.TrailingStop != nil || oldStopLoss != "")
+	keepsProfit := !removeProfit && (params.TakeProfit != nil || oldTakeProfit != "")
+	if (removeStop || removeProfit) && !keepsStop && !keepsProfit {
+		return nil, fmt.Errorf("%w: cannot remove the last stop loss / take profit of an open position, close the position instead", ErrInvalidProtection)
+	}
+
+	result := &AmendProtectionResult{Order: order}
+
+	// ====== STOP LOSS ======
+	var stopUpdates map[string]interface{}
+	var stopChange string
+	switch {
+	case params.StopLoss != nil && params.StopLoss.Remove:
+		if err := ts.CancelAlgoOrder(oldStopLoss); err != nil {
+			return nil, fmt.Errorf("failed to cancel stop loss %s: %w", oldStopLoss, err)
+		}
+		stopUpdates = map[string]interface{}{"stop_loss_price": decimal.Zero, "algo_id_stop_loss": ""}
+		stopChange = "stop loss removed"
+
+	case params.StopLoss != nil:
+		res := ts.PlaceAlgoStopLoss(config, order.Symbol, stopPrice, closeSide, positionSide)
+		if !res.Success {
+			return nil, fmt.Errorf("failed to place stop loss: %s", res.Error)
+		}
+		if err := ts.CancelAlgoOrder(oldStopLoss); err != nil {
+			log.Printf("⚠️  Order %d: failed to cancel old stop loss %s: %v", order.ID, oldStopLoss, err)
+		}
+		stopUpdates = map[string]interface{}{"stop_loss_price": stopPrice, "algo_id_stop_loss": res.OrderID}
+		stopChange = fmt.Sprintf("stop loss → %s (algo %s)", stopPrice, res.OrderID)
+
+	case params.TrailingStop != nil:
+		// Trailing stop bắt buộc khối lượng: lấy khối lượng hiện tại của vị thế trên sàn
+		positions, err := ts.getAllFuturesPositions(config)
+		if err != nil {
+			return nil, fmt.Errorf("position query failed: %w", err)
+		}
+		info := matchPositionSide(positions, order.Symbol, positionSide)
+		if info == nil {
+			return nil, fmt.Errorf("%w: no open %s %s position on the exchange", ErrInvalidProtection, order.Symbol, strings.ToLower(positionSide))
+		}
+		lot, err := ts.getSymbolLotInfo("futures", order.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		quantity := floorToStep(info.PositionAmt.Abs(), lot.StepSize)
+
+		config.CallbackRate = params.TrailingStop.CallbackRate.InexactFloat64()
+		config.ActivationPrice = params.TrailingStop.ActivationPercent.InexactFloat64()
+		res := ts.PlaceTrailingStopOrder(config, order.Symbol, quantity, entrySide, entryPrice, entryPrice)
+		if !res.Success {
+			return nil, fmt.Errorf("failed to place trailing stop: %s", res.Error)
+		}
+		if err := ts.CancelAlgoOrder(oldStopLoss); err != nil {
+			log.Printf("⚠️  Order %d: failed to cancel old stop loss %s: %v", order.ID, oldStopLoss, err)
+		}
+		// Trailing stop không có giá cố định
+		stopUpdates = map[string]interface{}{"stop_loss_price": decimal.Zero, "algo_id_stop_loss": res.OrderID}
+		stopChange = fmt.Sprintf("stop loss → trailing %s%% (algo %s)", params.TrailingStop.CallbackRate, res.OrderID)
+	}
+	// Ghi DB ngay sau khi leg SL xong: nếu leg TP lỗi, DB vẫn trỏ tới algo SL mới chứ không phải algo đã huỷ
+	if stopUpdates != nil {
+		saveProtectionLeg(db, order, "algo_id_stop_loss", oldStopLoss, stopUpdates, stopChange, source, actor)
+		result.Changes = append(result.Changes, stopChange)
+	}
+
+	// ====== TAKE PROFIT ======
+	var profitUpdates map[string]interface{}
+	var profitChange string
+	switch {
+	case params.TakeProfit != nil && params.TakeProfit.Remove:
+		if err := ts.CancelAlgoOrder(oldTakeProfit); err != nil {
+			return partialProtectionResult(result, fmt.Errorf("failed to cancel take profit %s: %w", oldTakeProfit, err))
+		}
+		profitUpdates = map[string]interface{}{"take_profit_price": decimal.Zero, "algo_id_take_profit": ""}
+		profitChange = "take profit removed"
+
+	case params.TakeProfit != nil:
+		res := ts.PlaceAlgoTakeProfit(config, order.Symbol, profitPrice, closeSide, positionSide)
+		if !res.Success {
+			return partialProtectionResult(result, fmt.Errorf("failed to place take profit: %s", res.Error))
+		}
+		if err := ts.CancelAlgoOrder(oldTakeProfit); err != nil {
+			log.Printf("⚠️  Order %d: failed to cancel old take profit %s: %v", order.ID, oldTakeProfit, err)
+		}
+		profitUpdates = map[string]interface{}{"take_profit_price": profitPrice, "algo_id_take_profit": res.OrderID}
+		profitChange = fmt.Sprintf("take profit → %s (algo %s)", profitPrice, res.OrderID)
+	}
+	if profitUpdates != nil {
+		saveProtectionLeg(db, order, "algo_id_take_profit", oldTakeProfit, profitUpdates, profitChange, source, actor)
+		result.Changes = append(result.Changes, profitChange)
+	}
+
+	log.Printf("🛡️  Order %d (%s): %s", order.ID, order.Symbol, strings.Join(result.Changes, ", "))
+	return result, nil
+}
+
+// partialProtectionResult trả về leg đã áp dụng (đã ghi DB) kèm lỗi của leg sau
+func partialProtectionResult(result *AmendProtectionResult, err error) (*AmendProtectionResult, error) {
+	if len(result.Changes) == 0 {
+		return nil, err
+	}
+	result.Partial = true
+	result.Error = err.Error()
+	log.Printf("⚠️  Order %d (%s): partially amended (%s): %v", result.Order.ID, result.Order.Symbol, strings.Join(result.Changes, ", "), err)
+	return result, err
+}
+
+// saveProtectionLeg ghi thay đổi 1 leg cho lệnh và các lệnh khác (DCA) đang dùng chung algo cũ của leg đó,
+// thêm OrderEvent và đẩy order_update qua WebSocket
+func saveProtectionLeg(db *gorm.DB, order *models.Order, algoColumn, oldAlgoID string, updates map[string]interface{}, message, source, actor string) {
+	affected := []models.Order{*order}
+	if oldAlgoID != "" {
+		var shared []models.Order
+		if err := db.Where("id <> ? AND user_id = ? AND symbol = ? AND LOWER(status) NOT IN ? AND "+algoColumn+" = ?",
+			order.ID, order.UserID, order.Symbol, terminalOrderStatuses, oldAlgoID).Find(&shared).Error; err != nil {
+			log.Printf("⚠️  Order %d: failed to load orders sharing algo %s: %v", order.ID, oldAlgoID, err)
+		}
+		affected = append(affected, shared...)
+	}
+
+	for i := range affected {
+		o := &affected[i]
+		if err := db.Model(&models.Order{}).Where("id = ?", o.ID).Updates(updates).Error; err != nil {
+			log.Printf("⚠️  Order %d: failed to save stop loss / take profit: %v", o.ID, err)
+			continue
+		}
+		if err := db.First(o, o.ID).Error; err != nil {
+			continue
+		}
+		recordOrderEvent(db, o, models.OrderEvent{
+			Event:     OrderEventProtection,
+			OldStatus: NormalizeOrderStatus(o.Status),
+			NewStatus: NormalizeOrderStatus(o.Status),
+		}, OrderChange{Source: source, Actor: actor, Message: message, Payload: updates})
+		notifyProtectionUpdate(o)
+	}
+	*order = affected[0]
+}
+
+// notifyProtectionUpdate đẩy order_update kèm SL/TP mới của lệnh tới các tab của user
+func notifyProtectionUpdate(order *models.Order) {
+	positionEventHubMu.RLock()
+	hub := positionEventHub
+	positionEventHubMu.RUnlock()
+	if hub == nil {
+		return
+	}
+
+	hub.BroadcastToUser(order.UserID, WebSocketMessage{
+		Type: "order_update",
+		Data: map[string]interface{}{
+			"order_id":            order.ID,
+			"timestamp":           time.Now().Unix(),
+			"symbol":              order.Symbol,
+			"side":                order.Side,
+			"status":              order.Status,
+			"trading_mode":        order.TradingMode,
+			"stop_loss_price":     order.StopLossPrice,
+			"take_profit_price":   order.TakeProfitPrice,
+			"algo_id_stop_loss":   order.AlgoIDStopLoss,
+			"algo_id_take_profit": order.AlgoIDTakeProfit,
+		},
+	})
+}
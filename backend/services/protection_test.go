@@ -0,0 +1,39 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestProtectionLegValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		leg     ProtectionLeg
+		wantErr bool
+	}{
+		{"price", ProtectionLeg{Price: decimal.NewFromInt(95000)}, false},
+		{"percent", ProtectionLeg{Percent: decimal.NewFromFloat(1.5)}, false},
+		{"remove", ProtectionLeg{Remove: true}, false},
+		{"nothing set", ProtectionLeg{}, true},
+		{"negative price", ProtectionLeg{Price: decimal.NewFromInt(-1)}, true},
+		{"zero percent", ProtectionLeg{Percent: decimal.Zero}, true},
+		{"price and percent", ProtectionLeg{Price: decimal.NewFromInt(95000), Percent: decimal.NewFromInt(2)}, true},
+		{"price and remove", ProtectionLeg{Price: decimal.NewFromInt(95000), Remove: true}, true},
+		{"percent and remove", ProtectionLeg{Percent: decimal.NewFromInt(2), Remove: true}, true},
+		{"all set", ProtectionLeg{Price: decimal.NewFromInt(95000), Percent: decimal.NewFromInt(2), Remove: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.leg.validate("stop_loss")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidProtection) {
+				t.Errorf("validate() error = %v, want ErrInvalidProtection", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyRateLimiter is a token bucket per key (vd: exchange API key), giữ trạng thái giữa các lần chạy
+// để burst không được "nạp lại" mỗi chu kỳ.
+type KeyRateLimiter struct {
+	rate  float64 // token mỗi giây
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewKeyRateLimiter creates a limiter allowing perSecond requests per key with the given burst
+func NewKeyRateLimiter(perSecond float64, burst int) *KeyRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &KeyRateLimiter{
+		rate:    perSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Wait reserves one token for key and sleeps until it is available.
+// Trả về thời gian đã chờ; ok = false nếu stop bị đóng trong lúc chờ.
+func (l *KeyRateLimiter) Wait(key string, stop <-chan struct{}) (time.Duration, bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	// Token có thể âm: các lần gọi sau xếp hàng theo thứ tự đặt chỗ
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return 0, true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, true
+	case <-stop:
+		return time.Since(now), false
+	}
+}
+
+// Prune removes buckets that have been idle (and full) for longer than maxIdle
+func (l *KeyRateLimiter) Prune(maxIdle time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > maxIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
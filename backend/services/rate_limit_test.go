@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond float64
+		burst     int
+		keys      []string // lần lượt gọi Wait với các key này
+		wantWait  []bool   // lần gọi tương ứng có phải chờ không
+	}{
+		{"within burst", 20, 3, []string{"a", "a", "a"}, []bool{false, false, false}},
+		{"over burst", 20, 2, []string{"a", "a", "a", "a"}, []bool{false, false, true, true}},
+		{"burst below 1 is 1", 20, 0, []string{"a", "a"}, []bool{false, true}},
+		{"keys are independent", 20, 1, []string{"a", "a", "b"}, []bool{false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewKeyRateLimiter(tt.perSecond, tt.burst)
+			interval := time.Duration(float64(time.Second) / tt.perSecond)
+			for i, key := range tt.keys {
+				waited, ok := l.Wait(key, nil)
+				if !ok {
+					t.Fatalf("call %d: Wait returned ok = false", i)
+				}
+				if got := waited > 0; got != tt.wantWait[i] {
+					t.Errorf("call %d (%s): waited %s, want wait = %v", i, key, waited, tt.wantWait[i])
+				}
+				// Token được đặt chỗ theo thứ tự nên không chờ quá 1 interval mỗi lần
+				if waited > 2*interval {
+					t.Errorf("call %d (%s): waited %s, more than %s", i, key, waited, 2*interval)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyRateLimiterWaitStopped(t *testing.T) {
+	l := NewKeyRateLimiter(0.1, 1) // Lần 2 phải chờ 10s
+	l.Wait("a", nil)
+
+	stop := make(chan struct{})
+	close(stop)
+	start := time.Now()
+	if _, ok := l.Wait("a", stop); ok {
+		t.Error("Wait returned ok = true after stop was closed")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait blocked %s after stop was closed", elapsed)
+	}
+}
+
+func TestKeyRateLimiterPrune(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxIdle  time.Duration
+		wantKept bool
+	}{
+		{"idle bucket removed", 0, false},
+		{"recent bucket kept", time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewKeyRateLimiter(10, 1)
+			l.Wait("a", nil)
+			time.Sleep(time.Millisecond)
+			l.Prune(tt.maxIdle)
+			if _, kept := l.buckets["a"]; kept != tt.wantKept {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
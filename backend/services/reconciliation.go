@@ -0,0 +1,785 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	// Lệnh / vị thế mới tạo trong khoảng này chưa đối soát (sàn và DB có thể chưa kịp đồng bộ)
+	reconcileGracePeriod = 2 * time.Minute
+	// Cùng 1 sai lệch chưa sửa được chỉ cảnh báo lại sau khoảng này
+	reconcileAlertCooldown = time.Hour
+	// Thời gian chờ execution lock của symbol trước khi tự sửa; lock bận (đang đặt / đóng lệnh) thì để lượt sau
+	reconcileLockWait = 5 * time.Second
+)
+
+// Loại sai lệch giữa sàn và DB
+const (
+	ReconcileStaleOrder        = "stale_order"         // Lệnh DB còn mở nhưng sàn không còn vị thế / lệnh
+	ReconcileUntrackedPosition = "untracked_position"  // Vị thế trên sàn không có trong DB (mở tay trên Binance)
+	ReconcileClosedPosition    = "closed_position"     // Position DB còn mở nhưng sàn đã đóng
+	ReconcilePositionSize      = "position_size"       // Khối lượng Position khác sàn
+	ReconcileMissingStopLoss   = "missing_stop_loss"   // SL algo của lệnh không còn trên sàn
+	ReconcileMissingTakeProfit = "missing_take_profit" // TP algo của lệnh không còn trên sàn
+)
+
+// ReconcileIssue is one discrepancy found between exchange and database state
+type ReconcileIssue struct {
+	Type       string `json:"type"`
+	Symbol     string `json:"symbol"`
+	Side       string `json:"side,omitempty"`
+	OrderID    uint   `json:"order_id,omitempty"`
+	PositionID uint   `json:"position_id,omitempty"`
+	Message    string `json:"message"`
+	Fixed      bool   `json:"fixed"`
+	Action     string `json:"action,omitempty"` // Đã sửa thế nào (closed order, re-placed stop loss...)
+}
+
+// ReconcileReport is the result of reconciling one exchange account
+type ReconcileReport struct {
+	AccountKey    string           `json:"account_key"`
+	UserID        uint             `json:"user_id"`
+	ExchangeKeyID uint             `json:"exchange_key_id,omitempty"`
+	BotConfigIDs  []uint           `json:"bot_config_ids,omitempty"`
+	StartedAt     time.Time        `json:"started_at"`
+	DurationMs    int64            `json:"duration_ms"`
+	Positions     int              `json:"positions"`
+	OpenOrders    int              `json:"open_order_symbols"`
+	AlgoOrders    int              `json:"algo_orders"`
+	Orders        int              `json:"orders"`
+	Issues        []ReconcileIssue `json:"issues"`
+	Fixed         int              `json:"fixed"`
+	Alerts        int              `json:"alerts"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// binanceOpenAlgoOrder là 1 phần tử của /fapi/v1/openAlgoOrders (SL/TP đang chờ kích hoạt)
+type binanceOpenAlgoOrder struct {
+	AlgoID        int64           `json:"algoId"`
+	Symbol        string          `json:"symbol"`
+	Side          string          `json:"side"`
+	PositionSide  string          `json:"positionSide"`
+	OrderType     string          `json:"orderType"`
+	TriggerPrice  decimal.Decimal `json:"triggerPrice"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	ClosePosition bool            `json:"closePosition"`
+	WorkingType   string          `json:"workingType"`
+}
+
+// listOpenAlgoOrders trả về các algo order (conditional) đang mở của account.
+// GetOpenAlgoOrders chỉ trả về ID trailing stop trong openOrders nên không đủ để đối chiếu SL/TP.
+func (ts *TradingService) listOpenAlgoOrders() ([]binanceOpenAlgoOrder, error) {
+	var orders []binanceOpenAlgoOrder
+	if err := ts.signedFuturesGet("/fapi/v1/openAlgoOrders", url.Values{}, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// ReconciliationService định kỳ đối soát vị thế, lệnh mở và algo order trên sàn với DB cho từng exchange account (futures).
+// Sai lệch an toàn được tự sửa (đóng lệnh / Position đã hết, đồng bộ khối lượng, ghi nhận vị thế mở tay),
+// phần còn lại ghi cảnh báo vào system log; SL bị mất được đặt lại nếu bật ReplaceStops.
+type ReconciliationService struct {
+	DB           *gorm.DB
+	Interval     time.Duration
+	ReplaceStops bool
+
+	running int32
+
+	mu      sync.RWMutex
+	reports map[string]*ReconcileReport
+	alerted map[string]time.Time
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// NewReconciliationService creates the reconciliation job; interval 0 disables the schedule (vẫn chạy tay được)
+func NewReconciliationService(db *gorm.DB, interval time.Duration, replaceStops bool) *ReconciliationService {
+	return &ReconciliationService{
+		DB:           db,
+		Interval:     interval,
+		ReplaceStops: replaceStops,
+		reports:      make(map[string]*ReconcileReport),
+		alerted:      make(map[string]time.Time),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Start runs the reconciliation on its interval
+func (s *ReconciliationService) Start() {
+	if s.Interval <= 0 {
+		log.Println("🧮 Reconciliation schedule disabled")
+		return
+	}
+	log.Printf("🧮 Reconciliation started - every %s (replace stops: %v)", s.Interval, s.ReplaceStops)
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.RunAll()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the schedule
+func (s *ReconciliationService) Stop() {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+}
+
+// RunAll đối soát tất cả futures account đang active; bỏ qua nếu lượt trước chưa xong
+func (s *ReconciliationService) RunAll() []ReconcileReport {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		log.Println("🧮 Reconciliation already running, skipped")
+		return nil
+	}
+	defer atomic.StoreInt32(&s.running, 0)
+	s.pruneAlerts()
+
+	accounts, err := loadExchangeAccounts(s.DB)
+	if err != nil {
+		log.Printf("❌ Reconciliation: failed to load exchange accounts: %v", err)
+		return nil
+	}
+
+	reports := make([]ReconcileReport, 0, len(accounts))
+	issues, fixed := 0, 0
+	for _, account := range accounts {
+		if account.tradingMode != "futures" {
+			continue
+		}
+		report := s.reconcileAccount(account)
+		issues += len(report.Issues)
+		fixed += report.Fixed
+		reports = append(reports, report)
+	}
+	if issues > 0 {
+		log.Printf("🧮 Reconciliation complete: %d account(s), %d issue(s), %d fixed", len(reports), issues, fixed)
+	}
+	return reports
+}
+
+// Reports trả về kết quả lần đối soát gần nhất của từng account (userID 0 = tất cả)
+func (s *ReconciliationService) Reports(userID uint) []ReconcileReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]ReconcileReport, 0, len(s.reports))
+	for _, r := range s.reports {
+		if userID == 0 || r.UserID == userID {
+			result = append(result, *r)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AccountKey < result[j].AccountKey })
+	return result
+}
+
+// pruneAlerts xoá các cảnh báo đã hết cooldown (sai lệch đã hết hoặc sẽ được cảnh báo lại)
+func (s *ReconciliationService) pruneAlerts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, at := range s.alerted {
+		if time.Since(at) >= reconcileAlertCooldown {
+			delete(s.alerted, key)
+		}
+	}
+}
+
+// lockSymbol lấy execution lock của account + symbol để không tự sửa chồng lên signal / lệnh tay / chốt một phần đang chạy
+func lockSymbol(ts *TradingService, account streamAccount, symbol, owner string) (func(), error) {
+	return ExecutionLocks().Acquire(ExecutionLockKey(account.exchange, ts.APIKey, symbol), "reconcile:"+owner, reconcileLockWait)
+}
+
+// accountTradingService tạo TradingService cho account (exchange key, hoặc bot đầu tiên dùng API key)
+func (s *ReconciliationService) accountTradingService(account streamAccount) (*TradingService, *models.TradingConfig, error) {
+	config := &models.TradingConfig{UserID: account.userID, Exchange: account.exchange, TradingMode: "futures"}
+	if account.exchangeKeyID > 0 {
+		var key models.ExchangeKey
+		if err := s.DB.First(&key, account.exchangeKeyID).Error; err != nil {
+			return nil, nil, fmt.Errorf("exchange key %d not found: %w", account.exchangeKeyID, err)
+		}
+		apiKey, apiSecret := exchangeKeyCredentials(&key)
+		return NewTradingService(apiKey, apiSecret, account.exchange, s.DB, account.userID), config, nil
+	}
+	if len(account.botConfigIDs) == 0 {
+		return nil, nil, fmt.Errorf("account has no exchange key or bot config")
+	}
+	if err := s.DB.First(config, account.botConfigIDs[0]).Error; err != nil {
+		return nil, nil, fmt.Errorf("bot config %d not found: %w", account.botConfigIDs[0], err)
+	}
+	apiKey, err := utils.DecryptString(config.APIKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt API key: %w", err)
+	}
+	apiSecret, err := utils.DecryptString(config.APISecret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt API secret: %w", err)
+	}
+	return NewTradingService(apiKey, apiSecret, account.exchange, s.DB, account.userID), config, nil
+}
+
+// accountScope giới hạn query Order / Position theo bot + exchange key của account
+func accountScope(db *gorm.DB, account streamAccount) *gorm.DB {
+	botIDs := account.botConfigIDs
+	if len(botIDs) == 0 {
+		botIDs = []uint{0}
+	}
+	if account.exchangeKeyID > 0 {
+		return db.Where("user_id = ? AND (bot_config_id IN ? OR exchange_key_id = ?)", account.userID, botIDs, account.exchangeKeyID)
+	}
+	return db.Where("user_id = ? AND bot_config_id IN ?", account.userID, botIDs)
+}
+
+func (s *ReconciliationService) reconcileAccount(account streamAccount) ReconcileReport {
+	report := ReconcileReport{
+		AccountKey:    account.key,
+		UserID:        account.userID,
+		ExchangeKeyID: account.exchangeKeyID,
+		BotConfigIDs:  account.botConfigIDs,
+		StartedAt:     time.Now(),
+		Issues:        []ReconcileIssue{},
+	}
+	defer func() {
+		report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+		s.mu.Lock()
+		s.reports[account.key] = &report
+		s.mu.Unlock()
+	}()
+
+	ts, config, err := s.accountTradingService(account)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	// ====== Trạng thái trên sàn ======
+	positions, err := ts.getAllFuturesPositions(config)
+	if err != nil {
+		report.Error = fmt.Sprintf("positions: %v", err)
+		return report
+	}
+	openSymbols := make(map[string]bool)
+	symbols, err := ts.listFuturesOpenOrderSymbols(config)
+	if err != nil {
+		report.Error = fmt.Sprintf("open orders: %v", err)
+		return report
+	}
+	for _, sym := range symbols {
+		openSymbols[sym] = true
+	}
+	algoOrders, err := ts.listOpenAlgoOrders()
+	if err != nil {
+		report.Error = fmt.Sprintf("algo orders: %v", err)
+		return report
+	}
+	for _, list := range positions {
+		report.Positions += len(list)
+	}
+	report.OpenOrders = len(openSymbols)
+	report.AlgoOrders = len(algoOrders)
+
+	// ====== Trạng thái trong DB ======
+	var orders []models.Order
+	if err := accountScope(s.DB, account).
+		Where("LOWER(trading_mode) IN (?, ?) AND LOWER(status) NOT IN ?", "futures", "future", terminalOrderStatuses).
+		Order("created_at ASC").Find(&orders).Error; err != nil {
+		report.Error = fmt.Sprintf("orders: %v", err)
+		return report
+	}
+	var dbPositions []models.Position
+	if err := accountScope(s.DB, account).Where("status <> ?", PositionStatusClosed).Find(&dbPositions).Error; err != nil {
+		report.Error = fmt.Sprintf("positions (db): %v", err)
+		return report
+	}
+	report.Orders = len(orders)
+
+	s.reconcileOrders(&report, account, ts, config, orders, positions, openSymbols, algoOrders)
+	s.reconcilePositions(&report, account, ts, orders, dbPositions, positions)
+
+	for _, issue := range report.Issues {
+		if issue.Fixed {
+			report.Fixed++
+		}
+		if s.raiseAlert(account, issue) {
+			report.Alerts++
+		}
+	}
+	return report
+}
+
+// reconcileOrders: lệnh mở trong DB không còn vị thế / lệnh trên sàn, SL/TP algo bị mất
+func (s *ReconciliationService) reconcileOrders(report *ReconcileReport, account streamAccount, ts *TradingService, config *models.TradingConfig,
+	orders []models.Order, positions map[string][]FuturesPositionInfo, openSymbols map[string]bool, algoOrders []binanceOpenAlgoOrder) {
+
+	algoIDs := make(map[string]bool, len(algoOrders))
+	for _, a := range algoOrders {
+		algoIDs[fmt.Sprint(a.AlgoID)] = true
+	}
+	hasAlgo := func(symbol, side, closeSide string, types ...string) bool {
+		for _, a := range algoOrders {
+			if a.Symbol != symbol || !strings.EqualFold(a.Side, closeSide) || !protectsPositionSide(a.PositionSide, side) {
+				continue
+			}
+			for _, t := range types {
+				if strings.EqualFold(a.OrderType, t) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	checkedStops := make(map[string]bool)
+	for i := range orders {
+		order := &orders[i]
+		if time.Since(order.UpdatedAt) < reconcileGracePeriod {
+			continue
+		}
+		side := positionSideOfOrder(order.Side)
+		position := matchPositionSide(positions, order.Symbol, side)
+
+		if position == nil {
+			if openSymbols[order.Symbol] {
+				continue // Còn lệnh chờ khớp trên symbol
+			}
+			if issue, ok := s.fixStaleOrder(account, ts, config, order); ok {
+				report.Issues = append(report.Issues, issue)
+			}
+			continue
+		}
+
+		// Vị thế còn mở: SL/TP của lệnh phải còn trên sàn (DCA dùng chung SL → chỉ kiểm tra 1 lần / symbol + chiều)
+		groupKey := order.Symbol + "|" + side
+		if checkedStops[groupKey] {
+			continue
+		}
+		closeSide := "SELL"
+		if side == "SHORT" {
+			closeSide = "BUY"
+		}
+		if order.AlgoIDStopLoss != "" && order.StopLossPrice.IsPositive() && !algoIDs[order.AlgoIDStopLoss] &&
+			!hasAlgo(order.Symbol, side, closeSide, "STOP_MARKET", "STOP", "TRAILING_STOP_MARKET") {
+			checkedStops[groupKey] = true
+			if issue, ok := s.fixMissingStop(account, ts, config, order, position, side, closeSide); ok {
+				report.Issues = append(report.Issues, issue)
+			}
+		}
+		if order.AlgoIDTakeProfit != "" && order.TakeProfitPrice.IsPositive() && !algoIDs[order.AlgoIDTakeProfit] &&
+			!hasAlgo(order.Symbol, side, closeSide, "TAKE_PROFIT_MARKET", "TAKE_PROFIT") {
+			checkedStops[groupKey] = true
+			report.Issues = append(report.Issues, ReconcileIssue{
+				Type: ReconcileMissingTakeProfit, Symbol: order.Symbol, Side: side, OrderID: order.ID,
+				Message: fmt.Sprintf("Take profit %s (algo %s) of order #%d is no longer on the exchange", order.TakeProfitPrice, order.AlgoIDTakeProfit, order.ID),
+			})
+		}
+	}
+}
+
+// protectsPositionSide: lệnh đóng có positionSide (hedge mode) chỉ bảo vệ vị thế cùng chiều; BOTH / rỗng là one-way mode
+func protectsPositionSide(orderPositionSide, side string) bool {
+	ps := strings.ToUpper(orderPositionSide)
+	return ps == "" || ps == "BOTH" || ps == side
+}
+
+// reloadUnchangedOrder đọc lại lệnh sau khi lấy lock; false nếu lệnh đã đóng / vừa được cập nhật kể từ lúc đọc
+func (s *ReconciliationService) reloadUnchangedOrder(order *models.Order) bool {
+	var current models.Order
+	if err := s.DB.First(&current, order.ID).Error; err != nil {
+		return false
+	}
+	if isTerminalOrderStatus(current.Status) || !current.UpdatedAt.Equal(order.UpdatedAt) {
+		return false
+	}
+	*order = current
+	return true
+}
+
+// binanceOpenOrder là 1 phần tử của /fapi/v1/openOrders (chỉ các trường cần để nhận diện trailing stop)
+type binanceOpenOrder struct {
+	OrderID      int64  `json:"orderId"`
+	Symbol       string `json:"symbol"`
+	Side         string `json:"side"`
+	PositionSide string `json:"positionSide"`
+	Type         string `json:"type"`
+}
+
+// hasOpenTrailingStop kiểm tra trailing stop thường (openOrders) đang bảo vệ vị thế side của symbol
+func (ts *TradingService) hasOpenTrailingStop(symbol, side, closeSide string) (bool, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	var orders []binanceOpenOrder
+	if err := ts.signedFuturesGet("/fapi/v1/openOrders", params, &orders); err != nil {
+		return false, err
+	}
+	for _, o := range orders {
+		if strings.EqualFold(o.Type, "TRAILING_STOP_MARKET") && strings.EqualFold(o.Side, closeSide) &&
+			protectsPositionSide(o.PositionSide, side) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fixStaleOrder lấy trạng thái thật của lệnh trên sàn; đã khớp → vị thế đã đóng (closed + đối soát PnL), chưa khớp → trạng thái của sàn.
+// ok = false: lệnh vừa thay đổi trong lúc đối soát → không còn là sai lệch.
+func (s *ReconciliationService) fixStaleOrder(account streamAccount, ts *TradingService, config *models.TradingConfig, order *models.Order) (ReconcileIssue, bool) {
+	issue := ReconcileIssue{
+		Type: ReconcileStaleOrder, Symbol: order.Symbol, Side: positionSideOfOrder(order.Side), OrderID: order.ID,
+		Message: fmt.Sprintf("Order #%d is %s in DB but the exchange has no %s position or open order", order.ID, order.Status, order.Symbol),
+	}
+
+	release, err := lockSymbol(ts, account, order.Symbol, fmt.Sprintf("order:%d", order.ID))
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (not fixed: %v)", err)
+		return issue, true
+	}
+	defer release()
+	if !s.reloadUnchangedOrder(order) {
+		return issue, false
+	}
+
+	statusResult := ts.CheckOrderStatus(config, order.OrderID, order.Symbol, protectiveAlgoID(order))
+	if !statusResult.Success {
+		issue.Message += fmt.Sprintf(" (status check failed: %s)", statusResult.Error)
+		return issue, true
+	}
+	if statusResult.IsRunning {
+		return issue, true
+	}
+
+	status := NormalizeOrderStatus(statusResult.Status)
+	updates := map[string]interface{}{}
+	switch status {
+	case OrderStatusFilled, OrderStatusPartiallyFilled, OrderStatusClosed:
+		status = OrderStatusClosed
+		updates["closed_at"] = time.Now()
+	case OrderStatusNew, OrderStatusPending, "":
+		return issue, true // Sàn báo còn mở nhưng không có trong openOrders → để người dùng kiểm tra
+	}
+
+	changed, err := ApplyOrderChange(s.DB, order, OrderChange{
+		Status:  status,
+		Updates: updates,
+		Source:  OrderSourceReconcile,
+		Message: "reconciliation: no position or open order on exchange",
+		Payload: statusResult,
+	})
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (update failed: %v)", err)
+		return issue, true
+	}
+	issue.Fixed = true
+	issue.Action = "marked " + status
+	if changed && status == OrderStatusClosed {
+		SettleClosedFuturesOrders(s.DB, []uint{order.ID})
+	} else {
+		syncOrderPosition(s.DB, order)
+	}
+	return issue, true
+}
+
+// fixMissingStop đặt lại SL (closePosition) nếu bật ReplaceStops và giá SL còn hợp lệ so với mark price.
+// ok = false: SL vừa được sửa / đặt lại trong lúc đối soát → không còn là sai lệch.
+func (s *ReconciliationService) fixMissingStop(account streamAccount, ts *TradingService, config *models.TradingConfig, order *models.Order,
+	position *FuturesPositionInfo, side, closeSide string) (ReconcileIssue, bool) {
+
+	issue := ReconcileIssue{
+		Type: ReconcileMissingStopLoss, Symbol: order.Symbol, Side: side, OrderID: order.ID,
+		Message: fmt.Sprintf("Stop loss %s (algo %s) of order #%d is no longer on the exchange: position is unprotected",
+			order.StopLossPrice, order.AlgoIDStopLoss, order.ID),
+	}
+
+	// Trailing stop thường (openOrders) cùng chiều vị thế vẫn bảo vệ vị thế
+	if trailing, err := ts.hasOpenTrailingStop(order.Symbol, side, closeSide); err == nil && trailing {
+		issue.Message += " (a trailing stop is still open)"
+		return issue, true
+	}
+	if !s.ReplaceStops {
+		return issue, true
+	}
+
+	release, err := lockSymbol(ts, account, order.Symbol, fmt.Sprintf("stop:%d", order.ID))
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (not re-placed: %v)", err)
+		return issue, true
+	}
+	defer release()
+	// Trong lock: SL có thể vừa được sửa / đặt lại (amend, chốt một phần) sau lúc đọc danh sách algo
+	if !s.reloadUnchangedOrder(order) {
+		return issue, false
+	}
+	algoOrders, err := ts.listOpenAlgoOrders()
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (not re-placed: %v)", err)
+		return issue, true
+	}
+	for _, a := range algoOrders {
+		if fmt.Sprint(a.AlgoID) == order.AlgoIDStopLoss {
+			return issue, false
+		}
+	}
+
+	mark := position.MarkPrice
+	if (side == "LONG" && !order.StopLossPrice.LessThan(mark)) || (side == "SHORT" && !order.StopLossPrice.GreaterThan(mark)) {
+		issue.Message += fmt.Sprintf(" (not re-placed: mark price %s already beyond stop)", mark)
+		return issue, true
+	}
+
+	result := ts.PlaceAlgoStopLoss(config, order.Symbol, order.StopLossPrice, closeSide, side)
+	if !result.Success {
+		issue.Message += fmt.Sprintf(" (re-place failed: %s)", result.Error)
+		return issue, true
+	}
+	// Các lệnh cùng vị thế dùng chung SL cũ → trỏ sang algo mới
+	if err := s.DB.Model(&models.Order{}).
+		Where("user_id = ? AND symbol = ? AND algo_id_stop_loss = ?", order.UserID, order.Symbol, order.AlgoIDStopLoss).
+		Update("algo_id_stop_loss", result.OrderID).Error; err != nil {
+		log.Printf("⚠️  Order %d: failed to save re-placed stop loss %s: %v", order.ID, result.OrderID, err)
+	}
+	issue.Fixed = true
+	issue.Action = "re-placed stop loss (algo " + result.OrderID + ")"
+	return issue, true
+}
+
+// reconcilePositions: vị thế trên sàn không có trong DB, Position DB đã đóng trên sàn, khối lượng lệch
+func (s *ReconciliationService) reconcilePositions(report *ReconcileReport, account streamAccount, ts *TradingService,
+	orders []models.Order, dbPositions []models.Position, positions map[string][]FuturesPositionInfo) {
+
+	bySide := make(map[string][]*models.Position)
+	for i := range dbPositions {
+		key := dbPositions[i].Symbol + "|" + dbPositions[i].Side
+		bySide[key] = append(bySide[key], &dbPositions[i])
+	}
+	trackedOrders := make(map[string]bool)
+	for i := range orders {
+		trackedOrders[orders[i].Symbol+"|"+positionSideOfOrder(orders[i].Side)] = true
+	}
+
+	for symbol, list := range positions {
+		for i := range list {
+			info := &list[i]
+			side := positionSideOf(info.PositionSide, info.PositionAmt)
+			key := symbol + "|" + side
+			tracked := bySide[key]
+
+			switch {
+			case len(tracked) == 0 && !trackedOrders[key]:
+				if issue, ok := s.trackPosition(account, ts, info, side); ok {
+					report.Issues = append(report.Issues, issue)
+				}
+			case len(tracked) == 1:
+				if !tracked[0].Quantity.Equal(info.PositionAmt.Abs()) {
+					if issue, ok := s.syncPositionSize(account, ts, tracked[0], info); ok {
+						report.Issues = append(report.Issues, issue)
+					}
+				}
+			case len(tracked) > 1:
+				// Nhiều bot chung 1 vị thế trên sàn: không tự chia khối lượng
+				total := decimal.Zero
+				for _, p := range tracked {
+					total = total.Add(p.Quantity)
+				}
+				if !total.Equal(info.PositionAmt.Abs()) {
+					report.Issues = append(report.Issues, ReconcileIssue{
+						Type: ReconcilePositionSize, Symbol: symbol, Side: side,
+						Message: fmt.Sprintf("%d positions total %s but exchange %s %s position is %s", len(tracked), total, symbol, side, info.PositionAmt.Abs()),
+					})
+				}
+			}
+		}
+	}
+
+	for i := range dbPositions {
+		pos := &dbPositions[i]
+		if time.Since(pos.UpdatedAt) < reconcileGracePeriod || matchPositionSide(positions, pos.Symbol, pos.Side) != nil {
+			continue
+		}
+		if issue, ok := s.fixClosedPosition(account, ts, pos); ok {
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+}
+
+// reloadUnchangedPosition đọc lại Position sau khi lấy lock; false nếu vị thế đã đóng / vừa được cập nhật kể từ lúc đọc
+func (s *ReconciliationService) reloadUnchangedPosition(pos *models.Position) bool {
+	var current models.Position
+	if err := s.DB.First(&current, pos.ID).Error; err != nil {
+		return false
+	}
+	if current.Status == PositionStatusClosed || !current.UpdatedAt.Equal(pos.UpdatedAt) {
+		return false
+	}
+	*pos = current
+	return true
+}
+
+// syncPositionSize đồng bộ khối lượng Position theo sàn (trong execution lock của symbol)
+func (s *ReconciliationService) syncPositionSize(account streamAccount, ts *TradingService, pos *models.Position, info *FuturesPositionInfo) (ReconcileIssue, bool) {
+	issue := ReconcileIssue{
+		Type: ReconcilePositionSize, Symbol: pos.Symbol, Side: pos.Side, PositionID: pos.ID,
+		Message: fmt.Sprintf("Position #%d size %s differs from exchange %s", pos.ID, pos.Quantity, info.PositionAmt.Abs()),
+	}
+	release, err := lockSymbol(ts, account, pos.Symbol, fmt.Sprintf("position:%d", pos.ID))
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (not synced: %v)", err)
+		return issue, true
+	}
+	defer release()
+	if !s.reloadUnchangedPosition(pos) {
+		return issue, false
+	}
+	if err := ApplyExchangePosition(s.DB, pos, info); err == nil {
+		issue.Fixed, issue.Action = true, "synced size from exchange"
+	}
+	return issue, true
+}
+
+// fixClosedPosition đóng Position DB mà sàn không còn vị thế (trong execution lock của symbol)
+func (s *ReconciliationService) fixClosedPosition(account streamAccount, ts *TradingService, pos *models.Position) (ReconcileIssue, bool) {
+	issue := ReconcileIssue{
+		Type: ReconcileClosedPosition, Symbol: pos.Symbol, Side: pos.Side, PositionID: pos.ID,
+		Message: fmt.Sprintf("Position #%d is %s in DB but closed on the exchange", pos.ID, pos.Status),
+	}
+	release, err := lockSymbol(ts, account, pos.Symbol, fmt.Sprintf("position:%d", pos.ID))
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (not closed: %v)", err)
+		return issue, true
+	}
+	defer release()
+	if !s.reloadUnchangedPosition(pos) {
+		return issue, false
+	}
+	if err := ClosePosition(s.DB, pos.ID, decimal.Zero, "", time.Now()); err != nil {
+		issue.Message += fmt.Sprintf(" (close failed: %v)", err)
+	} else {
+		issue.Fixed, issue.Action = true, "closed position"
+	}
+	return issue, true
+}
+
+// trackPosition ghi nhận vị thế mở ngoài hệ thống (Position không có lệnh) để theo dõi PnL / đóng.
+// ok = false: lệnh / Position của vị thế vừa được lưu trong lúc đối soát (signal đang mở lệnh).
+func (s *ReconciliationService) trackPosition(account streamAccount, ts *TradingService, info *FuturesPositionInfo, side string) (ReconcileIssue, bool) {
+	issue := ReconcileIssue{
+		Type: ReconcileUntrackedPosition, Symbol: info.Symbol, Side: side,
+		Message: fmt.Sprintf("%s %s position of %s on the exchange is not tracked (opened outside TraderCoin?)", info.Symbol, side, info.PositionAmt.Abs()),
+	}
+	release, err := lockSymbol(ts, account, info.Symbol, "track:"+info.Symbol)
+	if err != nil {
+		issue.Message += fmt.Sprintf(" (not recorded: %v)", err)
+		return issue, true
+	}
+	defer release()
+	if s.isPositionTracked(account, info.Symbol, side) {
+		return issue, false
+	}
+	pos := models.Position{
+		UserID:        account.userID,
+		ExchangeKeyID: account.exchangeKeyID,
+		Exchange:      account.exchange,
+		Symbol:        info.Symbol,
+		TradingMode:   "futures",
+		Side:          side,
+		Status:        PositionStatusOpen,
+		Quantity:      info.PositionAmt.Abs(),
+		MaxQuantity:   info.PositionAmt.Abs(),
+		EntryPrice:    info.EntryPrice,
+		Leverage:      info.Leverage,
+		OpenedAt:      time.Now(),
+	}
+	if account.exchangeKeyID == 0 && len(account.botConfigIDs) > 0 {
+		pos.BotConfigID = account.botConfigIDs[0]
+	}
+	if err := s.DB.Create(&pos).Error; err != nil {
+		issue.Message += fmt.Sprintf(" (failed to record: %v)", err)
+		return issue, true
+	}
+	if err := ApplyExchangePosition(s.DB, &pos, info); err != nil {
+		log.Printf("⚠️  Position %d: failed to sync: %v", pos.ID, err)
+	}
+	issue.PositionID = pos.ID
+	issue.Fixed = true
+	issue.Action = fmt.Sprintf("created position #%d", pos.ID)
+	return issue, true
+}
+
+// isPositionTracked kiểm tra lại trong DB (sau khi lấy lock) xem vị thế symbol + side đã có lệnh mở / Position chưa
+func (s *ReconciliationService) isPositionTracked(account streamAccount, symbol, side string) bool {
+	var positions int64
+	accountScope(s.DB.Model(&models.Position{}), account).
+		Where("symbol = ? AND side = ? AND status <> ?", symbol, side, PositionStatusClosed).Count(&positions)
+	if positions > 0 {
+		return true
+	}
+	var orders []models.Order
+	accountScope(s.DB, account).
+		Where("symbol = ? AND LOWER(trading_mode) IN (?, ?) AND LOWER(status) NOT IN ?", symbol, "futures", "future", terminalOrderStatuses).
+		Find(&orders)
+	for i := range orders {
+		if positionSideOfOrder(orders[i].Side) == side {
+			return true
+		}
+	}
+	return false
+}
+
+// raiseAlert ghi system log cho sai lệch: đã sửa → INFO, chưa sửa → WARNING (cùng sai lệch chỉ cảnh báo lại sau cooldown).
+// Vị thế mở ngoài hệ thống luôn là WARNING dù đã được ghi nhận.
+func (s *ReconciliationService) raiseAlert(account streamAccount, issue ReconcileIssue) bool {
+	level, action := utils.LogLevelInfo, "RECONCILE_FIXED"
+	if !issue.Fixed || issue.Type == ReconcileUntrackedPosition {
+		level, action = utils.LogLevelWarning, "RECONCILE_ALERT"
+
+		key := fmt.Sprintf("%s|%s|%s|%s|%d|%d", account.key, issue.Type, issue.Symbol, issue.Side, issue.OrderID, issue.PositionID)
+		s.mu.Lock()
+		last, seen := s.alerted[key]
+		if !issue.Fixed && seen && time.Since(last) < reconcileAlertCooldown {
+			s.mu.Unlock()
+			return false
+		}
+		s.alerted[key] = time.Now()
+		s.mu.Unlock()
+	}
+
+	options := map[string]interface{}{
+		"symbol":      issue.Symbol,
+		"exchange":    account.exchange,
+		"type":        issue.Type,
+		"side":        issue.Side,
+		"account_key": account.key,
+		"fixed":       issue.Fixed,
+		"action":      issue.Action,
+	}
+	if issue.OrderID > 0 {
+		options["order_id"] = issue.OrderID
+	}
+	if issue.PositionID > 0 {
+		options["position_id"] = issue.PositionID
+	}
+	message := issue.Message
+	if issue.Fixed {
+		message += " → " + issue.Action
+	}
+	if err := utils.CreateSystemLog(s.DB, account.userID, level, action, message, options); err != nil {
+		log.Printf("⚠️  Reconciliation: failed to write alert: %v", err)
+		return false
+	}
+	return level == utils.LogLevelWarning
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"net/http"
+	"tradercoin/backend/config"
 
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
@@ -9,9 +10,13 @@ import (
 )
 
 type Services struct {
-	DB           *gorm.DB
-	Redis        *redis.Client
-	OrderMonitor *OrderMonitorService // Background worker for order status updates
+	Config         *config.Config
+	DB             *gorm.DB
+	Redis          *redis.Client
+	OrderMonitor   *OrderMonitorService   // Background worker for order status updates
+	SignalExecutor *SignalExecutor        // Auto-execute signals for subscribed bots
+	StreamManager  *StreamManager         // Server-side exchange user data streams
+	Reconciliation *ReconciliationService // Periodic exchange ↔ database reconciliation
 }
 
 // GetWebSocketUpgrader returns WebSocket upgrader with CORS settings
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// Loại action của signal
+const (
+	SignalActionEntry        = "entry"         // buy/long, sell/short: mở vị thế
+	SignalActionCloseLong    = "close_long"    // đóng vị thế long
+	SignalActionCloseShort   = "close_short"   // đóng vị thế short
+	SignalActionCloseAll     = "close_all"     // đóng mọi vị thế + huỷ lệnh chờ của symbol
+	SignalActionReverse      = "reverse"       // đóng vị thế hiện tại rồi mở chiều ngược lại
+	SignalActionPartialClose = "partial_close" // đóng một phần (%) vị thế hiện tại
+)
+
+// SignalAction is the parsed form of TradingSignal.Action
+type SignalAction struct {
+	Kind    string          `json:"kind"`
+	Side    string          `json:"side,omitempty"` // buy/sell cho entry
+	Percent decimal.Decimal `json:"percent"`        // % vị thế cần đóng (100 với close/reverse)
+}
+
+// IsClose returns true for actions that only reduce positions
+func (a SignalAction) IsClose() bool {
+	return a.Kind != SignalActionEntry && a.Kind != SignalActionReverse
+}
+
+// OpensPosition returns true for actions that place a new entry order
+func (a SignalAction) OpensPosition() bool {
+	return a.Kind == SignalActionEntry || a.Kind == SignalActionReverse
+}
+
+// signalActionAliases map action (đã chuẩn hoá) sang loại action
+var signalActionAliases = map[string]SignalAction{
+	"buy":   {Kind: SignalActionEntry, Side: "buy"},
+	"long":  {Kind: SignalActionEntry, Side: "buy"},
+	"sell":  {Kind: SignalActionEntry, Side: "sell"},
+	"short": {Kind: SignalActionEntry, Side: "sell"},
+
+	"close_long": {Kind: SignalActionCloseLong},
+	"exit_long":  {Kind: SignalActionCloseLong},
+	"closelong":  {Kind: SignalActionCloseLong},
+	"exitlong":   {Kind: SignalActionCloseLong},
+
+	"close_short": {Kind: SignalActionCloseShort},
+	"exit_short":  {Kind: SignalActionCloseShort},
+	"closeshort":  {Kind: SignalActionCloseShort},
+	"exitshort":   {Kind: SignalActionCloseShort},
+	"cover":       {Kind: SignalActionCloseShort},
+
+	"close":     {Kind: SignalActionCloseAll},
+	"exit":      {Kind: SignalActionCloseAll},
+	"close_all": {Kind: SignalActionCloseAll},
+	"closeall":  {Kind: SignalActionCloseAll},
+	"exit_all":  {Kind: SignalActionCloseAll},
+	"flat":      {Kind: SignalActionCloseAll},
+	"flatten":   {Kind: SignalActionCloseAll},
+
+	"reverse": {Kind: SignalActionReverse},
+	"flip":    {Kind: SignalActionReverse},
+
+	"partial_close": {Kind: SignalActionPartialClose},
+	"close_partial": {Kind: SignalActionPartialClose},
+	"partial":       {Kind: SignalActionPartialClose},
+	"reduce":        {Kind: SignalActionPartialClose},
+}
+
+// partialCloseActionRe nhận action kèm % như "close 50%", "close_50", "exit 25%", "partial_close 30"
+var partialCloseActionRe = regexp.MustCompile(`^(?:close|exit|partial_close|close_partial|partial|reduce)_?(\d+(?:\.\d+)?)%?$`)
+
+// ParseSignalAction parses the action of a signal; percent là field "percent" của payload (dùng cho partial close)
+func ParseSignalAction(action string, percent decimal.Decimal) (SignalAction, error) {
+	normalized := strings.ToLower(strings.TrimSpace(action))
+	normalized = strings.NewReplacer(" ", "_", "-", "_").Replace(normalized)
+
+	parsed, ok := signalActionAliases[normalized]
+	if !ok {
+		m := partialCloseActionRe.FindStringSubmatch(normalized)
+		if m == nil {
+			return SignalAction{}, fmt.Errorf("unsupported action %q", action)
+		}
+		parsed = SignalAction{Kind: SignalActionPartialClose}
+		percent, _ = decimal.NewFromString(m[1])
+	}
+
+	switch parsed.Kind {
+	case SignalActionEntry:
+		return parsed, nil
+	case SignalActionPartialClose:
+		if !percent.IsPositive() || percent.GreaterThan(hundred) {
+			return SignalAction{}, fmt.Errorf("partial close requires a percent between 0 and 100 (got %s)", percent)
+		}
+		parsed.Percent = percent
+		// 100% → đóng hết vị thế hiện tại
+		if percent.Equal(hundred) {
+			parsed.Kind = SignalActionCloseAll
+		}
+		return parsed, nil
+	default:
+		// close/reverse luôn đóng toàn bộ vị thế (đóng một phần dùng partial_close)
+		parsed.Percent = hundred
+		return parsed, nil
+	}
+}
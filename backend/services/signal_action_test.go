@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseSignalAction(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      string
+		percent     string
+		wantKind    string
+		wantSide    string
+		wantPercent string
+		wantErr     bool
+	}{
+		{"buy", "buy", "0", SignalActionEntry, "buy", "0", false},
+		{"long uppercase", "LONG", "0", SignalActionEntry, "buy", "0", false},
+		{"sell", "sell", "0", SignalActionEntry, "sell", "0", false},
+		{"short with spaces", "  short ", "0", SignalActionEntry, "sell", "0", false},
+		{"close long", "close_long", "0", SignalActionCloseLong, "", "100", false},
+		{"exit long with space", "exit long", "0", SignalActionCloseLong, "", "100", false},
+		{"close short with dash", "close-short", "0", SignalActionCloseShort, "", "100", false},
+		{"cover", "cover", "0", SignalActionCloseShort, "", "100", false},
+		{"close", "close", "0", SignalActionCloseAll, "", "100", false},
+		{"flat", "flat", "0", SignalActionCloseAll, "", "100", false},
+		{"reverse", "reverse", "0", SignalActionReverse, "", "100", false},
+		{"flip", "flip", "0", SignalActionReverse, "", "100", false},
+		{"partial close with percent field", "partial_close", "25", SignalActionPartialClose, "", "25", false},
+		{"partial close 100% closes all", "reduce", "100", SignalActionCloseAll, "", "100", false},
+		{"partial close without percent", "partial_close", "0", "", "", "", true},
+		{"partial close over 100", "partial", "150", "", "", "", true},
+		{"percent in action", "close 50%", "0", SignalActionPartialClose, "", "50", false},
+		{"percent in action with underscore", "close_50", "0", SignalActionPartialClose, "", "50", false},
+		{"decimal percent in action", "exit 12.5%", "0", SignalActionPartialClose, "", "12.5", false},
+		{"percent in action overrides field", "reduce 30", "80", SignalActionPartialClose, "", "30", false},
+		{"percent in action over 100", "close 120%", "0", "", "", "", true},
+		{"unknown", "hold", "0", "", "", "", true},
+		{"empty", "", "0", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignalAction(tt.action, decimal.RequireFromString(tt.percent))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSignalAction(%q, %s) error = %v, wantErr %v", tt.action, tt.percent, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Kind != tt.wantKind || got.Side != tt.wantSide {
+				t.Errorf("ParseSignalAction(%q) = %s/%s, want %s/%s", tt.action, got.Kind, got.Side, tt.wantKind, tt.wantSide)
+			}
+			if want := decimal.RequireFromString(tt.wantPercent); !got.Percent.Equal(want) {
+				t.Errorf("ParseSignalAction(%q) percent = %s, want %s", tt.action, got.Percent, want)
+			}
+		})
+	}
+}
+
+func TestSignalActionKinds(t *testing.T) {
+	tests := []struct {
+		kind      string
+		wantClose bool
+		wantOpens bool
+	}{
+		{SignalActionEntry, false, true},
+		{SignalActionReverse, false, true},
+		{SignalActionCloseLong, true, false},
+		{SignalActionCloseShort, true, false},
+		{SignalActionCloseAll, true, false},
+		{SignalActionPartialClose, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			a := SignalAction{Kind: tt.kind}
+			if a.IsClose() != tt.wantClose || a.OpensPosition() != tt.wantOpens {
+				t.Errorf("%s: IsClose = %v, OpensPosition = %v, want %v, %v", tt.kind, a.IsClose(), a.OpensPosition(), tt.wantClose, tt.wantOpens)
+			}
+		})
+	}
+}
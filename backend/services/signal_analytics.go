@@ -0,0 +1,697 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultShadowHorizon là thời gian giữ lệnh giả định của shadow PnL (nếu không có signal đóng trước đó)
+	DefaultShadowHorizon = 24 * time.Hour
+	// DefaultMaxShadowSignals giới hạn số signal mô phỏng mỗi lần gọi (mỗi signal cần 1 lần lấy nến);
+	// signal đã có trong cache không tính, nên gọi lại nhiều lần sẽ dần mô phỏng hết
+	DefaultMaxShadowSignals = 20
+	// MaxShadowSignalsPerRequest là giới hạn trên của shadow_limit
+	MaxShadowSignalsPerRequest = 30
+
+	// Toàn server chỉ lấy tối đa shadowFetchesPerMinute lần nến / phút (weight của IP server dùng chung với giao dịch)
+	shadowFetchesPerMinute = 60
+	// Kết quả mô phỏng giữ trong cache tối đa shadowCacheTTL, tối đa shadowCacheMaxEntries kết quả
+	shadowCacheTTL        = 24 * time.Hour
+	shadowCacheMaxEntries = 5000
+
+	analyticsNoGroup = "(none)"
+)
+
+// SignalAnalyticsQuery chọn phạm vi thống kê
+type SignalAnalyticsQuery struct {
+	UserID           uint // 0 = toàn hệ thống (admin)
+	Since            time.Time
+	Prefix           string
+	Strategy         string
+	Shadow           bool
+	Horizon          time.Duration
+	MaxShadowSignals int
+}
+
+// SignalGroupStats are the performance statistics of one strategy or webhook prefix
+type SignalGroupStats struct {
+	Key             string          `json:"key"`
+	Signals         int             `json:"signals"`          // Signal hợp lệ nhận được
+	ExecutedSignals int             `json:"executed_signals"` // Signal có ít nhất 1 lệnh vào
+	Trades          int             `json:"trades"`           // Lệnh vào đã đóng
+	OpenTrades      int             `json:"open_trades"`
+	Wins            int             `json:"wins"`
+	Losses          int             `json:"losses"`
+	WinRate         decimal.Decimal `json:"win_rate"` // %
+	TotalPnL        decimal.Decimal `json:"total_pnl"`
+	AvgWin          decimal.Decimal `json:"avg_win"`
+	AvgLoss         decimal.Decimal `json:"avg_loss"`
+	Expectancy      decimal.Decimal `json:"expectancy"`   // PnL kỳ vọng mỗi lệnh
+	AvgR            decimal.Decimal `json:"avg_r"`        // R trung bình (PnL / rủi ro tới stop-loss)
+	RTrades         int             `json:"r_trades"`     // Số lệnh có stop-loss để tính R
+	MaxDrawdown     decimal.Decimal `json:"max_drawdown"` // Sụt giảm lớn nhất của PnL luỹ kế
+	AvgHoldMinutes  decimal.Decimal `json:"avg_hold_minutes"`
+	Shadow          *ShadowStats    `json:"shadow,omitempty"`
+
+	trades []analyticsTrade
+	shadow []shadowResult
+}
+
+// ShadowStats is the hypothetical performance of entry signals that nobody executed
+type ShadowStats struct {
+	Signals         int             `json:"signals"`   // Signal vào lệnh không ai thực thi
+	Evaluated       int             `json:"evaluated"` // Đã mô phỏng được bằng giá lịch sử
+	Pending         int             `json:"pending"`   // Chưa hết horizon
+	Skipped         int             `json:"skipped"`   // Không có dữ liệu giá hoặc vượt giới hạn mô phỏng
+	Wins            int             `json:"wins"`
+	Losses          int             `json:"losses"`
+	WinRate         decimal.Decimal `json:"win_rate"`
+	TotalPnLPercent decimal.Decimal `json:"total_pnl_percent"`
+	AvgPnLPercent   decimal.Decimal `json:"avg_pnl_percent"`
+	AvgR            decimal.Decimal `json:"avg_r"`
+	RTrades         int             `json:"r_trades"`
+	MaxDrawdown     decimal.Decimal `json:"max_drawdown_percent"`
+}
+
+// SignalAnalyticsReport groups the statistics by strategy and by webhook prefix
+type SignalAnalyticsReport struct {
+	Since        time.Time          `json:"since"`
+	Until        time.Time          `json:"until"`
+	HorizonHours float64            `json:"horizon_hours"`
+	ByStrategy   []SignalGroupStats `json:"by_strategy"`
+	ByPrefix     []SignalGroupStats `json:"by_prefix"`
+}
+
+// analyticsTrade là 1 lệnh vào theo signal đã đóng
+type analyticsTrade struct {
+	PnL      decimal.Decimal
+	R        *decimal.Decimal
+	Hold     time.Duration
+	ClosedAt time.Time
+}
+
+// shadowResult là kết quả mô phỏng 1 signal
+type shadowResult struct {
+	Status     string // evaluated, pending, skipped
+	PnLPercent decimal.Decimal
+	R          *decimal.Decimal
+	ExitAt     time.Time
+	ExitReason string
+}
+
+// shadowResultCache lưu kết quả mô phỏng đã xong (dữ liệu lịch sử không đổi) theo "signalID:horizon",
+// có TTL và giới hạn số phần tử
+type shadowResultCache struct {
+	mu      sync.Mutex
+	entries map[string]shadowCacheEntry
+}
+
+type shadowCacheEntry struct {
+	result    shadowResult
+	expiresAt time.Time
+}
+
+var shadowCache = &shadowResultCache{entries: make(map[string]shadowCacheEntry)}
+
+func (c *shadowResultCache) Load(key string) (shadowResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return shadowResult{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return shadowResult{}, false
+	}
+	return entry.result, true
+}
+
+func (c *shadowResultCache) Store(key string, result shadowResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= shadowCacheMaxEntries {
+		// Dọn phần tử hết hạn; vẫn đầy thì bỏ phần tử sắp hết hạn nhất
+		oldestKey, oldest := "", time.Time{}
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+				continue
+			}
+			if oldestKey == "" || e.expiresAt.Before(oldest) {
+				oldestKey, oldest = k, e.expiresAt
+			}
+		}
+		if len(c.entries) >= shadowCacheMaxEntries {
+			delete(c.entries, oldestKey)
+		}
+	}
+	c.entries[key] = shadowCacheEntry{result: result, expiresAt: now.Add(shadowCacheTTL)}
+}
+
+// shadowFetchLimiter giới hạn số lần lấy nến của toàn server theo cửa sổ 1 phút
+type shadowFetchLimiter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+}
+
+var shadowFetches = &shadowFetchLimiter{}
+
+// Allow trả về false khi đã hết lượt lấy nến trong phút hiện tại
+func (l *shadowFetchLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.windowStart) >= time.Minute {
+		l.windowStart, l.count = now, 0
+	}
+	if l.count >= shadowFetchesPerMinute {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// BuildSignalAnalytics computes per-strategy and per-prefix statistics of signals received since q.Since
+func BuildSignalAnalytics(db *gorm.DB, q SignalAnalyticsQuery) (*SignalAnalyticsReport, error) {
+	now := time.Now()
+	if q.Horizon <= 0 {
+		q.Horizon = DefaultShadowHorizon
+	}
+	if q.MaxShadowSignals <= 0 {
+		q.MaxShadowSignals = DefaultMaxShadowSignals
+	}
+	if q.MaxShadowSignals > MaxShadowSignalsPerRequest {
+		q.MaxShadowSignals = MaxShadowSignalsPerRequest
+	}
+
+	query := db.Where("received_at >= ? AND (reject_reason = '' OR reject_reason IS NULL)", q.Since)
+	if q.Prefix != "" {
+		query = query.Where("webhook_prefix = ?", q.Prefix)
+	}
+	if q.Strategy != "" {
+		query = query.Where("LOWER(strategy) = ?", strings.ToLower(q.Strategy))
+	}
+	if q.UserID != 0 {
+		// Signal của user: gửi tới prefix của user (kể cả prefix đã rotate) hoặc user đã vào lệnh theo signal
+		prefixes := db.Unscoped().Model(&models.WebhookPrefix{}).Select("prefix").Where("user_id = ?", q.UserID)
+		ordered := db.Model(&models.Order{}).Select("signal_id").Where("user_id = ? AND signal_id IS NOT NULL", q.UserID)
+		query = query.Where("webhook_prefix IN (?) OR id IN (?)", prefixes, ordered)
+	}
+
+	var signals []models.TradingSignal
+	if err := query.Order("received_at ASC").Find(&signals).Error; err != nil {
+		return nil, fmt.Errorf("failed to load signals: %w", err)
+	}
+
+	report := &SignalAnalyticsReport{Since: q.Since, Until: now, HorizonHours: q.Horizon.Hours()}
+	if len(signals) == 0 {
+		report.ByStrategy = []SignalGroupStats{}
+		report.ByPrefix = []SignalGroupStats{}
+		return report, nil
+	}
+
+	signalIDs := make([]uint, 0, len(signals))
+	for _, s := range signals {
+		signalIDs = append(signalIDs, s.ID)
+	}
+
+	// Lệnh theo signal (của user, hoặc mọi user với admin)
+	var orders []models.Order
+	orderQuery := db.Where("signal_id IN ?", signalIDs)
+	if q.UserID != 0 {
+		orderQuery = orderQuery.Where("user_id = ?", q.UserID)
+	}
+	if err := orderQuery.Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("failed to load orders: %w", err)
+	}
+	entriesBySignal := make(map[uint][]models.Order)
+	for _, o := range orders {
+		if isSignalEntryOrder(&o) {
+			entriesBySignal[*o.SignalID] = append(entriesBySignal[*o.SignalID], o)
+		}
+	}
+
+	// Shadow chỉ tính signal không ai thực thi (không có lệnh nào của bất kỳ user)
+	executedByAnyone := make(map[uint]bool)
+	if q.Shadow {
+		var ids []uint
+		if err := db.Model(&models.Order{}).Where("signal_id IN ?", signalIDs).Distinct().Pluck("signal_id", &ids).Error; err != nil {
+			return nil, fmt.Errorf("failed to load executed signals: %w", err)
+		}
+		for _, id := range ids {
+			executedByAnyone[id] = true
+		}
+	}
+
+	byStrategy := make(map[string]*SignalGroupStats)
+	byPrefix := make(map[string]*SignalGroupStats)
+	group := func(groups map[string]*SignalGroupStats, key string) *SignalGroupStats {
+		if key == "" {
+			key = analyticsNoGroup
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &SignalGroupStats{Key: key}
+			groups[key] = g
+		}
+		return g
+	}
+
+	// Mô phỏng từ signal mới nhất để giới hạn MaxShadowSignals giữ lại phần gần đây
+	shadowBudget := q.MaxShadowSignals
+	shadowResults := make(map[uint]shadowResult)
+	if q.Shadow {
+		for i := len(signals) - 1; i >= 0; i-- {
+			s := &signals[i]
+			if executedByAnyone[s.ID] {
+				continue
+			}
+			action, err := ParseSignalAction(s.Action, s.ClosePercent)
+			if err != nil || action.Kind != SignalActionEntry {
+				continue
+			}
+			res, fetched := simulateShadowSignal(s, action.Side, signals[i+1:], q.Horizon, now, shadowBudget > 0)
+			if fetched {
+				shadowBudget--
+			}
+			shadowResults[s.ID] = res
+		}
+	}
+
+	for i := range signals {
+		s := &signals[i]
+		targets := []*SignalGroupStats{group(byStrategy, s.Strategy), group(byPrefix, s.WebhookPrefix)}
+		entries := entriesBySignal[s.ID]
+		shadow, hasShadow := shadowResults[s.ID]
+
+		for _, g := range targets {
+			g.Signals++
+			if len(entries) > 0 {
+				g.ExecutedSignals++
+			}
+			for j := range entries {
+				trade, closed, open := analyticsTradeFromOrder(&entries[j])
+				if closed {
+					g.trades = append(g.trades, trade)
+				} else if open {
+					g.OpenTrades++
+				}
+			}
+			if hasShadow {
+				g.shadow = append(g.shadow, shadow)
+			}
+		}
+	}
+
+	report.ByStrategy = finalizeGroups(byStrategy, q.Shadow)
+	report.ByPrefix = finalizeGroups(byPrefix, q.Shadow)
+	return report, nil
+}
+
+// isSignalEntryOrder phân biệt lệnh vào với lệnh đóng (close/partial close dùng client order ID "signalID:close")
+func isSignalEntryOrder(o *models.Order) bool {
+	if o.SignalID == nil {
+		return false
+	}
+	if o.ClientOrderID == "" {
+		return true // Lệnh cũ trước khi có client order ID chỉ có lệnh vào
+	}
+	return o.ClientOrderID == GenerateClientOrderID(o.UserID, o.BotConfigID, OrderSourceSignal, strconv.FormatUint(uint64(*o.SignalID), 10))
+}
+
+// analyticsTradeFromOrder trả về trade của lệnh vào; closed = đã đóng, open = vị thế còn mở
+func analyticsTradeFromOrder(o *models.Order) (analyticsTrade, bool, bool) {
+	status := strings.ToLower(o.Status)
+	if status != "closed" {
+		for _, s := range terminalOrderStatuses {
+			if status == s {
+				return analyticsTrade{}, false, false // Huỷ / lỗi: không phải trade
+			}
+		}
+		return analyticsTrade{}, false, true
+	}
+
+	entry := o.FilledPrice
+	if !entry.IsPositive() {
+		entry = o.Price
+	}
+	qty := o.FilledQuantity
+	if !qty.IsPositive() {
+		qty = o.Quantity
+	}
+
+	pnl := o.PnL
+	switch {
+	case marketOf(o.TradingMode) == "futures" && (!o.RealizedPnL.IsZero() || !o.Commission.IsZero()):
+		// Đã có realized PnL / phí từ sàn: PnL của lệnh là số thực nhận
+	case entry.IsPositive() && o.ExitPrice.IsPositive():
+		pnl, _ = CalculatePnL(o.Side, entry, o.ExitPrice, qty)
+	case entry.IsPositive() && o.CurrentPrice.IsPositive():
+		pnl, _ = CalculatePnL(o.Side, entry, o.CurrentPrice, qty)
+	}
+
+	closedAt := o.UpdatedAt
+	if o.ClosedAt != nil {
+		closedAt = *o.ClosedAt
+	}
+	trade := analyticsTrade{PnL: pnl, Hold: closedAt.Sub(o.CreatedAt), ClosedAt: closedAt}
+	if o.StopLossPrice.IsPositive() && entry.IsPositive() {
+		risk := entry.Sub(o.StopLossPrice).Abs().Mul(qty.Abs())
+		if risk.IsPositive() {
+			r := pnl.Div(risk)
+			trade.R = &r
+		}
+	}
+	return trade, true, false
+}
+
+func finalizeGroups(groups map[string]*SignalGroupStats, withShadow bool) []SignalGroupStats {
+	result := make([]SignalGroupStats, 0, len(groups))
+	for _, g := range groups {
+		g.finalizeTrades()
+		if withShadow {
+			g.finalizeShadow()
+		}
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].TotalPnL.Equal(result[j].TotalPnL) {
+			return result[i].TotalPnL.GreaterThan(result[j].TotalPnL)
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func (g *SignalGroupStats) finalizeTrades() {
+	sort.Slice(g.trades, func(i, j int) bool { return g.trades[i].ClosedAt.Before(g.trades[j].ClosedAt) })
+
+	var totalWin, totalLoss, totalR decimal.Decimal
+	var totalHold time.Duration
+	pnls := make([]decimal.Decimal, 0, len(g.trades))
+	for _, t := range g.trades {
+		g.Trades++
+		g.TotalPnL = g.TotalPnL.Add(t.PnL)
+		if t.PnL.IsPositive() {
+			g.Wins++
+			totalWin = totalWin.Add(t.PnL)
+		} else if t.PnL.IsNegative() {
+			g.Losses++
+			totalLoss = totalLoss.Add(t.PnL.Abs())
+		}
+		if t.R != nil {
+			g.RTrades++
+			totalR = totalR.Add(*t.R)
+		}
+		totalHold += t.Hold
+		pnls = append(pnls, t.PnL)
+	}
+	if g.Trades == 0 {
+		return
+	}
+
+	trades := decimal.NewFromInt(int64(g.Trades))
+	winRate := decimal.NewFromInt(int64(g.Wins)).Div(trades)
+	lossRate := decimal.NewFromInt(int64(g.Losses)).Div(trades)
+	g.WinRate = winRate.Mul(hundred).Round(2)
+	if g.Wins > 0 {
+		g.AvgWin = totalWin.Div(decimal.NewFromInt(int64(g.Wins))).Round(8)
+	}
+	if g.Losses > 0 {
+		g.AvgLoss = totalLoss.Div(decimal.NewFromInt(int64(g.Losses))).Round(8)
+	}
+	g.Expectancy = winRate.Mul(g.AvgWin).Sub(lossRate.Mul(g.AvgLoss)).Round(8)
+	if g.RTrades > 0 {
+		g.AvgR = totalR.Div(decimal.NewFromInt(int64(g.RTrades))).Round(4)
+	}
+	g.MaxDrawdown = maxDrawdown(pnls)
+	g.AvgHoldMinutes = decimal.NewFromFloat((totalHold / time.Duration(g.Trades)).Minutes()).Round(2)
+}
+
+func (g *SignalGroupStats) finalizeShadow() {
+	stats := &ShadowStats{}
+	sort.Slice(g.shadow, func(i, j int) bool { return g.shadow[i].ExitAt.Before(g.shadow[j].ExitAt) })
+
+	var totalR decimal.Decimal
+	pnls := make([]decimal.Decimal, 0, len(g.shadow))
+	for _, s := range g.shadow {
+		stats.Signals++
+		switch s.Status {
+		case "pending":
+			stats.Pending++
+			continue
+		case "skipped":
+			stats.Skipped++
+			continue
+		}
+		stats.Evaluated++
+		stats.TotalPnLPercent = stats.TotalPnLPercent.Add(s.PnLPercent)
+		if s.PnLPercent.IsPositive() {
+			stats.Wins++
+		} else if s.PnLPercent.IsNegative() {
+			stats.Losses++
+		}
+		if s.R != nil {
+			stats.RTrades++
+			totalR = totalR.Add(*s.R)
+		}
+		pnls = append(pnls, s.PnLPercent)
+	}
+	if stats.Evaluated > 0 {
+		evaluated := decimal.NewFromInt(int64(stats.Evaluated))
+		stats.WinRate = decimal.NewFromInt(int64(stats.Wins)).Div(evaluated).Mul(hundred).Round(2)
+		stats.AvgPnLPercent = stats.TotalPnLPercent.Div(evaluated).Round(4)
+		stats.TotalPnLPercent = stats.TotalPnLPercent.Round(4)
+		stats.MaxDrawdown = maxDrawdown(pnls).Round(4)
+	}
+	if stats.RTrades > 0 {
+		stats.AvgR = totalR.Div(decimal.NewFromInt(int64(stats.RTrades))).Round(4)
+	}
+	g.Shadow = stats
+}
+
+// maxDrawdown trả về mức sụt lớn nhất từ đỉnh của chuỗi PnL luỹ kế (số dương)
+func maxDrawdown(pnls []decimal.Decimal) decimal.Decimal {
+	var equity, peak, drawdown decimal.Decimal
+	for _, p := range pnls {
+		equity = equity.Add(p)
+		if equity.GreaterThan(peak) {
+			peak = equity
+		}
+		if dd := peak.Sub(equity); dd.GreaterThan(drawdown) {
+			drawdown = dd
+		}
+	}
+	return drawdown
+}
+
+// simulateShadowSignal mô phỏng lệnh vào tại giá signal, thoát khi chạm SL/TP của signal,
+// khi có signal đóng/đảo chiều cùng symbol + prefix + strategy, hoặc hết horizon.
+// fetched = đã gọi API lấy nến (tính vào giới hạn); canFetch = false thì chỉ dùng cache.
+func simulateShadowSignal(signal *models.TradingSignal, side string, later []models.TradingSignal, horizon time.Duration, now time.Time, canFetch bool) (shadowResult, bool) {
+	start := signal.ReceivedAt
+	if signal.SignalTime != nil {
+		start = *signal.SignalTime
+	}
+	end := start.Add(horizon)
+	exitPrice := decimal.Zero
+	exitReason := "horizon"
+
+	for i := range later {
+		next := &later[i]
+		if next.ReceivedAt.After(end) {
+			break
+		}
+		if !strings.EqualFold(next.Symbol, signal.Symbol) || next.WebhookPrefix != signal.WebhookPrefix ||
+			!strings.EqualFold(next.Strategy, signal.Strategy) {
+			continue
+		}
+		if closesShadowPosition(next, side) {
+			end = next.ReceivedAt
+			exitPrice = next.Price
+			exitReason = "signal"
+			break
+		}
+	}
+
+	if end.After(now) {
+		return shadowResult{Status: "pending"}, false
+	}
+
+	cacheKey := fmt.Sprintf("%d:%d", signal.ID, int64(horizon.Minutes()))
+	if cached, ok := shadowCache.Load(cacheKey); ok {
+		return cached, false
+	}
+	if !canFetch || !shadowFetches.Allow() {
+		return shadowResult{Status: "skipped", ExitAt: end}, false
+	}
+
+	candles, err := fetchHistoricalCandles(signal.Symbol, start, end)
+	if err != nil || len(candles) == 0 {
+		return shadowResult{Status: "skipped", ExitAt: end}, true
+	}
+
+	entry := signal.Price
+	if !entry.IsPositive() {
+		entry = candles[0].Open
+	}
+	long := isLongSide(side)
+	stopLoss, takeProfit := signal.StopLoss, signal.TakeProfit
+	exitAt := end
+
+	if exitReason != "signal" || !exitPrice.IsPositive() {
+		exitPrice = candles[len(candles)-1].Close
+	}
+	for _, c := range candles {
+		// Cùng 1 nến chạm cả SL và TP → giả định chạm SL trước (thận trọng)
+		hitSL := stopLoss.IsPositive() && ((long && c.Low.LessThanOrEqual(stopLoss)) || (!long && c.High.GreaterThanOrEqual(stopLoss)))
+		hitTP := takeProfit.IsPositive() && ((long && c.High.GreaterThanOrEqual(takeProfit)) || (!long && c.Low.LessThanOrEqual(takeProfit)))
+		if hitSL {
+			exitPrice, exitReason, exitAt = stopLoss, "stop_loss", c.OpenTime
+			break
+		}
+		if hitTP {
+			exitPrice, exitReason, exitAt = takeProfit, "take_profit", c.OpenTime
+			break
+		}
+	}
+
+	diff := exitPrice.Sub(entry)
+	if !long {
+		diff = diff.Neg()
+	}
+	result := shadowResult{
+		Status:     "evaluated",
+		PnLPercent: diff.Div(entry).Mul(hundred).Round(4),
+		ExitAt:     exitAt,
+		ExitReason: exitReason,
+	}
+	if stopLoss.IsPositive() {
+		if risk := entry.Sub(stopLoss).Abs(); risk.IsPositive() {
+			r := diff.Div(risk).Round(4)
+			result.R = &r
+		}
+	}
+	shadowCache.Store(cacheKey, result)
+	return result, true
+}
+
+// closesShadowPosition returns true if next closes or reverses a shadow position of the given side
+func closesShadowPosition(next *models.TradingSignal, side string) bool {
+	action, err := ParseSignalAction(next.Action, next.ClosePercent)
+	if err != nil {
+		return false
+	}
+	long := isLongSide(side)
+	switch action.Kind {
+	case SignalActionEntry:
+		return isLongSide(action.Side) != long
+	case SignalActionCloseAll, SignalActionReverse:
+		return true
+	case SignalActionCloseLong:
+		return long
+	case SignalActionCloseShort:
+		return !long
+	}
+	return false
+}
+
+// historicalCandle là 1 nến OHLC
+type historicalCandle struct {
+	OpenTime time.Time
+	Open     decimal.Decimal
+	High     decimal.Decimal
+	Low      decimal.Decimal
+	Close    decimal.Decimal
+}
+
+// candleIntervals theo thứ tự tăng dần; chọn khung nhỏ nhất mà số nến ≤ 1000 (1 lần gọi API)
+var candleIntervals = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1m", time.Minute}, {"3m", 3 * time.Minute}, {"5m", 5 * time.Minute}, {"15m", 15 * time.Minute},
+	{"30m", 30 * time.Minute}, {"1h", time.Hour}, {"2h", 2 * time.Hour}, {"4h", 4 * time.Hour},
+	{"12h", 12 * time.Hour}, {"1d", 24 * time.Hour},
+}
+
+// fetchHistoricalCandles lấy nến Binance (spot, nếu symbol không có trên spot thì futures) trong [start, end]
+func fetchHistoricalCandles(symbol string, start, end time.Time) ([]historicalCandle, error) {
+	interval := candleIntervals[len(candleIntervals)-1].name
+	for _, iv := range candleIntervals {
+		if end.Sub(start)/iv.duration <= 1000 {
+			interval = iv.name
+			break
+		}
+	}
+
+	isTestnet := false
+	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
+
+	params := url.Values{}
+	params.Set("symbol", strings.ToUpper(symbol))
+	params.Set("interval", interval)
+	params.Set("startTime", strconv.FormatInt(start.UnixMilli(), 10))
+	params.Set("endTime", strconv.FormatInt(end.UnixMilli(), 10))
+	params.Set("limit", "1000")
+
+	candles, status, err := getKlines(adapter.SpotAPIURL + "/api/v3/klines?" + params.Encode())
+	if status == http.StatusBadRequest {
+		candles, _, err = getKlines(adapter.FuturesAPIURL + "/fapi/v1/klines?" + params.Encode())
+	}
+	return candles, err
+}
+
+func getKlines(fullURL string) ([]historicalCandle, int, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(fullURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("klines failed (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	// Mỗi nến: [openTime, open, high, low, close, volume, closeTime, ...]
+	var raw [][]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse klines: %w", err)
+	}
+	candles := make([]historicalCandle, 0, len(raw))
+	for _, k := range raw {
+		if len(k) < 5 {
+			continue
+		}
+		openTime, _ := k[0].(float64)
+		str := func(v interface{}) decimal.Decimal {
+			s, _ := v.(string)
+			return ParseDecimal(s)
+		}
+		candles = append(candles, historicalCandle{
+			OpenTime: time.UnixMilli(int64(openTime)),
+			Open:     str(k[1]),
+			High:     str(k[2]),
+			Low:      str(k[3]),
+			Close:    str(k[4]),
+		})
+	}
+	return candles, resp.StatusCode, nil
+}
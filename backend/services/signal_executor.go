@@ -0,0 +1,1021 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Error codes của SignalExecutionResult (controller map sang HTTP status)
+const (
+	SignalErrInvalidAmount = "invalid_amount"
+	SignalErrInvalidAction = "invalid_action"
+	SignalErrNoPosition    = "no_position"
+	SignalErrOverride      = "override_rejected"
+	SignalErrCredentials   = "credentials"
+	SignalErrLocked        = "locked"
+	SignalErrDuplicate     = "duplicate"
+	SignalErrOrderFailed   = "order_failed"
+	SignalErrDatabase      = "db_error"
+	SignalErrStale         = "stale"
+	SignalErrFiltered      = "filtered" // Bị filter của bot chặn (khung giờ, cooldown, rate cap, khoảng cách giá, IP whitelist)
+)
+
+// terminalOrderStatuses are order statuses that no longer hold a position
+var terminalOrderStatuses = []string{"closed", "cancelled", "canceled", "failed", "expired", "rejected"}
+
+// SignalExecutionResult is the outcome of executing a signal with one bot config
+type SignalExecutionResult struct {
+	Success     bool               `json:"success"`
+	BotConfigID uint               `json:"bot_config_id"`
+	Action      string             `json:"action,omitempty"` // Loại action đã parse (entry, close_long, reverse, ...)
+	Order       *models.Order      `json:"order,omitempty"`  // Lệnh cuối cùng (với reverse là lệnh mở chiều mới)
+	Orders      []*models.Order    `json:"orders,omitempty"` // Tất cả lệnh đã ghi (reverse: lệnh đóng + lệnh mở)
+	UserSignal  *models.UserSignal `json:"user_signal,omitempty"`
+	Error       string             `json:"error,omitempty"`
+	ErrorCode   string             `json:"error_code,omitempty"`
+	Details     interface{}        `json:"details,omitempty"`
+	// IgnoredOverrides: override trong payload bị bỏ qua vì bot không bật allow_signal_overrides
+	IgnoredOverrides []string `json:"ignored_overrides,omitempty"`
+}
+
+// signalExecError là lỗi của một bước thực thi signal
+type signalExecError struct {
+	Code    string
+	Message string
+	Details interface{}
+}
+
+// signalRun gom state của một lần thực thi signal với một bot config
+type signalRun struct {
+	db     *gorm.DB
+	ts     *TradingService
+	signal *models.TradingSignal
+	config *models.TradingConfig
+	action SignalAction
+	// overrides là size/order type từ payload (đã qua kiểm tra giới hạn của bot)
+	overrides PayloadOverrides
+}
+
+// ExecuteSignalForBot thực thi 1 signal bằng 1 bot config (mở, đóng, đảo chiều hoặc đóng một phần vị thế)
+// và ghi Order + UserSignal. Dùng chung cho execute thủ công (POST /signals/:id/execute) và auto-execute.
+func ExecuteSignalForBot(db *gorm.DB, signal *models.TradingSignal, config *models.TradingConfig, source string) SignalExecutionResult {
+	result := SignalExecutionResult{BotConfigID: config.ID}
+
+	fail := func(code, msg string, details interface{}) SignalExecutionResult {
+		result.ErrorCode = code
+		result.Error = msg
+		result.Details = details
+		switch code {
+		case SignalErrDuplicate, SignalErrLocked:
+			// Lệnh trùng: không ghi đè record đã executed
+		case SignalErrNoPosition:
+			// Không có vị thế để đóng → bỏ qua, không phải lỗi
+			result.UserSignal = recordUserSignal(db, config.UserID, signal.ID, "ignored", &config.ID, nil, msg)
+		case SignalErrFiltered:
+			result.UserSignal = recordUserSignal(db, config.UserID, signal.ID, "ignored", &config.ID, nil, msg)
+			if block, ok := details.(*SignalFilterBlock); ok && result.UserSignal != nil && result.UserSignal.Status == "ignored" {
+				result.UserSignal.BlockedBy = block.Filter
+				db.Model(result.UserSignal).Update("blocked_by", block.Filter)
+			}
+		default:
+			result.UserSignal = recordUserSignal(db, config.UserID, signal.ID, "failed", &config.ID, nil, msg)
+		}
+		return result
+	}
+
+	// Signal bị từ chối lúc nhận hoặc đã quá cũ → không vào lệnh
+	if signal.RejectReason != "" {
+		return fail(SignalErrStale, "Signal was rejected: "+signal.RejectReason, nil)
+	}
+	if err := CheckSignalPayloadAge(signal, time.Now()); err != nil {
+		return fail(SignalErrStale, err.Error(), nil)
+	}
+	// IP whitelist của bot: chỉ áp dụng cho signal đến từ webhook (có SourceIP)
+	if config.IPWhitelist != "" && signal.SourceIP != "" && !IsIPAllowed(config.IPWhitelist, signal.SourceIP) {
+		block := &SignalFilterBlock{Filter: "ip_whitelist", Reason: fmt.Sprintf("source IP %s is not in the bot's IP whitelist", signal.SourceIP)}
+		return fail(SignalErrFiltered, block.Error(), block)
+	}
+
+	action, err := ParseSignalAction(signal.Action, signal.ClosePercent)
+	if err != nil {
+		return fail(SignalErrInvalidAction, err.Error(), nil)
+	}
+	result.Action = action.Kind
+	if action.Kind == SignalActionReverse && config.TradingMode != "futures" {
+		return fail(SignalErrInvalidAction, "Reverse is only supported in futures mode", nil)
+	}
+
+	// Override từ payload chỉ áp dụng khi bot cho phép và phải nằm trong giới hạn của bot
+	var overrides PayloadOverrides
+	if action.OpensPosition() {
+		parsed, err := ParsePayloadOverrides(signal.Overrides)
+		if err != nil {
+			return fail(SignalErrOverride, err.Error(), nil)
+		}
+		if !parsed.IsEmpty() {
+			if !config.AllowSignalOverrides {
+				result.IgnoredOverrides = parsed.Fields()
+				utils.LogWarn(fmt.Sprintf("⚠️  Signal %d: bot %d does not allow signal overrides, ignored %s and used bot config",
+					signal.ID, config.ID, strings.Join(result.IgnoredOverrides, ", ")))
+			} else if err := parsed.CheckBounds(config, signal.Price); err != nil {
+				return fail(SignalErrOverride, "Signal overrides rejected: "+err.Error(), nil)
+			} else {
+				overrides = parsed
+				overridden := overrides.ApplyTo(*config)
+				config = &overridden
+			}
+		}
+	}
+
+	// Use config amount (trừ khi payload gửi size)
+	if action.OpensPosition() && !overrides.HasSize() && !decimal.NewFromFloat(config.Amount).IsPositive() {
+		return fail(SignalErrInvalidAmount, "Bot config amount must be greater than 0", nil)
+	}
+
+	apiKey, err := utils.DecryptString(config.APIKey)
+	if err != nil {
+		log.Printf("Failed to decrypt API key for config %d: %v", config.ID, err)
+		return fail(SignalErrCredentials, "Failed to decrypt API credentials", nil)
+	}
+	apiSecret, err := utils.DecryptString(config.APISecret)
+	if err != nil {
+		log.Printf("Failed to decrypt API secret for config %d: %v", config.ID, err)
+		return fail(SignalErrCredentials, "Failed to decrypt API credentials", nil)
+	}
+
+	// Lock theo (API key, symbol) để không chạy song song với lệnh khác cùng symbol (pre-cleanup sẽ đóng vị thế)
+	lockKey := ExecutionLockKey(config.Exchange, apiKey, signal.Symbol)
+	release, err := ExecutionLocks().Acquire(lockKey, fmt.Sprintf("%s:signal:%d", source, signal.ID), DefaultLockWaitTimeout)
+	if err != nil {
+		utils.LogWarn(fmt.Sprintf("⏳ Signal %d / bot %d: %v", signal.ID, config.ID, err))
+		return fail(SignalErrLocked, "Another order operation is in progress for this symbol, please retry", nil)
+	}
+	defer release()
+
+	// Filter của bot chỉ chặn lệnh mở vị thế (tín hiệu đóng luôn được thực thi).
+	// Xét trong lock: 2 signal song song cùng bot/symbol không thể cùng vượt qua cooldown / rate cap.
+	if action.OpensPosition() {
+		block, err := EvaluateSignalFilters(db, config, signal, time.Now(), func() (decimal.Decimal, error) {
+			price, err := NewTradingService("", "", config.Exchange, db, config.UserID).GetCurrentPrice(config, signal.Symbol)
+			return price, err
+		})
+		if err != nil {
+			return fail(SignalErrDatabase, "Failed to evaluate signal filters: "+err.Error(), nil)
+		}
+		if block != nil {
+			utils.LogInfo(fmt.Sprintf("🚫 Signal %d skipped for bot %d: %s", signal.ID, config.ID, block.Error()))
+			return fail(SignalErrFiltered, block.Error(), block)
+		}
+	}
+
+	run := &signalRun{
+		db:        db,
+		ts:        NewTradingService(apiKey, apiSecret, config.Exchange, db, config.UserID),
+		signal:    signal,
+		config:    config,
+		action:    action,
+		overrides: overrides,
+	}
+
+	var order *models.Order
+	var execErr *signalExecError
+	switch action.Kind {
+	case SignalActionEntry:
+		order, execErr = run.openPosition(action.Side)
+		if order != nil {
+			result.Orders = append(result.Orders, order)
+		}
+
+	case SignalActionReverse:
+		// Retry sau khi bước đóng đã xong nhưng mở chiều mới lỗi → coi bước đóng là hoàn tất, chỉ mở lại.
+		// Duplicate chỉ trả về khi lệnh mở (client ID của entry) đã tồn tại.
+		closeOrder, closed := run.ts.findExistingOrderByClientID(run.clientOrderID("close"))
+		positionSide := ""
+		if closed {
+			positionSide = closeOrder.PositionSide
+			utils.LogInfo(fmt.Sprintf("♻️  Signal %d / bot %d: close step already done (order %d), opening reverse entry",
+				signal.ID, config.ID, closeOrder.ID))
+		} else {
+			var closeErr *signalExecError
+			closeOrder, positionSide, closeErr = run.closePosition("", action.Percent)
+			if closeErr != nil {
+				return fail(closeErr.Code, closeErr.Message, closeErr.Details)
+			}
+		}
+		result.Orders = append(result.Orders, closeOrder)
+
+		side := "buy"
+		if positionSide == "LONG" {
+			side = "sell"
+		}
+		order, execErr = run.openPosition(side)
+		if execErr != nil {
+			// Vị thế cũ đã đóng nhưng chưa mở được chiều mới
+			execErr.Message = "Position closed but reverse entry failed: " + execErr.Message
+		} else {
+			result.Orders = append(result.Orders, order)
+		}
+
+	default:
+		positionFilter := ""
+		switch action.Kind {
+		case SignalActionCloseLong:
+			positionFilter = "LONG"
+		case SignalActionCloseShort:
+			positionFilter = "SHORT"
+		}
+		order, _, execErr = run.closePosition(positionFilter, action.Percent)
+		if order != nil {
+			result.Orders = append(result.Orders, order)
+		}
+	}
+
+	if execErr != nil {
+		return fail(execErr.Code, execErr.Message, execErr.Details)
+	}
+
+	result.Success = true
+	result.Order = order
+	result.UserSignal = recordUserSignal(db, config.UserID, signal.ID, "executed", &config.ID, &order.ID, "")
+	if len(result.IgnoredOverrides) > 0 && result.UserSignal != nil {
+		result.UserSignal.IgnoredOverrides = strings.Join(result.IgnoredOverrides, ",")
+		db.Model(result.UserSignal).Update("ignored_overrides", result.UserSignal.IgnoredOverrides)
+	}
+
+	utils.LogInfo(fmt.Sprintf("✅ Signal %d executed (%s, %s) by user %d with bot %d, Order ID: %d",
+		signal.ID, source, action.Kind, config.UserID, config.ID, order.ID))
+	return result
+}
+
+// clientOrderID sinh client order ID cố định theo (user, bot, signal[, bước]) → webhook/click gửi lại không đặt lệnh 2 lần
+func (r *signalRun) clientOrderID(step string) string {
+	ref := strconv.FormatUint(uint64(r.signal.ID), 10)
+	if step != "" {
+		ref += ":" + step
+	}
+	return GenerateClientOrderID(r.config.UserID, r.config.ID, OrderSourceSignal, ref)
+}
+
+// openPosition đặt lệnh market mở vị thế và ghi Order
+func (r *signalRun) openPosition(side string) (*models.Order, *signalExecError) {
+	signal, config := r.signal, r.config
+
+	// Mặc định market order (signal price chỉ dùng để tham khảo); payload có thể yêu cầu limit
+	orderType := "market"
+	price := decimal.Zero
+	if r.overrides.OrderType == "limit" {
+		orderType = "limit"
+		price = signal.Price
+		if r.overrides.LimitPrice != nil {
+			price = *r.overrides.LimitPrice
+		}
+		if !price.IsPositive() {
+			return nil, &signalExecError{SignalErrOverride, "Limit order requires limitPrice or price in the signal", nil}
+		}
+		price = ParseDecimal(r.ts.FormatPriceByTickSize(config.TradingMode, signal.Symbol, price))
+	}
+
+	amount, execErr := r.orderAmount(price)
+	if execErr != nil {
+		return nil, execErr
+	}
+	clientOrderID := r.clientOrderID("")
+
+	utils.LogInfo(fmt.Sprintf("🔍 DEBUG PlaceOrder params: side=%s, orderType=%s, symbol=%s, amount=%s, price=%s",
+		side, orderType, signal.Symbol, amount, price))
+
+	orderResult := r.ts.PlaceOrder(config, side, orderType, signal.Symbol, amount, price, clientOrderID)
+
+	if orderResult.Status == "duplicate" {
+		utils.LogWarn(fmt.Sprintf("⛔ Signal %d already placed with bot config %d: %s", signal.ID, config.ID, orderResult.Error))
+		return nil, &signalExecError{SignalErrDuplicate, "Order for this signal was already placed", orderResult.ErrorDetails}
+	}
+
+	if !orderResult.Success {
+		utils.LogError(fmt.Sprintf("❌ Failed to execute signal %d with bot %d: %v", signal.ID, config.ID, orderResult.Error))
+		return nil, &signalExecError{SignalErrOrderFailed, orderResult.Error, orderResult.ErrorDetails}
+	}
+
+	// Calculate SL/TP prices: ưu tiên SL/TP từ signal, nếu không có thì dùng % của bot
+	var stopLoss, takeProfit decimal.Decimal
+	filledPrice := orderResult.FilledPrice
+	if filledPrice.IsPositive() {
+		if signal.StopLoss.IsPositive() {
+			stopLoss = signal.StopLoss
+		} else {
+			stopLoss = CalculateStopLossPrice(side, filledPrice, config.StopLossPercent)
+		}
+
+		if signal.TakeProfit.IsPositive() {
+			takeProfit = signal.TakeProfit
+		} else {
+			takeProfit = CalculateTakeProfitPrice(side, filledPrice, config.TakeProfitPercent)
+		}
+	}
+
+	// Create order record using Algo IDs from orderResult
+	signalID := signal.ID
+	order := models.Order{
+		UserID:           config.UserID,
+		BotConfigID:      config.ID,
+		SignalID:         &signalID,
+		Exchange:         config.Exchange,
+		Symbol:           orderResult.Symbol,
+		OrderID:          orderResult.OrderID, // Exchange order ID
+		ClientOrderID:    clientOrderID,
+		Side:             orderResult.Side,
+		Type:             orderResult.Type,
+		Quantity:         orderResult.Quantity,
+		Price:            orderResult.Price,
+		FilledPrice:      orderResult.FilledPrice,
+		Status:           NormalizeOrderStatus(orderResult.Status),
+		TradingMode:      config.TradingMode,
+		Leverage:         config.Leverage,
+		StopLossPrice:    stopLoss,
+		TakeProfitPrice:  takeProfit,
+		AlgoIDStopLoss:   orderResult.AlgoIDStopLoss,
+		AlgoIDTakeProfit: orderResult.AlgoIDTakeProfit,
+	}
+
+	if err := r.db.Create(&order).Error; err != nil {
+		return nil, &signalExecError{SignalErrDatabase, fmt.Sprintf("Failed to create order record: %v", err), nil}
+	}
+	RecordOrderCreated(r.db, &order, OrderSourceSignal, OrderActorBot(config.ID), orderResult)
+	if _, err := LinkEntryOrder(r.db, &order); err != nil {
+		log.Printf("⚠️  Order %d: failed to link position: %v", order.ID, err)
+	}
+	return &order, nil
+}
+
+// orderAmount trả về khối lượng lệnh mở: quantity/quoteSize từ payload hoặc amount của bot
+func (r *signalRun) orderAmount(limitPrice decimal.Decimal) (decimal.Decimal, *signalExecError) {
+	config, o := r.config, r.overrides
+
+	var amount decimal.Decimal
+	switch {
+	case o.Quantity != nil:
+		amount = *o.Quantity
+	case o.QuoteSize != nil:
+		refPrice := limitPrice
+		if !refPrice.IsPositive() {
+			refPrice = r.signal.Price
+		}
+		if !refPrice.IsPositive() {
+			current, err := r.ts.GetCurrentPrice(config, r.signal.Symbol)
+			if err != nil {
+				return decimal.Zero, &signalExecError{SignalErrOrderFailed, fmt.Sprintf("Failed to get price for quoteSize: %v", err), nil}
+			}
+			refPrice = current
+		}
+
+		mode := "spot"
+		if config.TradingMode == "futures" {
+			mode = "futures"
+		}
+		lot, err := r.ts.getSymbolLotInfo(mode, r.signal.Symbol)
+		if err != nil {
+			return decimal.Zero, &signalExecError{SignalErrOrderFailed, err.Error(), nil}
+		}
+		amount = floorToStep(o.QuoteSize.Div(refPrice), lot.StepSize)
+	default:
+		return decimal.NewFromFloat(config.Amount), nil
+	}
+
+	if !amount.IsPositive() {
+		return decimal.Zero, &signalExecError{SignalErrInvalidAmount, "Order size from signal rounds to zero", nil}
+	}
+	if config.SignalMaxQuantity > 0 && amount.GreaterThan(decimal.NewFromFloat(config.SignalMaxQuantity)) {
+		return decimal.Zero, &signalExecError{SignalErrOverride,
+			fmt.Sprintf("Signal overrides rejected: quantity %s exceeds bot limit %v", amount, config.SignalMaxQuantity), nil}
+	}
+	return amount, nil
+}
+
+// closePosition đóng percent% vị thế của bot (positionFilter LONG/SHORT, rỗng = chiều đang mở).
+// Trả về lệnh đóng đã ghi và chiều của vị thế vừa đóng.
+func (r *signalRun) closePosition(positionFilter string, percent decimal.Decimal) (*models.Order, string, *signalExecError) {
+	clientOrderID := r.clientOrderID("close")
+	if existing, found := r.ts.findExistingOrderByClientID(clientOrderID); found {
+		return nil, "", &signalExecError{SignalErrDuplicate, "Close order for this signal was already placed", duplicateOrderResult(existing).ErrorDetails}
+	}
+
+	if r.config.TradingMode == "futures" {
+		return r.closeFuturesPosition(positionFilter, percent, clientOrderID)
+	}
+	return r.closeSpotPosition(positionFilter, percent, clientOrderID)
+}
+
+// closeFuturesPosition đóng vị thế futures: đóng hết qua CloseFuturesPositionMarket + huỷ SL/TP còn treo,
+// đóng một phần qua lệnh reduceOnly
+func (r *signalRun) closeFuturesPosition(positionFilter string, percent decimal.Decimal, clientOrderID string) (*models.Order, string, *signalExecError) {
+	symbol := r.signal.Symbol
+	full := !percent.LessThan(hundred)
+
+	pos, err := r.ts.getFuturesPositionInfo(r.config, symbol)
+	if err != nil {
+		return nil, "", &signalExecError{SignalErrOrderFailed, fmt.Sprintf("Failed to query position: %v", err), nil}
+	}
+	if pos.Quantity.IsZero() || (positionFilter != "" && pos.Side != positionFilter) {
+		if r.action.Kind == SignalActionCloseAll {
+			// Không còn vị thế nhưng vẫn dọn lệnh chờ của symbol
+			if err := r.ts.CancelAllOrdersAndPosition(r.config, symbol); err != nil {
+				utils.LogWarn(fmt.Sprintf("⚠️  Failed to cancel open orders for %s: %v", symbol, err))
+			}
+		}
+		return nil, "", noPositionError(positionFilter)
+	}
+
+	var res OrderResult
+	if full {
+		res = r.ts.CloseFuturesPositionMarket(r.config, symbol)
+		if res.Success {
+			// Huỷ SL/TP và trailing stop của vị thế vừa đóng
+			if err := r.ts.CancelAllOrdersAndPosition(r.config, symbol); err != nil {
+				utils.LogWarn(fmt.Sprintf("⚠️  Failed to cancel open orders for %s: %v", symbol, err))
+			}
+		}
+	} else {
+		res = r.ts.ReduceFuturesPositionMarket(r.config, symbol, percent, clientOrderID)
+	}
+	if !res.Success {
+		if res.Error == "no-position" {
+			return nil, "", noPositionError(positionFilter)
+		}
+		return nil, "", &signalExecError{SignalErrOrderFailed, res.Error, res.ErrorDetails}
+	}
+
+	exitPrice := res.FilledPrice
+	if !exitPrice.IsPositive() {
+		if mark, err := r.ts.GetMarkPrice(symbol); err == nil {
+			exitPrice = mark
+		}
+	}
+
+	order, execErr := r.recordClose(pos.Side, res, exitPrice, clientOrderID, full)
+	return order, pos.Side, execErr
+}
+
+// closeSpotPosition bán lượng coin bot đang giữ (theo các lệnh BUY đã khớp, không vượt quá số dư free)
+func (r *signalRun) closeSpotPosition(positionFilter string, percent decimal.Decimal, clientOrderID string) (*models.Order, string, *signalExecError) {
+	symbol := r.signal.Symbol
+
+	// Spot không có vị thế short
+	if positionFilter == "SHORT" {
+		return nil, "", noPositionError(positionFilter)
+	}
+
+	_, held, _ := r.openEntries("LONG")
+	if !held.IsPositive() {
+		return nil, "", noPositionError(positionFilter)
+	}
+
+	lot, err := r.ts.getSymbolLotInfo("spot", symbol)
+	if err != nil {
+		return nil, "", &signalExecError{SignalErrOrderFailed, err.Error(), nil}
+	}
+	free, err := r.ts.GetSpotFreeBalance(lot.BaseAsset)
+	if err != nil {
+		return nil, "", &signalExecError{SignalErrOrderFailed, fmt.Sprintf("Failed to query %s balance: %v", lot.BaseAsset, err), nil}
+	}
+
+	// Phí có thể bị trừ vào base asset → không bán quá số dư thực có
+	quantity := floorToStep(decimal.Min(held, free).Mul(percent).Div(hundred), lot.StepSize)
+	if !quantity.IsPositive() {
+		return nil, "", &signalExecError{SignalErrOrderFailed,
+			fmt.Sprintf("Nothing to sell: holding %s %s (free %s, step %s)", held, lot.BaseAsset, free, lot.StepSize), nil}
+	}
+
+	res := r.ts.PlaceOrder(r.config, "sell", "market", symbol, quantity, decimal.Zero, clientOrderID)
+	if res.Status == "duplicate" {
+		return nil, "", &signalExecError{SignalErrDuplicate, "Close order for this signal was already placed", res.ErrorDetails}
+	}
+	if !res.Success {
+		return nil, "", &signalExecError{SignalErrOrderFailed, res.Error, res.ErrorDetails}
+	}
+
+	exitPrice := res.FilledPrice
+	if !exitPrice.IsPositive() {
+		if current, err := r.ts.GetCurrentPrice(r.config, symbol); err == nil {
+			exitPrice = current
+		}
+	}
+
+	order, execErr := r.recordClose("LONG", res, exitPrice, clientOrderID, !percent.LessThan(hundred))
+	return order, "LONG", execErr
+}
+
+// openEntries trả về các lệnh mở vị thế positionSide (LONG/SHORT) của bot còn hiệu lực,
+// tổng khối lượng và giá vào trung bình
+func (r *signalRun) openEntries(positionSide string) ([]models.Order, decimal.Decimal, decimal.Decimal) {
+	entrySide := "BUY"
+	if positionSide == "SHORT" {
+		entrySide = "SELL"
+	}
+
+	query := r.db.Where("bot_config_id = ? AND symbol = ? AND UPPER(side) = ? AND LOWER(status) NOT IN (?)",
+		r.config.ID, r.signal.Symbol, entrySide, terminalOrderStatuses)
+	if r.config.TradingMode != "futures" {
+		// Spot: chỉ lệnh đã khớp mới là coin đang giữ
+		query = query.Where("LOWER(status) IN (?)", []string{"filled", "partially_filled"})
+	}
+
+	var entries []models.Order
+	if err := query.Find(&entries).Error; err != nil {
+		utils.LogError(fmt.Sprintf("❌ Failed to load open entries of bot %d: %v", r.config.ID, err))
+		return nil, decimal.Zero, decimal.Zero
+	}
+
+	quantity, notional := decimal.Zero, decimal.Zero
+	for _, entry := range entries {
+		qty := entry.FilledQuantity
+		if !qty.IsPositive() {
+			qty = entry.Quantity
+		}
+		price := entry.FilledPrice
+		if !price.IsPositive() {
+			price = entry.Price
+		}
+		quantity = quantity.Add(qty)
+		notional = notional.Add(price.Mul(qty))
+	}
+
+	avgEntry := decimal.Zero
+	if quantity.IsPositive() {
+		avgEntry = notional.Div(quantity)
+	}
+	return entries, quantity, avgEntry
+}
+
+// recordClose ghi lệnh đóng (kèm PnL của phần đã đóng); đóng hết thì chuyển các lệnh mở sang "closed"
+func (r *signalRun) recordClose(positionSide string, res OrderResult, exitPrice decimal.Decimal, clientOrderID string, full bool) (*models.Order, *signalExecError) {
+	entries, _, avgEntry := r.openEntries(positionSide)
+
+	entrySide, closeSide := "buy", "SELL"
+	if positionSide == "SHORT" {
+		entrySide, closeSide = "sell", "BUY"
+	}
+	pnl, pnlPercent := CalculatePnL(entrySide, avgEntry, exitPrice, res.Quantity)
+
+	side := strings.ToUpper(res.Side)
+	if side == "" {
+		side = closeSide
+	}
+	status := "closed"
+	if r.config.TradingMode != "futures" && !strings.EqualFold(res.Status, "filled") {
+		// Spot chưa khớp hết → để OrderMonitor theo dõi tiếp
+		status = NormalizeOrderStatus(res.Status)
+	}
+
+	signalID := r.signal.ID
+	order := models.Order{
+		UserID:         r.config.UserID,
+		BotConfigID:    r.config.ID,
+		SignalID:       &signalID,
+		Exchange:       r.config.Exchange,
+		Symbol:         r.signal.Symbol,
+		OrderID:        res.OrderID,
+		ClientOrderID:  clientOrderID,
+		Side:           side,
+		Type:           "MARKET",
+		Quantity:       res.Quantity,
+		Price:          res.Price,
+		FilledPrice:    exitPrice,
+		FilledQuantity: res.Quantity,
+		CurrentPrice:   exitPrice,
+		Status:         status,
+		TradingMode:    r.config.TradingMode,
+		Leverage:       r.config.Leverage,
+		PositionSide:   positionSide,
+		PnL:            pnl,
+		PnLPercent:     pnlPercent,
+	}
+	if err := r.db.Create(&order).Error; err != nil {
+		return nil, &signalExecError{SignalErrDatabase, fmt.Sprintf("Failed to create order record: %v", err), nil}
+	}
+	RecordOrderCreated(r.db, &order, OrderSourceSignal, OrderActorBot(r.config.ID), res)
+
+	if full && len(entries) > 0 {
+		ids := make([]uint, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if _, err := ApplyOrderChanges(r.db, ids, OrderChange{
+			Status: OrderStatusClosed,
+			Updates: map[string]interface{}{
+				"current_price": exitPrice,
+				"exit_price":    exitPrice,
+				"exit_reason":   ExitReasonSignal,
+				"closed_at":     time.Now(),
+			},
+			Source:  OrderSourceSignal,
+			Actor:   OrderActorBot(r.config.ID),
+			Message: fmt.Sprintf("closed by signal %d (order %d)", r.signal.ID, order.ID),
+		}); err != nil {
+			utils.LogError(fmt.Sprintf("❌ Failed to mark entries of bot %d as closed: %v", r.config.ID, err))
+		} else if r.config.TradingMode == "futures" {
+			// Realized PnL / phí / funding thực tế lấy từ userTrades + income sau khi lệnh đóng khớp xong
+			SettleClosedFuturesOrders(r.db, ids)
+		}
+	}
+
+	// Vị thế futures: ghi phần đã đóng, gắn lệnh đóng vào vị thế
+	reduced := map[uint]bool{}
+	for _, entry := range entries {
+		if entry.PositionID == nil || reduced[*entry.PositionID] {
+			continue
+		}
+		reduced[*entry.PositionID] = true
+		if err := ReducePosition(r.db, *entry.PositionID, &order, res.Quantity, exitPrice, full, ExitReasonSignal); err != nil {
+			utils.LogError(fmt.Sprintf("❌ Failed to update position %d: %v", *entry.PositionID, err))
+		}
+	}
+	return &order, nil
+}
+
+// noPositionError là kết quả khi không có vị thế phù hợp để đóng
+func noPositionError(positionFilter string) *signalExecError {
+	msg := "No open position to close"
+	if positionFilter != "" {
+		msg = fmt.Sprintf("No open %s position to close", strings.ToLower(positionFilter))
+	}
+	return &signalExecError{Code: SignalErrNoPosition, Message: msg}
+}
+
+// recordUserSignal tạo hoặc cập nhật trạng thái thực thi signal của 1 bot (unique theo user_id + signal_id + bot_key).
+// Không ghi đè record đã "executed" bằng kết quả thất bại của lần chạy sau.
+func recordUserSignal(db *gorm.DB, userID, signalID uint, status string, botConfigID, orderID *uint, errorMsg string) *models.UserSignal {
+	now := time.Now()
+	botKey := uint(0)
+	if botConfigID != nil {
+		botKey = *botConfigID
+	}
+
+	userSignal := models.UserSignal{
+		UserID:      userID,
+		SignalID:    signalID,
+		Status:      status,
+		BotConfigID: botConfigID,
+		BotKey:      botKey,
+		OrderID:     orderID,
+		ExecutedAt:  &now,
+		ErrorMsg:    errorMsg,
+	}
+	// Tạo trước; record đã tồn tại (kể cả do lần chạy song song vừa tạo) → không lỗi, cập nhật record đó
+	result := db.Clauses(UserSignalConflict()).Create(&userSignal)
+	if result.Error != nil {
+		utils.LogError(fmt.Sprintf("❌ Failed to create UserSignal: %v", result.Error))
+		return nil
+	}
+	if result.RowsAffected > 0 {
+		return &userSignal
+	}
+
+	userSignal = models.UserSignal{}
+	if err := db.Where("user_id = ? AND signal_id = ? AND bot_key = ?", userID, signalID, botKey).First(&userSignal).Error; err != nil {
+		utils.LogError(fmt.Sprintf("❌ Failed to load UserSignal: %v", err))
+		return nil
+	}
+	if userSignal.Status == "executed" && status != "executed" {
+		return &userSignal
+	}
+	userSignal.Status = status
+	userSignal.OrderID = orderID
+	userSignal.ExecutedAt = &now
+	userSignal.ErrorMsg = errorMsg
+	if err := db.Save(&userSignal).Error; err != nil {
+		utils.LogError(fmt.Sprintf("❌ Failed to update UserSignal: %v", err))
+	}
+	return &userSignal
+}
+
+// UserSignalConflict bỏ qua insert trùng (user_id, signal_id, bot_key) thay vì trả lỗi duplicate key
+func UserSignalConflict() clause.OnConflict {
+	return clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "signal_id"}, {Name: "bot_key"}},
+		DoNothing: true,
+	}
+}
+
+// SignalExecutor tự động thực thi signal cho các bot bật auto-execute.
+// Mọi bước (fan-out, Telegram, thực thi từng bot) chạy qua SignalQueue nên không mất khi restart và được retry khi lỗi tạm thời.
+type SignalExecutor struct {
+	DB           *gorm.DB
+	WebSocketHub *WebSocketHub
+	Queue        *SignalQueue
+}
+
+// NewSignalExecutor creates the auto-execute executor; redisClient may be nil (queue chỉ dùng DB)
+func NewSignalExecutor(db *gorm.DB, wsHub *WebSocketHub, redisClient *redis.Client) *SignalExecutor {
+	e := &SignalExecutor{
+		DB:           db,
+		WebSocketHub: wsHub,
+		Queue:        NewSignalQueue(db, redisClient),
+	}
+	e.Queue.Handle(SignalJobFanout, e.handleFanout)
+	e.Queue.Handle(SignalJobTelegram, e.handleTelegram)
+	e.Queue.Handle(SignalJobExecute, e.handleExecute)
+	return e
+}
+
+// Start launches the queue workers
+func (e *SignalExecutor) Start() {
+	e.Queue.Start()
+}
+
+// Stop stops the queue workers
+func (e *SignalExecutor) Stop() {
+	e.Queue.Stop()
+}
+
+// Enqueue tạo job fan-out cho signal mới (không block webhook; job được lưu DB trước khi trả về)
+func (e *SignalExecutor) Enqueue(signalID uint) error {
+	_, err := e.Queue.Enqueue(&models.SignalJob{
+		Kind:     SignalJobFanout,
+		DedupKey: fmt.Sprintf("%s:%d", SignalJobFanout, signalID),
+		SignalID: signalID,
+	})
+	return err
+}
+
+// handleFanout tạo job Telegram và job execute cho tất cả bot khớp routing rules / auto-execute
+func (e *SignalExecutor) handleFanout(job *models.SignalJob) error {
+	var signal models.TradingSignal
+	if err := e.DB.First(&signal, job.SignalID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("signal %d not found", job.SignalID)
+		}
+		return Retryable(err)
+	}
+
+	if _, err := e.Queue.Enqueue(&models.SignalJob{
+		Kind:     SignalJobTelegram,
+		DedupKey: fmt.Sprintf("%s:%d", SignalJobTelegram, signal.ID),
+		SignalID: signal.ID,
+	}); err != nil {
+		return Retryable(err)
+	}
+
+	// Signal không có prefix → không xác định được user, không auto-execute
+	userID, err := FindPrefixOwner(e.DB, signal.WebhookPrefix)
+	if err != nil {
+		return Retryable(fmt.Errorf("failed to find owner of prefix %s: %w", signal.WebhookPrefix, err))
+	}
+	if userID == 0 {
+		return nil
+	}
+
+	targets, _, err := ResolveSignalTargets(e.DB, userID, &signal)
+	if err != nil {
+		return Retryable(fmt.Errorf("failed to resolve bots for signal %d: %w", signal.ID, err))
+	}
+	if len(targets) == 0 {
+		log.Printf("ℹ️  Auto-execute: no routed bots for signal %d (%s %s, prefix=%s)",
+			signal.ID, signal.Action, signal.Symbol, signal.WebhookPrefix)
+		return nil
+	}
+
+	log.Printf("🤖 Auto-execute: signal %d (%s %s) → %d bot(s)", signal.ID, signal.Action, signal.Symbol, len(targets))
+
+	for _, target := range targets {
+		if _, err := e.enqueueExecution(&signal, target.Config.UserID, target.Config.ID, target); err != nil {
+			// Job đã tạo sẽ không tạo lại (DedupKey) nên retry fan-out là an toàn
+			return Retryable(err)
+		}
+	}
+	return nil
+}
+
+// enqueueExecution tạo job thực thi signal với 1 bot (target được lưu kèm để giữ rule + override)
+func (e *SignalExecutor) enqueueExecution(signal *models.TradingSignal, userID, botConfigID uint, target SignalTarget) (*models.SignalJob, error) {
+	payload, err := json.Marshal(target)
+	if err != nil {
+		return nil, err
+	}
+	return e.Queue.Enqueue(&models.SignalJob{
+		Kind:        SignalJobExecute,
+		DedupKey:    fmt.Sprintf("%s:%d:%d", SignalJobExecute, signal.ID, botConfigID),
+		SignalID:    signal.ID,
+		UserID:      &userID,
+		BotConfigID: &botConfigID,
+		Payload:     string(payload),
+	})
+}
+
+// handleTelegram broadcast signal qua bot Telegram đang bật đầu tiên
+func (e *SignalExecutor) handleTelegram(job *models.SignalJob) error {
+	var signal models.TradingSignal
+	if err := e.DB.First(&signal, job.SignalID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("signal %d not found", job.SignalID)
+		}
+		return Retryable(err)
+	}
+
+	var telegramConfigs []models.TelegramConfig
+	if err := e.DB.Where("is_enabled = ? AND bot_token != ''", true).Find(&telegramConfigs).Error; err != nil {
+		return Retryable(fmt.Errorf("failed to query Telegram configs: %w", err))
+	}
+	if len(telegramConfigs) == 0 {
+		log.Printf("⚠️ No active Telegram bot configs found, skipping Telegram broadcast")
+		return nil
+	}
+
+	// Use the first active bot token (or implement logic to choose specific bot)
+	telegramService := NewTelegramService(e.DB)
+	if err := telegramService.BroadcastTestConnectionToAllUsers(telegramConfigs[0].BotToken, signal.Symbol, signal.Action); err != nil {
+		return Retryable(fmt.Errorf("failed to broadcast Telegram notification: %w", err))
+	}
+	log.Printf("✅ Telegram notification sent to all users with bot: %s", telegramConfigs[0].BotName)
+	return nil
+}
+
+// handleExecute thực thi signal với 1 bot; lỗi tạm thời (mạng, sàn quá tải, lock bận) được retry,
+// user chỉ nhận thông báo khi có kết quả cuối cùng
+func (e *SignalExecutor) handleExecute(job *models.SignalJob) error {
+	if job.UserID == nil || job.BotConfigID == nil {
+		return errors.New("execute job is missing user or bot config")
+	}
+
+	var target SignalTarget
+	if job.Payload != "" {
+		if err := json.Unmarshal([]byte(job.Payload), &target); err != nil {
+			return fmt.Errorf("invalid job payload: %w", err)
+		}
+	}
+
+	var signal models.TradingSignal
+	if err := e.DB.First(&signal, job.SignalID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("signal %d not found", job.SignalID)
+		}
+		return Retryable(err)
+	}
+
+	var botConfig models.TradingConfig
+	if err := e.DB.Where("id = ? AND user_id = ?", *job.BotConfigID, *job.UserID).First(&botConfig).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("bot config %d not found", *job.BotConfigID)
+		}
+		return Retryable(err)
+	}
+
+	config := target.Overrides.Apply(botConfig)
+	result := ExecuteSignalForBot(e.DB, &signal, &config, "auto")
+
+	if !result.Success && isTransientExecution(result) {
+		if job.Attempts < job.MaxAttempts {
+			return Retryable(errors.New(result.Error))
+		}
+		e.notifyExecution(&signal, &config, target, result)
+		return fmt.Errorf("giving up after %d attempts: %s", job.Attempts, result.Error)
+	}
+
+	e.notifyExecution(&signal, &config, target, result)
+	switch {
+	case result.Success, result.ErrorCode == SignalErrDuplicate, result.ErrorCode == SignalErrNoPosition, result.ErrorCode == SignalErrFiltered:
+		return nil
+	default:
+		// Lỗi không retry được (stale, override sai, credentials...) → dead-letter để admin xem / re-drive
+		return fmt.Errorf("%s: %s", result.ErrorCode, result.Error)
+	}
+}
+
+// transientOrderErrors là các dấu hiệu lỗi tạm thời trong thông báo lỗi của sàn / HTTP client
+var transientOrderErrors = []string{
+	"failed to send request", "failed to read response", "timeout", "status 5", "status 429",
+	"-1003", "-1006", "-1007", "-1021", "too many requests", "connection reset", "position query failed",
+}
+
+// isTransientExecution returns true if a failed execution is worth retrying
+func isTransientExecution(result SignalExecutionResult) bool {
+	switch result.ErrorCode {
+	case SignalErrLocked, SignalErrDatabase:
+		return true
+	case SignalErrOrderFailed:
+		msg := strings.ToLower(result.Error)
+		for _, marker := range transientOrderErrors {
+			if strings.Contains(msg, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RedriveExecution chạy lại signal với 1 bot của user (dùng cho UserSignal failed).
+// Job execute cũ được đặt lại từ đầu; nếu chưa có (vd. execute thủ công) thì tạo job mới.
+func (e *SignalExecutor) RedriveExecution(userID, signalID, botConfigID uint) (*models.SignalJob, error) {
+	var signal models.TradingSignal
+	if err := e.DB.First(&signal, signalID).Error; err != nil {
+		return nil, err
+	}
+	var botConfig models.TradingConfig
+	if err := e.DB.Where("id = ? AND user_id = ?", botConfigID, userID).First(&botConfig).Error; err != nil {
+		return nil, err
+	}
+
+	var job models.SignalJob
+	err := e.DB.Where("dedup_key = ?", fmt.Sprintf("%s:%d:%d", SignalJobExecute, signalID, botConfigID)).First(&job).Error
+	switch {
+	case err == nil:
+		if job.Status == SignalJobPending || job.Status == SignalJobRunning {
+			return &job, nil
+		}
+		if err := e.Queue.reset(&job); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		created, err := e.enqueueExecution(&signal, userID, botConfigID, SignalTarget{Source: "redrive"})
+		if err != nil {
+			return nil, err
+		}
+		job = *created
+	default:
+		return nil, err
+	}
+
+	e.DB.Model(&models.UserSignal{}).
+		Where("user_id = ? AND signal_id = ? AND bot_config_id = ? AND status = ?", userID, signalID, botConfigID, "failed").
+		Updates(map[string]interface{}{"status": "pending", "error_msg": ""})
+
+	utils.LogInfo(fmt.Sprintf("🔁 Re-drive signal %d with bot %d (user %d), job %d", signalID, botConfigID, userID, job.ID))
+	return &job, nil
+}
+
+// notifyExecution gửi kết quả auto-execute cho user qua WebSocket
+func (e *SignalExecutor) notifyExecution(signal *models.TradingSignal, config *models.TradingConfig, target SignalTarget, result SignalExecutionResult) {
+	if result.Success {
+		utils.CreateSystemLog(e.DB, config.UserID, utils.LogLevelSuccess, "SIGNAL_AUTO_EXECUTED",
+			fmt.Sprintf("Auto-executed signal #%d (%s %s) with bot %s", signal.ID, strings.ToUpper(signal.Action), signal.Symbol, config.Name),
+			map[string]interface{}{
+				"symbol":   signal.Symbol,
+				"exchange": strings.ToUpper(config.Exchange),
+				"order_id": result.Order.ID,
+			})
+	} else if block, ok := result.Details.(*SignalFilterBlock); ok && result.ErrorCode == SignalErrFiltered {
+		utils.CreateSystemLog(e.DB, config.UserID, utils.LogLevelInfo, "SIGNAL_FILTERED",
+			fmt.Sprintf("Signal #%d (%s %s) skipped by bot %s: %s", signal.ID, strings.ToUpper(signal.Action), signal.Symbol, config.Name, block.Reason),
+			map[string]interface{}{
+				"symbol":   signal.Symbol,
+				"exchange": strings.ToUpper(config.Exchange),
+				"filter":   block.Filter,
+			})
+	} else if result.ErrorCode != SignalErrDuplicate && result.ErrorCode != SignalErrNoPosition {
+		utils.CreateSystemLog(e.DB, config.UserID, utils.LogLevelError, "SIGNAL_AUTO_EXECUTE_FAILED",
+			fmt.Sprintf("Auto-execute of signal #%d with bot %s failed: %s", signal.ID, config.Name, result.Error),
+			map[string]interface{}{
+				"symbol":   signal.Symbol,
+				"exchange": strings.ToUpper(config.Exchange),
+				"details":  result.Details,
+			})
+	}
+
+	if e.WebSocketHub == nil {
+		return
+	}
+
+	data := map[string]interface{}{
+		"signal_id":     signal.ID,
+		"bot_config_id": config.ID,
+		"symbol":        signal.Symbol,
+		"action":        signal.Action,
+		"action_kind":   result.Action,
+		"success":       result.Success,
+		"source":        target.Source,
+		"timestamp":     time.Now().Unix(),
+	}
+	if target.RuleID != nil {
+		data["rule_id"] = *target.RuleID
+		data["rule_name"] = target.RuleName
+	}
+	if result.Order != nil {
+		data["order_id"] = result.Order.ID
+		data["status"] = result.Order.Status
+	}
+	if result.Error != "" {
+		data["error"] = result.Error
+	}
+	if block, ok := result.Details.(*SignalFilterBlock); ok {
+		data["blocked_by"] = block.Filter
+	}
+	if len(result.IgnoredOverrides) > 0 {
+		data["ignored_overrides"] = result.IgnoredOverrides
+	}
+
+	e.WebSocketHub.BroadcastToUser(config.UserID, WebSocketMessage{
+		Type: "signal_executed",
+		Data: data,
+	})
+}
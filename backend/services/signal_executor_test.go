@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+	"tradercoin/backend/models"
+)
+
+func TestRecordUserSignal(t *testing.T) {
+	bot1, bot2 := uint(1), uint(2)
+	type call struct {
+		status string
+		bot    *uint
+	}
+	tests := []struct {
+		name      string
+		calls     []call
+		wantRows  int64
+		wantFinal string // status của record ứng với call cuối
+	}{
+		{"one record per bot", []call{{"executed", &bot1}, {"executed", &bot2}}, 2, "executed"},
+		{"same bot updates its record", []call{{"failed", &bot1}, {"executed", &bot1}}, 1, "executed"},
+		{"executed is not overwritten by a failure", []call{{"executed", &bot1}, {"failed", &bot1}}, 1, "executed"},
+		{"user status without bot is deduped", []call{{"failed", nil}, {"ignored", nil}}, 1, "ignored"},
+		{"user status and bot execution are separate", []call{{"ignored", nil}, {"executed", &bot1}}, 2, "executed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			var last *models.UserSignal
+			for _, c := range tt.calls {
+				last = recordUserSignal(db, 1, 10, c.status, c.bot, nil, "")
+				if last == nil {
+					t.Fatalf("recordUserSignal(%s) returned nil", c.status)
+				}
+			}
+
+			var rows int64
+			db.Model(&models.UserSignal{}).Where("user_id = ? AND signal_id = ?", 1, 10).Count(&rows)
+			if rows != tt.wantRows {
+				t.Errorf("%d user signal row(s), want %d", rows, tt.wantRows)
+			}
+			var saved models.UserSignal
+			db.First(&saved, last.ID)
+			if saved.Status != tt.wantFinal {
+				t.Errorf("status = %q, want %q", saved.Status, tt.wantFinal)
+			}
+		})
+	}
+}
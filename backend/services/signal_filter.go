@@ -0,0 +1,263 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // Timezone của user phải load được cả khi máy chủ không có /usr/share/zoneinfo
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Tên filter chặn signal (ghi vào UserSignal.BlockedBy)
+const (
+	SignalFilterTradingDays      = "trading_days"
+	SignalFilterTradingHours     = "trading_hours"
+	SignalFilterTradeCooldown    = "trade_cooldown"
+	SignalFilterStopLossCooldown = "stop_loss_cooldown"
+	SignalFilterMaxPerHour       = "max_signals_per_hour"
+	SignalFilterMaxPerDay        = "max_signals_per_day"
+	SignalFilterMinPriceDistance = "min_price_distance"
+	SignalFilterMaxPriceDistance = "max_price_distance"
+)
+
+// SignalFilterBlock describes the filter that blocked a signal for a bot
+type SignalFilterBlock struct {
+	Filter string `json:"filter"`
+	Reason string `json:"reason"`
+}
+
+func (b *SignalFilterBlock) Error() string {
+	return fmt.Sprintf("blocked by %s: %s", b.Filter, b.Reason)
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// tradingWindow là khung giờ [Start, End) tính bằng phút trong ngày; End < Start là khung qua đêm (22:00-02:00)
+type tradingWindow struct {
+	Start int
+	End   int
+}
+
+func (w tradingWindow) contains(minute int) bool {
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// ParseTradingDays parses "mon,tue,wed" (rỗng = mọi ngày)
+func ParseTradingDays(raw string) (map[time.Weekday]bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	days := make(map[time.Weekday]bool)
+	for _, part := range strings.Split(raw, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		day, ok := weekdayNames[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid trading day %q", strings.TrimSpace(part))
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+// ParseTradingHours parses "08:00-12:00,22:00-02:00" (rỗng = cả ngày)
+func ParseTradingHours(raw string) ([]tradingWindow, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var windows []tradingWindow
+	for _, part := range strings.Split(raw, ",") {
+		bounds := strings.Split(strings.TrimSpace(part), "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid trading hours %q (expected HH:MM-HH:MM)", strings.TrimSpace(part))
+		}
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid trading hours %q (empty window)", strings.TrimSpace(part))
+		}
+		windows = append(windows, tradingWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+func parseClock(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LoadUserLocation returns the location of an IANA timezone name (rỗng = UTC)
+func LoadUserLocation(timezone string) (*time.Location, error) {
+	if strings.TrimSpace(timezone) == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(strings.TrimSpace(timezone))
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", timezone)
+	}
+	return loc, nil
+}
+
+// ValidateSignalFilters checks the filter settings of a bot config
+func ValidateSignalFilters(config *models.TradingConfig) error {
+	if _, err := ParseTradingDays(config.SignalTradingDays); err != nil {
+		return err
+	}
+	if _, err := ParseTradingHours(config.SignalTradingHours); err != nil {
+		return err
+	}
+	if config.SignalCooldownMinutes < 0 || config.SignalStopLossCooldownMinutes < 0 {
+		return errors.New("signal cooldowns must be greater than or equal to 0")
+	}
+	if config.SignalMaxPerHour < 0 || config.SignalMaxPerDay < 0 {
+		return errors.New("signal rate caps must be greater than or equal to 0")
+	}
+	if config.SignalMinPriceDistancePercent < 0 || config.SignalMaxPriceDistancePercent < 0 {
+		return errors.New("signal price distances must be greater than or equal to 0")
+	}
+	if config.SignalMaxPriceDistancePercent > 0 && config.SignalMinPriceDistancePercent > config.SignalMaxPriceDistancePercent {
+		return errors.New("signal_min_price_distance_percent must not exceed signal_max_price_distance_percent")
+	}
+	return nil
+}
+
+// EvaluateSignalFilters kiểm tra các filter của bot trước khi mở vị thế theo signal.
+// currentPrice chỉ được gọi khi bot cấu hình filter khoảng cách giá. Trả về nil nếu signal được phép.
+func EvaluateSignalFilters(db *gorm.DB, config *models.TradingConfig, signal *models.TradingSignal, now time.Time, currentPrice func() (decimal.Decimal, error)) (*SignalFilterBlock, error) {
+	if config.SignalTradingDays != "" || config.SignalTradingHours != "" {
+		var user models.User
+		if err := db.Select("id", "timezone").First(&user, config.UserID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load user timezone: %w", err)
+		}
+		loc, err := LoadUserLocation(user.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		local := now.In(loc)
+
+		days, err := ParseTradingDays(config.SignalTradingDays)
+		if err != nil {
+			return nil, err
+		}
+		if days != nil && !days[local.Weekday()] {
+			return &SignalFilterBlock{SignalFilterTradingDays,
+				fmt.Sprintf("%s is not a trading day (%s)", local.Weekday(), loc)}, nil
+		}
+
+		windows, err := ParseTradingHours(config.SignalTradingHours)
+		if err != nil {
+			return nil, err
+		}
+		if windows != nil {
+			minute := local.Hour()*60 + local.Minute()
+			allowed := false
+			for _, w := range windows {
+				if w.contains(minute) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return &SignalFilterBlock{SignalFilterTradingHours,
+					fmt.Sprintf("%s is outside trading hours %s (%s)", local.Format("15:04"), config.SignalTradingHours, loc)}, nil
+			}
+		}
+	}
+
+	if config.SignalCooldownMinutes > 0 {
+		since := now.Add(-time.Duration(config.SignalCooldownMinutes) * time.Minute)
+		var last models.Order
+		err := db.Where("bot_config_id = ? AND symbol = ? AND created_at > ?", config.ID, signal.Symbol, since).
+			Order("created_at DESC").First(&last).Error
+		if err == nil {
+			return &SignalFilterBlock{SignalFilterTradeCooldown,
+				fmt.Sprintf("last %s trade at %s, cooldown %d min", signal.Symbol, last.CreatedAt.Format(time.RFC3339), config.SignalCooldownMinutes)}, nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if config.SignalStopLossCooldownMinutes > 0 {
+		// Vị thế được coi là dính stop-loss khi đóng với PnL âm
+		since := now.Add(-time.Duration(config.SignalStopLossCooldownMinutes) * time.Minute)
+		var last models.Order
+		err := db.Where("bot_config_id = ? AND symbol = ? AND status = ? AND pn_l < 0 AND updated_at > ?", config.ID, signal.Symbol, "closed", since).
+			Order("updated_at DESC").First(&last).Error
+		if err == nil {
+			return &SignalFilterBlock{SignalFilterStopLossCooldown,
+				fmt.Sprintf("%s position closed at a loss at %s, cooldown %d min", signal.Symbol, last.UpdatedAt.Format(time.RFC3339), config.SignalStopLossCooldownMinutes)}, nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	caps := []struct {
+		filter string
+		limit  int
+		window time.Duration
+		label  string
+	}{
+		{SignalFilterMaxPerHour, config.SignalMaxPerHour, time.Hour, "hour"},
+		{SignalFilterMaxPerDay, config.SignalMaxPerDay, 24 * time.Hour, "24h"},
+	}
+	for _, rateCap := range caps {
+		if rateCap.limit <= 0 {
+			continue
+		}
+		var count int64
+		if err := db.Model(&models.UserSignal{}).
+			Where("bot_config_id = ? AND status = ? AND executed_at > ?", config.ID, "executed", now.Add(-rateCap.window)).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count >= int64(rateCap.limit) {
+			return &SignalFilterBlock{rateCap.filter,
+				fmt.Sprintf("%d signals executed in the last %s (max %d)", count, rateCap.label, rateCap.limit)}, nil
+		}
+	}
+
+	if (config.SignalMinPriceDistancePercent > 0 || config.SignalMaxPriceDistancePercent > 0) && signal.Price.IsPositive() {
+		price, err := currentPrice()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current price: %w", err)
+		}
+		if price.IsPositive() {
+			distance := signal.Price.Sub(price).Abs().Div(price).Mul(hundred)
+			if minDistance := decimal.NewFromFloat(config.SignalMinPriceDistancePercent); minDistance.IsPositive() && distance.LessThan(minDistance) {
+				return &SignalFilterBlock{SignalFilterMinPriceDistance,
+					fmt.Sprintf("signal price %s is %s%% from current price %s (min %s%%)", signal.Price, distance.Round(4), price, minDistance)}, nil
+			}
+			if maxDistance := decimal.NewFromFloat(config.SignalMaxPriceDistancePercent); maxDistance.IsPositive() && distance.GreaterThan(maxDistance) {
+				return &SignalFilterBlock{SignalFilterMaxPriceDistance,
+					fmt.Sprintf("signal price %s is %s%% from current price %s (max %s%%)", signal.Price, distance.Round(4), price, maxDistance)}, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
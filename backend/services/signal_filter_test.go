@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+func TestParseTradingHours(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []tradingWindow
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"blank", "  ", nil, false},
+		{"single window", "08:00-12:00", []tradingWindow{{8 * 60, 12 * 60}}, false},
+		{"overnight window", "22:00-02:00", []tradingWindow{{22 * 60, 2 * 60}}, false},
+		{"until midnight", "18:30-24:00", []tradingWindow{{18*60 + 30, 24 * 60}}, false},
+		{"two windows with spaces", "08:00-12:00, 13:15 - 17:45", []tradingWindow{{8 * 60, 12 * 60}, {13*60 + 15, 17*60 + 45}}, false},
+		{"empty window", "08:00-08:00", nil, true},
+		{"missing end", "08:00", nil, true},
+		{"hour only", "8-12", nil, true},
+		{"invalid hour", "25:00-26:00", nil, true},
+		{"trailing comma", "08:00-12:00,", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTradingHours(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTradingHours(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseTradingHours(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ParseTradingHours(%q)[%d] = %v, want %v", tt.raw, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTradingWindowContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window tradingWindow
+		minute int
+		want   bool
+	}{
+		{"inside", tradingWindow{8 * 60, 12 * 60}, 10 * 60, true},
+		{"start is inclusive", tradingWindow{8 * 60, 12 * 60}, 8 * 60, true},
+		{"end is exclusive", tradingWindow{8 * 60, 12 * 60}, 12 * 60, false},
+		{"before", tradingWindow{8 * 60, 12 * 60}, 7 * 60, false},
+		{"overnight late", tradingWindow{22 * 60, 2 * 60}, 23 * 60, true},
+		{"overnight early", tradingWindow{22 * 60, 2 * 60}, 60, true},
+		{"overnight midday", tradingWindow{22 * 60, 2 * 60}, 12 * 60, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.contains(tt.minute); got != tt.want {
+				t.Errorf("%v.contains(%d) = %v, want %v", tt.window, tt.minute, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSignalFilters(t *testing.T) {
+	// Thứ Tư 10:30 UTC (17:30 ở Asia/Ho_Chi_Minh)
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+
+	order := func(symbol, status string, pnl float64, at time.Time) models.Order {
+		return models.Order{
+			UserID: 1, BotConfigID: 1, Exchange: "binance", Symbol: symbol, Side: "buy", Type: "market",
+			Status: status, PnL: decimal.NewFromFloat(pnl), CreatedAt: at, UpdatedAt: at,
+		}
+	}
+	executed := func(signalID uint, at time.Time) models.UserSignal {
+		botID := uint(1)
+		return models.UserSignal{UserID: 1, SignalID: signalID, BotConfigID: &botID, Status: "executed", ExecutedAt: &at}
+	}
+
+	tests := []struct {
+		name        string
+		timezone    string
+		config      models.TradingConfig
+		signalPrice float64
+		orders      []models.Order
+		userSignals []models.UserSignal
+		price       float64
+		want        string // filter chặn signal, "" = cho phép
+	}{
+		{name: "no filters"},
+		{name: "not a trading day", config: models.TradingConfig{SignalTradingDays: "mon,tue"}, want: SignalFilterTradingDays},
+		{name: "trading day", config: models.TradingConfig{SignalTradingDays: "Wednesday,thu"}},
+		{name: "inside trading hours", config: models.TradingConfig{SignalTradingHours: "08:00-12:00"}},
+		{name: "outside trading hours in user timezone", timezone: "Asia/Ho_Chi_Minh",
+			config: models.TradingConfig{SignalTradingHours: "08:00-12:00"}, want: SignalFilterTradingHours},
+		{name: "outside overnight window", config: models.TradingConfig{SignalTradingHours: "22:00-02:00"}, want: SignalFilterTradingHours},
+		{name: "trade cooldown active", config: models.TradingConfig{SignalCooldownMinutes: 60},
+			orders: []models.Order{order("BTCUSDT", "filled", 0, now.Add(-30*time.Minute))}, want: SignalFilterTradeCooldown},
+		{name: "trade cooldown expired", config: models.TradingConfig{SignalCooldownMinutes: 60},
+			orders: []models.Order{order("BTCUSDT", "filled", 0, now.Add(-90*time.Minute))}},
+		{name: "trade cooldown other symbol", config: models.TradingConfig{SignalCooldownMinutes: 60},
+			orders: []models.Order{order("ETHUSDT", "filled", 0, now.Add(-10*time.Minute))}},
+		{name: "stop loss cooldown after loss", config: models.TradingConfig{SignalStopLossCooldownMinutes: 60},
+			orders: []models.Order{order("BTCUSDT", "closed", -5, now.Add(-10*time.Minute))}, want: SignalFilterStopLossCooldown},
+		{name: "stop loss cooldown after profit", config: models.TradingConfig{SignalStopLossCooldownMinutes: 60},
+			orders: []models.Order{order("BTCUSDT", "closed", 5, now.Add(-10*time.Minute))}},
+		{name: "hourly cap reached", config: models.TradingConfig{SignalMaxPerHour: 2},
+			userSignals: []models.UserSignal{executed(1, now.Add(-10*time.Minute)), executed(2, now.Add(-20*time.Minute))}, want: SignalFilterMaxPerHour},
+		{name: "hourly cap counts last hour only", config: models.TradingConfig{SignalMaxPerHour: 2},
+			userSignals: []models.UserSignal{executed(1, now.Add(-10*time.Minute)), executed(2, now.Add(-2*time.Hour))}},
+		{name: "daily cap not reached", config: models.TradingConfig{SignalMaxPerDay: 3},
+			userSignals: []models.UserSignal{executed(1, now.Add(-time.Hour)), executed(2, now.Add(-5*time.Hour))}},
+		{name: "price too close", config: models.TradingConfig{SignalMinPriceDistancePercent: 1},
+			signalPrice: 100.5, price: 100, want: SignalFilterMinPriceDistance},
+		{name: "price too far", config: models.TradingConfig{SignalMaxPriceDistancePercent: 1},
+			signalPrice: 103, price: 100, want: SignalFilterMaxPriceDistance},
+		{name: "price distance in range", config: models.TradingConfig{SignalMinPriceDistancePercent: 0.5, SignalMaxPriceDistancePercent: 2},
+			signalPrice: 101, price: 100},
+		{name: "price distance without signal price", config: models.TradingConfig{SignalMaxPriceDistancePercent: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			timezone := tt.timezone
+			if timezone == "" {
+				timezone = "UTC"
+			}
+			if err := db.Create(&models.User{ID: 1, Email: "filter@example.com", PasswordHash: "x", Timezone: timezone}).Error; err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+			for i := range tt.orders {
+				if err := db.Create(&tt.orders[i]).Error; err != nil {
+					t.Fatalf("failed to create order: %v", err)
+				}
+			}
+			for i := range tt.userSignals {
+				if err := db.Create(&tt.userSignals[i]).Error; err != nil {
+					t.Fatalf("failed to create user signal: %v", err)
+				}
+			}
+
+			config := tt.config
+			config.ID, config.UserID = 1, 1
+			signal := &models.TradingSignal{Symbol: "BTCUSDT", Action: "buy", Price: decimal.NewFromFloat(tt.signalPrice)}
+			priceCalls := 0
+			currentPrice := func() (decimal.Decimal, error) {
+				priceCalls++
+				if tt.price == 0 {
+					return decimal.Zero, errors.New("current price not expected")
+				}
+				return decimal.NewFromFloat(tt.price), nil
+			}
+
+			block, err := EvaluateSignalFilters(db, &config, signal, now, currentPrice)
+			if err != nil {
+				t.Fatalf("EvaluateSignalFilters error: %v", err)
+			}
+			got := ""
+			if block != nil {
+				got = block.Filter
+			}
+			if got != tt.want {
+				t.Errorf("blocked by %q (%v), want %q", got, block, tt.want)
+			}
+			if tt.price == 0 && priceCalls > 0 {
+				t.Errorf("current price fetched %d time(s) without a price distance filter", priceCalls)
+			}
+		})
+	}
+}
+
+// Timezone không hợp lệ của user là lỗi, không âm thầm dùng UTC
+func TestEvaluateSignalFiltersInvalidTimezone(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.Create(&models.User{ID: 1, Email: "tz@example.com", PasswordHash: "x", Timezone: "Mars/Olympus"}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	config := &models.TradingConfig{ID: 1, UserID: 1, SignalTradingHours: "08:00-12:00"}
+	_, err := EvaluateSignalFilters(db, config, &models.TradingSignal{Symbol: "BTCUSDT"}, time.Now(), nil)
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected timezone error, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tradercoin/backend/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultSignalMaxAge là tuổi tối đa của signal (tính từ timestamp trong payload) khi nhận và khi thực thi
+	DefaultSignalMaxAge = 5 * time.Minute
+	// DefaultSignalDedupWindow là khoảng thời gian signal cùng nội dung bị coi là trùng (TradingView retry)
+	DefaultSignalDedupWindow = 60 * time.Second
+	// externalIDDedupWindow: signal_id do vendor gửi được coi là idempotency key trong 7 ngày
+	externalIDDedupWindow = 7 * 24 * time.Hour
+)
+
+// ErrSignalStale is returned when a signal is older than the configured max age
+var ErrSignalStale = errors.New("signal is stale")
+
+var (
+	signalGuardMu     sync.RWMutex
+	signalMaxAge      = DefaultSignalMaxAge
+	signalDedupWindow = DefaultSignalDedupWindow
+)
+
+// ConfigureSignalGuards sets max age and dedup window (gọi trong main từ config); maxAge 0 = tắt kiểm tra tuổi
+func ConfigureSignalGuards(maxAge, dedupWindow time.Duration) {
+	signalGuardMu.Lock()
+	defer signalGuardMu.Unlock()
+	signalMaxAge = maxAge
+	signalDedupWindow = dedupWindow
+}
+
+// SignalMaxAge returns the configured max signal age
+func SignalMaxAge() time.Duration {
+	signalGuardMu.RLock()
+	defer signalGuardMu.RUnlock()
+	return signalMaxAge
+}
+
+// SignalDedupWindow returns the configured content-hash dedup window
+func SignalDedupWindow() time.Duration {
+	signalGuardMu.RLock()
+	defer signalGuardMu.RUnlock()
+	return signalDedupWindow
+}
+
+// ParseSignalTimestamp parses the payload timestamp: unix giây/mili giây (number hoặc string) hoặc RFC3339 ({{timenow}})
+func ParseSignalTimestamp(raw json.RawMessage) (*time.Time, error) {
+	value := strings.Trim(strings.TrimSpace(string(raw)), `"`)
+	if value == "" || value == "null" || value == "0" {
+		return nil, nil
+	}
+
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		var t time.Time
+		if n > 1e12 {
+			t = time.UnixMilli(int64(n))
+		} else {
+			t = time.Unix(int64(n), 0)
+		}
+		return &t, nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// SignalDedupKey hashes the idempotency identity of a signal:
+// signal_id của vendor nếu có, ngược lại là nội dung signal (gồm cả timestamp).
+func SignalDedupKey(signal *models.TradingSignal) string {
+	var raw string
+	if signal.ExternalID != "" {
+		raw = fmt.Sprintf("id|%s|%s", signal.WebhookPrefix, signal.ExternalID)
+	} else {
+		signalTime := ""
+		if signal.SignalTime != nil {
+			signalTime = strconv.FormatInt(signal.SignalTime.UnixMilli(), 10)
+		}
+		raw = strings.Join([]string{
+			"content",
+			signal.WebhookPrefix,
+			strings.ToUpper(signal.Symbol),
+			strings.ToLower(signal.Action),
+			signal.Price.String(),
+			signal.StopLoss.String(),
+			signal.TakeProfit.String(),
+			signal.ClosePercent.String(),
+			signal.Overrides,
+			signal.Strategy,
+			signal.Message,
+			signalTime,
+		}, "|")
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindDuplicateSignal returns an earlier signal with the same dedup key inside the dedup window (nil if none)
+func FindDuplicateSignal(db *gorm.DB, signal *models.TradingSignal) (*models.TradingSignal, error) {
+	if signal.DedupKey == "" {
+		signal.DedupKey = SignalDedupKey(signal)
+	}
+
+	window := SignalDedupWindow()
+	if signal.ExternalID != "" {
+		window = externalIDDedupWindow
+	}
+	if window <= 0 {
+		return nil, nil
+	}
+
+	var existing models.TradingSignal
+	err := db.Where("dedup_key = ? AND received_at > ?", signal.DedupKey, time.Now().Add(-window)).
+		Order("id ASC").First(&existing).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// CheckSignalAge rejects signals older than the max age at webhook ingest (tính từ timestamp payload, không có thì từ lúc nhận)
+func CheckSignalAge(signal *models.TradingSignal, now time.Time) error {
+	reference := signal.ReceivedAt
+	if signal.SignalTime != nil {
+		reference = *signal.SignalTime
+	}
+	return checkSignalAge(reference, now)
+}
+
+// CheckSignalPayloadAge kiểm tra tuổi signal lúc thực thi, chỉ theo timestamp trong payload.
+// Không dùng ReceivedAt: thực thi tay (POST /signals/:id/execute) và retry của queue hợp lệ dù signal đã nhận lâu.
+func CheckSignalPayloadAge(signal *models.TradingSignal, now time.Time) error {
+	if signal.SignalTime == nil {
+		return nil
+	}
+	return checkSignalAge(*signal.SignalTime, now)
+}
+
+func checkSignalAge(reference, now time.Time) error {
+	maxAge := SignalMaxAge()
+	if maxAge <= 0 || reference.IsZero() {
+		return nil
+	}
+
+	age := now.Sub(reference)
+	if age > maxAge {
+		return fmt.Errorf("%w: age %s exceeds max %s", ErrSignalStale, age.Round(time.Second), maxAge)
+	}
+	// Timestamp ở tương lai quá xa → đồng hồ nguồn sai, không tin được
+	if age < -maxAge {
+		return fmt.Errorf("%w: timestamp %s is in the future", ErrSignalStale, reference.Format(time.RFC3339))
+	}
+	return nil
+}
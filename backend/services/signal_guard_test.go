@@ -0,0 +1,158 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseSignalTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    int64 // unix milli; 0 = nil
+		wantErr bool
+	}{
+		{"unix seconds", `1700000000`, 1700000000000, false},
+		{"unix millis", `1700000000123`, 1700000000123, false},
+		{"unix seconds as string", `"1700000000"`, 1700000000000, false},
+		{"RFC3339", `"2023-11-14T22:13:20Z"`, 1700000000000, false},
+		{"datetime", `"2023-11-14 22:13:20"`, 1700000000000, false},
+		{"empty", ``, 0, false},
+		{"null", `null`, 0, false},
+		{"zero", `0`, 0, false},
+		{"invalid", `"yesterday"`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignalTimestamp(json.RawMessage(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSignalTimestamp(%s) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			switch {
+			case tt.want == 0 && got != nil:
+				t.Errorf("ParseSignalTimestamp(%s) = %s, want nil", tt.raw, got)
+			case tt.want != 0 && (got == nil || got.UnixMilli() != tt.want):
+				t.Errorf("ParseSignalTimestamp(%s) = %v, want %d", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSignalAge(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name           string
+		receivedAt     time.Time
+		signalTime     *time.Time
+		wantIngestErr  bool // CheckSignalAge (lúc nhận webhook)
+		wantExecuteErr bool // CheckSignalPayloadAge (lúc thực thi)
+	}{
+		{"fresh payload timestamp", now, at(-time.Minute), false, false},
+		{"stale payload timestamp", now, at(-10 * time.Minute), true, true},
+		{"payload timestamp far in the future", now, at(10 * time.Minute), true, true},
+		{"no timestamp, received now", now, nil, false, false},
+		{"no timestamp, received long ago", now.Add(-time.Hour), nil, true, false},
+		{"payload timestamp wins over received at", now.Add(-time.Hour), at(-time.Minute), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := &models.TradingSignal{ReceivedAt: tt.receivedAt, SignalTime: tt.signalTime}
+			err := CheckSignalAge(signal, now)
+			if (err != nil) != tt.wantIngestErr {
+				t.Errorf("CheckSignalAge() error = %v, wantErr %v", err, tt.wantIngestErr)
+			}
+			if err != nil && !errors.Is(err, ErrSignalStale) {
+				t.Errorf("CheckSignalAge() error = %v, want ErrSignalStale", err)
+			}
+			if err := CheckSignalPayloadAge(signal, now); (err != nil) != tt.wantExecuteErr {
+				t.Errorf("CheckSignalPayloadAge() error = %v, wantErr %v", err, tt.wantExecuteErr)
+			}
+		})
+	}
+}
+
+func TestSignalDedupKey(t *testing.T) {
+	base := models.TradingSignal{WebhookPrefix: "abc", Symbol: "BTCUSDT", Action: "buy", Price: decimal.NewFromInt(95000)}
+	key := SignalDedupKey(&base)
+
+	tests := []struct {
+		name     string
+		modify   func(s *models.TradingSignal)
+		wantSame bool
+	}{
+		{"same content", func(s *models.TradingSignal) {}, true},
+		{"symbol and action case", func(s *models.TradingSignal) { s.Symbol = "btcusdt"; s.Action = "BUY" }, true},
+		{"other price", func(s *models.TradingSignal) { s.Price = decimal.NewFromInt(95001) }, false},
+		{"other prefix", func(s *models.TradingSignal) { s.WebhookPrefix = "def" }, false},
+		{"other payload timestamp", func(s *models.TradingSignal) { ts := time.Unix(1700000000, 0); s.SignalTime = &ts }, false},
+		{"vendor signal id", func(s *models.TradingSignal) { s.ExternalID = "sig-1" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signal := base
+			tt.modify(&signal)
+			if got := SignalDedupKey(&signal) == key; got != tt.wantSame {
+				t.Errorf("same dedup key = %v, want %v", got, tt.wantSame)
+			}
+		})
+	}
+
+	// Có signal_id của vendor thì chỉ dùng id, bỏ qua nội dung
+	a := models.TradingSignal{WebhookPrefix: "abc", ExternalID: "sig-1", Symbol: "BTCUSDT", Price: decimal.NewFromInt(1)}
+	b := models.TradingSignal{WebhookPrefix: "abc", ExternalID: "sig-1", Symbol: "ETHUSDT", Price: decimal.NewFromInt(2)}
+	if SignalDedupKey(&a) != SignalDedupKey(&b) {
+		t.Error("signals with the same vendor signal id have different dedup keys")
+	}
+}
+
+func TestFindDuplicateSignal(t *testing.T) {
+	tests := []struct {
+		name          string
+		externalID    string
+		receivedAgo   time.Duration // tuổi của signal đã lưu
+		wantDuplicate bool
+	}{
+		{"same content inside window", "", 10 * time.Second, true},
+		{"same content after window", "", 2 * DefaultSignalDedupWindow, false},
+		{"same vendor id after content window", "sig-1", time.Hour, true},
+		{"same vendor id after 7 days", "sig-1", 8 * 24 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			stored := models.TradingSignal{WebhookPrefix: "abc", Symbol: "BTCUSDT", Action: "buy", ExternalID: tt.externalID,
+				ReceivedAt: time.Now().Add(-tt.receivedAgo)}
+			stored.DedupKey = SignalDedupKey(&stored)
+			if err := db.Create(&stored).Error; err != nil {
+				t.Fatalf("failed to create signal: %v", err)
+			}
+
+			incoming := models.TradingSignal{WebhookPrefix: "abc", Symbol: "BTCUSDT", Action: "buy", ExternalID: tt.externalID,
+				ReceivedAt: time.Now()}
+			duplicate, err := FindDuplicateSignal(db, &incoming)
+			if err != nil {
+				t.Fatalf("FindDuplicateSignal() error = %v", err)
+			}
+			if (duplicate != nil) != tt.wantDuplicate {
+				t.Errorf("duplicate found = %v, want %v", duplicate != nil, tt.wantDuplicate)
+			}
+			if duplicate != nil && duplicate.ID != stored.ID {
+				t.Errorf("duplicate = #%d, want #%d", duplicate.ID, stored.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Loại job của signal queue
+const (
+	SignalJobFanout   = "fanout"   // tìm bot cần thực thi signal và tạo job execute cho từng bot
+	SignalJobTelegram = "telegram" // broadcast signal qua Telegram
+	SignalJobExecute  = "execute"  // thực thi signal với 1 bot config
+)
+
+// Trạng thái của SignalJob
+const (
+	SignalJobPending = "pending"
+	SignalJobRunning = "running"
+	SignalJobDone    = "done"
+	SignalJobDead    = "dead" // hết lượt retry hoặc lỗi không retry được → chờ admin re-drive
+)
+
+const (
+	// DefaultSignalJobMaxAttempts là số lần chạy tối đa của một job trước khi chuyển sang dead
+	DefaultSignalJobMaxAttempts = 5
+
+	signalJobStream      = "tradercoin:signal_jobs"
+	signalJobGroup       = "signal-workers"
+	signalJobBaseBackoff = 5 * time.Second
+	signalJobMaxBackoff  = 5 * time.Minute
+	// Worker gia hạn lease (locked_at) của job đang chạy mỗi signalJobHeartbeat;
+	// job "running" không được gia hạn quá signalJobLeaseTimeout (process chết giữa chừng) được đưa lại về pending
+	signalJobHeartbeat    = 30 * time.Second
+	signalJobLeaseTimeout = 2 * time.Minute
+)
+
+// ErrSignalJobNotDead is returned when re-driving a job that is not in the dead-letter state
+var ErrSignalJobNotDead = errors.New("only dead jobs can be re-driven")
+
+// SignalJobHandler processes one job; trả về Retryable(err) nếu lỗi tạm thời (sẽ retry với backoff)
+type SignalJobHandler func(job *models.SignalJob) error
+
+// retryableError đánh dấu lỗi tạm thời (lỗi mạng, sàn quá tải, lock đang bận...)
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as transient so the job is retried with backoff
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether err was marked with Retryable
+func IsRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// SignalQueue is a durable job queue for signal processing.
+// Bảng signal_jobs là nguồn dữ liệu chính (job không mất khi restart); nếu có Redis thì
+// job mới được đẩy qua Redis Stream để mọi instance nhận ngay, retry vẫn được lên lịch trong DB.
+type SignalQueue struct {
+	DB           *gorm.DB
+	redis        *redis.Client
+	consumer     string
+	workers      int
+	pollInterval time.Duration
+	handlers     map[string]SignalJobHandler
+	jobs         chan uint
+	stopChan     chan bool
+}
+
+// NewSignalQueue creates a signal queue; redisClient may be nil (chỉ dùng DB polling)
+func NewSignalQueue(db *gorm.DB, redisClient *redis.Client) *SignalQueue {
+	hostname, _ := os.Hostname()
+	pollInterval := time.Second
+	if redisClient != nil {
+		// Job mới đến qua stream, polling chỉ còn để nhặt job retry / job bị bỏ dở
+		pollInterval = 5 * time.Second
+	}
+	return &SignalQueue{
+		DB:           db,
+		redis:        redisClient,
+		consumer:     fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		workers:      4,
+		pollInterval: pollInterval,
+		handlers:     make(map[string]SignalJobHandler),
+		jobs:         make(chan uint, 100),
+		stopChan:     make(chan bool),
+	}
+}
+
+// Backend returns "redis" or "database"
+func (q *SignalQueue) Backend() string {
+	if q.redis != nil {
+		return "redis"
+	}
+	return "database"
+}
+
+// Handle registers the handler of a job kind (gọi trước Start)
+func (q *SignalQueue) Handle(kind string, handler SignalJobHandler) {
+	q.handlers[kind] = handler
+}
+
+// Enqueue lưu job (bỏ qua nếu DedupKey đã tồn tại) và báo cho worker.
+// Trả về job đã có trong DB nếu trùng DedupKey.
+func (q *SignalQueue) Enqueue(job *models.SignalJob) (*models.SignalJob, error) {
+	if job.Status == "" {
+		job.Status = SignalJobPending
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = DefaultSignalJobMaxAttempts
+	}
+	if job.NextRunAt.IsZero() {
+		job.NextRunAt = time.Now()
+	}
+
+	result := q.DB.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "dedup_key"}}, DoNothing: true}).Create(job)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create signal job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var existing models.SignalJob
+		if err := q.DB.Where("dedup_key = ?", job.DedupKey).First(&existing).Error; err != nil {
+			return nil, fmt.Errorf("failed to load existing signal job: %w", err)
+		}
+		return &existing, nil
+	}
+
+	q.publish(job.ID)
+	return job, nil
+}
+
+// Redrive đưa job dead về pending với lượt retry mới
+func (q *SignalQueue) Redrive(id uint) (*models.SignalJob, error) {
+	var job models.SignalJob
+	if err := q.DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	if job.Status != SignalJobDead {
+		return nil, ErrSignalJobNotDead
+	}
+	if err := q.reset(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// reset đưa job về pending (attempts = 0) và báo cho worker
+func (q *SignalQueue) reset(job *models.SignalJob) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       SignalJobPending,
+		"attempts":     0,
+		"next_run_at":  now,
+		"locked_by":    "",
+		"locked_at":    nil,
+		"completed_at": nil,
+	}
+	if err := q.DB.Model(job).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to reset signal job: %w", err)
+	}
+	job.Status = SignalJobPending
+	job.Attempts = 0
+	job.NextRunAt = now
+	job.LockedBy = ""
+	job.LockedAt = nil
+	job.CompletedAt = nil
+
+	q.publish(job.ID)
+	return nil
+}
+
+// CountByStatus returns the number of jobs per status
+func (q *SignalQueue) CountByStatus() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := q.DB.Model(&models.SignalJob{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	stats := map[string]int64{SignalJobPending: 0, SignalJobRunning: 0, SignalJobDone: 0, SignalJobDead: 0}
+	for _, r := range rows {
+		stats[r.Status] = r.Count
+	}
+	return stats, nil
+}
+
+// Start launches the workers, the DB poller and (nếu có Redis) stream consumer
+func (q *SignalQueue) Start() {
+	log.Printf("📬 Signal queue started (%s backend, %d workers)", q.Backend(), q.workers)
+
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for {
+				select {
+				case id := <-q.jobs:
+					q.run(id)
+				case <-q.stopChan:
+					return
+				}
+			}
+		}()
+	}
+
+	go q.poll()
+	if q.redis != nil {
+		go q.readStream()
+	}
+}
+
+// Stop stops the workers (job đang chạy dở sẽ được nhặt lại khi hết lease signalJobLeaseTimeout)
+func (q *SignalQueue) Stop() {
+	close(q.stopChan)
+	log.Println("⏹️  Signal queue stopped")
+}
+
+// publish báo có job mới: XADD lên Redis Stream, hoặc đẩy thẳng vào channel nếu không có Redis.
+// Nếu thất bại thì poller vẫn nhặt job từ DB.
+func (q *SignalQueue) publish(id uint) {
+	if q.redis != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err := q.redis.XAdd(ctx, &redis.XAddArgs{
+			Stream: signalJobStream,
+			MaxLen: 10000,
+			Approx: true,
+			Values: map[string]interface{}{"job_id": id},
+		}).Err()
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️  Signal queue: XADD failed for job %d, falling back to DB polling: %v", id, err)
+	}
+	q.dispatch(id)
+}
+
+// dispatch đưa job vào channel của worker (không block; channel đầy thì để poller nhặt lại)
+func (q *SignalQueue) dispatch(id uint) {
+	select {
+	case q.jobs <- id:
+	default:
+	}
+}
+
+// poll nhặt job đến hạn (retry, job chưa được publish) và job running bị bỏ dở
+func (q *SignalQueue) poll() {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.recoverStale()
+			q.dispatchDue()
+		case <-q.stopChan:
+			return
+		}
+	}
+}
+
+// recoverStale đưa về pending các job running đã hết lease (worker không còn gia hạn locked_at)
+func (q *SignalQueue) recoverStale() {
+	result := q.DB.Model(&models.SignalJob{}).
+		Where("status = ? AND locked_at < ?", SignalJobRunning, time.Now().Add(-signalJobLeaseTimeout)).
+		Updates(map[string]interface{}{"status": SignalJobPending, "next_run_at": time.Now(), "locked_by": ""})
+	if result.Error != nil {
+		log.Printf("⚠️  Signal queue: failed to recover stale jobs: %v", result.Error)
+	} else if result.RowsAffected > 0 {
+		log.Printf("♻️  Signal queue: recovered %d stale running job(s)", result.RowsAffected)
+	}
+}
+
+func (q *SignalQueue) dispatchDue() {
+	var ids []uint
+	err := q.DB.Model(&models.SignalJob{}).
+		Where("status = ? AND next_run_at <= ?", SignalJobPending, time.Now()).
+		Order("next_run_at ASC").Limit(cap(q.jobs)).Pluck("id", &ids).Error
+	if err != nil {
+		log.Printf("⚠️  Signal queue: failed to load due jobs: %v", err)
+		return
+	}
+	for _, id := range ids {
+		q.dispatch(id)
+	}
+}
+
+// readStream đọc job mới từ Redis Stream (consumer group: mỗi message chỉ giao cho 1 instance)
+func (q *SignalQueue) readStream() {
+	ctx := context.Background()
+	if err := q.redis.XGroupCreateMkStream(ctx, signalJobStream, signalJobGroup, "$").Err(); err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		log.Printf("⚠️  Signal queue: failed to create consumer group, using DB polling only: %v", err)
+		return
+	}
+
+	for {
+		select {
+		case <-q.stopChan:
+			return
+		default:
+		}
+
+		streams, err := q.redis.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    signalJobGroup,
+			Consumer: q.consumer,
+			Streams:  []string{signalJobStream, ">"},
+			Count:    10,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				log.Printf("⚠️  Signal queue: XREADGROUP failed: %v", err)
+				time.Sleep(q.pollInterval)
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				raw, _ := msg.Values["job_id"].(string)
+				if id, err := strconv.ParseUint(raw, 10, 64); err == nil {
+					select {
+					case q.jobs <- uint(id):
+					case <-q.stopChan:
+						return
+					}
+				}
+				// Trạng thái job nằm trong DB nên ack ngay; job lỗi sẽ được poller retry
+				q.redis.XAck(ctx, signalJobStream, signalJobGroup, msg.ID)
+			}
+		}
+	}
+}
+
+// run claim job (pending → running) rồi gọi handler; chỉ 1 worker/instance claim được mỗi lượt
+func (q *SignalQueue) run(id uint) {
+	now := time.Now()
+	claim := q.DB.Model(&models.SignalJob{}).
+		Where("id = ? AND status = ? AND next_run_at <= ?", id, SignalJobPending, now).
+		Updates(map[string]interface{}{
+			"status":    SignalJobRunning,
+			"attempts":  gorm.Expr("attempts + 1"),
+			"locked_by": q.consumer,
+			"locked_at": now,
+		})
+	if claim.Error != nil {
+		log.Printf("⚠️  Signal queue: failed to claim job %d: %v", id, claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		return // Job đã được worker khác xử lý hoặc chưa đến hạn
+	}
+
+	var job models.SignalJob
+	if err := q.DB.First(&job, id).Error; err != nil {
+		log.Printf("⚠️  Signal queue: failed to load job %d: %v", id, err)
+		return
+	}
+
+	stop := make(chan struct{})
+	go q.heartbeat(job.ID, stop)
+	err := q.handle(&job)
+	close(stop)
+	q.finish(&job, err)
+}
+
+// heartbeat gia hạn lease của job đang chạy cho tới khi stop bị đóng
+func (q *SignalQueue) heartbeat(id uint, stop <-chan struct{}) {
+	ticker := time.NewTicker(signalJobHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		result := q.DB.Model(&models.SignalJob{}).
+			Where("id = ? AND status = ? AND locked_by = ?", id, SignalJobRunning, q.consumer).
+			Update("locked_at", time.Now())
+		if result.Error != nil {
+			log.Printf("⚠️  Signal queue: failed to renew lease of job %d: %v", id, result.Error)
+		} else if result.RowsAffected == 0 {
+			log.Printf("⚠️  Signal queue: lease of job %d was lost while still running", id)
+			return
+		}
+	}
+}
+
+// handle gọi handler của job (recover panic để không làm chết worker)
+func (q *SignalQueue) handle(job *models.SignalJob) (err error) {
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in %s job: %v", job.Kind, r)
+		}
+	}()
+	return handler(job)
+}
+
+// finish ghi kết quả: done, retry với backoff, hoặc dead
+func (q *SignalQueue) finish(job *models.SignalJob, err error) {
+	now := time.Now()
+	updates := map[string]interface{}{"locked_by": "", "locked_at": nil}
+
+	switch {
+	case err == nil:
+		updates["status"] = SignalJobDone
+		updates["completed_at"] = now
+		updates["last_error"] = ""
+	case IsRetryable(err) && job.Attempts < job.MaxAttempts:
+		delay := signalJobBackoff(job.Attempts)
+		updates["status"] = SignalJobPending
+		updates["next_run_at"] = now.Add(delay)
+		updates["last_error"] = err.Error()
+		log.Printf("🔁 Signal queue: %s job %d failed (attempt %d/%d), retrying in %s: %v",
+			job.Kind, job.ID, job.Attempts, job.MaxAttempts, delay.Round(time.Second), err)
+	default:
+		updates["status"] = SignalJobDead
+		updates["completed_at"] = now
+		updates["last_error"] = err.Error()
+		log.Printf("☠️  Signal queue: %s job %d moved to dead-letter after %d attempt(s): %v",
+			job.Kind, job.ID, job.Attempts, err)
+	}
+
+	// Chỉ ghi nếu worker này vẫn giữ lease (job mất lease có thể đã được worker khác nhận)
+	result := q.DB.Model(&models.SignalJob{}).
+		Where("id = ? AND status = ? AND locked_by = ?", job.ID, SignalJobRunning, q.consumer).
+		Updates(updates)
+	if result.Error != nil {
+		log.Printf("❌ Signal queue: failed to update job %d: %v", job.ID, result.Error)
+	} else if result.RowsAffected == 0 {
+		log.Printf("⚠️  Signal queue: job %d lost its lease before finishing, result not saved", job.ID)
+	}
+}
+
+// signalJobBackoff: 5s, 10s, 20s, ... tối đa 5 phút, cộng jitter tới 20% để các instance không retry cùng lúc
+func signalJobBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := signalJobMaxBackoff
+	if attempt <= 16 {
+		if d := signalJobBaseBackoff << (attempt - 1); d < signalJobMaxBackoff {
+			delay = d
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"tradercoin/backend/models"
+
+	"gorm.io/gorm"
+)
+
+func TestSignalJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration // delay trước jitter
+	}{
+		{0, 5 * time.Second},
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{7, signalJobMaxBackoff},
+		{100, signalJobMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt=%d", tt.attempt), func(t *testing.T) {
+			maxJitter := tt.want / 5
+			for i := 0; i < 20; i++ {
+				got := signalJobBackoff(tt.attempt)
+				if got < tt.want || got > tt.want+maxJitter {
+					t.Fatalf("signalJobBackoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.want, tt.want+maxJitter)
+				}
+			}
+		})
+	}
+}
+
+func TestSignalQueueEnqueueDedup(t *testing.T) {
+	q := NewSignalQueue(newTestDB(t), nil)
+	first, err := q.Enqueue(&models.SignalJob{Kind: SignalJobExecute, DedupKey: "execute:1:1", SignalID: 1})
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	second, err := q.Enqueue(&models.SignalJob{Kind: SignalJobExecute, DedupKey: "execute:1:1", SignalID: 1})
+	if err != nil {
+		t.Fatalf("Enqueue() duplicate error = %v", err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("duplicate Enqueue returned job %d, want existing job %d", second.ID, first.ID)
+	}
+	var count int64
+	q.DB.Model(&models.SignalJob{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d job(s) stored, want 1", count)
+	}
+}
+
+func TestSignalQueueRun(t *testing.T) {
+	errTemporary := errors.New("exchange busy")
+
+	tests := []struct {
+		name        string
+		attempts    int // attempts trước lần chạy này
+		maxAttempts int
+		handlerErr  error
+		panics      bool
+		loseLease   bool // worker khác nhận job trong lúc handler đang chạy
+		wantStatus  string
+		wantRetry   bool // next_run_at được lùi theo backoff
+	}{
+		{"success", 0, 5, nil, false, false, SignalJobDone, false},
+		{"retryable error", 0, 5, Retryable(errTemporary), false, false, SignalJobPending, true},
+		{"retryable error on last attempt", 4, 5, Retryable(errTemporary), false, false, SignalJobDead, false},
+		{"permanent error", 0, 5, errTemporary, false, false, SignalJobDead, false},
+		{"panic", 0, 5, nil, true, false, SignalJobDead, false},
+		{"lease lost", 0, 5, nil, false, true, SignalJobRunning, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			q := NewSignalQueue(db, nil)
+			q.Handle(SignalJobExecute, func(job *models.SignalJob) error {
+				if tt.loseLease {
+					db.Model(&models.SignalJob{}).Where("id = ?", job.ID).Update("locked_by", "other-worker")
+				}
+				if tt.panics {
+					panic("boom")
+				}
+				return tt.handlerErr
+			})
+
+			job, err := q.Enqueue(&models.SignalJob{Kind: SignalJobExecute, DedupKey: "job", SignalID: 1,
+				Attempts: tt.attempts, MaxAttempts: tt.maxAttempts, NextRunAt: time.Now().Add(-time.Second)})
+			if err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+			start := time.Now()
+			q.run(job.ID)
+
+			var saved models.SignalJob
+			db.First(&saved, job.ID)
+			if saved.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (last error %q)", saved.Status, tt.wantStatus, saved.LastError)
+			}
+			if saved.Attempts != tt.attempts+1 {
+				t.Errorf("attempts = %d, want %d", saved.Attempts, tt.attempts+1)
+			}
+			if retried := saved.NextRunAt.After(start.Add(signalJobBaseBackoff / 2)); retried != tt.wantRetry {
+				t.Errorf("next_run_at = %s, retry scheduled = %v, want %v", saved.NextRunAt, retried, tt.wantRetry)
+			}
+			if tt.loseLease && saved.LockedBy != "other-worker" {
+				t.Errorf("locked_by = %q, result of the worker without lease was saved", saved.LockedBy)
+			}
+		})
+	}
+}
+
+func TestSignalQueueRunSkipsClaimedJob(t *testing.T) {
+	db := newTestDB(t)
+	q := NewSignalQueue(db, nil)
+	calls := 0
+	q.Handle(SignalJobExecute, func(job *models.SignalJob) error {
+		calls++
+		return nil
+	})
+
+	tests := []struct {
+		name      string
+		status    string
+		nextRunAt time.Time
+	}{
+		{"running on another worker", SignalJobRunning, time.Now().Add(-time.Second)},
+		{"retry not due yet", SignalJobPending, time.Now().Add(time.Minute)},
+		{"dead", SignalJobDead, time.Now().Add(-time.Second)},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := models.SignalJob{Kind: SignalJobExecute, DedupKey: fmt.Sprintf("job-%d", i), SignalID: 1, Status: tt.status, NextRunAt: tt.nextRunAt}
+			db.Create(&job)
+			calls = 0
+			q.run(job.ID)
+			if calls != 0 {
+				t.Errorf("handler called %d time(s), want 0", calls)
+			}
+		})
+	}
+}
+
+func TestSignalQueueRecoverStale(t *testing.T) {
+	tests := []struct {
+		name       string
+		lockedAgo  time.Duration
+		wantStatus string
+	}{
+		{"lease renewed recently", signalJobHeartbeat, SignalJobRunning},
+		{"lease expired", signalJobLeaseTimeout + time.Minute, SignalJobPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			q := NewSignalQueue(db, nil)
+			lockedAt := time.Now().Add(-tt.lockedAgo)
+			job := models.SignalJob{Kind: SignalJobExecute, DedupKey: "job", SignalID: 1, Status: SignalJobRunning,
+				LockedBy: "dead-worker", LockedAt: &lockedAt, NextRunAt: time.Now()}
+			db.Create(&job)
+
+			q.recoverStale()
+
+			var saved models.SignalJob
+			db.First(&saved, job.ID)
+			if saved.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", saved.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSignalQueueRedrive(t *testing.T) {
+	tests := []struct {
+		status  string
+		wantErr error
+	}{
+		{SignalJobDead, nil},
+		{SignalJobPending, ErrSignalJobNotDead},
+		{SignalJobDone, ErrSignalJobNotDead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			db := newTestDB(t)
+			q := NewSignalQueue(db, nil)
+			job := models.SignalJob{Kind: SignalJobExecute, DedupKey: "job", SignalID: 1, Status: tt.status, Attempts: 5, NextRunAt: time.Now()}
+			db.Create(&job)
+
+			_, err := q.Redrive(job.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Redrive() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var saved models.SignalJob
+			db.First(&saved, job.ID)
+			if tt.wantErr == nil && (saved.Status != SignalJobPending || saved.Attempts != 0) {
+				t.Errorf("after redrive status = %q attempts = %d, want pending with 0 attempts", saved.Status, saved.Attempts)
+			}
+			if tt.wantErr != nil && saved.Status != tt.status {
+				t.Errorf("status changed to %q", saved.Status)
+			}
+		})
+	}
+
+	if _, err := NewSignalQueue(newTestDB(t), nil).Redrive(999); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Redrive() of a missing job error = %v, want ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"tradercoin/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// SignalOverrides are per-rule adjustments applied to a bot config before execution
+type SignalOverrides struct {
+	SizeMultiplier    float64  `json:"size_multiplier,omitempty"`
+	Leverage          int      `json:"leverage,omitempty"`
+	StopLossPercent   *float64 `json:"stop_loss_percent,omitempty"`
+	TakeProfitPercent *float64 `json:"take_profit_percent,omitempty"`
+}
+
+// Apply trả về bản sao config đã áp override (không sửa config gốc trong DB)
+func (o SignalOverrides) Apply(config models.TradingConfig) models.TradingConfig {
+	if o.SizeMultiplier > 0 {
+		config.Amount = config.Amount * o.SizeMultiplier
+	}
+	if o.Leverage > 0 {
+		config.Leverage = o.Leverage
+	}
+	if o.StopLossPercent != nil {
+		config.StopLossPercent = *o.StopLossPercent
+	}
+	if o.TakeProfitPercent != nil {
+		config.TakeProfitPercent = *o.TakeProfitPercent
+	}
+	return config
+}
+
+// SignalTarget is a bot config that a signal should be executed with
+type SignalTarget struct {
+	Config    models.TradingConfig `json:"-"`
+	RuleID    *uint                `json:"rule_id,omitempty"`
+	RuleName  string               `json:"rule_name,omitempty"`
+	Source    string               `json:"source"` // "rule" hoặc "auto_execute"
+	Overrides SignalOverrides      `json:"overrides"`
+}
+
+// RuleEvaluation explains why a routing rule did or did not match a signal
+type RuleEvaluation struct {
+	RuleID   uint   `json:"rule_id"`
+	RuleName string `json:"rule_name"`
+	Priority int    `json:"priority"`
+	Matched  bool   `json:"matched"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// RuleBotConfigIDs parses the JSON array of bot config IDs stored on a rule
+func RuleBotConfigIDs(rule *models.SignalRoutingRule) ([]uint, error) {
+	if strings.TrimSpace(rule.BotConfigIDs) == "" {
+		return nil, nil
+	}
+	var ids []uint
+	if err := json.Unmarshal([]byte(rule.BotConfigIDs), &ids); err != nil {
+		return nil, fmt.Errorf("invalid bot_config_ids: %w", err)
+	}
+	return ids, nil
+}
+
+// RuleCustomFields parses the JSON object of custom field conditions stored on a rule
+func RuleCustomFields(rule *models.SignalRoutingRule) (map[string]string, error) {
+	if strings.TrimSpace(rule.CustomFields) == "" {
+		return nil, nil
+	}
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(rule.CustomFields), &fields); err != nil {
+		return nil, fmt.Errorf("invalid custom_fields: %w", err)
+	}
+	return fields, nil
+}
+
+// ValidateRoutingRule kiểm tra regex / JSON của rule trước khi lưu
+func ValidateRoutingRule(rule *models.SignalRoutingRule) error {
+	if rule.MessageRegex != "" {
+		if _, err := regexp.Compile(rule.MessageRegex); err != nil {
+			return fmt.Errorf("invalid message_regex: %w", err)
+		}
+	}
+	for _, pattern := range splitList(rule.MatchSymbol) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid match_symbol pattern %q", pattern)
+		}
+	}
+	ids, err := RuleBotConfigIDs(rule)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return errors.New("bot_config_ids must contain at least one bot config")
+	}
+	if _, err := RuleCustomFields(rule); err != nil {
+		return err
+	}
+	if rule.SizeMultiplier < 0 {
+		return errors.New("size_multiplier must be >= 0")
+	}
+	if rule.Leverage < 0 || rule.Leverage > 125 {
+		return errors.New("leverage must be between 0 and 125")
+	}
+	return nil
+}
+
+// MatchRoutingRule checks a rule against a signal; payload là raw webhook JSON (có thể nil)
+func MatchRoutingRule(rule *models.SignalRoutingRule, signal *models.TradingSignal, payload map[string]interface{}) (bool, string) {
+	if rule.MatchPrefix != "" && !strings.EqualFold(rule.MatchPrefix, signal.WebhookPrefix) {
+		return false, fmt.Sprintf("prefix %q != %q", signal.WebhookPrefix, rule.MatchPrefix)
+	}
+
+	if rule.MatchStrategy != "" && !strings.EqualFold(rule.MatchStrategy, signal.Strategy) {
+		return false, fmt.Sprintf("strategy %q != %q", signal.Strategy, rule.MatchStrategy)
+	}
+
+	if patterns := splitList(rule.MatchSymbol); len(patterns) > 0 {
+		symbol := strings.ToUpper(signal.Symbol)
+		matched := false
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToUpper(pattern), symbol); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, fmt.Sprintf("symbol %q not in %q", signal.Symbol, rule.MatchSymbol)
+		}
+	}
+
+	if actions := splitList(rule.MatchAction); len(actions) > 0 {
+		matched := false
+		for _, action := range actions {
+			if strings.EqualFold(action, signal.Action) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, fmt.Sprintf("action %q not in %q", signal.Action, rule.MatchAction)
+		}
+	}
+
+	if rule.MessageRegex != "" {
+		re, err := regexp.Compile(rule.MessageRegex)
+		if err != nil {
+			return false, fmt.Sprintf("invalid message_regex: %v", err)
+		}
+		if !re.MatchString(signal.Message) {
+			return false, fmt.Sprintf("message does not match %q", rule.MessageRegex)
+		}
+	}
+
+	fields, err := RuleCustomFields(rule)
+	if err != nil {
+		return false, err.Error()
+	}
+	for field, expected := range fields {
+		value, ok := lookupPayloadField(payload, field)
+		if !ok {
+			return false, fmt.Sprintf("field %q missing", field)
+		}
+		if !strings.EqualFold(value, expected) {
+			return false, fmt.Sprintf("field %q = %q, expected %q", field, value, expected)
+		}
+	}
+
+	return true, ""
+}
+
+// ResolveSignalTargets trả về danh sách bot sẽ thực thi signal cho user:
+// bot từ routing rules (theo priority) trước, sau đó các bot bật auto-execute chưa có trong danh sách.
+func ResolveSignalTargets(db *gorm.DB, userID uint, signal *models.TradingSignal) ([]SignalTarget, []RuleEvaluation, error) {
+	targets := []SignalTarget{}
+	evaluations := []RuleEvaluation{}
+	seen := make(map[uint]bool)
+
+	// Prefix đã rotate (còn trong grace period) → route như prefix mới
+	if signal.WebhookPrefix != "" {
+		if current := CurrentWebhookPrefix(db, signal.WebhookPrefix); !strings.EqualFold(current, signal.WebhookPrefix) {
+			routed := *signal
+			routed.WebhookPrefix = current
+			signal = &routed
+		}
+	}
+
+	payload := map[string]interface{}{}
+	if signal.RawPayload != "" {
+		json.Unmarshal([]byte(signal.RawPayload), &payload)
+	}
+
+	// ====== ROUTING RULES ======
+	var rules []models.SignalRoutingRule
+	if err := db.Where("user_id = ? AND active = ?", userID, true).
+		Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		return nil, nil, err
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		matched, reason := MatchRoutingRule(rule, signal, payload)
+		evaluations = append(evaluations, RuleEvaluation{
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			Priority: rule.Priority,
+			Matched:  matched,
+			Reason:   reason,
+		})
+		if !matched {
+			continue
+		}
+
+		ids, err := RuleBotConfigIDs(rule)
+		if err != nil || len(ids) == 0 {
+			continue
+		}
+
+		var configs []models.TradingConfig
+		if err := db.Where("id IN ? AND user_id = ? AND is_active = ?", ids, userID, true).
+			Find(&configs).Error; err != nil {
+			return nil, nil, err
+		}
+
+		ruleID := rule.ID
+		overrides := SignalOverrides{
+			SizeMultiplier:    rule.SizeMultiplier,
+			Leverage:          rule.Leverage,
+			StopLossPercent:   rule.StopLossPercent,
+			TakeProfitPercent: rule.TakeProfitPercent,
+		}
+		for _, config := range configs {
+			// 1 bot chỉ chạy 1 lần / signal: rule có priority cao hơn thắng
+			if seen[config.ID] {
+				continue
+			}
+			seen[config.ID] = true
+			targets = append(targets, SignalTarget{
+				Config:    config,
+				RuleID:    &ruleID,
+				RuleName:  rule.Name,
+				Source:    "rule",
+				Overrides: overrides,
+			})
+		}
+
+		if rule.StopOnMatch {
+			break
+		}
+	}
+
+	// ====== AUTO-EXECUTE BOTS ======
+	if signal.WebhookPrefix != "" {
+		configs, err := findAutoExecuteBots(db, userID, signal)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, config := range configs {
+			if seen[config.ID] {
+				continue
+			}
+			seen[config.ID] = true
+			targets = append(targets, SignalTarget{Config: config, Source: "auto_execute"})
+		}
+	}
+
+	return targets, evaluations, nil
+}
+
+// FindPrefixOwner returns the user owning an active webhook prefix (0 if none)
+func FindPrefixOwner(db *gorm.DB, prefix string) (uint, error) {
+	if prefix == "" {
+		return 0, nil
+	}
+	wp, err := FindActiveWebhookPrefix(db, prefix)
+	if err != nil || wp == nil {
+		return 0, err
+	}
+	return wp.UserID, nil
+}
+
+// findAutoExecuteBots trả về các bot của user bật auto-execute và khớp signal
+func findAutoExecuteBots(db *gorm.DB, userID uint, signal *models.TradingSignal) ([]models.TradingConfig, error) {
+	var configs []models.TradingConfig
+	err := db.Where("user_id = ? AND auto_execute = ? AND is_active = ?", userID, true, true).
+		Where("UPPER(symbol) = ?", strings.ToUpper(signal.Symbol)).
+		Where("(auto_execute_prefix = '' OR auto_execute_prefix IS NULL OR auto_execute_prefix = ?)", signal.WebhookPrefix).
+		Where("(auto_execute_strategy = '' OR auto_execute_strategy IS NULL OR LOWER(auto_execute_strategy) = ?)", strings.ToLower(signal.Strategy)).
+		Find(&configs).Error
+	return configs, err
+}
+
+// lookupPayloadField reads a field from the raw payload, hỗ trợ JSONPath (vd: "meta.timeframe", "$.legs[0].side")
+func lookupPayloadField(payload map[string]interface{}, field string) (string, bool) {
+	value, ok := ExtractJSONPath(payload, field)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// splitList splits a comma-separated list, bỏ khoảng trắng và phần tử rỗng
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	// Binance listen key hết hạn sau 60 phút nếu không gia hạn
+	listenKeyKeepAliveInterval = 30 * time.Minute
+	// Không có message (kể cả ping của sàn) trong khoảng này → coi như kết nối chết
+	userStreamReadTimeout     = 10 * time.Minute
+	userStreamRefreshInterval = time.Minute
+	userStreamBackoffBase     = time.Second
+	userStreamBackoffMax      = 5 * time.Minute
+)
+
+// Trạng thái kết nối của một user data stream
+const (
+	StreamStateConnecting = "connecting"
+	StreamStateConnected  = "connected"
+	StreamStateBackoff    = "backoff"
+	StreamStateStopped    = "stopped"
+)
+
+// StreamHealth is the connection health of one exchange account stream
+type StreamHealth struct {
+	AccountKey      string     `json:"account_key"`
+	UserID          uint       `json:"user_id"`
+	Exchange        string     `json:"exchange"`
+	TradingMode     string     `json:"trading_mode"`
+	ExchangeKeyID   uint       `json:"exchange_key_id,omitempty"`
+	BotConfigIDs    []uint     `json:"bot_config_ids,omitempty"`
+	State           string     `json:"state"`
+	ConnectedAt     *time.Time `json:"connected_at,omitempty"`
+	LastEventAt     *time.Time `json:"last_event_at,omitempty"`
+	LastKeepAliveAt *time.Time `json:"last_keep_alive_at,omitempty"`
+	LastResyncAt    *time.Time `json:"last_resync_at,omitempty"`
+	NextRetryAt     *time.Time `json:"next_retry_at,omitempty"`
+	Events          int64      `json:"events"`
+	Reconnects      int        `json:"reconnects"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// streamAccount là 1 exchange account cần giữ stream (gộp exchange key + các bot dùng chung API key)
+type streamAccount struct {
+	key           string
+	userID        uint
+	exchange      string
+	tradingMode   string
+	apiKey        string
+	exchangeKeyID uint
+	botConfigIDs  []uint
+}
+
+type managedStream struct {
+	account streamAccount
+	stop    chan struct{}
+
+	mu     sync.Mutex
+	health StreamHealth
+}
+
+// StreamManager keeps one authenticated user data stream per active exchange account, independent of browser sessions.
+// Tự tạo / gia hạn listen key, reconnect với exponential backoff và resync qua REST sau mỗi lần kết nối.
+type StreamManager struct {
+	DB           *gorm.DB
+	WebSocketHub *WebSocketHub
+
+	resync func(accountKey string) (OrderMonitorRun, error)
+
+	mu       sync.RWMutex
+	streams  map[string]*managedStream
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// NewStreamManager creates a stream manager; events are processed and broadcast through wsHub
+func NewStreamManager(db *gorm.DB, wsHub *WebSocketHub) *StreamManager {
+	return &StreamManager{
+		DB:           db,
+		WebSocketHub: wsHub,
+		streams:      make(map[string]*managedStream),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// SetResync sets the REST resync run after a stream (re)connects (vd: OrderMonitorService.ResyncAccount)
+func (m *StreamManager) SetResync(resync func(accountKey string) (OrderMonitorRun, error)) {
+	m.resync = resync
+}
+
+// Start loads the active accounts and keeps their streams in sync with the database
+func (m *StreamManager) Start() {
+	log.Println("🔌 Stream Manager started - one user data stream per active exchange key")
+	go func() {
+		m.refresh()
+		ticker := time.NewTicker(userStreamRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.refresh()
+			case <-m.stopChan:
+				m.mu.Lock()
+				for key, s := range m.streams {
+					close(s.stop)
+					delete(m.streams, key)
+				}
+				m.mu.Unlock()
+				log.Println("⏹️  Stream Manager stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop closes all streams
+func (m *StreamManager) Stop() {
+	m.stopOnce.Do(func() { close(m.stopChan) })
+}
+
+// IsStreamLive implements UserStreamCoverage
+func (m *StreamManager) IsStreamLive(accountKey string) bool {
+	m.mu.RLock()
+	s, ok := m.streams[accountKey]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health.State == StreamStateConnected
+}
+
+// Health returns the health of every managed stream (userID = 0 → tất cả user)
+func (m *StreamManager) Health(userID uint) []StreamHealth {
+	m.mu.RLock()
+	result := make([]StreamHealth, 0, len(m.streams))
+	for _, s := range m.streams {
+		s.mu.Lock()
+		h := s.health
+		s.mu.Unlock()
+		if userID == 0 || h.UserID == userID {
+			result = append(result, h)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UserID != result[j].UserID {
+			return result[i].UserID < result[j].UserID
+		}
+		return result[i].AccountKey < result[j].AccountKey
+	})
+	return result
+}
+
+// refresh mở stream cho account mới và đóng stream của account không còn active
+func (m *StreamManager) refresh() {
+	accounts, err := loadExchangeAccounts(m.DB)
+	if err != nil {
+		log.Printf("❌ Stream Manager: failed to load exchange accounts: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, s := range m.streams {
+		if _, ok := accounts[key]; !ok {
+			log.Printf("🔌 Stream %s no longer active, closing", key)
+			close(s.stop)
+			delete(m.streams, key)
+		}
+	}
+	for key, account := range accounts {
+		if existing, ok := m.streams[key]; ok {
+			existing.mu.Lock()
+			existing.health.BotConfigIDs = account.botConfigIDs
+			existing.mu.Unlock()
+			continue
+		}
+		s := &managedStream{
+			account: account,
+			stop:    make(chan struct{}),
+			health: StreamHealth{
+				AccountKey:    key,
+				UserID:        account.userID,
+				Exchange:      account.exchange,
+				TradingMode:   account.tradingMode,
+				ExchangeKeyID: account.exchangeKeyID,
+				BotConfigIDs:  account.botConfigIDs,
+				State:         StreamStateConnecting,
+			},
+		}
+		m.streams[key] = s
+		go m.run(s)
+	}
+}
+
+// loadExchangeAccounts gom exchange key và bot đang active theo AccountStreamKey (chỉ Binance spot/futures)
+func loadExchangeAccounts(db *gorm.DB) (map[string]streamAccount, error) {
+	accounts := make(map[string]streamAccount)
+
+	var keys []models.ExchangeKey
+	if err := db.Where("is_active = ? AND LOWER(exchange) = ?", true, "binance").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		mode := normalizeStreamMode(keys[i].TradingMode)
+		if mode == "" {
+			continue
+		}
+		apiKey, _ := exchangeKeyCredentials(&keys[i])
+		if apiKey == "" {
+			continue
+		}
+		key := AccountStreamKey("binance", mode, apiKey)
+		accounts[key] = streamAccount{
+			key: key, userID: keys[i].UserID, exchange: "binance", tradingMode: mode,
+			apiKey: apiKey, exchangeKeyID: keys[i].ID,
+		}
+	}
+
+	var configs []models.TradingConfig
+	if err := db.Where("is_active = ? AND LOWER(exchange) = ?", true, "binance").Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		mode := normalizeStreamMode(configs[i].TradingMode)
+		if mode == "" {
+			continue
+		}
+		apiKey, err := utils.DecryptString(configs[i].APIKey)
+		if err != nil || apiKey == "" {
+			continue
+		}
+		key := AccountStreamKey("binance", mode, apiKey)
+		account, ok := accounts[key]
+		if !ok {
+			account = streamAccount{key: key, userID: configs[i].UserID, exchange: "binance", tradingMode: mode, apiKey: apiKey}
+		}
+		account.botConfigIDs = append(account.botConfigIDs, configs[i].ID)
+		accounts[key] = account
+	}
+	return accounts, nil
+}
+
+func normalizeStreamMode(tradingMode string) string {
+	switch strings.ToLower(tradingMode) {
+	case "", "spot":
+		return "spot"
+	case "futures", "future":
+		return "futures"
+	}
+	return "" // margin... chưa hỗ trợ user data stream
+}
+
+// exchangeKeyCredentials trả về API key/secret của ExchangeKey.
+// Key có thể chưa được mã hoá (xem controllers.DecryptExchangeKey) nên giải mã lỗi thì dùng giá trị gốc.
+func exchangeKeyCredentials(key *models.ExchangeKey) (string, string) {
+	apiKey, apiSecret := key.APIKey, key.APISecret
+	if decrypted, err := utils.DecryptString(key.APIKey); err == nil && decrypted != "" {
+		apiKey = decrypted
+	}
+	if decrypted, err := utils.DecryptString(key.APISecret); err == nil && decrypted != "" {
+		apiSecret = decrypted
+	}
+	return apiKey, apiSecret
+}
+
+// run giữ kết nối của 1 stream cho tới khi bị đóng; lỗi → backoff rồi kết nối lại
+func (m *StreamManager) run(s *managedStream) {
+	adapter := GetExchangeAdapter(s.account.exchange, false).(*BinanceAdapter)
+	failures := 0
+
+	for {
+		select {
+		case <-s.stop:
+			s.setState(StreamStateStopped)
+			return
+		default:
+		}
+
+		s.setState(StreamStateConnecting)
+		connected, err := m.session(s, adapter)
+		if err == nil {
+			s.setState(StreamStateStopped) // Dừng chủ động
+			return
+		}
+
+		if connected {
+			failures = 0
+		}
+		failures++
+		delay := userStreamBackoff(failures)
+		retryAt := time.Now().Add(delay)
+		s.mu.Lock()
+		s.health.State = StreamStateBackoff
+		s.health.LastError = err.Error()
+		s.health.NextRetryAt = &retryAt
+		s.health.Reconnects++
+		s.mu.Unlock()
+		log.Printf("⚠️  Stream %s: %v (retry in %s)", s.account.key, err, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			s.setState(StreamStateStopped)
+			return
+		}
+	}
+}
+
+// session tạo listen key, kết nối và đọc message cho tới khi lỗi (trả về lỗi) hoặc bị dừng (trả về nil).
+// connected = đã kết nối được trước khi lỗi.
+func (m *StreamManager) session(s *managedStream, adapter *BinanceAdapter) (connected bool, err error) {
+	account := s.account
+	listenKey, err := adapter.CreateUserStreamKey(account.apiKey, account.tradingMode)
+	if err != nil {
+		return false, fmt.Errorf("create listen key: %w", err)
+	}
+	defer adapter.CloseUserStreamKey(account.apiKey, account.tradingMode, listenKey)
+
+	conn, _, err := websocket.DefaultDialer.Dial(adapter.GetWSURL(account.tradingMode, listenKey), nil)
+	if err != nil {
+		return false, fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+
+	now := time.Now()
+	s.mu.Lock()
+	s.health.State = StreamStateConnected
+	s.health.ConnectedAt = &now
+	s.health.NextRetryAt = nil
+	s.health.LastError = ""
+	s.mu.Unlock()
+	log.Printf("🔌 Stream %s connected (%s)", account.key, account.tradingMode)
+
+	// Sự kiện trước khi kết nối (server khởi động lại, mất kết nối) bị lỡ → resync trạng thái lệnh qua REST
+	// sau mỗi lần kết nối, kể cả lần đầu (OrderMonitor bỏ qua account khi stream đang live)
+	if m.resync != nil {
+		go func() {
+			run, err := m.resync(account.key)
+			resyncAt := time.Now()
+			s.mu.Lock()
+			s.health.LastResyncAt = &resyncAt
+			s.mu.Unlock()
+			if err != nil {
+				log.Printf("⚠️  Stream %s: resync failed: %v", account.key, err)
+				return
+			}
+			log.Printf("🔄 Stream %s resynced: %d checked, %d updated", account.key, run.Checked, run.Updated)
+		}()
+	}
+
+	exchConn := &ExchangeConnection{
+		ExchangeKeyID: account.exchangeKeyID,
+		UserID:        account.userID,
+		Exchange:      account.exchange,
+		TradingMode:   account.tradingMode,
+		ListenKey:     listenKey,
+		Conn:          conn,
+		AccountKey:    account.key,
+	}
+
+	conn.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+
+			var message map[string]interface{}
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+			eventAt := time.Now()
+			s.mu.Lock()
+			s.health.LastEventAt = &eventAt
+			s.health.Events++
+			s.mu.Unlock()
+
+			if getStringValue(message, "e") == "listenKeyExpired" {
+				readErr <- fmt.Errorf("listen key expired")
+				return
+			}
+			if m.WebSocketHub != nil {
+				m.WebSocketHub.processExchangeMessage(exchConn, message)
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(listenKeyKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			return true, fmt.Errorf("read: %w", err)
+		case <-keepAlive.C:
+			if err := adapter.KeepAliveUserStreamKey(account.apiKey, account.tradingMode, listenKey); err != nil {
+				return true, fmt.Errorf("keep alive listen key: %w", err)
+			}
+			keptAt := time.Now()
+			s.mu.Lock()
+			s.health.LastKeepAliveAt = &keptAt
+			s.mu.Unlock()
+		case <-s.stop:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			return true, nil
+		}
+	}
+}
+
+func (s *managedStream) setState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health.State = state
+}
+
+// userStreamBackoff: 1s·2^(n-1), tối đa 5 phút, cộng thêm tối đa 20% jitter
+func userStreamBackoff(failures int) time.Duration {
+	delay := userStreamBackoffBase
+	for i := 1; i < failures && delay < userStreamBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > userStreamBackoffMax {
+		delay = userStreamBackoffMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUserStreamBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration // delay trước jitter
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{9, 256 * time.Second},
+		{10, userStreamBackoffMax},
+		{100, userStreamBackoffMax},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("failures=%d", tt.failures), func(t *testing.T) {
+			maxJitter := tt.want / 5
+			for i := 0; i < 20; i++ {
+				got := userStreamBackoff(tt.failures)
+				if got < tt.want || got > tt.want+maxJitter {
+					t.Fatalf("userStreamBackoff(%d) = %s, want between %s and %s", tt.failures, got, tt.want, tt.want+maxJitter)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 	"tradercoin/backend/utils"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -476,7 +478,7 @@ func (s *TelegramService) HandleUpdates(botToken string) error {
 				}
 
 				// Đặt lệnh qua hàm PlaceOrderFromTelegram
-				orderResult, err := s.PlaceOrderFromTelegram(userID, symbol, side, "market", 0, 0)
+				orderResult, err := s.PlaceOrderFromTelegram(userID, symbol, side, "market", 0, 0, callback.ID)
 				if err != nil {
 					responseText := fmt.Sprintf("❌ Lỗi đặt lệnh %s %s:\n<code>%v</code>", side, symbol, err)
 					msg := tgbotapi.NewMessage(callback.Message.Chat.ID, responseText)
@@ -498,9 +500,13 @@ func (s *TelegramService) HandleUpdates(botToken string) error {
 				responseText += fmt.Sprintf("Status: <b>%s</b>\n", orderResult.Status)
 				responseText += fmt.Sprintf("Order ID: <code>%s</code>", orderResult.OrderID)
 
-				// Send confirmation message
+				// Send confirmation message (kèm nút đóng một phần nếu lệnh đã gắn vào vị thế futures)
 				msg := tgbotapi.NewMessage(callback.Message.Chat.ID, responseText)
 				msg.ParseMode = "HTML"
+				var placed models.Order
+				if err := s.db.Where("user_id = ? AND order_id = ?", userID, orderResult.OrderID).First(&placed).Error; err == nil && placed.PositionID != nil {
+					msg.ReplyMarkup = BuildPartialCloseKeyboard(*placed.PositionID)
+				}
 				bot.Send(msg)
 
 				// Answer callback query (tắt loading indicator)
@@ -508,6 +514,50 @@ func (s *TelegramService) HandleUpdates(botToken string) error {
 				bot.Request(callbackConfig)
 
 				log.Printf("✅ Order placed: %s %s - OrderID: %s", side, symbol, orderResult.OrderID)
+			} else if len(parts) == 3 && parts[0] == "pclose" {
+				// Đóng một phần vị thế: pclose_<positionID>_<percent>
+				positionID, errID := strconv.ParseUint(parts[1], 10, 64)
+				percent, errPct := decimal.NewFromString(parts[2])
+				if errID != nil || errPct != nil {
+					callbackConfig := tgbotapi.NewCallback(callback.ID, "❌ Invalid command")
+					bot.Request(callbackConfig)
+					continue
+				}
+
+				userID, err := s.getUserIDFromChatID(callback.From.ID)
+				if err != nil {
+					callbackConfig := tgbotapi.NewCallback(callback.ID, "❌ User not found")
+					bot.Request(callbackConfig)
+					continue
+				}
+
+				result, err := s.PartialCloseFromTelegram(userID, uint(positionID), percent, callback.ID)
+				if err != nil {
+					responseText := fmt.Sprintf("❌ Lỗi đóng %s%% vị thế #%d:\n<code>%v</code>", percent, positionID, err)
+					msg := tgbotapi.NewMessage(callback.Message.Chat.ID, responseText)
+					msg.ParseMode = "HTML"
+					bot.Send(msg)
+
+					callbackConfig := tgbotapi.NewCallback(callback.ID, "❌ Lỗi đóng vị thế")
+					bot.Request(callbackConfig)
+					continue
+				}
+
+				responseText := "✂️ <b>Đã đóng một phần vị thế!</b>\n\n"
+				responseText += fmt.Sprintf("Symbol: <b>%s %s</b>\n", result.Position.Symbol, result.Position.Side)
+				responseText += fmt.Sprintf("Closed: <b>%s</b> (%s%%)\n", result.ClosedQuantity, percent)
+				responseText += fmt.Sprintf("Price: <b>%v</b>\n", result.Order.FilledPrice)
+				responseText += fmt.Sprintf("Remaining: <b>%s</b>\n", result.RemainingQuantity)
+				responseText += fmt.Sprintf("Est. PnL: <b>%s</b>\n", result.Order.PnL.StringFixed(4))
+				responseText += fmt.Sprintf("Order ID: <code>%s</code>", result.Order.OrderID)
+
+				msg := tgbotapi.NewMessage(callback.Message.Chat.ID, responseText)
+				msg.ParseMode = "HTML"
+				msg.ReplyMarkup = BuildPartialCloseKeyboard(result.Position.ID)
+				bot.Send(msg)
+
+				callbackConfig := tgbotapi.NewCallback(callback.ID, fmt.Sprintf("✅ Closed %s%%", percent))
+				bot.Request(callbackConfig)
 			} else {
 				// Unknown callback data
 				callbackConfig := tgbotapi.NewCallback(callback.ID, "❌ Unknown command")
@@ -537,8 +587,9 @@ func (s *TelegramService) getUserIDFromChatID(telegramUserID int64) (uint, error
 	return config.ID, nil
 }
 
-// PlaceOrderFromTelegram đặt lệnh từ Telegram bot
-func (s *TelegramService) PlaceOrderFromTelegram(userID uint, symbol, side, orderType string, amount, price float64) (*OrderResult, error) {
+// PlaceOrderFromTelegram đặt lệnh từ Telegram bot.
+// requestRef (callback query ID) dùng để sinh client order ID, tránh đặt trùng khi Telegram gửi lại update.
+func (s *TelegramService) PlaceOrderFromTelegram(userID uint, symbol, side, orderType string, amount, price float64, requestRef string) (*OrderResult, error) {
 	// Lấy bot config đầu tiên của user (hoặc có thể lấy theo default)
 	var config models.TradingConfig
 	err := s.db.Where("user_id = ? AND is_active = ? AND symbol = ? AND is_default = ?", userID, true, symbol, true).
@@ -574,8 +625,20 @@ func (s *TelegramService) PlaceOrderFromTelegram(userID uint, symbol, side, orde
 	}
 
 	// Tạo trading service và đặt lệnh
+	clientOrderID := ""
+	if requestRef != "" {
+		clientOrderID = GenerateClientOrderID(userID, config.ID, OrderSourceTelegram, requestRef)
+	}
+
+	// Serialize với signal/manual order trên cùng API key + symbol
+	release, err := ExecutionLocks().Acquire(ExecutionLockKey(config.Exchange, apiKey, symbol), fmt.Sprintf("telegram:user:%d", userID), DefaultLockWaitTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("đang có lệnh khác xử lý cho %s, vui lòng thử lại", symbol)
+	}
+	defer release()
+
 	tradingService := NewTradingService(apiKey, apiSecret, config.Exchange, s.db, userID)
-	orderResult := tradingService.PlaceOrder(&config, side, orderType, symbol, amount, price)
+	orderResult := tradingService.PlaceOrder(&config, side, orderType, symbol, decimal.NewFromFloat(amount), decimal.NewFromFloat(price), clientOrderID)
 
 	if !orderResult.Success {
 		errorMsg := orderResult.Error
@@ -586,25 +649,8 @@ func (s *TelegramService) PlaceOrderFromTelegram(userID uint, symbol, side, orde
 	}
 
 	// Tính toán SL/TP prices
-	var stopLoss, takeProfit float64
-	filledPrice := orderResult.FilledPrice
-	if filledPrice > 0 {
-		if config.StopLossPercent > 0 {
-			if side == "buy" {
-				stopLoss = filledPrice * (1 - config.StopLossPercent/100)
-			} else {
-				stopLoss = filledPrice * (1 + config.StopLossPercent/100)
-			}
-		}
-
-		if config.TakeProfitPercent > 0 {
-			if side == "buy" {
-				takeProfit = filledPrice * (1 + config.TakeProfitPercent/100)
-			} else {
-				takeProfit = filledPrice * (1 - config.TakeProfitPercent/100)
-			}
-		}
-	}
+	stopLoss := CalculateStopLossPrice(side, orderResult.FilledPrice, config.StopLossPercent)
+	takeProfit := CalculateTakeProfitPrice(side, orderResult.FilledPrice, config.TakeProfitPercent)
 
 	// Lưu order vào database
 	order := models.Order{
@@ -613,33 +659,67 @@ func (s *TelegramService) PlaceOrderFromTelegram(userID uint, symbol, side, orde
 		Exchange:         config.Exchange,
 		Symbol:           orderResult.Symbol,
 		OrderID:          orderResult.OrderID,
+		ClientOrderID:    clientOrderID,
 		Side:             orderResult.Side,
 		Type:             orderResult.Type,
 		Quantity:         orderResult.Quantity,
 		Price:            orderResult.Price,
 		FilledPrice:      orderResult.FilledPrice,
-		Status:           orderResult.Status,
+		Status:           NormalizeOrderStatus(orderResult.Status),
 		TradingMode:      config.TradingMode,
 		Leverage:         config.Leverage,
 		StopLossPrice:    stopLoss,
 		TakeProfitPrice:  takeProfit,
 		AlgoIDStopLoss:   orderResult.AlgoIDStopLoss,
 		AlgoIDTakeProfit: orderResult.AlgoIDTakeProfit,
-		PnL:              0,
-		PnLPercent:       0,
 	}
 
 	if err := s.db.Create(&order).Error; err != nil {
 		log.Printf("⚠️ Lỗi lưu order vào database: %v", err)
 		// Không return error vì order đã được đặt thành công trên exchange
+	} else {
+		RecordOrderCreated(s.db, &order, OrderSourceTelegram, OrderActorUser(order.UserID), orderResult)
+		if _, err := LinkEntryOrder(s.db, &order); err != nil {
+			log.Printf("⚠️ Order %d: lỗi gắn vị thế: %v", order.ID, err)
+		}
 	}
 
-	log.Printf("✅ Order từ Telegram đã được đặt: OrderID=%s, Symbol=%s, Side=%s, Amount=%f",
+	log.Printf("✅ Order từ Telegram đã được đặt: OrderID=%s, Symbol=%s, Side=%s, Amount=%s",
 		orderResult.OrderID, orderResult.Symbol, orderResult.Side, orderResult.Quantity)
 
 	return &orderResult, nil
 }
 
+// PartialCloseFromTelegram đóng percent% vị thế của user bằng lệnh reduce-only MARKET.
+// requestRef (callback query ID) tránh đóng 2 lần khi Telegram gửi lại update.
+func (s *TelegramService) PartialCloseFromTelegram(userID, positionID uint, percent decimal.Decimal, requestRef string) (*PartialCloseResult, error) {
+	var position models.Position
+	if err := s.db.Where("id = ? AND user_id = ?", positionID, userID).First(&position).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("không tìm thấy vị thế #%d", positionID)
+		}
+		return nil, fmt.Errorf("lỗi truy vấn database: %w", err)
+	}
+
+	result, err := PartialClosePosition(s.db, &position, PartialCloseParams{Percent: percent, OrderType: "market"},
+		OrderSourceTelegram, OrderActorUser(userID), requestRef)
+	if errors.Is(err, ErrLockTimeout) {
+		return nil, fmt.Errorf("đang có lệnh khác xử lý cho %s, vui lòng thử lại", position.Symbol)
+	}
+	return result, err
+}
+
+// BuildPartialCloseKeyboard trả về các nút đóng 25% / 50% / 75% vị thế (callback: pclose_<positionID>_<percent>)
+func BuildPartialCloseKeyboard(positionID uint) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✂️ 25%", fmt.Sprintf("pclose_%d_25", positionID)),
+			tgbotapi.NewInlineKeyboardButtonData("✂️ 50%", fmt.Sprintf("pclose_%d_50", positionID)),
+			tgbotapi.NewInlineKeyboardButtonData("✂️ 75%", fmt.Sprintf("pclose_%d_75", positionID)),
+		),
+	)
+}
+
 // BuildTradeLabels nhận symbol (vd: DOGEUSDT) và side (vd: BUY)
 // trả về:
 //   - prettySymbol: "DOGE/USDT BUY"
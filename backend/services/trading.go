@@ -9,16 +9,17 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"tradercoin/backend/config"
 	"tradercoin/backend/models"
 	"tradercoin/backend/utils"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -38,21 +39,22 @@ const (
 
 // OrderResult represents the result of placing an order
 type OrderResult struct {
-	Success          bool        `json:"success"`
-	OrderID          string      `json:"order_id"`
-	Symbol           string      `json:"symbol"`
-	Side             string      `json:"side"`
-	Type             string      `json:"type"`
-	Quantity         float64     `json:"quantity"`
-	Price            float64     `json:"price"`
-	FilledPrice      float64     `json:"filled_price"`
-	Status           string      `json:"status"`
-	AlgoIDStopLoss   string      `json:"algo_id_stop_loss,omitempty"`
-	AlgoIDTakeProfit string      `json:"algo_id_take_profit,omitempty"`
-	StopLossPrice    float64     `json:"stop_loss_price,omitempty"`
-	TakeProfitPrice  float64     `json:"take_profit_price,omitempty"`
-	Error            string      `json:"error,omitempty"`
-	ErrorDetails     interface{} `json:"error_details,omitempty"`
+	Success          bool            `json:"success"`
+	OrderID          string          `json:"order_id"`
+	Symbol           string          `json:"symbol"`
+	Side             string          `json:"side"`
+	Type             string          `json:"type"`
+	Quantity         decimal.Decimal `json:"quantity"`
+	Price            decimal.Decimal `json:"price"`
+	FilledPrice      decimal.Decimal `json:"filled_price"`
+	Status           string          `json:"status"`
+	AlgoIDStopLoss   string          `json:"algo_id_stop_loss,omitempty"`
+	AlgoIDTakeProfit string          `json:"algo_id_take_profit,omitempty"`
+	StopLossPrice    decimal.Decimal `json:"stop_loss_price,omitempty"`
+	TakeProfitPrice  decimal.Decimal `json:"take_profit_price,omitempty"`
+	ClientOrderID    string          `json:"client_order_id,omitempty"`
+	Error            string          `json:"error,omitempty"`
+	ErrorDetails     interface{}     `json:"error_details,omitempty"`
 }
 
 // NewTradingService creates a new trading service instance
@@ -66,12 +68,15 @@ func NewTradingService(apiKey, apiSecret, exchange string, db *gorm.DB, userID u
 	}
 }
 
-// GetCurrentPrice lấy giá hiện tại của symbol từ Binance
-func (ts *TradingService) GetCurrentPrice(config *models.TradingConfig, symbol string) (float64, error) {
+// GetCurrentPrice lấy giá hiện tại của symbol (price cache từ market data stream, fallback REST Binance)
+func (ts *TradingService) GetCurrentPrice(config *models.TradingConfig, symbol string) (decimal.Decimal, error) {
 	tradingMode := config.TradingMode
 	if tradingMode == "" {
 		tradingMode = "spot"
 	}
+	if price, ok := MarketData().Price(tradingMode, symbol); ok {
+		return price, nil
+	}
 
 	isTestnet := false
 	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
@@ -90,7 +95,7 @@ func (ts *TradingService) GetCurrentPrice(config *models.TradingConfig, symbol s
 
 	resp, err := http.Get(fullURL)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get price: %w", err)
+		return decimal.Zero, fmt.Errorf("failed to get price: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -101,19 +106,24 @@ func (ts *TradingService) GetCurrentPrice(config *models.TradingConfig, symbol s
 
 	body, _ := io.ReadAll(resp.Body)
 	if err := json.Unmarshal(body, &priceResp); err != nil {
-		return 0, fmt.Errorf("failed to parse price: %w", err)
+		return decimal.Zero, fmt.Errorf("failed to parse price: %w", err)
 	}
 
-	price, err := strconv.ParseFloat(priceResp.Price, 64)
+	price, err := decimal.NewFromString(priceResp.Price)
 	if err != nil {
-		return 0, fmt.Errorf("invalid price format: %w", err)
+		return decimal.Zero, fmt.Errorf("invalid price format: %w", err)
 	}
+	MarketData().Observe(tradingMode, symbol, price)
 
 	return price, nil
 }
 
-// GetMarkPrice lấy mark price cho Futures (dùng để validate SL/TP)
-func (ts *TradingService) GetMarkPrice(symbol string) (float64, error) {
+// GetMarkPrice lấy mark price cho Futures (dùng để validate SL/TP), ưu tiên price cache
+func (ts *TradingService) GetMarkPrice(symbol string) (decimal.Decimal, error) {
+	if mark, ok := MarketData().MarkPrice(symbol); ok {
+		return mark, nil
+	}
+
 	isTestnet := false
 	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
 
@@ -121,7 +131,7 @@ func (ts *TradingService) GetMarkPrice(symbol string) (float64, error) {
 
 	resp, err := http.Get(fullURL)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get mark price: %w", err)
+		return decimal.Zero, fmt.Errorf("failed to get mark price: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -132,66 +142,154 @@ func (ts *TradingService) GetMarkPrice(symbol string) (float64, error) {
 
 	body, _ := io.ReadAll(resp.Body)
 	if err := json.Unmarshal(body, &markPriceResp); err != nil {
-		return 0, fmt.Errorf("failed to parse mark price: %w", err)
+		return decimal.Zero, fmt.Errorf("failed to parse mark price: %w", err)
 	}
 
-	markPrice, err := strconv.ParseFloat(markPriceResp.MarkPrice, 64)
+	markPrice, err := decimal.NewFromString(markPriceResp.MarkPrice)
 	if err != nil {
-		return 0, fmt.Errorf("invalid mark price format: %w", err)
+		return decimal.Zero, fmt.Errorf("invalid mark price format: %w", err)
 	}
+	MarketData().ObserveMark(symbol, markPrice)
 
 	return markPrice, nil
 }
 
-// FormatPriceByTickSize làm tròn giá theo tickSize của symbol
+// fallbackTickSizes là tickSize của các symbol phổ biến, dùng khi không lấy được exchangeInfo
 // DOGEUSDT: tickSize=0.00001 → 5 decimals
 // ETHUSDT: tickSize=0.01 → 2 decimals
 // BTCUSDT: tickSize=0.1 → 1 decimal
-func (ts *TradingService) FormatPriceByTickSize(symbol string, price float64) string {
-	// Map common symbols to their tick sizes
-	tickSizeMap := map[string]float64{
-		"BTCUSDT":   0.1,
-		"ETHUSDT":   0.01,
-		"BNBUSDT":   0.01,
-		"DOGEUSDT":  0.00001,
-		"ADAUSDT":   0.00001,
-		"XRPUSDT":   0.0001,
-		"SOLUSDT":   0.001,
-		"DOTUSDT":   0.001,
-		"MATICUSDT": 0.0001,
-		"SHIBUSDT":  0.00000001,
-	}
-
-	tickSize, exists := tickSizeMap[symbol]
-	if !exists {
-		// Default: 8 decimals for unknown symbols
-		return fmt.Sprintf("%.8f", price)
-	}
-
-	// Calculate precision from tickSize
-	precision := 0
-	temp := tickSize
-	for temp < 1 {
-		precision++
-		temp *= 10
-	}
-
-	// Round to tick size
-	rounded := math.Round(price/tickSize) * tickSize
-
-	// Format with correct precision
-	format := fmt.Sprintf("%%.%df", precision)
-	return fmt.Sprintf(format, rounded)
+var fallbackTickSizes = map[string]string{
+	"BTCUSDT":   "0.1",
+	"ETHUSDT":   "0.01",
+	"BNBUSDT":   "0.01",
+	"DOGEUSDT":  "0.00001",
+	"ADAUSDT":   "0.00001",
+	"XRPUSDT":   "0.0001",
+	"SOLUSDT":   "0.001",
+	"DOTUSDT":   "0.001",
+	"MATICUSDT": "0.0001",
+	"SHIBUSDT":  "0.00000001",
+}
+
+// symbolLotInfo là thông tin LOT_SIZE / PRICE_FILTER của symbol (dùng để làm tròn khối lượng và giá gửi lên sàn)
+type symbolLotInfo struct {
+	BaseAsset string
+	StepSize  decimal.Decimal
+	TickSize  decimal.Decimal
+}
+
+// symbolLotCache cache exchangeInfo theo "mode:symbol" (thông tin này gần như không đổi)
+var symbolLotCache sync.Map
+
+// getSymbolLotInfo lấy base asset, stepSize và tickSize của symbol từ exchangeInfo (spot hoặc futures)
+func (ts *TradingService) getSymbolLotInfo(tradingMode, symbol string) (symbolLotInfo, error) {
+	tradingMode = marketOf(tradingMode)
+	cacheKey := tradingMode + ":" + symbol
+	if cached, ok := symbolLotCache.Load(cacheKey); ok {
+		return cached.(symbolLotInfo), nil
+	}
+
+	isTestnet := false
+	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
+
+	var fullURL string
+	if tradingMode == "futures" {
+		// Futures exchangeInfo không lọc theo symbol
+		fullURL = adapter.FuturesAPIURL + "/fapi/v1/exchangeInfo"
+	} else {
+		fullURL = fmt.Sprintf("%s/api/v3/exchangeInfo?symbol=%s", adapter.SpotAPIURL, symbol)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(fullURL)
+	if err != nil {
+		return symbolLotInfo{}, fmt.Errorf("failed to get exchange info: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return symbolLotInfo{}, fmt.Errorf("exchangeInfo failed (status %d)", resp.StatusCode)
+	}
+
+	var info struct {
+		Symbols []struct {
+			Symbol    string `json:"symbol"`
+			BaseAsset string `json:"baseAsset"`
+			Filters   []struct {
+				FilterType string `json:"filterType"`
+				StepSize   string `json:"stepSize"`
+				TickSize   string `json:"tickSize"`
+			} `json:"filters"`
+		} `json:"symbols"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return symbolLotInfo{}, fmt.Errorf("failed to parse exchange info: %w", err)
+	}
+
+	for _, s := range info.Symbols {
+		if s.Symbol != symbol {
+			continue
+		}
+		lot := symbolLotInfo{BaseAsset: s.BaseAsset}
+		for _, f := range s.Filters {
+			switch f.FilterType {
+			case "LOT_SIZE":
+				lot.StepSize = ParseDecimal(f.StepSize)
+			case "PRICE_FILTER":
+				lot.TickSize = ParseDecimal(f.TickSize)
+			}
+		}
+		symbolLotCache.Store(cacheKey, lot)
+		return lot, nil
+	}
+	return symbolLotInfo{}, fmt.Errorf("symbol %s not found in exchange info", symbol)
+}
+
+// roundToTick làm tròn giá tới bội số gần nhất của tickSize
+func roundToTick(price, tick decimal.Decimal) decimal.Decimal {
+	if !tick.IsPositive() {
+		return price.Truncate(quantityDecimals)
+	}
+	return price.Div(tick).Round(0).Mul(tick)
+}
+
+// floorToStep làm tròn xuống khối lượng theo stepSize của sàn
+func floorToStep(quantity, step decimal.Decimal) decimal.Decimal {
+	if !step.IsPositive() {
+		return quantity.Truncate(8)
+	}
+	return quantity.Div(step).Floor().Mul(step)
+}
+
+// FormatPriceByTickSize làm tròn giá theo tickSize (PRICE_FILTER) của symbol trên spot / futures
+func (ts *TradingService) FormatPriceByTickSize(tradingMode, symbol string, price decimal.Decimal) string {
+	tick := decimal.Zero
+	if lot, err := ts.getSymbolLotInfo(tradingMode, symbol); err == nil {
+		tick = lot.TickSize
+	}
+	if !tick.IsPositive() {
+		tick = ParseDecimal(fallbackTickSizes[symbol])
+	}
+	return FormatDecimal(roundToTick(price, tick))
+}
+
+// FormatQuantityByStepSize làm tròn xuống khối lượng theo stepSize (LOT_SIZE) của symbol trên spot / futures
+func (ts *TradingService) FormatQuantityByStepSize(tradingMode, symbol string, quantity decimal.Decimal) string {
+	step := decimal.Zero
+	if lot, err := ts.getSymbolLotInfo(tradingMode, symbol); err == nil {
+		step = lot.StepSize
+	}
+	return FormatDecimal(floorToStep(quantity, step))
 }
 
 // ValidateNotional kiểm tra minimum notional cho Binance Futures
-func (ts *TradingService) ValidateNotional(config *models.TradingConfig, symbol string, quantity, price float64) error {
+func (ts *TradingService) ValidateNotional(config *models.TradingConfig, symbol string, quantity, price decimal.Decimal) error {
 	if config.TradingMode != "futures" {
 		return nil // Spot không cần validate notional
 	}
 
 	// Nếu là MARKET order hoặc chưa có price, lấy giá hiện tại
-	if price == 0 {
+	if price.IsZero() {
 		currentPrice, err := ts.GetCurrentPrice(config, symbol)
 		if err != nil {
 			return fmt.Errorf("failed to get current price: %w", err)
@@ -200,7 +298,7 @@ func (ts *TradingService) ValidateNotional(config *models.TradingConfig, symbol
 	}
 
 	// Tính notional value
-	notional := quantity * price
+	notional := quantity.Mul(price)
 
 	// Kiểm tra minimum
 	// if notional < MinNotionalUSDT {
@@ -213,18 +311,34 @@ func (ts *TradingService) ValidateNotional(config *models.TradingConfig, symbol
 	// }
 
 	fmt.Printf("✅ NOTIONAL VALIDATION PASSED:\n")
-	fmt.Printf("   Quantity: %.8f\n", quantity)
-	fmt.Printf("   Price: $%.2f\n", price)
-	fmt.Printf("   Notional: $%.2f (minimum: $%.2f)\n\n", notional, MinNotionalUSDT)
+	fmt.Printf("   Quantity: %s\n", quantity)
+	fmt.Printf("   Price: $%s\n", price)
+	fmt.Printf("   Notional: $%s (minimum: $%.2f)\n\n", notional.StringFixed(2), MinNotionalUSDT)
 
 	return nil
 }
 
-// PlaceOrder places an order on the exchange
-func (ts *TradingService) PlaceOrder(config *models.TradingConfig, side, orderType, symbol string, amount, price float64) OrderResult {
+// PlaceOrder places an order on the exchange.
+// clientOrderID (xem GenerateClientOrderID) dùng để chống đặt trùng lệnh; để trống nếu không cần.
+func (ts *TradingService) PlaceOrder(config *models.TradingConfig, side, orderType, symbol string, amount, price decimal.Decimal, clientOrderID string) OrderResult {
+	// Từ chối nếu client order ID đã có order trong DB (webhook/callback gửi lại)
+	if existing, found := ts.findExistingOrderByClientID(clientOrderID); found {
+		fmt.Printf("⛔ Duplicate order refused: client order ID %s already used by order #%d\n", clientOrderID, existing.ID)
+		if ts.DB != nil && ts.UserID > 0 {
+			utils.CreateSystemLog(ts.DB, ts.UserID, utils.LogLevelWarning, "ORDER_DUPLICATE",
+				fmt.Sprintf("Refused duplicate %s order for %s (client order ID %s)", strings.ToUpper(side), symbol, clientOrderID),
+				map[string]interface{}{
+					"symbol":   symbol,
+					"exchange": strings.ToUpper(ts.Exchange),
+					"order_id": existing.ID,
+				})
+		}
+		return duplicateOrderResult(existing)
+	}
+
 	switch ts.Exchange {
 	case "binance":
-		return ts.placeBinanceOrder(config, side, orderType, symbol, amount, price)
+		return ts.placeBinanceOrder(config, side, orderType, symbol, amount, price, clientOrderID)
 	case "bittrex":
 		return ts.placeBittrexOrder(config, side, orderType, symbol, amount, price)
 	default:
@@ -236,11 +350,11 @@ func (ts *TradingService) PlaceOrder(config *models.TradingConfig, side, orderTy
 }
 
 // placeBinanceOrder places an order on Binance
-func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side, orderType, symbol string, amount, price float64) OrderResult {
+func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side, orderType, symbol string, amount, price decimal.Decimal, clientOrderID string) OrderResult {
 	// Log order initiation
 	if ts.DB != nil && ts.UserID > 0 {
 		utils.CreateSystemLog(ts.DB, ts.UserID, utils.LogLevelInfo, "ORDER_INITIATED",
-			fmt.Sprintf("Initiating %s %s order for %s (%.8f @ %.8f)", strings.ToUpper(side), strings.ToUpper(orderType), symbol, amount, price),
+			fmt.Sprintf("Initiating %s %s order for %s (%s @ %s)", strings.ToUpper(side), strings.ToUpper(orderType), symbol, FormatDecimal(amount), FormatDecimal(price)),
 			map[string]interface{}{
 				"symbol":   symbol,
 				"exchange": strings.ToUpper(ts.Exchange),
@@ -257,6 +371,30 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 
 	adapter := GetExchangeAdapter("binance", isTestnet).(*BinanceAdapter)
 
+	// Retry sau timeout: lệnh với client order ID này có thể đã lên sàn (lần trước sàn đã nhận)
+	// → không đặt lại, và KHÔNG chạy pre-cleanup vì sẽ đóng luôn vị thế vừa mở
+	if body, found, err := ts.lookupUnresolvedOrder(adapter, tradingMode, symbol, clientOrderID); err != nil {
+		return OrderResult{Success: false, ClientOrderID: clientOrderID, Error: err.Error()}
+	} else if found {
+		existing, parseErr := orderResultFromQuery(body)
+		if parseErr != nil {
+			// Lệnh đã có trên sàn nhưng không đọc được response → không được đặt lại (sẽ trùng lệnh)
+			return OrderResult{Success: false, ClientOrderID: clientOrderID,
+				Error: fmt.Sprintf("order %s already exists on exchange but its response could not be parsed: %v", clientOrderID, parseErr)}
+		}
+		fmt.Printf("♻️  Order %s already exists on exchange (OrderID: %s) - skipping placement\n", clientOrderID, existing.OrderID)
+		if ts.DB != nil && ts.UserID > 0 {
+			utils.CreateSystemLog(ts.DB, ts.UserID, utils.LogLevelWarning, "ORDER_RECOVERED",
+				fmt.Sprintf("Order %s for %s already exists on exchange, reusing it", clientOrderID, symbol),
+				map[string]interface{}{
+					"symbol":   symbol,
+					"exchange": strings.ToUpper(ts.Exchange),
+					"details":  map[string]interface{}{"exchange_order_id": existing.OrderID, "status": existing.Status},
+				})
+		}
+		return existing
+	}
+
 	var baseURL string
 	var endpoint string
 	if tradingMode == "futures" {
@@ -322,10 +460,15 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 	} else {
 		params.Set("type", "LIMIT")
 		params.Set("timeInForce", "GTC")
-		params.Set("price", fmt.Sprintf("%.8f", price))
+		params.Set("price", ts.FormatPriceByTickSize(tradingMode, symbol, price))
 	}
 
-	params.Set("quantity", fmt.Sprintf("%.8f", amount))
+	// Làm tròn theo stepSize/tickSize của symbol, tránh -1111 (precision) khi amount chuyển từ float64
+	params.Set("quantity", ts.FormatQuantityByStepSize(tradingMode, symbol, amount))
+
+	if clientOrderID != "" {
+		params.Set("newClientOrderId", clientOrderID)
+	}
 
 	// For Futures: add leverage if configured
 	if tradingMode == "futures" && config.Leverage > 0 {
@@ -359,37 +502,65 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 
 	// Make request
 	client := &http.Client{Timeout: 30 * time.Second}
+	var body []byte
+	statusCode := http.StatusOK
 	resp, err := client.Do(req)
 	if err != nil {
-		return OrderResult{
-			Success:      false,
-			Error:        "Failed to send request to exchange",
-			ErrorDetails: err.Error(),
+		// Timeout/network error: lệnh có thể đã lên sàn → hỏi lại theo client order ID
+		recovered, ok := ts.recoverOrderByClientID(adapter, tradingMode, symbol, clientOrderID)
+		if !ok {
+			return OrderResult{
+				Success:       false,
+				ClientOrderID: clientOrderID,
+				Error:         "Failed to send request to exchange",
+				ErrorDetails:  err.Error(),
+			}
 		}
-	}
-	defer resp.Body.Close()
+		body = recovered
+	} else {
+		defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return OrderResult{
-			Success:      false,
-			Error:        "Failed to read response",
-			ErrorDetails: err.Error(),
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			recovered, ok := ts.recoverOrderByClientID(adapter, tradingMode, symbol, clientOrderID)
+			if !ok {
+				return OrderResult{
+					Success:       false,
+					ClientOrderID: clientOrderID,
+					Error:         "Failed to read response",
+					ErrorDetails:  err.Error(),
+				}
+			}
+			body = recovered
+		} else {
+			statusCode = resp.StatusCode
 		}
 	}
 
 	// Log raw response from exchange
 	fmt.Printf("\n🟡 MAIN ORDER - Exchange Response:\n")
-	fmt.Printf("Status Code: %d\n", resp.StatusCode)
+	fmt.Printf("Status Code: %d\n", statusCode)
 	fmt.Printf("Response Body: %s\n\n", string(body))
 
+	// Sàn trả lỗi mơ hồ (5xx, -1006, -1007): trạng thái lệnh chưa rõ → hỏi lại theo client order ID
+	if statusCode != http.StatusOK {
+		var errorResp map[string]interface{}
+		json.Unmarshal(body, &errorResp)
+		if isAmbiguousOrderFailure(statusCode, errorResp) {
+			if recovered, ok := ts.recoverOrderByClientID(adapter, tradingMode, symbol, clientOrderID); ok {
+				body = recovered
+				statusCode = http.StatusOK
+			}
+		}
+	}
+
 	// Check status code
-	if resp.StatusCode != http.StatusOK {
+	if statusCode != http.StatusOK {
 		var errorResp map[string]interface{}
 		json.Unmarshal(body, &errorResp)
 
 		// Try to get error message from Binance response
-		errorMsg := fmt.Sprintf("Binance API error (status %d)", resp.StatusCode)
+		errorMsg := fmt.Sprintf("Binance API error (status %d)", statusCode)
 		if msg, ok := errorResp["msg"].(string); ok {
 			errorMsg = fmt.Sprintf("%s: %s", errorMsg, msg)
 		}
@@ -402,7 +573,7 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 		// Log error to database
 		if ts.DB != nil && ts.UserID > 0 {
 			detailsMap := map[string]interface{}{
-				"status_code": resp.StatusCode,
+				"status_code": statusCode,
 				"error_code":  errorResp["code"],
 				"error_msg":   errorResp["msg"],
 			}
@@ -416,10 +587,11 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 		}
 
 		return OrderResult{
-			Success: false,
-			Error:   errorMsg,
+			Success:       false,
+			ClientOrderID: clientOrderID,
+			Error:         errorMsg,
 			ErrorDetails: map[string]interface{}{
-				"status_code": resp.StatusCode,
+				"status_code": statusCode,
 				"response":    errorResp,
 				"raw_body":    string(body),
 			},
@@ -453,17 +625,24 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 	}
 
 	// Calculate filled price from fills
-	filledPrice := 0.0
+	filledPrice := decimal.Zero
 	if len(binanceResp.Fills) > 0 {
-		filledPrice, _ = strconv.ParseFloat(binanceResp.Fills[0].Price, 64)
+		filledPrice = ParseDecimal(binanceResp.Fills[0].Price)
 	} else if binanceResp.AvgPrice != "" {
-		filledPrice, _ = strconv.ParseFloat(binanceResp.AvgPrice, 64)
+		filledPrice = ParseDecimal(binanceResp.AvgPrice)
 	} else if binanceResp.Price != "" {
-		filledPrice, _ = strconv.ParseFloat(binanceResp.Price, 64)
+		filledPrice = ParseDecimal(binanceResp.Price)
+	}
+	if filledPrice.IsZero() {
+		// Response lấy lại qua GET order (spot) không có fills/avgPrice
+		executedQty := ParseDecimal(binanceResp.ExecutedQty)
+		if executedQty.IsPositive() {
+			filledPrice = ParseDecimal(binanceResp.CummulativeQuoteQty).Div(executedQty)
+		}
 	}
 
-	quantity, _ := strconv.ParseFloat(binanceResp.OrigQty, 64)
-	orderPrice, _ := strconv.ParseFloat(binanceResp.Price, 64)
+	quantity := ParseDecimal(binanceResp.OrigQty)
+	orderPrice := ParseDecimal(binanceResp.Price)
 
 	// Log success details
 	fmt.Printf("✅ MAIN ORDER PLACED:\n")
@@ -472,7 +651,7 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 	fmt.Printf("   Type: %s\n", binanceResp.Type)
 	fmt.Printf("   Side: %s\n", binanceResp.Side)
 	fmt.Printf("   Quantity: %s\n", binanceResp.OrigQty)
-	fmt.Printf("   Filled Price: %.8f\n", filledPrice)
+	fmt.Printf("   Filled Price: %s\n", filledPrice)
 	fmt.Printf("   Status: %s\n", binanceResp.Status)
 	fmt.Printf("   Trading Mode: %s\n", tradingMode)
 	fmt.Printf("   Stop Loss %%: %.2f\n", config.StopLossPercent)
@@ -499,7 +678,7 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 	// Log success to database
 	if ts.DB != nil && ts.UserID > 0 {
 		utils.CreateSystemLog(ts.DB, ts.UserID, utils.LogLevelSuccess, "ORDER_EXECUTED",
-			fmt.Sprintf("Successfully placed %s %s order for %s at $%.8f (Qty: %.8f)",
+			fmt.Sprintf("Successfully placed %s %s order for %s at $%s (Qty: %s)",
 				strings.ToUpper(binanceResp.Side), strings.ToUpper(binanceResp.Type), binanceResp.Symbol, filledPrice, quantity),
 			map[string]interface{}{
 				"symbol":   binanceResp.Symbol,
@@ -522,6 +701,7 @@ func (ts *TradingService) placeBinanceOrder(config *models.TradingConfig, side,
 		Status:           strings.ToLower(binanceResp.Status), // Convert to lowercase: FILLED -> filled
 		AlgoIDStopLoss:   algoIDStopLoss,
 		AlgoIDTakeProfit: algoIDTakeProfit,
+		ClientOrderID:    clientOrderID,
 	}
 }
 
@@ -547,9 +727,9 @@ func (ts *TradingService) placeAutoTPSL(
 	},
 	binanceSide string,
 	binanceType string,
-	filledPrice float64,
-	orderPrice float64,
-	quantity float64,
+	filledPrice decimal.Decimal,
+	orderPrice decimal.Decimal,
+	quantity decimal.Decimal,
 ) (algoIDStopLoss string, algoIDTakeProfit string) {
 	statusFilled := "❌"
 	shouldPlaceTPSL := false
@@ -600,16 +780,16 @@ func (ts *TradingService) placeAutoTPSL(
 
 	// Sử dụng filled price hoặc order price để tính TP/SL
 	entryPrice := filledPrice
-	if entryPrice == 0 {
+	if entryPrice.IsZero() {
 		entryPrice = orderPrice
 	}
 
 	// Nếu vẫn không có entry price, lấy giá hiện tại
-	if entryPrice == 0 {
+	if entryPrice.IsZero() {
 		currentPrice, err := ts.GetCurrentPrice(config, symbol)
 		if err == nil {
 			entryPrice = currentPrice
-			fmt.Printf("⚠️  Using current price as entry: %.8f\n", entryPrice)
+			fmt.Printf("⚠️  Using current price as entry: %s\n", entryPrice)
 		} else {
 			fmt.Printf("❌ Cannot determine entry price, skipping TP/SL\n\n")
 			return "", ""
@@ -625,17 +805,10 @@ func (ts *TradingService) placeAutoTPSL(
 	// Place Stop Loss if configured
 	fmt.Printf("\n🔍 DEBUG BEFORE SL: StopLossPercent=%.2f, TakeProfitPercent=%.2f\n", config.StopLossPercent, config.TakeProfitPercent)
 	if config.StopLossPercent > 0 {
-		var stopLossPrice float64
 		// ⭐ Dựa vào POSITION type, không phải binanceSide
 		// LONG position (BUY to open): Stop Loss BELOW entry (sell when price drops)
 		// SHORT position (SELL to open): Stop Loss ABOVE entry (buy when price rises)
-		if binanceSide == "BUY" {
-			// LONG position: SL below entry
-			stopLossPrice = entryPrice * (1 - config.StopLossPercent/100)
-		} else {
-			// SHORT position: SL above entry
-			stopLossPrice = entryPrice * (1 + config.StopLossPercent/100)
-		}
+		stopLossPrice := CalculateStopLossPrice(binanceSide, entryPrice, config.StopLossPercent)
 
 		// Validate: Stop Loss không được trigger ngay lập tức
 		currentMarkPrice, err := ts.GetMarkPrice(symbol)
@@ -648,24 +821,24 @@ func (ts *TradingService) placeAutoTPSL(
 
 			if binanceSide == "BUY" {
 				// LONG: SL triggers when price <= stopLossPrice
-				if currentMarkPrice <= stopLossPrice {
+				if currentMarkPrice.LessThanOrEqual(stopLossPrice) {
 					wouldTrigger = true
-					reason = fmt.Sprintf("LONG position: Current price %.8f <= SL price %.8f", currentMarkPrice, stopLossPrice)
+					reason = fmt.Sprintf("LONG position: Current price %s <= SL price %s", currentMarkPrice, stopLossPrice)
 				}
 			} else {
 				// SHORT: SL triggers when price >= stopLossPrice
-				if currentMarkPrice >= stopLossPrice {
+				if currentMarkPrice.GreaterThanOrEqual(stopLossPrice) {
 					wouldTrigger = true
-					reason = fmt.Sprintf("SHORT position: Current price %.8f >= SL price %.8f", currentMarkPrice, stopLossPrice)
+					reason = fmt.Sprintf("SHORT position: Current price %s >= SL price %s", currentMarkPrice, stopLossPrice)
 				}
 			}
 
 			if wouldTrigger {
 				errMsg := fmt.Sprintf("❌ STOP LOSS VALIDATION FAILED: %s. Order would immediately trigger!", reason)
 				fmt.Printf("\n%s\n", errMsg)
-				fmt.Printf("   Entry Price: %.8f\n", entryPrice)
-				fmt.Printf("   Current Mark Price: %.8f\n", currentMarkPrice)
-				fmt.Printf("   Stop Loss Price: %.8f\n", stopLossPrice)
+				fmt.Printf("   Entry Price: %s\n", entryPrice)
+				fmt.Printf("   Current Mark Price: %s\n", currentMarkPrice)
+				fmt.Printf("   Stop Loss Price: %s\n", stopLossPrice)
 				fmt.Printf("   Stop Loss %%: %.2f%%\n", config.StopLossPercent)
 				fmt.Printf("   Position Type: %s\n", binanceSide)
 
@@ -675,11 +848,12 @@ func (ts *TradingService) placeAutoTPSL(
 		}
 
 		fmt.Printf("📊 Placing STOP LOSS:\n")
-		fmt.Printf("   Entry Price: %.8f\n", entryPrice)
+		fmt.Printf("   Entry Price: %s\n", entryPrice)
 		fmt.Printf("   Stop Loss %%: %.2f%%\n", config.StopLossPercent)
-		fmt.Printf("   Stop Loss Price: %.8f\n", stopLossPrice)
+		fmt.Printf("   Stop Loss Price: %s\n", stopLossPrice)
 		fmt.Printf("   Side: %s\n\n", closeSide)
 
+		// Trigger price được làm tròn theo tickSize trong PlaceAlgoStopLoss
 		slResult := ts.PlaceStopLossOrder(config, symbol, stopLossPrice, quantity, closeSide)
 		if !slResult.Success {
 			fmt.Printf("⚠️  Failed to place Stop Loss: %s\n\n", slResult.Error)
@@ -692,19 +866,13 @@ func (ts *TradingService) placeAutoTPSL(
 	// Place Take Profit if configured
 	fmt.Printf("🔍 DEBUG: TakeProfitPercent = %.2f (should be > 0 to place TP)\n", config.TakeProfitPercent)
 	if config.TakeProfitPercent > 0 {
-		var takeProfitPrice float64
-		if binanceSide == "BUY" {
-			// LONG position: TP above entry
-			takeProfitPrice = entryPrice * (1 + config.TakeProfitPercent/100)
-		} else {
-			// SHORT position: TP below entry
-			takeProfitPrice = entryPrice * (1 - config.TakeProfitPercent/100)
-		}
+		// LONG position: TP above entry, SHORT position: TP below entry
+		takeProfitPrice := CalculateTakeProfitPrice(binanceSide, entryPrice, config.TakeProfitPercent)
 
 		fmt.Printf("📊 Placing TAKE PROFIT:\n")
-		fmt.Printf("   Entry Price: %.8f\n", entryPrice)
+		fmt.Printf("   Entry Price: %s\n", entryPrice)
 		fmt.Printf("   Take Profit %%: %.2f%%\n", config.TakeProfitPercent)
-		fmt.Printf("   Take Profit Price: %.8f\n", takeProfitPrice)
+		fmt.Printf("   Take Profit Price: %s\n", takeProfitPrice)
 		fmt.Printf("   Side: %s\n\n", closeSide)
 
 		tpResult := ts.PlaceTakeProfitOrder(config, symbol, takeProfitPrice, quantity, closeSide)
@@ -720,7 +888,7 @@ func (ts *TradingService) placeAutoTPSL(
 }
 
 // placeBittrexOrder places an order on Bittrex
-func (ts *TradingService) placeBittrexOrder(tradingConfig *models.TradingConfig, side, orderType, symbol string, amount, price float64) OrderResult {
+func (ts *TradingService) placeBittrexOrder(tradingConfig *models.TradingConfig, side, orderType, symbol string, amount, price decimal.Decimal) OrderResult {
 	cfg := config.Load()
 	baseURL := cfg.Exchanges.Bittrex.APIURL
 	endpoint := "/orders"
@@ -821,14 +989,14 @@ func (ts *TradingService) placeBittrexOrder(tradingConfig *models.TradingConfig,
 
 	// Parse response
 	var bittrexResp struct {
-		ID           string  `json:"id"`
-		MarketSymbol string  `json:"marketSymbol"`
-		Direction    string  `json:"direction"`
-		Type         string  `json:"type"`
-		Quantity     float64 `json:"quantity"`
-		Limit        float64 `json:"limit"`
-		FillQuantity float64 `json:"fillQuantity"`
-		Status       string  `json:"status"`
+		ID           string          `json:"id"`
+		MarketSymbol string          `json:"marketSymbol"`
+		Direction    string          `json:"direction"`
+		Type         string          `json:"type"`
+		Quantity     decimal.Decimal `json:"quantity"`
+		Limit        decimal.Decimal `json:"limit"`
+		FillQuantity decimal.Decimal `json:"fillQuantity"`
+		Status       string          `json:"status"`
 	}
 
 	if err := json.Unmarshal(body, &bittrexResp); err != nil {
@@ -853,7 +1021,7 @@ func (ts *TradingService) placeBittrexOrder(tradingConfig *models.TradingConfig,
 }
 
 // PlaceStopLossOrder places a stop loss order on Binance
-func (ts *TradingService) PlaceStopLossOrder(config *models.TradingConfig, symbol string, stopPrice float64, quantity float64, side string) OrderResult {
+func (ts *TradingService) PlaceStopLossOrder(config *models.TradingConfig, symbol string, stopPrice, quantity decimal.Decimal, side string) OrderResult {
 	if ts.Exchange != "binance" {
 		return OrderResult{
 			Success: false,
@@ -879,7 +1047,7 @@ func (ts *TradingService) PlaceStopLossOrder(config *models.TradingConfig, symbo
 }
 
 // PlaceTakeProfitOrder places a take profit order on Binance
-func (ts *TradingService) PlaceTakeProfitOrder(config *models.TradingConfig, symbol string, takeProfitPrice float64, quantity float64, side string) OrderResult {
+func (ts *TradingService) PlaceTakeProfitOrder(config *models.TradingConfig, symbol string, takeProfitPrice, quantity decimal.Decimal, side string) OrderResult {
 	if ts.Exchange != "binance" {
 		return OrderResult{
 			Success: false,
@@ -911,9 +1079,9 @@ func (ts *TradingService) PlaceTakeProfitOrder(config *models.TradingConfig, sym
 	params.Set("symbol", symbol)
 	params.Set("side", strings.ToUpper(side))
 	params.Set("type", "TAKE_PROFIT_LIMIT")
-	params.Set("quantity", fmt.Sprintf("%.8f", quantity))
-	params.Set("stopPrice", fmt.Sprintf("%.8f", takeProfitPrice))
-	params.Set("price", fmt.Sprintf("%.8f", takeProfitPrice*1.01)) // Slightly higher to ensure execution
+	params.Set("quantity", ts.FormatQuantityByStepSize("spot", symbol, quantity))
+	params.Set("stopPrice", ts.FormatPriceByTickSize("spot", symbol, takeProfitPrice))
+	params.Set("price", ts.FormatPriceByTickSize("spot", symbol, takeProfitPrice.Mul(decimal.NewFromFloat(1.01)))) // Slightly higher to ensure execution
 	params.Set("timeInForce", "GTC")
 
 	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
@@ -1017,10 +1185,10 @@ func (ts *TradingService) PlaceTakeProfitOrder(config *models.TradingConfig, sym
 func (ts *TradingService) PlaceTrailingStopOrder(
 	config *models.TradingConfig,
 	symbol string,
-	quantity float64, // số lượng cần đóng (bắt buộc cho Trailing Stop)
+	quantity decimal.Decimal, // số lượng cần đóng (bắt buộc cho Trailing Stop)
 	side string, // side của vị thế mở: "BUY" (LONG) hoặc "SELL" (SHORT)
-	filledPrice float64,
-	orderPrice float64,
+	filledPrice decimal.Decimal,
+	orderPrice decimal.Decimal,
 ) OrderResult {
 
 	if ts.Exchange != "binance" || config.TradingMode != "futures" {
@@ -1028,27 +1196,28 @@ func (ts *TradingService) PlaceTrailingStopOrder(
 	}
 
 	// Lấy callback rate từ config
-	callbackRate := config.CallbackRate
-	if callbackRate <= 0 {
-		callbackRate = 1.0 // Default 1%
+	callbackRate := decimal.NewFromFloat(config.CallbackRate).Round(2)
+	if !callbackRate.IsPositive() {
+		callbackRate = decimal.NewFromInt(1) // Default 1%
 	}
 
 	// Tính activation price từ phần trăm trong config
-	var activatePrice float64
+	activatePrice := decimal.Zero
 	if config.ActivationPrice > 0 {
 		// Sử dụng filled price hoặc order price làm entry
 		entryPrice := filledPrice
-		if entryPrice == 0 {
+		if entryPrice.IsZero() {
 			entryPrice = orderPrice
 		}
 
 		// Tính activation price dựa trên phần trăm và side
+		offset := decimal.NewFromFloat(config.ActivationPrice).Div(hundred)
 		if strings.ToUpper(side) == "BUY" {
 			// LONG position: activation price phía trên entry
-			activatePrice = entryPrice * (1 + config.ActivationPrice/100)
+			activatePrice = entryPrice.Mul(decimal.NewFromInt(1).Add(offset))
 		} else {
 			// SHORT position: activation price phía dưới entry
-			activatePrice = entryPrice * (1 - config.ActivationPrice/100)
+			activatePrice = entryPrice.Mul(decimal.NewFromInt(1).Sub(offset))
 		}
 	}
 
@@ -1068,16 +1237,17 @@ func (ts *TradingService) PlaceTrailingStopOrder(
 	params.Set("side", closeSide)
 	params.Set("type", "TRAILING_STOP_MARKET") // type đúng
 
-	params.Set("callbackRate", fmt.Sprintf("%.2f", callbackRate)) // 1.0 = 1%, min 0.1 max 10
+	params.Set("callbackRate", callbackRate.String()) // 1.0 = 1%, min 0.1 max 10
 
-	// Set activation price nếu có
-	if activatePrice > 0 {
-		params.Set("activatePrice", fmt.Sprintf("%.2f", activatePrice))
-		fmt.Printf("   Calculated Activate Price: %.2f (from %.2f%% of entry)\n", activatePrice, config.ActivationPrice)
+	// Set activation price nếu có (làm tròn theo tickSize của symbol)
+	if activatePrice.IsPositive() {
+		activatePriceStr := ts.FormatPriceByTickSize("futures", symbol, activatePrice)
+		params.Set("activatePrice", activatePriceStr)
+		fmt.Printf("   Calculated Activate Price: %s (from %.2f%% of entry)\n", activatePriceStr, config.ActivationPrice)
 	}
 
-	params.Set("quantity", fmt.Sprintf("%.8f", quantity)) // ⭐ Bắt buộc quantity cho TRAILING_STOP_MARKET
-	params.Set("reduceOnly", "TRUE")                      // ⭐ Thêm dòng này
+	params.Set("quantity", ts.FormatQuantityByStepSize("futures", symbol, quantity)) // ⭐ Bắt buộc quantity cho TRAILING_STOP_MARKET
+	params.Set("reduceOnly", "TRUE")                                                 // ⭐ Thêm dòng này
 	params.Set("workingType", "MARK_PRICE")
 	params.Set("priceProtect", "TRUE")
 
@@ -1175,20 +1345,20 @@ func (ts *TradingService) CheckOrderStatus(config *models.TradingConfig, exchang
 
 // OrderStatusResult represents the result of checking order status
 type OrderStatusResult struct {
-	Success     bool    `json:"success"`
-	Error       string  `json:"error,omitempty"`
-	OrderID     string  `json:"order_id"`
-	Symbol      string  `json:"symbol"`
-	Status      string  `json:"status"` // filled, new, canceled, ...
-	Filled      float64 `json:"filled_qty"`
-	Remaining   float64 `json:"remaining_qty"`
-	AvgPrice    float64 `json:"avg_price"`
-	IsRunning   bool    `json:"is_running"`             // true nếu lệnh hoặc Algo Order liên quan đang chạy
-	RunningType string  `json:"running_type,omitempty"` // "NORMAL", "ALGO", hoặc ""
-	AlgoStatus  string  `json:"algo_status,omitempty"`
-	AlgoType    string  `json:"algo_type,omitempty"`
-	OrigQty     float64 `json:"orig_qty,omitempty"`
-	Side        string  `json:"side,omitempty"`
+	Success     bool            `json:"success"`
+	Error       string          `json:"error,omitempty"`
+	OrderID     string          `json:"order_id"`
+	Symbol      string          `json:"symbol"`
+	Status      string          `json:"status"` // filled, new, canceled, ...
+	Filled      decimal.Decimal `json:"filled_qty"`
+	Remaining   decimal.Decimal `json:"remaining_qty"`
+	AvgPrice    decimal.Decimal `json:"avg_price"`
+	IsRunning   bool            `json:"is_running"`             // true nếu lệnh hoặc Algo Order liên quan đang chạy
+	RunningType string          `json:"running_type,omitempty"` // "NORMAL", "ALGO", hoặc ""
+	AlgoStatus  string          `json:"algo_status,omitempty"`
+	AlgoType    string          `json:"algo_type,omitempty"`
+	OrigQty     decimal.Decimal `json:"orig_qty,omitempty"`
+	Side        string          `json:"side,omitempty"`
 }
 
 // RunningOrderStatus represents the status of a running order (normal or algo)
@@ -1289,10 +1459,10 @@ func (ts *TradingService) checkBinanceOrderStatus(
 		return OrderStatusResult{Success: false, Error: "Failed to parse response"}
 	}
 
-	origQty, _ := strconv.ParseFloat(binanceResp.OrigQty, 64)
-	executedQty, _ := strconv.ParseFloat(binanceResp.ExecutedQty, 64)
-	avgPrice, _ := strconv.ParseFloat(binanceResp.AvgPrice, 64)
-	remaining := origQty - executedQty
+	origQty := ParseDecimal(binanceResp.OrigQty)
+	executedQty := ParseDecimal(binanceResp.ExecutedQty)
+	avgPrice := ParseDecimal(binanceResp.AvgPrice)
+	remaining := origQty.Sub(executedQty)
 
 	finalStatus := strings.ToLower(binanceResp.Status)
 	isNormalRunning := finalStatus == "new" || finalStatus == "partially_filled"
@@ -1884,7 +2054,7 @@ func (ts *TradingService) CloseFuturesPositionMarket(config *models.TradingConfi
 	if err != nil {
 		return OrderResult{Success: false, Error: fmt.Sprintf("position query failed: %v", err)}
 	}
-	if pos.Quantity == 0 {
+	if pos.Quantity.IsZero() {
 		return OrderResult{Success: false, Error: "no-position"}
 	}
 
@@ -1935,7 +2105,7 @@ func (ts *TradingService) CloseFuturesPositionMarket(config *models.TradingConfi
 
 	if resp.StatusCode == http.StatusOK {
 		fmt.Printf("✅ Closed position via MARKET closePosition for %s\n", symbol)
-		return OrderResult{Success: true}
+		return closeOrderResult(body, symbol, oppositeSide, pos.Quantity)
 	}
 
 	// Fallback: reduceOnly MARKET with quantity (no closePosition)
@@ -1945,7 +2115,7 @@ func (ts *TradingService) CloseFuturesPositionMarket(config *models.TradingConfi
 	params.Set("symbol", symbol)
 	params.Set("side", oppositeSide)
 	params.Set("type", "MARKET")
-	params.Set("quantity", fmt.Sprintf("%.8f", pos.Quantity))
+	params.Set("quantity", ts.FormatQuantityByStepSize("futures", symbol, pos.Quantity))
 	params.Set("reduceOnly", "true")
 	if hedge {
 		if pos.Side == "LONG" {
@@ -1981,14 +2151,14 @@ func (ts *TradingService) CloseFuturesPositionMarket(config *models.TradingConfi
 		return OrderResult{Success: false, Error: msg, ErrorDetails: errorResp}
 	}
 
-	fmt.Printf("✅ Closed position via reduceOnly MARKET for %s (qty %.8f)\n", symbol, pos.Quantity)
-	return OrderResult{Success: true}
+	fmt.Printf("✅ Closed position via reduceOnly MARKET for %s (qty %s)\n", symbol, pos.Quantity)
+	return closeOrderResult(body, symbol, oppositeSide, pos.Quantity)
 }
 
 // futuresPosition holds simplified position info
 type futuresPosition struct {
-	Quantity float64 // absolute position size
-	Side     string  // LONG or SHORT
+	Quantity decimal.Decimal // absolute position size
+	Side     string          // LONG or SHORT
 }
 
 // getFuturesPositionInfo retrieves current position size/side for the symbol
@@ -2039,20 +2209,21 @@ func (ts *TradingService) getFuturesPositionInfo(config *models.TradingConfig, s
 			continue
 		}
 		posAmtStr, _ := it["positionAmt"].(string)
-		posAmt, _ := strconv.ParseFloat(posAmtStr, 64)
+		posAmt := ParseDecimal(posAmtStr)
 		side := "LONG"
-		if posAmt < 0 {
+		if posAmt.IsNegative() {
 			side = "SHORT"
 		}
-		return futuresPosition{Quantity: math.Abs(posAmt), Side: side}, nil
+		return futuresPosition{Quantity: posAmt.Abs(), Side: side}, nil
 	}
-	return futuresPosition{Quantity: 0, Side: "LONG"}, nil
+	return futuresPosition{Quantity: decimal.Zero, Side: "LONG"}, nil
 }
 
-// getAllFuturesPositions retrieves all futures positions for the account
-func (ts *TradingService) getAllFuturesPositions(config *models.TradingConfig) (map[string]futuresPosition, error) {
+// getAllFuturesPositions retrieves all non-zero futures positions of the account in one positionRisk call.
+// Key là symbol; hedge mode có thể có 2 vị thế (LONG và SHORT) cho cùng symbol.
+func (ts *TradingService) getAllFuturesPositions(config *models.TradingConfig) (map[string][]FuturesPositionInfo, error) {
 	if config.TradingMode != "futures" {
-		return map[string]futuresPosition{}, nil
+		return map[string][]FuturesPositionInfo{}, nil
 	}
 
 	isTestnet := false
@@ -2087,21 +2258,18 @@ func (ts *TradingService) getAllFuturesPositions(config *models.TradingConfig) (
 		return nil, fmt.Errorf("positionRisk failed (status %d)", resp.StatusCode)
 	}
 
-	var arr []map[string]interface{}
+	var arr []binancePositionRisk
 	if err := json.Unmarshal(body, &arr); err != nil {
 		return nil, err
 	}
 
-	positions := make(map[string]futuresPosition)
-	for _, it := range arr {
-		sym, _ := it["symbol"].(string)
-		posAmtStr, _ := it["positionAmt"].(string)
-		posAmt, _ := strconv.ParseFloat(posAmtStr, 64)
-		side := "LONG"
-		if posAmt < 0 {
-			side = "SHORT"
+	positions := make(map[string][]FuturesPositionInfo)
+	for _, raw := range arr {
+		pos := raw.toPositionInfo()
+		if pos.PositionAmt.IsZero() {
+			continue
 		}
-		positions[sym] = futuresPosition{Quantity: math.Abs(posAmt), Side: side}
+		positions[pos.Symbol] = append(positions[pos.Symbol], pos)
 	}
 	return positions, nil
 }
@@ -2189,10 +2357,7 @@ func (ts *TradingService) CloseAllFuturesPositionsMarket(config *models.TradingC
 		return err
 	}
 	var firstErr error
-	for sym, pos := range positions {
-		if pos.Quantity == 0 {
-			continue
-		}
+	for sym := range positions {
 		res := ts.CloseFuturesPositionMarket(config, sym)
 		if !res.Success && res.Error != "no-position" && firstErr == nil {
 			firstErr = errors.New(res.Error)
@@ -2322,18 +2487,61 @@ func (ts *TradingService) SetLeverage(config *models.TradingConfig, symbol strin
 
 // FuturesPositionInfo represents position information from Binance Futures
 type FuturesPositionInfo struct {
-	Symbol           string  `json:"symbol"`
-	PositionAmt      float64 `json:"position_amt"`      // Position size
-	EntryPrice       float64 `json:"entry_price"`       // Entry price
-	MarkPrice        float64 `json:"mark_price"`        // Current mark price
-	UnrealizedProfit float64 `json:"unrealized_profit"` // Unrealized PnL
-	LiquidationPrice float64 `json:"liquidation_price"` // Liquidation price
-	Leverage         int     `json:"leverage"`          // Leverage
-	MarginType       string  `json:"margin_type"`       // ISOLATED or CROSS
-	Isolated         bool    `json:"isolated"`          // true if isolated margin, false if cross margin
-	IsolatedMargin   float64 `json:"isolated_margin"`   // Margin for isolated position
-	PositionSide     string  `json:"position_side"`     // BOTH, LONG, or SHORT
-	PnlPercent       float64 `json:"pnl_percent"`       // PnL percentage
+	Symbol           string          `json:"symbol"`
+	PositionAmt      decimal.Decimal `json:"position_amt"`      // Position size
+	EntryPrice       decimal.Decimal `json:"entry_price"`       // Entry price
+	MarkPrice        decimal.Decimal `json:"mark_price"`        // Current mark price
+	UnrealizedProfit decimal.Decimal `json:"unrealized_profit"` // Unrealized PnL
+	LiquidationPrice decimal.Decimal `json:"liquidation_price"` // Liquidation price
+	Leverage         int             `json:"leverage"`          // Leverage
+	MarginType       string          `json:"margin_type"`       // ISOLATED or CROSS
+	Isolated         bool            `json:"isolated"`          // true if isolated margin, false if cross margin
+	IsolatedMargin   decimal.Decimal `json:"isolated_margin"`   // Margin for isolated position
+	PositionSide     string          `json:"position_side"`     // BOTH, LONG, or SHORT
+	PnlPercent       decimal.Decimal `json:"pnl_percent"`       // PnL percentage
+}
+
+// binancePositionRisk là 1 phần tử của /fapi/v2/positionRisk
+type binancePositionRisk struct {
+	Symbol           string `json:"symbol"`
+	PositionAmt      string `json:"positionAmt"`
+	EntryPrice       string `json:"entryPrice"`
+	MarkPrice        string `json:"markPrice"`
+	UnRealizedProfit string `json:"unRealizedProfit"`
+	LiquidationPrice string `json:"liquidationPrice"`
+	Leverage         string `json:"leverage"`
+	MarginType       string `json:"marginType"`
+	Isolated         bool   `json:"isolated"`
+	IsolatedMargin   string `json:"isolatedMargin"`
+	PositionSide     string `json:"positionSide"`
+}
+
+func (p binancePositionRisk) toPositionInfo() FuturesPositionInfo {
+	posAmt := ParseDecimal(p.PositionAmt)
+	entryPrice := ParseDecimal(p.EntryPrice)
+	unrealizedPnl := ParseDecimal(p.UnRealizedProfit)
+	leverage, _ := strconv.Atoi(p.Leverage)
+
+	// Calculate PnL percentage (trên notional của vị thế)
+	pnlPercent := decimal.Zero
+	if entryPrice.IsPositive() && !posAmt.IsZero() {
+		pnlPercent = unrealizedPnl.Div(posAmt.Abs().Mul(entryPrice)).Mul(hundred).Round(2)
+	}
+
+	return FuturesPositionInfo{
+		Symbol:           p.Symbol,
+		PositionAmt:      posAmt,
+		EntryPrice:       entryPrice,
+		MarkPrice:        ParseDecimal(p.MarkPrice),
+		UnrealizedProfit: unrealizedPnl,
+		LiquidationPrice: ParseDecimal(p.LiquidationPrice),
+		Leverage:         leverage,
+		MarginType:       p.MarginType,
+		Isolated:         p.Isolated,
+		IsolatedMargin:   ParseDecimal(p.IsolatedMargin),
+		PositionSide:     p.PositionSide,
+		PnlPercent:       pnlPercent,
+	}
 }
 
 // GetFuturesPosition gets position information for a symbol
@@ -2377,19 +2585,7 @@ func (ts *TradingService) GetFuturesPosition(symbol string) (*FuturesPositionInf
 		return nil, fmt.Errorf("get position failed (status %d): %s", resp.StatusCode, string(body))
 	}
 
-	var positions []struct {
-		Symbol           string `json:"symbol"`
-		PositionAmt      string `json:"positionAmt"`
-		EntryPrice       string `json:"entryPrice"`
-		MarkPrice        string `json:"markPrice"`
-		UnRealizedProfit string `json:"unRealizedProfit"`
-		LiquidationPrice string `json:"liquidationPrice"`
-		Leverage         string `json:"leverage"`
-		MarginType       string `json:"marginType"`
-		Isolated         bool   `json:"isolated"`
-		IsolatedMargin   string `json:"isolatedMargin"`
-		PositionSide     string `json:"positionSide"`
-	}
+	var positions []binancePositionRisk
 
 	if err := json.Unmarshal(body, &positions); err != nil {
 		return nil, fmt.Errorf("failed to parse positions: %v, body: %s", err, string(body))
@@ -2403,48 +2599,20 @@ func (ts *TradingService) GetFuturesPosition(symbol string) (*FuturesPositionInf
 	}
 
 	// Find position for this symbol
-	for _, pos := range positions {
-		if pos.Symbol == symbol {
-			posAmt, _ := strconv.ParseFloat(pos.PositionAmt, 64)
-
-			fmt.Printf("✅ Found position for %s: PositionAmt=%.8f\n", symbol, posAmt)
+	for _, raw := range positions {
+		if raw.Symbol == symbol {
+			fmt.Printf("✅ Found position for %s: PositionAmt=%s\n", symbol, raw.PositionAmt)
 
+			pos := raw.toPositionInfo()
 			// Skip if no position
-			if posAmt == 0 {
+			if pos.PositionAmt.IsZero() {
 				fmt.Printf("⚠️  Position amount is 0, returning nil\n")
 				return nil, nil
 			}
 
-			entryPrice, _ := strconv.ParseFloat(pos.EntryPrice, 64)
-			markPrice, _ := strconv.ParseFloat(pos.MarkPrice, 64)
-			unrealizedPnl, _ := strconv.ParseFloat(pos.UnRealizedProfit, 64)
-			liqPrice, _ := strconv.ParseFloat(pos.LiquidationPrice, 64)
-			leverage, _ := strconv.Atoi(pos.Leverage)
-			isolatedMargin, _ := strconv.ParseFloat(pos.IsolatedMargin, 64)
-
-			// Calculate PnL percentage
-			pnlPercent := 0.0
-			if entryPrice > 0 {
-				pnlPercent = (unrealizedPnl / (math.Abs(posAmt) * entryPrice)) * 100
-			}
-
-			fmt.Printf("📊 Position Details: Entry=%.2f, Mark=%.2f, PnL=%.2f (%.2f%%), Leverage=%dx\n",
-				entryPrice, markPrice, unrealizedPnl, pnlPercent, leverage)
-
-			return &FuturesPositionInfo{
-				Symbol:           pos.Symbol,
-				PositionAmt:      posAmt,
-				EntryPrice:       entryPrice,
-				MarkPrice:        markPrice,
-				UnrealizedProfit: unrealizedPnl,
-				LiquidationPrice: liqPrice,
-				Leverage:         leverage,
-				MarginType:       pos.MarginType,
-				Isolated:         pos.Isolated,
-				IsolatedMargin:   isolatedMargin,
-				PositionSide:     pos.PositionSide,
-				PnlPercent:       pnlPercent,
-			}, nil
+			fmt.Printf("📊 Position Details: Entry=%s, Mark=%s, PnL=%s (%s%%), Leverage=%dx\n",
+				pos.EntryPrice, pos.MarkPrice, pos.UnrealizedProfit, pos.PnlPercent, pos.Leverage)
+			return &pos, nil
 		}
 	}
 
@@ -0,0 +1,538 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Binance futures user data stream event types
+const (
+	FuturesEventOrderTradeUpdate = "ORDER_TRADE_UPDATE"
+	FuturesEventAccountUpdate    = "ACCOUNT_UPDATE"
+	FuturesEventMarginCall       = "MARGIN_CALL"
+	FuturesEventAlgoUpdate       = "ALGO_UPDATE"
+	FuturesEventTriggerReject    = "CONDITIONAL_ORDER_TRIGGER_REJECT"
+)
+
+// Lệnh đã đóng được gán fill (vd: ORDER_TRADE_UPDATE đến sau ACCOUNT_UPDATE báo vị thế = 0) trong khoảng này
+const recentCloseWindow = 2 * time.Minute
+
+// Lệnh đóng / đảo / chốt một phần chỉ được lưu DB sau khi REST trả về, event stream thường tới trước:
+// fill của lệnh chưa có trong DB được tra lại vài lần trước khi coi là lệnh ngoài hệ thống
+const (
+	futuresFillLookupRetries = 3
+	futuresFillLookupDelay   = 2 * time.Second
+)
+
+// isFuturesUserEvent reports whether e is a futures user data stream event handled by the hub
+func isFuturesUserEvent(e string) bool {
+	switch e {
+	case FuturesEventOrderTradeUpdate, FuturesEventAccountUpdate, FuturesEventMarginCall,
+		FuturesEventAlgoUpdate, FuturesEventTriggerReject:
+		return true
+	}
+	return false
+}
+
+// FuturesOrderUpdate is a parsed ORDER_TRADE_UPDATE event
+type FuturesOrderUpdate struct {
+	Symbol          string          `json:"s"`
+	ClientOrderID   string          `json:"c"`
+	Side            string          `json:"S"`
+	Type            string          `json:"o"`
+	OrigType        string          `json:"ot"`
+	ExecType        string          `json:"x"` // NEW, TRADE, CANCELED, EXPIRED, AMENDMENT, CALCULATED (liquidation)
+	Status          string          `json:"X"`
+	OrderID         int64           `json:"i"`
+	Quantity        decimal.Decimal `json:"q"`
+	Price           decimal.Decimal `json:"p"`
+	AvgPrice        decimal.Decimal `json:"ap"`
+	StopPrice       decimal.Decimal `json:"sp"`
+	LastFilledQty   decimal.Decimal `json:"l"`
+	FilledQty       decimal.Decimal `json:"z"`
+	LastFilledPrice decimal.Decimal `json:"L"`
+	CommissionAsset string          `json:"N"`
+	Commission      decimal.Decimal `json:"n"`
+	TradeTime       int64           `json:"T"`
+	TradeID         int64           `json:"t"`
+	ReduceOnly      bool            `json:"R"`
+	PositionSide    string          `json:"ps"`
+	ClosePosition   bool            `json:"cp"`
+	RealizedProfit  decimal.Decimal `json:"rp"`
+}
+
+// FuturesPositionUpdate is one position of an ACCOUNT_UPDATE event
+type FuturesPositionUpdate struct {
+	Symbol         string          `json:"s"`
+	PositionAmt    decimal.Decimal `json:"pa"`
+	EntryPrice     decimal.Decimal `json:"ep"`
+	BreakEvenPrice decimal.Decimal `json:"bep"`
+	AccumRealized  decimal.Decimal `json:"cr"`
+	UnrealizedPnL  decimal.Decimal `json:"up"`
+	MarginType     string          `json:"mt"`
+	IsolatedWallet decimal.Decimal `json:"iw"`
+	PositionSide   string          `json:"ps"`
+}
+
+// FuturesBalanceUpdate is one asset balance of an ACCOUNT_UPDATE event
+type FuturesBalanceUpdate struct {
+	Asset              string          `json:"a"`
+	WalletBalance      decimal.Decimal `json:"wb"`
+	CrossWalletBalance decimal.Decimal `json:"cw"`
+	BalanceChange      decimal.Decimal `json:"bc"`
+}
+
+// FuturesAccountUpdate is a parsed ACCOUNT_UPDATE event
+type FuturesAccountUpdate struct {
+	Reason    string                  `json:"m"` // ORDER, FUNDING_FEE, DEPOSIT, WITHDRAW, MARGIN_TYPE_CHANGE...
+	Balances  []FuturesBalanceUpdate  `json:"B"`
+	Positions []FuturesPositionUpdate `json:"P"`
+}
+
+// FuturesMarginCallPosition is one position at risk in a MARGIN_CALL event
+type FuturesMarginCallPosition struct {
+	Symbol            string          `json:"s"`
+	PositionSide      string          `json:"ps"`
+	PositionAmt       decimal.Decimal `json:"pa"`
+	MarginType        string          `json:"mt"`
+	IsolatedWallet    decimal.Decimal `json:"iw"`
+	MarkPrice         decimal.Decimal `json:"mp"`
+	UnrealizedPnL     decimal.Decimal `json:"up"`
+	MaintenanceMargin decimal.Decimal `json:"mm"`
+}
+
+// FuturesMarginCall is a parsed MARGIN_CALL event
+type FuturesMarginCall struct {
+	CrossWalletBalance decimal.Decimal             `json:"cw"`
+	Positions          []FuturesMarginCallPosition `json:"p"`
+}
+
+// FuturesAlgoUpdate is a parsed ALGO_UPDATE event (conditional SL/TP/trailing orders)
+type FuturesAlgoUpdate struct {
+	AlgoID        int64           `json:"aid"`
+	ClientAlgoID  string          `json:"caid"`
+	AlgoType      string          `json:"at"`
+	OrderType     string          `json:"o"`
+	Symbol        string          `json:"s"`
+	Side          string          `json:"S"`
+	PositionSide  string          `json:"ps"`
+	Quantity      decimal.Decimal `json:"q"`
+	Status        string          `json:"X"` // NEW, TRIGGERING, TRIGGERED, FINISHED, CANCELED, EXPIRED, REJECTED
+	TriggerPrice  decimal.Decimal `json:"tp"`
+	ActualOrderID string          `json:"ai"`
+	Reason        string          `json:"rm"`
+}
+
+// FuturesTriggerReject is a parsed CONDITIONAL_ORDER_TRIGGER_REJECT event
+type FuturesTriggerReject struct {
+	Symbol  string `json:"s"`
+	OrderID int64  `json:"i"`
+	Reason  string `json:"r"`
+}
+
+// decodeFuturesEvent chuyển field con của message (vd "o", "a") sang struct
+func decodeFuturesEvent(message map[string]interface{}, field string, out interface{}) error {
+	raw, ok := message[field]
+	if !ok {
+		return fmt.Errorf("missing field %q", field)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// handleBinanceFuturesEvent parses a futures user data stream event, persists it and broadcasts it to the user
+func (h *WebSocketHub) handleBinanceFuturesEvent(exchConn *ExchangeConnection, eventType string, message map[string]interface{}) {
+	eventTime := getInt64Value(message, "E")
+
+	var (
+		msgType string
+		data    interface{}
+		err     error
+	)
+	switch eventType {
+	case FuturesEventOrderTradeUpdate:
+		var u FuturesOrderUpdate
+		if err = decodeFuturesEvent(message, "o", &u); err == nil {
+			h.applyFuturesOrderUpdate(exchConn, &u)
+			msgType, data = "order_update", map[string]interface{}{
+				"exchange": exchConn.Exchange, "trading_mode": "futures", "event_time": eventTime, "order": u,
+			}
+		}
+	case FuturesEventAccountUpdate:
+		var u FuturesAccountUpdate
+		if err = decodeFuturesEvent(message, "a", &u); err == nil {
+			h.applyFuturesAccountUpdate(exchConn, &u)
+			msgType, data = "account_update", map[string]interface{}{
+				"exchange": exchConn.Exchange, "event_time": eventTime,
+				"reason": u.Reason, "balances": u.Balances, "positions": u.Positions,
+			}
+		}
+	case FuturesEventMarginCall:
+		var mc FuturesMarginCall
+		mc.CrossWalletBalance = decimal.NewFromFloat(getFloatValue(message, "cw"))
+		if err = decodeFuturesEvent(message, "p", &mc.Positions); err == nil {
+			h.applyFuturesMarginCall(exchConn, &mc)
+			msgType, data = "margin_call", map[string]interface{}{
+				"exchange": exchConn.Exchange, "event_time": eventTime, "margin_call": mc,
+			}
+		}
+	case FuturesEventAlgoUpdate:
+		var u FuturesAlgoUpdate
+		if err = decodeFuturesEvent(message, "o", &u); err == nil {
+			h.applyFuturesAlgoUpdate(exchConn, &u)
+			msgType, data = "algo_update", map[string]interface{}{
+				"exchange": exchConn.Exchange, "event_time": eventTime, "algo": u,
+			}
+		}
+	case FuturesEventTriggerReject:
+		var r FuturesTriggerReject
+		if err = decodeFuturesEvent(message, "or", &r); err == nil {
+			utils.CreateSystemLog(h.DB, exchConn.UserID, utils.LogLevelError, "FUTURES_TRIGGER_REJECTED",
+				fmt.Sprintf("Conditional order %d on %s was rejected when triggered: %s", r.OrderID, r.Symbol, r.Reason),
+				map[string]interface{}{"symbol": r.Symbol, "order_id": r.OrderID, "reason": r.Reason})
+			msgType, data = "algo_update", map[string]interface{}{
+				"exchange": exchConn.Exchange, "event_time": eventTime, "trigger_reject": r,
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to parse %s event: %v", eventType, err)
+		return
+	}
+
+	h.Broadcast <- &BroadcastMessage{
+		UserID: exchConn.UserID,
+		Type:   msgType,
+		Data:   data,
+	}
+}
+
+// applyFuturesOrderUpdate lưu trạng thái, khối lượng khớp, realized PnL và phí của lệnh.
+// Lệnh được tìm theo order ID rồi tới client order ID; chưa thấy thì tra lại sau (xem futuresFillLookupRetries).
+// Fill của lệnh không do TraderCoin đặt (SL/TP algo đã kích hoạt, đóng tay trên sàn) được gán cho lệnh vào tương ứng.
+func (h *WebSocketHub) applyFuturesOrderUpdate(exchConn *ExchangeConnection, u *FuturesOrderUpdate) {
+	h.applyFuturesOrderUpdateAttempt(exchConn, u, 0)
+}
+
+func (h *WebSocketHub) applyFuturesOrderUpdateAttempt(exchConn *ExchangeConnection, u *FuturesOrderUpdate, attempt int) {
+	orderID := strconv.FormatInt(u.OrderID, 10)
+	isTrade := u.ExecType == "TRADE" || u.ExecType == "CALCULATED"
+
+	var order models.Order
+	query := h.DB.Where("user_id = ? AND symbol = ?", exchConn.UserID, u.Symbol)
+	if u.ClientOrderID != "" {
+		query = query.Where("order_id = ? OR client_order_id = ?", orderID, u.ClientOrderID)
+	} else {
+		query = query.Where("order_id = ?", orderID)
+	}
+	err := query.First(&order).Error
+	if err == nil {
+		updates := map[string]interface{}{
+			"filled_quantity": u.FilledQty,
+		}
+		if order.OrderID == "" {
+			updates["order_id"] = orderID
+		}
+		if u.AvgPrice.IsPositive() {
+			updates["filled_price"] = u.AvgPrice
+		}
+		if isTrade {
+			updates["realized_pn_l"] = gorm.Expr("realized_pn_l + ?", u.RealizedProfit)
+			updates["commission"] = gorm.Expr("commission + ?", u.Commission)
+			updates["commission_asset"] = u.CommissionAsset
+		}
+		// Lệnh vào futures FILLED vẫn giữ vị thế → chỉ ACCOUNT_UPDATE (vị thế = 0) mới chuyển sang closed;
+		// event đến muộn sau khi đã closed bị state machine bỏ qua phần trạng thái
+		if _, err := ApplyOrderChange(h.DB, &order, OrderChange{
+			Status:  u.Status,
+			Updates: updates,
+			Source:  OrderSourceUserStream,
+			Actor:   OrderActorUser(exchConn.UserID),
+			Payload: u,
+		}); err != nil && !errors.Is(err, ErrInvalidOrderTransition) {
+			log.Printf("Failed to update futures order %s: %v", orderID, err)
+		}
+		if isTrade {
+			h.recordPositionFill(order.PositionID, &order.ID, u)
+		}
+		if strings.EqualFold(u.Status, "FILLED") {
+			ResizeAfterPartialCloseFill(h.DB, &order)
+		}
+		return
+	}
+	if err != gorm.ErrRecordNotFound {
+		log.Printf("Failed to load futures order %s: %v", orderID, err)
+		return
+	}
+
+	// Fill giảm vị thế (reduce-only, close-position hoặc có realized PnL) của lệnh ngoài DB
+	if !isTrade || !(u.ReduceOnly || u.ClosePosition || !u.RealizedProfit.IsZero()) {
+		return
+	}
+	if attempt < futuresFillLookupRetries {
+		// Có thể là lệnh đóng / chốt một phần vừa đặt qua REST mà chưa kịp lưu DB
+		time.AfterFunc(futuresFillLookupDelay*time.Duration(attempt+1), func() {
+			h.applyFuturesOrderUpdateAttempt(exchConn, u, attempt+1)
+		})
+		return
+	}
+	if strings.HasPrefix(u.ClientOrderID, clientOrderIDPrefix) {
+		// Lệnh do TraderCoin đặt nhưng không lưu được DB: không gán vào lệnh vào (tránh tính PnL 2 lần), đối soát REST xử lý
+		log.Printf("⚠️  Fill of order %s (%s %s) not found in DB - leaving it to settlement", u.ClientOrderID, u.Symbol, orderID)
+		return
+	}
+	entry, found := h.findFuturesEntryOrder(exchConn.UserID, u.Symbol, closingPositionSide(u.Side, u.PositionSide))
+	if !found {
+		return
+	}
+	exitPrice := u.AvgPrice
+	if !exitPrice.IsPositive() {
+		exitPrice = u.LastFilledPrice
+	}
+	exitReason := FuturesExitReason(u.Type, u.OrigType, u.ClientOrderID)
+	if u.ExecType == "CALCULATED" {
+		exitReason = ExitReasonLiquidation
+	}
+	if err := h.DB.Model(&models.Order{}).Where("id = ?", entry.ID).Updates(map[string]interface{}{
+		"realized_pn_l":    gorm.Expr("realized_pn_l + ?", u.RealizedProfit),
+		"commission":       gorm.Expr("commission + ?", u.Commission),
+		"commission_asset": u.CommissionAsset,
+		"current_price":    u.LastFilledPrice,
+		"exit_price":       exitPrice,
+		"exit_reason":      exitReason,
+	}).Error; err != nil {
+		log.Printf("Failed to attribute fill of %s to order %d: %v", orderID, entry.ID, err)
+		return
+	}
+	h.recordPositionFill(entry.PositionID, nil, u)
+	if strings.EqualFold(entry.Status, "closed") {
+		// Vị thế đã đóng trước khi nhận fill → PnL của lệnh là realized PnL
+		h.DB.Model(&models.Order{}).Where("id = ?", entry.ID).Update("pn_l", gorm.Expr("realized_pn_l"))
+	}
+}
+
+// applyFuturesAccountUpdate cập nhật vị thế của các lệnh đang mở; vị thế = 0 → lệnh đóng
+func (h *WebSocketHub) applyFuturesAccountUpdate(exchConn *ExchangeConnection, u *FuturesAccountUpdate) {
+	for _, p := range u.Positions {
+		query := h.openFuturesOrders(exchConn.UserID, p.Symbol, positionSideOf(p.PositionSide, p.PositionAmt))
+		h.applyAccountPosition(exchConn.UserID, &p)
+
+		if p.PositionAmt.IsZero() {
+			var ids []uint
+			if err := query.Pluck("id", &ids).Error; err != nil {
+				log.Printf("Failed to load futures orders for %s: %v", p.Symbol, err)
+				continue
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			// Đóng vị thế: realized PnL tạm lấy từ các fill (ORDER_TRADE_UPDATE), đối soát lại qua REST (phí, funding)
+			ids, err := ApplyOrderChanges(h.DB, ids, OrderChange{
+				Status: OrderStatusClosed,
+				Updates: map[string]interface{}{
+					"pn_l":      gorm.Expr("realized_pn_l"),
+					"closed_at": time.Now(),
+				},
+				Source:  OrderSourceUserStream,
+				Actor:   OrderActorUser(exchConn.UserID),
+				Message: "position amount is 0 (ACCOUNT_UPDATE " + u.Reason + ")",
+				Payload: p,
+			})
+			if err != nil {
+				log.Printf("Failed to close futures orders for %s: %v", p.Symbol, err)
+				continue
+			}
+			log.Printf("Futures position %s %s closed → %d order(s) closed", p.Symbol, p.PositionSide, len(ids))
+			SettleClosedFuturesOrders(h.DB, ids)
+			continue
+		}
+
+		updates := map[string]interface{}{
+			"pn_l": p.UnrealizedPnL,
+		}
+		if p.MarginType != "" {
+			updates["margin_type"] = p.MarginType
+		}
+		if p.IsolatedWallet.IsPositive() {
+			updates["isolated_margin"] = p.IsolatedWallet
+		}
+		if err := query.Updates(updates).Error; err != nil {
+			log.Printf("Failed to update futures position %s: %v", p.Symbol, err)
+		}
+		if p.EntryPrice.IsPositive() {
+			h.openFuturesOrders(exchConn.UserID, p.Symbol, positionSideOf(p.PositionSide, p.PositionAmt)).
+				Where("filled_price = 0 OR filled_price IS NULL").
+				Updates(map[string]interface{}{"filled_price": p.EntryPrice})
+		}
+	}
+}
+
+// applyAccountPosition đồng bộ các Position đang mở của user + symbol (+ chiều) với vị thế trong ACCOUNT_UPDATE
+func (h *WebSocketHub) applyAccountPosition(userID uint, p *FuturesPositionUpdate) {
+	query := h.DB.Where("user_id = ? AND symbol = ? AND status <> ?", userID, p.Symbol, PositionStatusClosed)
+	if side := positionSideOf(p.PositionSide, p.PositionAmt); side != "" {
+		query = query.Where("side = ?", side)
+	}
+	var positions []models.Position
+	if err := query.Find(&positions).Error; err != nil {
+		log.Printf("Failed to load positions for %s: %v", p.Symbol, err)
+		return
+	}
+
+	info := &FuturesPositionInfo{
+		Symbol:           p.Symbol,
+		PositionAmt:      p.PositionAmt,
+		EntryPrice:       p.EntryPrice,
+		UnrealizedProfit: p.UnrealizedPnL,
+		MarginType:       strings.ToLower(p.MarginType),
+		IsolatedMargin:   p.IsolatedWallet,
+		PositionSide:     p.PositionSide,
+	}
+	for i := range positions {
+		if err := ApplyExchangePosition(h.DB, &positions[i], info); err != nil {
+			log.Printf("Failed to update position %d: %v", positions[i].ID, err)
+		}
+	}
+}
+
+// recordPositionFill lưu fill (ORDER_TRADE_UPDATE) vào vị thế và tính lại realized PnL / phí
+func (h *WebSocketHub) recordPositionFill(positionID, orderID *uint, u *FuturesOrderUpdate) {
+	if positionID == nil || !u.LastFilledQty.IsPositive() {
+		return
+	}
+	fill := models.PositionFill{
+		PositionID:      *positionID,
+		OrderID:         orderID,
+		TradeID:         u.TradeID,
+		ExchangeOrderID: strconv.FormatInt(u.OrderID, 10),
+		Side:            strings.ToUpper(u.Side),
+		Quantity:        u.LastFilledQty,
+		Price:           u.LastFilledPrice,
+		RealizedPnL:     u.RealizedProfit,
+		Commission:      u.Commission,
+		CommissionAsset: u.CommissionAsset,
+		FilledAt:        time.UnixMilli(u.TradeTime),
+	}
+	if err := RecordPositionFills(h.DB, []models.PositionFill{fill}); err != nil {
+		log.Printf("Failed to record fill %d of position %d: %v", u.TradeID, *positionID, err)
+		return
+	}
+	if err := RefreshPositionTotals(h.DB, *positionID); err != nil {
+		log.Printf("Failed to refresh position %d: %v", *positionID, err)
+	}
+}
+
+// applyFuturesMarginCall ghi cảnh báo margin call vào system log
+func (h *WebSocketHub) applyFuturesMarginCall(exchConn *ExchangeConnection, mc *FuturesMarginCall) {
+	symbols := make([]string, 0, len(mc.Positions))
+	for _, p := range mc.Positions {
+		symbols = append(symbols, fmt.Sprintf("%s %s (mark %s, maint. margin %s)", p.Symbol, p.PositionSide, p.MarkPrice, p.MaintenanceMargin))
+	}
+	utils.CreateSystemLog(h.DB, exchConn.UserID, utils.LogLevelWarning, "FUTURES_MARGIN_CALL",
+		fmt.Sprintf("Margin call: %s", strings.Join(symbols, ", ")),
+		map[string]interface{}{"cross_wallet_balance": mc.CrossWalletBalance, "positions": mc.Positions})
+}
+
+// applyFuturesAlgoUpdate ghi log khi SL/TP algo của lệnh kích hoạt hoặc bị huỷ/hết hạn (vị thế mất bảo vệ)
+func (h *WebSocketHub) applyFuturesAlgoUpdate(exchConn *ExchangeConnection, u *FuturesAlgoUpdate) {
+	algoID := strconv.FormatInt(u.AlgoID, 10)
+	var order models.Order
+	err := h.DB.Where("user_id = ? AND symbol = ? AND (algo_id_stop_loss = ? OR algo_id_take_profit = ?)",
+		exchConn.UserID, u.Symbol, algoID, algoID).First(&order).Error
+	if err != nil {
+		return
+	}
+
+	kind := "take profit"
+	if order.AlgoIDStopLoss == algoID {
+		kind = "stop loss"
+	}
+	options := map[string]interface{}{
+		"order_id": order.ID, "symbol": u.Symbol, "algo_id": algoID, "algo_status": u.Status, "trigger_price": u.TriggerPrice,
+	}
+
+	switch strings.ToUpper(u.Status) {
+	case "TRIGGERED", "FINISHED":
+		utils.CreateSystemLog(h.DB, exchConn.UserID, utils.LogLevelInfo, "FUTURES_ALGO_TRIGGERED",
+			fmt.Sprintf("%s %s of order #%d triggered at %s", u.Symbol, kind, order.ID, u.TriggerPrice), options)
+	case "CANCELED", "EXPIRED", "REJECTED":
+		if strings.EqualFold(order.Status, "closed") {
+			return // Vị thế đã đóng: huỷ SL/TP còn lại là bình thường
+		}
+		utils.CreateSystemLog(h.DB, exchConn.UserID, utils.LogLevelWarning, "FUTURES_ALGO_CANCELLED",
+			fmt.Sprintf("%s %s of open order #%d is %s: position may be unprotected", u.Symbol, kind, order.ID, strings.ToLower(u.Status)), options)
+	}
+}
+
+// openFuturesOrders là query các lệnh futures còn giữ vị thế của user + symbol (+ chiều vị thế)
+func (h *WebSocketHub) openFuturesOrders(userID uint, symbol, positionSide string) *gorm.DB {
+	query := h.DB.Model(&models.Order{}).
+		Where("user_id = ? AND symbol = ? AND LOWER(trading_mode) IN (?, ?)", userID, symbol, "futures", "future").
+		Where("LOWER(status) NOT IN ?", terminalOrderStatuses)
+	switch positionSide {
+	case "LONG":
+		query = query.Where("UPPER(side) IN (?, ?)", "BUY", "LONG")
+	case "SHORT":
+		query = query.Where("UPPER(side) IN (?, ?)", "SELL", "SHORT")
+	}
+	return query
+}
+
+// findFuturesEntryOrder tìm lệnh vào đang mở (hoặc vừa đóng) mà fill giảm vị thế thuộc về
+func (h *WebSocketHub) findFuturesEntryOrder(userID uint, symbol, positionSide string) (models.Order, bool) {
+	var order models.Order
+	if err := h.openFuturesOrders(userID, symbol, positionSide).Order("created_at DESC").First(&order).Error; err == nil {
+		return order, true
+	}
+
+	query := h.DB.Where("user_id = ? AND symbol = ? AND LOWER(trading_mode) IN (?, ?) AND LOWER(status) = ? AND updated_at > ?",
+		userID, symbol, "futures", "future", "closed", time.Now().Add(-recentCloseWindow))
+	switch positionSide {
+	case "LONG":
+		query = query.Where("UPPER(side) IN (?, ?)", "BUY", "LONG")
+	case "SHORT":
+		query = query.Where("UPPER(side) IN (?, ?)", "SELL", "SHORT")
+	}
+	if err := query.Order("updated_at DESC").First(&order).Error; err == nil {
+		return order, true
+	}
+	return order, false
+}
+
+// closingPositionSide: lệnh SELL giảm vị thế LONG và ngược lại (hedge mode dùng ps trực tiếp)
+func closingPositionSide(side, positionSide string) string {
+	if ps := strings.ToUpper(positionSide); ps == "LONG" || ps == "SHORT" {
+		return ps
+	}
+	if strings.EqualFold(side, "SELL") {
+		return "LONG"
+	}
+	return "SHORT"
+}
+
+// positionSideOf trả về LONG/SHORT của vị thế; one-way mode (BOTH) khi vị thế = 0 thì không xác định được → ""
+func positionSideOf(positionSide string, amount decimal.Decimal) string {
+	if ps := strings.ToUpper(positionSide); ps == "LONG" || ps == "SHORT" {
+		return ps
+	}
+	switch amount.Sign() {
+	case 1:
+		return "LONG"
+	case -1:
+		return "SHORT"
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	// WebhookSignatureHeader chứa HMAC-SHA256 (hex) của raw body, có thể có tiền tố "sha256="
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// DefaultWebhookRotationGrace là thời gian prefix cũ còn hiệu lực sau khi rotate
+	DefaultWebhookRotationGrace = 24 * time.Hour
+
+	webhookFailureLimit       = 10
+	webhookFailureWindow      = 15 * time.Minute
+	webhookFailureRedisPrefix = "tradercoin:webhook_fail:"
+)
+
+// Reasons a webhook request is rejected
+var (
+	ErrWebhookUnknownPrefix   = errors.New("unknown or inactive webhook prefix")
+	ErrWebhookMissingSecret   = errors.New("missing passphrase or signature")
+	ErrWebhookInvalidSecret   = errors.New("invalid passphrase or signature")
+	ErrWebhookNotConfigured   = errors.New("webhook secret not configured")
+	ErrWebhookTooManyFailures = errors.New("too many failed webhook attempts")
+)
+
+// GenerateWebhookSecret returns a random 32-byte hex secret
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// FindActiveWebhookPrefix returns an active, non-expired webhook prefix (nil if none)
+func FindActiveWebhookPrefix(db *gorm.DB, prefix string) (*models.WebhookPrefix, error) {
+	var wp models.WebhookPrefix
+	err := db.Where("prefix = ? AND active = ?", strings.ToLower(prefix), true).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&wp).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &wp, nil
+}
+
+// CurrentWebhookPrefix follows replaced_by of rotated prefixes and returns the prefix now in use.
+// Routing rules / bot auto-execute được chuyển sang prefix mới khi rotate, nên signal tới prefix cũ
+// trong grace period phải được route theo prefix mới.
+func CurrentWebhookPrefix(db *gorm.DB, prefix string) string {
+	current := strings.ToLower(prefix)
+	for i := 0; i < 10; i++ { // Giới hạn số lần rotate nối tiếp, tránh vòng lặp nếu dữ liệu lỗi
+		var wp models.WebhookPrefix
+		if err := db.Select("replaced_by").Where("prefix = ?", current).First(&wp).Error; err != nil || wp.ReplacedBy == "" {
+			return current
+		}
+		current = wp.ReplacedBy
+	}
+	return current
+}
+
+// VerifyWebhookSecret checks a passphrase (in body) or HMAC signature header against the secret
+func VerifyWebhookSecret(secret string, rawBody []byte, signature, passphrase string) error {
+	if passphrase == "" && signature == "" {
+		return ErrWebhookMissingSecret
+	}
+
+	if passphrase != "" && subtle.ConstantTimeCompare([]byte(passphrase), []byte(secret)) == 1 {
+		return nil
+	}
+
+	if signature != "" {
+		expected, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))
+		if err == nil {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(rawBody)
+			if hmac.Equal(expected, mac.Sum(nil)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrWebhookInvalidSecret
+}
+
+// VerifyWebhookPrefix checks the request against the prefix secret.
+// Prefix cũ chưa có secret bị từ chối cho tới khi user rotate để lấy secret (has_secret=false trên UI).
+func VerifyWebhookPrefix(wp *models.WebhookPrefix, rawBody []byte, signature, passphrase string) error {
+	if wp.Secret == "" {
+		utils.LogWarn("⚠️  Webhook prefix " + wp.Prefix + " has no secret - rejecting until it is rotated")
+		return ErrWebhookNotConfigured
+	}
+
+	secret, err := utils.DecryptString(wp.Secret)
+	if err != nil {
+		log.Printf("❌ Failed to decrypt secret of webhook prefix %s: %v", wp.Prefix, err)
+		return ErrWebhookInvalidSecret
+	}
+	return VerifyWebhookSecret(secret, rawBody, signature, passphrase)
+}
+
+// WebhookFailureKey là key đếm lỗi xác thực: theo prefix + IP, vì TradingView gửi alert của mọi user
+// từ vài IP dùng chung (đếm theo IP thì 1 user sai secret sẽ chặn webhook của tất cả user)
+func WebhookFailureKey(prefix, ip string) string {
+	return strings.ToLower(prefix) + "|" + ip
+}
+
+// RecordWebhookRejection lưu request bị từ chối và tăng bộ đếm lỗi của prefix + IP.
+// Chỉ sai/thiếu secret mới bị đếm; prefix không tồn tại và IP ngoài allowlist không đếm.
+func RecordWebhookRejection(db *gorm.DB, wp *models.WebhookPrefix, prefix, ip, userAgent string, reason error) {
+	rejection := models.WebhookRejection{
+		Prefix:    prefix,
+		IPAddress: ip,
+		UserAgent: truncateString(userAgent, 255),
+		Reason:    reason.Error(),
+		CreatedAt: time.Now(),
+	}
+	if wp != nil {
+		userID := wp.UserID
+		rejection.UserID = &userID
+	}
+	if err := db.Create(&rejection).Error; err != nil {
+		log.Printf("⚠️  Failed to record webhook rejection: %v", err)
+	}
+
+	if errors.Is(reason, ErrWebhookMissingSecret) || errors.Is(reason, ErrWebhookInvalidSecret) {
+		WebhookFailures().RecordFailure(WebhookFailureKey(prefix, ip))
+	}
+}
+
+// WebhookFailureLimiter blocks a key (prefix + IP) after too many failed webhook authentications.
+// Dùng Redis nếu có (nhiều instance dùng chung bộ đếm), ngược lại đếm trong process.
+type WebhookFailureLimiter struct {
+	redis *redis.Client
+
+	mu    sync.Mutex
+	local map[string]*failureWindow
+}
+
+type failureWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+var (
+	webhookFailureLimiter     *WebhookFailureLimiter
+	webhookFailureLimiterOnce sync.Once
+)
+
+// InitWebhookFailureLimiter khởi tạo limiter dùng chung (gọi 1 lần trong main sau khi có Redis)
+func InitWebhookFailureLimiter(redisClient *redis.Client) *WebhookFailureLimiter {
+	webhookFailureLimiterOnce.Do(func() {
+		webhookFailureLimiter = &WebhookFailureLimiter{
+			redis: redisClient,
+			local: make(map[string]*failureWindow),
+		}
+	})
+	return webhookFailureLimiter
+}
+
+// WebhookFailures returns the shared limiter (in-process only if InitWebhookFailureLimiter was not called)
+func WebhookFailures() *WebhookFailureLimiter {
+	return InitWebhookFailureLimiter(nil)
+}
+
+// IsBlocked returns true if the key exceeded the failure limit in the current window
+func (l *WebhookFailureLimiter) IsBlocked(key string) bool {
+	if l.redis != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		count, err := l.redis.Get(ctx, webhookFailureRedisPrefix+key).Int()
+		if err == nil {
+			return count >= webhookFailureLimit
+		}
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("⚠️  Redis webhook limiter unavailable, using local counter: %v", err)
+		} else {
+			return false
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	window, ok := l.local[key]
+	if !ok || time.Now().After(window.resetAt) {
+		return false
+	}
+	return window.count >= webhookFailureLimit
+}
+
+// RecordFailure increments the failure counter of a key
+func (l *WebhookFailureLimiter) RecordFailure(key string) {
+	if l.redis != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		redisKey := webhookFailureRedisPrefix + key
+		// SETNX (kèm TTL) + INCR trong 1 MULTI: key luôn có TTL, không bị chặn vĩnh viễn nếu lệnh EXPIRE riêng lỗi
+		_, err := l.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetNX(ctx, redisKey, 0, webhookFailureWindow)
+			pipe.Incr(ctx, redisKey)
+			return nil
+		})
+		if err == nil {
+			return
+		}
+		log.Printf("⚠️  Redis webhook limiter unavailable, using local counter: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	window, ok := l.local[key]
+	if !ok || now.After(window.resetAt) {
+		window = &failureWindow{resetAt: now.Add(webhookFailureWindow)}
+		l.local[key] = window
+	}
+	window.count++
+
+	// Dọn các window đã hết hạn để map không phình ra
+	if len(l.local) > 10000 {
+		for k, w := range l.local {
+			if now.After(w.resetAt) {
+				delete(l.local, k)
+			}
+		}
+	}
+}
+
+func truncateString(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+	"tradercoin/backend/models"
+	"tradercoin/backend/utils"
+)
+
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSecret(t *testing.T) {
+	const secret = "s3cret"
+	body := []byte(`{"symbol":"BTCUSDT","action":"buy"}`)
+
+	tests := []struct {
+		name       string
+		signature  string
+		passphrase string
+		wantErr    error
+	}{
+		{"valid passphrase", "", secret, nil},
+		{"valid signature", signBody(secret, body), "", nil},
+		{"valid signature with sha256= prefix", "sha256=" + signBody(secret, body), "", nil},
+		{"wrong passphrase, valid signature", signBody(secret, body), "wrong", nil},
+		{"nothing provided", "", "", ErrWebhookMissingSecret},
+		{"wrong passphrase", "", "wrong", ErrWebhookInvalidSecret},
+		{"signature of another body", signBody(secret, []byte(`{}`)), "", ErrWebhookInvalidSecret},
+		{"signature with another secret", signBody("other", body), "", ErrWebhookInvalidSecret},
+		{"signature not hex", "zz", "", ErrWebhookInvalidSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhookSecret(secret, body, tt.signature, tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyWebhookSecret() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookPrefix(t *testing.T) {
+	utils.InitEncryptionKey("test-encryption-key")
+	encrypted, err := utils.EncryptString("s3cret")
+	if err != nil {
+		t.Fatalf("failed to encrypt secret: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		secret     string
+		passphrase string
+		wantErr    error
+	}{
+		{"valid passphrase", encrypted, "s3cret", nil},
+		{"wrong passphrase", encrypted, "wrong", ErrWebhookInvalidSecret},
+		{"prefix without secret", "", "s3cret", ErrWebhookNotConfigured},
+		{"secret not decryptable", "not-encrypted", "not-encrypted", ErrWebhookInvalidSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wp := &models.WebhookPrefix{Prefix: "abc", Secret: tt.secret}
+			if err := VerifyWebhookPrefix(wp, nil, "", tt.passphrase); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyWebhookPrefix() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookFailureLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int
+		checkKey    string
+		wantBlocked bool
+	}{
+		{"below limit", webhookFailureLimit - 1, WebhookFailureKey("abc", "1.2.3.4"), false},
+		{"at limit", webhookFailureLimit, WebhookFailureKey("abc", "1.2.3.4"), true},
+		{"prefix key is case insensitive", webhookFailureLimit, WebhookFailureKey("ABC", "1.2.3.4"), true},
+		{"other prefix on the same IP", webhookFailureLimit, WebhookFailureKey("def", "1.2.3.4"), false},
+		{"same prefix from another IP", webhookFailureLimit, WebhookFailureKey("abc", "5.6.7.8"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &WebhookFailureLimiter{local: make(map[string]*failureWindow)}
+			for i := 0; i < tt.failures; i++ {
+				l.RecordFailure(WebhookFailureKey("abc", "1.2.3.4"))
+			}
+			if got := l.IsBlocked(tt.checkKey); got != tt.wantBlocked {
+				t.Errorf("IsBlocked(%q) = %v, want %v", tt.checkKey, got, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestWebhookFailureLimiterWindowExpires(t *testing.T) {
+	l := &WebhookFailureLimiter{local: make(map[string]*failureWindow)}
+	key := WebhookFailureKey("abc", "1.2.3.4")
+	for i := 0; i < webhookFailureLimit; i++ {
+		l.RecordFailure(key)
+	}
+	l.local[key].resetAt = time.Now().Add(-time.Second)
+
+	if l.IsBlocked(key) {
+		t.Error("IsBlocked = true after the failure window expired")
+	}
+	l.RecordFailure(key)
+	if got := l.local[key].count; got != 1 {
+		t.Errorf("count after a new window = %d, want 1", got)
+	}
+}
+
+func TestFindActiveWebhookPrefixRotationGrace(t *testing.T) {
+	db := newTestDB(t)
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	prefixes := []models.WebhookPrefix{
+		{UserID: 1, Prefix: "current", Active: true},
+		{UserID: 1, Prefix: "ingrace", Active: true, ExpiresAt: &future, ReplacedBy: "current"},
+		{UserID: 1, Prefix: "expired", Active: true, ExpiresAt: &past, ReplacedBy: "ingrace"},
+		{UserID: 1, Prefix: "inactive", Active: true},
+	}
+	for i := range prefixes {
+		if err := db.Create(&prefixes[i]).Error; err != nil {
+			t.Fatalf("failed to create prefix: %v", err)
+		}
+	}
+	// Active có default:true nên phải update riêng để lưu false
+	db.Model(&models.WebhookPrefix{}).Where("prefix = ?", "inactive").Update("active", false)
+
+	tests := []struct {
+		prefix      string
+		wantFound   bool
+		wantCurrent string
+	}{
+		{"current", true, "current"},
+		{"CURRENT", true, "current"},
+		{"ingrace", true, "current"},
+		{"expired", false, "current"},
+		{"inactive", false, "inactive"},
+		{"unknown", false, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			wp, err := FindActiveWebhookPrefix(db, tt.prefix)
+			if err != nil {
+				t.Fatalf("FindActiveWebhookPrefix() error = %v", err)
+			}
+			if (wp != nil) != tt.wantFound {
+				t.Errorf("FindActiveWebhookPrefix(%q) found = %v, want %v", tt.prefix, wp != nil, tt.wantFound)
+			}
+			if got := CurrentWebhookPrefix(db, tt.prefix); got != tt.wantCurrent {
+				t.Errorf("CurrentWebhookPrefix(%q) = %q, want %q", tt.prefix, got, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+func TestResolveSignalTargetsRotatedPrefix(t *testing.T) {
+	db := newTestDB(t)
+	future := time.Now().Add(time.Hour)
+	db.Create(&models.WebhookPrefix{UserID: 1, Prefix: "newprefix", Active: true})
+	db.Create(&models.WebhookPrefix{UserID: 1, Prefix: "oldprefix", Active: true, ExpiresAt: &future, ReplacedBy: "newprefix"})
+	// Sau khi rotate, rule và bot auto-execute đã chuyển sang prefix mới
+	ruleBot := models.TradingConfig{UserID: 1, Exchange: "binance", Symbol: "BTCUSDT", IsActive: true}
+	autoBot := models.TradingConfig{UserID: 1, Exchange: "binance", Symbol: "BTCUSDT", IsActive: true, AutoExecute: true, AutoExecutePrefix: "newprefix"}
+	db.Create(&ruleBot)
+	db.Create(&autoBot)
+	db.Create(&models.SignalRoutingRule{UserID: 1, Name: "rule", Active: true, MatchPrefix: "newprefix",
+		BotConfigIDs: "[" + fmt.Sprint(ruleBot.ID) + "]"})
+
+	for _, prefix := range []string{"newprefix", "oldprefix"} {
+		t.Run(prefix, func(t *testing.T) {
+			signal := models.TradingSignal{Symbol: "BTCUSDT", Action: "buy", WebhookPrefix: prefix}
+			targets, _, err := ResolveSignalTargets(db, 1, &signal)
+			if err != nil {
+				t.Fatalf("ResolveSignalTargets() error = %v", err)
+			}
+			if len(targets) != 2 {
+				t.Fatalf("%d target(s), want the rule bot and the auto-execute bot", len(targets))
+			}
+			if signal.WebhookPrefix != prefix {
+				t.Errorf("signal prefix changed to %q", signal.WebhookPrefix)
+			}
+		})
+	}
+}
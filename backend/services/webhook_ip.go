@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPAllowlistPresetTradingView mở rộng thành danh sách IP gửi webhook của TradingView
+const IPAllowlistPresetTradingView = "tradingview"
+
+// TradingViewWebhookIPs are the fixed source IPs published by TradingView for webhook alerts
+var TradingViewWebhookIPs = []string{
+	"52.89.214.238",
+	"34.212.75.30",
+	"54.218.53.128",
+	"52.32.178.7",
+}
+
+// ErrWebhookIPNotAllowed is returned when the source IP is not in the allowlist
+var ErrWebhookIPNotAllowed = errors.New("source IP not in allowlist")
+
+// SplitIPAllowlist tách allowlist lưu trong DB (phân cách bởi dấu phẩy, khoảng trắng hoặc xuống dòng)
+func SplitIPAllowlist(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+}
+
+// NormalizeIPAllowlist validates entries (IP, CIDR hoặc preset "tradingview") and returns the stored form
+func NormalizeIPAllowlist(entries []string) (string, error) {
+	normalized := make([]string, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, raw := range entries {
+		for _, entry := range SplitIPAllowlist(raw) {
+			entry = strings.ToLower(strings.TrimSpace(entry))
+			if entry != IPAllowlistPresetTradingView {
+				if _, err := parseAllowlistEntry(entry); err != nil {
+					return "", err
+				}
+			}
+			if !seen[entry] {
+				seen[entry] = true
+				normalized = append(normalized, entry)
+			}
+		}
+	}
+	return strings.Join(normalized, ","), nil
+}
+
+// IsIPAllowed checks an IP against a stored allowlist; empty allowlist = cho phép tất cả
+func IsIPAllowed(list, ip string) bool {
+	entries := SplitIPAllowlist(list)
+	if len(entries) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if strings.EqualFold(entry, IPAllowlistPresetTradingView) {
+			for _, tvIP := range TradingViewWebhookIPs {
+				if addr.Equal(net.ParseIP(tvIP)) {
+					return true
+				}
+			}
+			continue
+		}
+
+		network, err := parseAllowlistEntry(entry)
+		if err != nil {
+			continue
+		}
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowlistEntry parses an IP or CIDR; IP đơn được coi là /32 (IPv4) hoặc /128 (IPv6)
+func parseAllowlistEntry(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
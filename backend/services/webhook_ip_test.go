@@ -0,0 +1,65 @@
+package services
+
+import "testing"
+
+func TestIsIPAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		ip   string
+		want bool
+	}{
+		{"empty list allows all", "", "1.2.3.4", true},
+		{"blank list allows all", " , ", "1.2.3.4", true},
+		{"exact IPv4", "1.2.3.4", "1.2.3.4", true},
+		{"other IPv4", "1.2.3.4", "1.2.3.5", false},
+		{"CIDR match", "10.0.0.0/8", "10.20.30.40", true},
+		{"CIDR miss", "10.0.0.0/8", "11.0.0.1", false},
+		{"second entry", "1.2.3.4, 5.6.7.8", "5.6.7.8", true},
+		{"newline separated", "1.2.3.4\n5.6.7.8", "5.6.7.8", true},
+		{"exact IPv6", "2001:db8::1", "2001:db8::1", true},
+		{"IPv6 CIDR", "2001:db8::/32", "2001:db8:1::5", true},
+		{"IPv4-mapped IPv6", "1.2.3.4", "::ffff:1.2.3.4", true},
+		{"tradingview preset", IPAllowlistPresetTradingView, TradingViewWebhookIPs[0], true},
+		{"tradingview preset case-insensitive", "TradingView", TradingViewWebhookIPs[1], true},
+		{"tradingview preset other IP", IPAllowlistPresetTradingView, "1.2.3.4", false},
+		{"invalid entry skipped", "not-an-ip, 1.2.3.4", "1.2.3.4", true},
+		{"invalid source IP", "1.2.3.4", "unknown", false},
+		{"source IP with spaces", "1.2.3.4", " 1.2.3.4 ", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsIPAllowed(tt.list, tt.ip); got != tt.want {
+				t.Errorf("IsIPAllowed(%q, %q) = %v, want %v", tt.list, tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIPAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    string
+		wantErr bool
+	}{
+		{"empty", nil, "", false},
+		{"dedup and lowercase", []string{"1.2.3.4", "TradingView", "1.2.3.4"}, "1.2.3.4,tradingview", false},
+		{"split entries", []string{"1.2.3.4, 10.0.0.0/8"}, "1.2.3.4,10.0.0.0/8", false},
+		{"invalid IP", []string{"1.2.3"}, "", true},
+		{"invalid CIDR", []string{"10.0.0.0/40"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeIPAllowlist(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeIPAllowlist(%v) error = %v, wantErr %v", tt.entries, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeIPAllowlist(%v) = %q, want %q", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
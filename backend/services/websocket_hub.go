@@ -10,8 +10,10 @@ import (
 	"time"
 
 	"tradercoin/backend/config"
+	"tradercoin/backend/models"
 
 	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -43,6 +45,8 @@ type ExchangeConnection struct {
 	TradingMode   string
 	ListenKey     string
 	Conn          *websocket.Conn
+	AccountKey    string // AccountStreamKey của exchange key (dùng cho order monitor)
+	closed        bool   // Stream đã ngắt (lỗi đọc hoặc đóng)
 
 	// Map of session IDs to user's browser WebSocket connections
 	UserTabs map[string]*websocket.Conn
@@ -102,6 +106,9 @@ type WebSocketHub struct {
 	// Config
 	Config *config.Config
 
+	// userStreams: khi có StreamManager, user data stream do server giữ (không dial theo tab trình duyệt)
+	userStreams UserStreamCoverage
+
 	mu sync.RWMutex
 }
 
@@ -137,6 +144,21 @@ func (h *WebSocketHub) Run() {
 	}
 }
 
+// SetUserStreams hands exchange user data streams over to a server-side manager.
+// Từ đó hub chỉ theo dõi session trình duyệt, không tự mở stream cho từng tab nữa.
+func (h *WebSocketHub) SetUserStreams(c UserStreamCoverage) {
+	h.mu.Lock()
+	h.userStreams = c
+	h.mu.Unlock()
+}
+
+// UsesServerStreams reports whether user data streams are owned by the server-side manager
+func (h *WebSocketHub) UsesServerStreams() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.userStreams != nil
+}
+
 // handleRegister registers a new user connection
 func (h *WebSocketHub) handleRegister(req *RegisterRequest) {
 	log.Printf("Registering user %d, session %s for %s %s (key %d)",
@@ -152,9 +174,15 @@ func (h *WebSocketHub) handleRegister(req *RegisterRequest) {
 		h.UserSessions[req.UserID] = make(map[string]bool)
 	}
 	h.UserSessions[req.UserID][req.SessionID] = true
+	serverStreams := h.userStreams != nil
 
 	h.mu.Unlock()
 
+	// Stream đã do StreamManager giữ: mở thêm kết nối sẽ xử lý trùng fill (commission/PnL cộng dồn)
+	if serverStreams {
+		return
+	}
+
 	// Check if exchange connection exists
 	h.mu.RLock()
 	exchConn, exists := h.ExchangeConns[connKey]
@@ -181,6 +209,7 @@ func (h *WebSocketHub) handleRegister(req *RegisterRequest) {
 			TradingMode:   req.TradingMode,
 			ListenKey:     req.ListenKey,
 			Conn:          conn,
+			AccountKey:    h.accountKeyFor(req),
 			UserTabs:      make(map[string]*websocket.Conn),
 			done:          make(chan bool),
 		}
@@ -207,6 +236,38 @@ func (h *WebSocketHub) handleRegister(req *RegisterRequest) {
 		req.UserID, len(exchConn.UserTabs), connKey)
 }
 
+// accountKeyFor tính AccountStreamKey của exchange key đăng ký (rỗng nếu không tải được key)
+func (h *WebSocketHub) accountKeyFor(req *RegisterRequest) string {
+	var key models.ExchangeKey
+	if err := h.DB.Select("id", "api_key").First(&key, req.ExchangeKeyID).Error; err != nil {
+		return ""
+	}
+	apiKey, _ := exchangeKeyCredentials(&key)
+	return AccountStreamKey(req.Exchange, req.TradingMode, apiKey)
+}
+
+// IsStreamLive reports whether the account has a browser-opened user data stream that is still connected
+// (chỉ dùng khi không có StreamManager).
+func (h *WebSocketHub) IsStreamLive(accountKey string) bool {
+	if accountKey == "" {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, exchConn := range h.ExchangeConns {
+		if exchConn.AccountKey != accountKey || exchConn.Exchange != "binance" {
+			continue
+		}
+		exchConn.mu.RLock()
+		live := !exchConn.closed
+		exchConn.mu.RUnlock()
+		if live {
+			return true
+		}
+	}
+	return false
+}
+
 // handleUnregister unregisters a user connection
 func (h *WebSocketHub) handleUnregister(req *UnregisterRequest) {
 	log.Printf("Unregistering user %d, session %s (key %d)",
@@ -263,10 +324,18 @@ func (h *WebSocketHub) handleBroadcast(msg *BroadcastMessage) {
 		return
 	}
 
+	payload := map[string]interface{}{
+		"type": msg.Type,
+		"data": msg.Data,
+	}
+
 	// Find all exchange connections for this user
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	// Một tab có thể vừa là global tab vừa gắn vào exchange connection: chỉ gửi mỗi conn một lần
+	sent := make(map[*websocket.Conn]bool)
+
 	for _, exchConn := range h.ExchangeConns {
 		if exchConn.UserID != msg.UserID {
 			continue
@@ -274,26 +343,39 @@ func (h *WebSocketHub) handleBroadcast(msg *BroadcastMessage) {
 
 		exchConn.mu.RLock()
 		for sessionID, userConn := range exchConn.UserTabs {
-			if !sessions[sessionID] {
+			if !sessions[sessionID] || userConn == nil || sent[userConn] {
 				continue
 			}
+			sent[userConn] = true
 
-			err := userConn.WriteJSON(map[string]interface{}{
-				"type": msg.Type,
-				"data": msg.Data,
-			})
-			if err != nil {
+			if err := userConn.WriteJSON(payload); err != nil {
 				log.Printf("Failed to send to user %d session %s: %v",
 					msg.UserID, sessionID, err)
 			}
 		}
 		exchConn.mu.RUnlock()
 	}
+
+	// Stream do server giữ không có tab riêng: gửi qua global tabs của user
+	for sessionID, userConn := range h.GlobalUserTabs[msg.UserID] {
+		if userConn == nil || sent[userConn] {
+			continue
+		}
+		sent[userConn] = true
+
+		if err := userConn.WriteJSON(payload); err != nil {
+			log.Printf("Failed to send to user %d global session %s: %v",
+				msg.UserID, sessionID, err)
+		}
+	}
 }
 
 // listenToExchange listens to exchange WebSocket messages
 func (h *WebSocketHub) listenToExchange(exchConn *ExchangeConnection, connKey string) {
 	defer func() {
+		exchConn.mu.Lock()
+		exchConn.closed = true
+		exchConn.mu.Unlock()
 		log.Printf("Stopped listening to %s", connKey)
 	}()
 
@@ -325,6 +407,11 @@ func (h *WebSocketHub) processExchangeMessage(
 
 	switch exchConn.Exchange {
 	case "binance":
+		// Futures stream: order, vị thế, số dư, margin call, algo order → xử lý riêng
+		if eventType := getStringValue(message, "e"); isFuturesUserEvent(eventType) {
+			h.handleBinanceFuturesEvent(exchConn, eventType, message)
+			return
+		}
 		orderUpdate = h.parseBinanceMessage(exchConn, message)
 	case "okx":
 		orderUpdate = h.parseOKXMessage(exchConn, message)
@@ -340,7 +427,7 @@ func (h *WebSocketHub) processExchangeMessage(
 	}
 
 	// Update order in database
-	h.updateOrderInDB(orderUpdate)
+	h.updateOrderInDB(orderUpdate, message)
 
 	// Broadcast to user
 	h.Broadcast <- &BroadcastMessage{
@@ -382,17 +469,20 @@ func (h *WebSocketHub) parseBinanceMessage(
 		UpdateTime:    getInt64Value(message, "E"),
 	}
 
-	// Fetch current market price from Binance API (synchronously)
+	// Giá hiện tại: price cache (market data stream), chỉ gọi REST khi cache chưa có
 	update.CurrentPrice = h.fetchCurrentMarketPrice(symbol, exchConn.TradingMode)
 
 	return update
 }
 
-// fetchCurrentMarketPrice fetches current market price from Binance API
+// fetchCurrentMarketPrice returns the cached market price, falling back to the Binance ticker API
 func (h *WebSocketHub) fetchCurrentMarketPrice(symbol, tradingMode string) float64 {
 	if symbol == "" {
 		return 0
 	}
+	if price, ok := MarketData().Price(tradingMode, symbol); ok {
+		return price.InexactFloat64()
+	}
 
 	// Get Binance config
 	binanceCfg := h.Config.Exchanges.Binance
@@ -447,12 +537,11 @@ func (h *WebSocketHub) fetchCurrentMarketPrice(symbol, tradingMode string) float
 		return 0
 	}
 
-	// Convert price string to float64
-	var price float64
-	fmt.Sscanf(priceData.Price, "%f", &price)
+	price := ParseDecimal(priceData.Price)
 
-	log.Printf("✓ Fetched current market price for %s: %.8f", symbol, price)
-	return price
+	log.Printf("✓ Fetched current market price for %s: %s", symbol, price)
+	MarketData().Observe(tradingMode, symbol, price)
+	return price.InexactFloat64()
 }
 
 // parseOKXMessage parses OKX WebSocket message
@@ -473,36 +562,43 @@ func (h *WebSocketHub) parseBybitMessage(
 	return nil
 }
 
-// updateOrderInDB updates order in database
-func (h *WebSocketHub) updateOrderInDB(update *OrderUpdate) {
-	var order struct {
-		ID             uint
-		Status         string
-		FilledQuantity float64
-		FilledPrice    float64
-		UpdatedAt      time.Time
-	}
-
-	// Find order by exchange order ID
-	result := h.DB.Model(&order).
-		Where("user_id = ? AND exchange_key_id = ? AND order_id = ?",
-			update.UserID, update.ExchangeKeyID, update.OrderID).
-		Updates(map[string]interface{}{
-			"status":          update.Status,
-			"filled_quantity": update.ExecutedQty,
-			"filled_price":    update.ExecutedPrice,
-			"current_price":   update.CurrentPrice,
-			"updated_at":      time.Now(),
-		})
-
-	if result.Error != nil {
-		log.Printf("Failed to update order in DB: %v", result.Error)
+// updateOrderInDB updates order in database (trạng thái qua state machine, payload thô lưu vào OrderEvent)
+func (h *WebSocketHub) updateOrderInDB(update *OrderUpdate, raw map[string]interface{}) {
+	// Find order by exchange order ID (theo symbol, không lọc exchange_key_id: lệnh của bot lưu exchange_key_id = 0
+	// dù dùng chung API key với stream)
+	var order models.Order
+	err := h.DB.Where("user_id = ? AND symbol = ? AND order_id = ?",
+		update.UserID, update.Symbol, update.OrderID).First(&order).Error
+	if err == gorm.ErrRecordNotFound {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load order %s: %v", update.OrderID, err)
 		return
 	}
 
-	if result.RowsAffected > 0 {
+	updates := map[string]interface{}{
+		"filled_quantity": decimal.NewFromFloat(update.ExecutedQty),
+	}
+	if update.ExecutedPrice > 0 {
+		updates["filled_price"] = decimal.NewFromFloat(update.ExecutedPrice)
+	}
+	if update.CurrentPrice > 0 {
+		updates["current_price"] = decimal.NewFromFloat(update.CurrentPrice)
+	}
+	changed, err := ApplyOrderChange(h.DB, &order, OrderChange{
+		Status:  update.Status,
+		Updates: updates,
+		Source:  OrderSourceUserStream,
+		Actor:   OrderActorUser(update.UserID),
+		Payload: raw,
+	})
+	if err != nil {
+		log.Printf("Failed to update order in DB: %v", err)
+	}
+	if changed {
 		log.Printf("Updated order %s: status=%s, filled=%f",
-			update.OrderID, update.Status, update.ExecutedQty)
+			update.OrderID, order.Status, update.ExecutedQty)
 	}
 }
 
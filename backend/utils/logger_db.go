@@ -6,6 +6,7 @@ import (
 	"time"
 	"tradercoin/backend/models"
 
+	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
@@ -32,9 +33,15 @@ func CreateSystemLog(db *gorm.DB, userID uint, level, action, message string, op
 	if price, ok := options["price"].(float64); ok {
 		log.Price = price
 	}
+	if price, ok := options["price"].(decimal.Decimal); ok {
+		log.Price = price.InexactFloat64()
+	}
 	if amount, ok := options["amount"].(float64); ok {
 		log.Amount = amount
 	}
+	if amount, ok := options["amount"].(decimal.Decimal); ok {
+		log.Amount = amount.InexactFloat64()
+	}
 	if ipAddress, ok := options["ip_address"].(string); ok {
 		log.IPAddress = ipAddress
 	}
@@ -0,0 +1,378 @@
+'use client';
+
+import {useState, useEffect} from 'react';
+import {
+  getSignalJobs,
+  redriveSignalJob,
+  getFailedUserSignals,
+  redriveUserSignal,
+  SignalJob,
+  FailedUserSignal,
+} from '@/services/adminService';
+
+export default function SignalJobsPage() {
+  const [jobs, setJobs] = useState<SignalJob[]>([]);
+  const [failedSignals, setFailedSignals] = useState<FailedUserSignal[]>([]);
+  const [stats, setStats] = useState<Record<string, number>>({});
+  const [backend, setBackend] = useState('');
+  const [loading, setLoading] = useState(true);
+  const [filterStatus, setFilterStatus] = useState('dead');
+  const [filterKind, setFilterKind] = useState('all');
+  const [redriving, setRedriving] = useState<string | null>(null);
+
+  useEffect(() => {
+    fetchData();
+  }, [filterStatus, filterKind]);
+
+  const fetchData = async () => {
+    try {
+      setLoading(true);
+      const [jobData, failedData] = await Promise.all([
+        getSignalJobs({
+          status: filterStatus === 'all' ? undefined : filterStatus,
+          kind: filterKind === 'all' ? undefined : filterKind,
+          limit: 100,
+        }),
+        getFailedUserSignals({limit: 100}),
+      ]);
+      setJobs(jobData.jobs || []);
+      setStats(jobData.stats || {});
+      setBackend(jobData.backend || '');
+      setFailedSignals(failedData.user_signals || []);
+    } catch (error) {
+      console.error('Error fetching signal queue:', error);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handleRedriveJob = async (job: SignalJob) => {
+    if (!confirm(`Re-drive ${job.kind} job #${job.id}?`)) return;
+    try {
+      setRedriving(`job-${job.id}`);
+      await redriveSignalJob(job.id);
+      await fetchData();
+    } catch (error: any) {
+      alert(error.response?.data?.error || 'Failed to re-drive job');
+    } finally {
+      setRedriving(null);
+    }
+  };
+
+  const handleRedriveUserSignal = async (userSignal: FailedUserSignal) => {
+    if (
+      !confirm(
+        `Re-drive signal #${userSignal.signal_id} for user #${userSignal.user_id}?`,
+      )
+    )
+      return;
+    try {
+      setRedriving(`us-${userSignal.id}`);
+      await redriveUserSignal(userSignal.id);
+      await fetchData();
+    } catch (error: any) {
+      alert(error.response?.data?.error || 'Failed to re-drive execution');
+    } finally {
+      setRedriving(null);
+    }
+  };
+
+  const getStatusColor = (status: string) => {
+    switch (status) {
+      case 'done':
+        return 'bg-green-100 text-green-700 border border-green-300';
+      case 'pending':
+        return 'bg-yellow-100 text-yellow-700 border border-yellow-300';
+      case 'running':
+        return 'bg-blue-100 text-blue-700 border border-blue-300';
+      case 'dead':
+        return 'bg-red-100 text-red-700 border border-red-300';
+      default:
+        return 'bg-gray-100 text-gray-700 border border-gray-300';
+    }
+  };
+
+  const formatDate = (dateString?: string) => {
+    if (!dateString) return '-';
+    return new Date(dateString).toLocaleString('en-US', {
+      month: 'short',
+      day: 'numeric',
+      hour: '2-digit',
+      minute: '2-digit',
+      second: '2-digit',
+    });
+  };
+
+  if (loading && jobs.length === 0) {
+    return (
+      <div className="flex items-center justify-center min-h-screen">
+        <div className="text-center">
+          <div className="animate-spin rounded-full h-12 w-12 border-b-2 border-orange-400 mx-auto"></div>
+          <p className="mt-4 text-gray-600">Loading signal queue...</p>
+        </div>
+      </div>
+    );
+  }
+
+  return (
+    <div>
+      <div className="mb-8 flex items-start justify-between">
+        <div>
+          <h1 className="text-3xl font-bold text-gray-900 mb-2">
+            Signal Queue
+          </h1>
+          <p className="text-gray-500">
+            Durable signal processing jobs, dead-letter and re-drive
+            {backend && (
+              <span className="ml-2 px-2 py-1 bg-purple-100 text-purple-700 rounded text-xs font-mono">
+                {backend}
+              </span>
+            )}
+          </p>
+        </div>
+        <button
+          onClick={fetchData}
+          className="px-4 py-2 bg-orange-500 text-white rounded-lg hover:bg-orange-600 transition-colors">
+          Refresh
+        </button>
+      </div>
+
+      {/* Stats Cards */}
+      <div className="grid grid-cols-1 md:grid-cols-4 gap-4 mb-6">
+        {[
+          {
+            key: 'pending',
+            label: 'Pending',
+            border: 'border-yellow-500',
+            text: 'text-yellow-600',
+          },
+          {
+            key: 'running',
+            label: 'Running',
+            border: 'border-blue-500',
+            text: 'text-blue-600',
+          },
+          {
+            key: 'done',
+            label: 'Done',
+            border: 'border-green-500',
+            text: 'text-green-600',
+          },
+          {
+            key: 'dead',
+            label: 'Dead-letter',
+            border: 'border-red-500',
+            text: 'text-red-600',
+          },
+        ].map((card) => (
+          <button
+            key={card.key}
+            onClick={() => setFilterStatus(card.key)}
+            className={`text-left bg-white rounded-lg shadow-md hover:shadow-lg transition-shadow p-6 border-t-4 ${card.border}`}>
+            <div className="text-gray-500 text-sm mb-2 font-medium">
+              {card.label}
+            </div>
+            <div className={`text-3xl font-bold ${card.text}`}>
+              {stats[card.key] || 0}
+            </div>
+          </button>
+        ))}
+      </div>
+
+      {/* Filters */}
+      <div className="mb-6 bg-white rounded-lg shadow-md p-6 border-t-4 border-orange-400">
+        <div className="grid grid-cols-1 md:grid-cols-2 gap-4">
+          <select
+            value={filterStatus}
+            onChange={(e) => setFilterStatus(e.target.value)}
+            className="px-4 py-2 bg-white border-2 border-gray-300 rounded-lg text-gray-900 focus:outline-none focus:border-orange-500 transition-colors">
+            <option value="all">All Status</option>
+            <option value="pending">Pending</option>
+            <option value="running">Running</option>
+            <option value="done">Done</option>
+            <option value="dead">Dead-letter</option>
+          </select>
+
+          <select
+            value={filterKind}
+            onChange={(e) => setFilterKind(e.target.value)}
+            className="px-4 py-2 bg-white border-2 border-gray-300 rounded-lg text-gray-900 focus:outline-none focus:border-orange-500 transition-colors">
+            <option value="all">All Kinds</option>
+            <option value="fanout">Fan-out</option>
+            <option value="telegram">Telegram</option>
+            <option value="execute">Execute</option>
+          </select>
+        </div>
+      </div>
+
+      {/* Jobs Table */}
+      <div className="bg-white rounded-lg shadow-md border-t-4 border-orange-400 mb-8">
+        <div className="overflow-x-auto">
+          <table className="w-full table-auto">
+            <thead className="bg-gradient-to-r from-orange-50 to-orange-100">
+              <tr className="border-b-2 border-orange-400">
+                {[
+                  'ID',
+                  'Kind',
+                  'Signal',
+                  'User / Bot',
+                  'Status',
+                  'Attempts',
+                  'Next Run',
+                  'Last Error',
+                  '',
+                ].map((header) => (
+                  <th
+                    key={header}
+                    className="px-4 py-4 text-left text-xs font-medium text-orange-600 uppercase tracking-wider">
+                    {header}
+                  </th>
+                ))}
+              </tr>
+            </thead>
+            <tbody className="divide-y divide-gray-200 bg-white">
+              {jobs.length === 0 ? (
+                <tr>
+                  <td
+                    colSpan={9}
+                    className="px-6 py-8 text-center text-gray-500">
+                    No jobs found
+                  </td>
+                </tr>
+              ) : (
+                jobs.map((job) => (
+                  <tr
+                    key={job.id}
+                    className="hover:bg-orange-50 transition-colors">
+                    <td className="px-4 py-4 text-sm text-gray-900 font-medium">
+                      #{job.id}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-700 font-mono">
+                      {job.kind}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-700">
+                      #{job.signal_id}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-600">
+                      {job.user_id ? `#${job.user_id}` : '-'}
+                      {job.bot_config_id ? ` / bot #${job.bot_config_id}` : ''}
+                    </td>
+                    <td className="px-4 py-4">
+                      <span
+                        className={`px-2 py-1 rounded text-xs font-semibold uppercase ${getStatusColor(
+                          job.status,
+                        )}`}>
+                        {job.status}
+                      </span>
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-700">
+                      {job.attempts}/{job.max_attempts}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-600">
+                      {job.status === 'pending'
+                        ? formatDate(job.next_run_at)
+                        : formatDate(job.completed_at)}
+                    </td>
+                    <td
+                      className="px-4 py-4 text-sm text-red-600 max-w-xs truncate"
+                      title={job.last_error}>
+                      {job.last_error || '-'}
+                    </td>
+                    <td className="px-4 py-4 text-right">
+                      {job.status === 'dead' && (
+                        <button
+                          onClick={() => handleRedriveJob(job)}
+                          disabled={redriving === `job-${job.id}`}
+                          className="px-3 py-1 text-sm bg-orange-500 text-white rounded hover:bg-orange-600 disabled:opacity-50 transition-colors">
+                          Re-drive
+                        </button>
+                      )}
+                    </td>
+                  </tr>
+                ))
+              )}
+            </tbody>
+          </table>
+        </div>
+      </div>
+
+      {/* Failed executions */}
+      <div className="mb-4">
+        <h2 className="text-xl font-bold text-gray-900">Failed Executions</h2>
+        <p className="text-gray-500 text-sm">
+          User signals whose execution failed (manual or auto-execute)
+        </p>
+      </div>
+      <div className="bg-white rounded-lg shadow-md border-t-4 border-red-500">
+        <div className="overflow-x-auto">
+          <table className="w-full table-auto">
+            <thead className="bg-gradient-to-r from-red-50 to-red-100">
+              <tr className="border-b-2 border-red-400">
+                {['ID', 'Signal', 'User', 'Bot', 'Error', 'Failed At', ''].map(
+                  (header) => (
+                    <th
+                      key={header}
+                      className="px-4 py-4 text-left text-xs font-medium text-red-600 uppercase tracking-wider">
+                      {header}
+                    </th>
+                  ),
+                )}
+              </tr>
+            </thead>
+            <tbody className="divide-y divide-gray-200 bg-white">
+              {failedSignals.length === 0 ? (
+                <tr>
+                  <td
+                    colSpan={7}
+                    className="px-6 py-8 text-center text-gray-500">
+                    No failed executions
+                  </td>
+                </tr>
+              ) : (
+                failedSignals.map((us) => (
+                  <tr key={us.id} className="hover:bg-red-50 transition-colors">
+                    <td className="px-4 py-4 text-sm text-gray-900 font-medium">
+                      #{us.id}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-700">
+                      #{us.signal_id}
+                      {us.signal && (
+                        <span className="ml-2 font-semibold uppercase">
+                          {us.signal.action} {us.signal.symbol}
+                        </span>
+                      )}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-600">
+                      #{us.user_id}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-600">
+                      {us.bot_config_id ? `#${us.bot_config_id}` : '-'}
+                    </td>
+                    <td
+                      className="px-4 py-4 text-sm text-red-600 max-w-xs truncate"
+                      title={us.error_msg}>
+                      {us.error_msg || '-'}
+                    </td>
+                    <td className="px-4 py-4 text-sm text-gray-600">
+                      {formatDate(us.executed_at || us.updated_at)}
+                    </td>
+                    <td className="px-4 py-4 text-right">
+                      {us.bot_config_id && (
+                        <button
+                          onClick={() => handleRedriveUserSignal(us)}
+                          disabled={redriving === `us-${us.id}`}
+                          className="px-3 py-1 text-sm bg-orange-500 text-white rounded hover:bg-orange-600 disabled:opacity-50 transition-colors">
+                          Re-drive
+                        </button>
+                      )}
+                    </td>
+                  </tr>
+                ))
+              )}
+            </tbody>
+          </table>
+        </div>
+      </div>
+    </div>
+  );
+}
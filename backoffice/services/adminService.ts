@@ -61,6 +61,38 @@ export interface Signal {
   updated_at: string;
 }
 
+export interface SignalJob {
+  id: number;
+  kind: 'fanout' | 'telegram' | 'execute';
+  dedup_key: string;
+  signal_id: number;
+  user_id?: number;
+  bot_config_id?: number;
+  payload: string;
+  status: 'pending' | 'running' | 'done' | 'dead';
+  attempts: number;
+  max_attempts: number;
+  next_run_at: string;
+  last_error: string;
+  locked_by: string;
+  locked_at?: string;
+  completed_at?: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface FailedUserSignal {
+  id: number;
+  user_id: number;
+  signal_id: number;
+  status: string;
+  bot_config_id?: number;
+  error_msg: string;
+  executed_at?: string;
+  updated_at: string;
+  signal?: Signal;
+}
+
 export interface Transaction {
   id: number;
   user_id: number;
@@ -174,6 +206,44 @@ export const getSignals = async (params?: {
   return response.data;
 };
 
+// Signal queue (dead-letter + re-drive)
+export const getSignalJobs = async (params?: {
+  status?: string;
+  kind?: string;
+  signal_id?: number;
+  limit?: number;
+  offset?: number;
+}) => {
+  const response = await api.get<{
+    jobs: SignalJob[];
+    total: number;
+    stats: Record<string, number>;
+    backend: string;
+  }>('/api/v1/admin/signal-jobs', {params});
+  return response.data;
+};
+
+export const redriveSignalJob = async (id: number) => {
+  const response = await api.post(`/api/v1/admin/signal-jobs/${id}/redrive`);
+  return response.data;
+};
+
+export const getFailedUserSignals = async (params?: {
+  user_id?: number;
+  limit?: number;
+}) => {
+  const response = await api.get<{
+    user_signals: FailedUserSignal[];
+    total: number;
+  }>('/api/v1/admin/user-signals/failed', {params});
+  return response.data;
+};
+
+export const redriveUserSignal = async (id: number) => {
+  const response = await api.post(`/api/v1/admin/user-signals/${id}/redrive`);
+  return response.data;
+};
+
 // Transactions
 export const getTransactions = async (params?: {
   user_id?: number;
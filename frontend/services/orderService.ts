@@ -30,6 +30,20 @@ export interface Order {
   margin_type?: string; // isolated/cross
   isolated_margin?: number;
 
+  // Fills from the futures user data stream
+  realized_pnl?: number;
+  commission?: number;
+  commission_asset?: string;
+
+  // Close accounting (set when the position is closed)
+  funding_fee?: number;
+  exit_price?: number;
+  exit_reason?: string; // 'stop_loss', 'take_profit', 'trailing_stop', 'manual', 'liquidation', 'signal'
+  closed_at?: string;
+
+  // Futures position this order opened / scaled / closed
+  position_id?: number;
+
   created_at: string;
   updated_at: string;
 
@@ -49,6 +63,29 @@ export interface Order {
   };
 }
 
+export interface OrderEvent {
+  id: number;
+  order_id: number;
+  user_id: number;
+  event: string; // 'created', 'status_changed', 'fill'
+  source: string; // 'manual', 'telegram', 'signal', 'order_monitor', 'user_stream', 'system'
+  actor?: string; // 'user:5', 'bot:12'
+  old_status?: string;
+  new_status?: string;
+  filled_qty_delta: number;
+  filled_quantity: number;
+  filled_price: number;
+  message?: string;
+  payload?: string; // Raw exchange payload (JSON)
+  created_at: string;
+}
+
+export interface OrderTimeline {
+  order_id: number;
+  status: string;
+  events: OrderEvent[];
+}
+
 export interface OrderHistoryParams {
   bot_config_id?: number;
   symbol?: string;
@@ -82,6 +119,12 @@ export const getOrder = async (id: number): Promise<Order> => {
   return response.data;
 };
 
+// Get status / fill history of an order
+export const getOrderTimeline = async (id: number): Promise<OrderTimeline> => {
+  const response = await api.get(`/orders/${id}/timeline`);
+  return response.data;
+};
+
 // Get completed orders (filled or closed)
 export const getCompletedOrders = async (
   params?: OrderHistoryParams,
@@ -102,3 +145,27 @@ export const closeOrder = async (
   console.log('✅ closeOrder response:', response.data);
   return response.data;
 };
+
+export interface ProtectionLeg {
+  price?: number; // absolute trigger price, or
+  percent?: number; // percent from entry price, or
+  remove?: boolean; // cancel this leg
+}
+
+export interface AmendProtectionParams {
+  stop_loss?: ProtectionLeg;
+  take_profit?: ProtectionLeg;
+  trailing_stop?: {
+    callback_rate: number; // 0.1 - 10 (%)
+    activation_percent?: number; // percent from entry price, 0 = activate now
+  }; // replaces the stop loss leg
+}
+
+// Amend, trail or remove stop loss / take profit of an open futures order
+export const amendOrderProtection = async (
+  orderId: number,
+  params: AmendProtectionParams,
+): Promise<{order: Order; changes: string[]}> => {
+  const response = await api.put(`/orders/${orderId}/protection`, params);
+  return response.data.result;
+};
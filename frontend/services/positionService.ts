@@ -0,0 +1,98 @@
+import api from './api';
+import {Order} from './orderService';
+
+export interface PositionFill {
+  id: number;
+  position_id: number;
+  order_id?: number;
+  trade_id: number;
+  exchange_order_id: string;
+  side: string; // 'BUY' or 'SELL'
+  quantity: number;
+  price: number;
+  realized_pnl: number;
+  commission: number;
+  commission_asset: string;
+  filled_at: string;
+}
+
+export interface Position {
+  id: number;
+  user_id: number;
+  bot_config_id: number;
+  exchange_key_id: number;
+  exchange: string;
+  symbol: string;
+  trading_mode: string;
+  side: string; // 'LONG' or 'SHORT'
+  status: string; // 'open', 'scaling', 'closing', 'closed'
+  quantity: number;
+  max_quantity: number;
+  entry_price: number; // Average entry price
+  exit_price: number; // Average exit price
+  mark_price: number;
+  liquidation_price: number;
+  leverage: number;
+  margin_type?: string; // isolated/cross
+  isolated_margin: number;
+  unrealized_pnl: number;
+  realized_pnl: number;
+  commission: number;
+  funding_fee: number;
+  exit_reason?: string; // 'stop_loss', 'take_profit', 'trailing_stop', 'manual', 'liquidation', 'signal'
+  opened_at: string;
+  closed_at?: string;
+  created_at: string;
+  updated_at: string;
+
+  // Only returned by getPosition
+  orders?: Order[];
+  fills?: PositionFill[];
+}
+
+export interface PositionListParams {
+  bot_config_id?: number;
+  symbol?: string;
+  status?: string; // a lifecycle status, or 'active' for every non-closed position
+  limit?: number;
+  offset?: number;
+}
+
+// List positions with filters
+export const listPositions = async (
+  params?: PositionListParams,
+): Promise<Position[]> => {
+  const response = await api.get('/positions', {params});
+  return response.data;
+};
+
+// Get single position with its linked orders and fills
+export const getPosition = async (id: number): Promise<Position> => {
+  const response = await api.get(`/positions/${id}`);
+  return response.data;
+};
+
+export interface PartialCloseParams {
+  percent?: number; // 0-100 (exclusive), or
+  quantity?: number; // a fixed quantity below the position size
+  order_type?: 'market' | 'limit'; // default 'market'
+  price?: number; // required for limit orders
+  request_id?: string; // idempotency key, reuse it when retrying
+}
+
+export interface PartialCloseResult {
+  order: Order;
+  position: Position;
+  closed_quantity: number; // 0 for limit orders until they fill
+  remaining_quantity: number;
+  resized_algo_orders?: string[]; // new algo IDs of resized SL/TP orders
+}
+
+// Close part of a position with a reduce-only market or limit order
+export const partialClosePosition = async (
+  id: number,
+  params: PartialCloseParams,
+): Promise<PartialCloseResult> => {
+  const response = await api.post(`/positions/${id}/close`, params);
+  return response.data.result;
+};
@@ -21,6 +21,8 @@ export interface TradingSignal {
   executed_by_user_id?: number; // From user_signals.user_id
   executed_at?: string; // From user_signals
   error_message?: string; // From user_signals.error_msg
+  executed_count?: number; // Số bot đã thực thi signal
+  failed_count?: number; // Số bot thực thi thất bại
   order?: any; // Order details if executed
 }
 
@@ -30,15 +32,35 @@ export interface SignalsResponse {
 }
 
 export interface ExecuteSignalRequest {
-  bot_config_id: number;
+  bot_config_id?: number;
+  bot_config_ids?: number[]; // Execute trên nhiều bot cùng lúc (kết quả riêng từng bot)
   test_mode?: boolean; // 🧪 Enable test mode to bypass PlaceOrder
 }
 
+export interface BotExecutionResult {
+  bot_config_id: number;
+  bot_name: string;
+  exchange: string;
+  success: boolean;
+  action?: string;
+  order?: any;
+  orders?: any[];
+  error?: string;
+  error_code?: string;
+  http_status: number;
+  details?: any;
+}
+
 export interface ExecuteSignalResponse {
-  status: string;
+  status: string; // success | partial | failed (bulk)
   signal: TradingSignal;
-  order: any;
+  order?: any;
   message: string;
+  // Bulk execution (bot_config_ids)
+  results?: BotExecutionResult[];
+  total?: number;
+  succeeded?: number;
+  failed?: number;
 }
 
 export interface CreateSignalPayload {
@@ -52,6 +74,49 @@ export interface CreateSignalPayload {
   strategy?: string;
 }
 
+export interface ShadowStats {
+  signals: number;
+  evaluated: number;
+  pending: number;
+  skipped: number;
+  wins: number;
+  losses: number;
+  win_rate: number;
+  total_pnl_percent: number;
+  avg_pnl_percent: number;
+  avg_r: number;
+  r_trades: number;
+  max_drawdown_percent: number;
+}
+
+export interface SignalGroupStats {
+  key: string; // strategy hoặc webhook prefix ("(none)" nếu trống)
+  signals: number;
+  executed_signals: number;
+  trades: number;
+  open_trades: number;
+  wins: number;
+  losses: number;
+  win_rate: number;
+  total_pnl: number;
+  avg_win: number;
+  avg_loss: number;
+  expectancy: number;
+  avg_r: number;
+  r_trades: number;
+  max_drawdown: number;
+  avg_hold_minutes: number;
+  shadow?: ShadowStats;
+}
+
+export interface SignalAnalyticsReport {
+  since: string;
+  until: string;
+  horizon_hours: number;
+  by_strategy: SignalGroupStats[];
+  by_prefix: SignalGroupStats[];
+}
+
 // List all trading signals
 export const listSignals = async (params?: {
   status?: string;
@@ -65,6 +130,19 @@ export const listSignals = async (params?: {
   return response.data;
 };
 
+// Strategy / webhook prefix performance (shadow = PnL giả định của signal không ai thực thi)
+export const getSignalAnalytics = async (params?: {
+  since_days?: number;
+  prefix?: string;
+  strategy?: string;
+  shadow?: boolean;
+  horizon_hours?: number;
+  shadow_limit?: number;
+}): Promise<SignalAnalyticsReport> => {
+  const response = await api.get('/signals/analytics', {params});
+  return response.data;
+};
+
 // Get single signal
 export const getSignal = async (id: number): Promise<TradingSignal> => {
   const response = await api.get(`/signals/${id}`);
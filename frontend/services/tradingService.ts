@@ -7,6 +7,7 @@ export interface PlaceOrderRequest {
   order_type: 'market' | 'limit';
   amount?: number;
   price?: number;
+  request_id?: string; // idempotency key, reuse it when retrying the same order
 }
 
 export interface PlaceOrderResponse {
@@ -68,7 +69,11 @@ export interface Balance {
 export const placeOrder = async (
   orderData: PlaceOrderRequest,
 ): Promise<PlaceOrderResponse> => {
-  const response = await api.post('/trading/place-order', orderData);
+  // Mỗi lần bấm đặt lệnh là một request_id mới; gửi lại cùng request_id sẽ không đặt lệnh thứ hai
+  const response = await api.post('/trading/place-order', {
+    ...orderData,
+    request_id: orderData.request_id ?? crypto.randomUUID(),
+  });
   return response.data;
 };
 
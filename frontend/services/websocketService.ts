@@ -21,6 +21,11 @@ type OrderUpdate = {
   executed_price: number;
   current_price: number;
   update_time: number;
+  // Sent when stop loss / take profit is amended
+  stop_loss_price?: number;
+  take_profit_price?: number;
+  algo_id_stop_loss?: string;
+  algo_id_take_profit?: string;
 };
 
 type PriceUpdate = {
@@ -31,6 +36,26 @@ type PriceUpdate = {
   update_time: number;
 };
 
+type PositionUpdate = {
+  position_id: number;
+  symbol: string;
+  side: string; // LONG/SHORT
+  status: string; // open, scaling, closing, closed
+  quantity: string;
+  entry_price: string;
+  exit_price: string;
+  mark_price: string;
+  liquidation_price: string;
+  leverage: number;
+  unrealized_pnl: string;
+  realized_pnl: string;
+  commission: string;
+  funding_fee: string;
+  exit_reason: string;
+  bot_config_id: number;
+  timestamp: number;
+};
+
 type MessageHandler = (message: WebSocketMessage) => void;
 
 /**
@@ -386,6 +411,26 @@ class WebSocketService {
     return this.onMessage(handler);
   }
 
+  /**
+   * onPositionUpdate - Subscribe chỉ vào position updates (futures)
+   *
+   * @param {Function} callback - Function nhận PositionUpdate data
+   * @returns {Function} Unsubscribe function
+   *
+   * Backend gửi khi:
+   * - Vị thế mở / nhồi thêm / đóng một phần / đóng
+   * - Đồng bộ PnL, mark price, liquidation price từ sàn
+   */
+  onPositionUpdate(callback: (update: PositionUpdate) => void): () => void {
+    const handler: MessageHandler = (message) => {
+      if (message.type === 'position_update') {
+        callback(message.data as PositionUpdate);
+      }
+    };
+
+    return this.onMessage(handler);
+  }
+
   /**
    * isConnected - Kiểm tra WebSocket có đang connected không
    *
@@ -463,4 +508,4 @@ class WebSocketService {
 const websocketService = new WebSocketService();
 
 export default websocketService;
-export type {OrderUpdate, PriceUpdate, WebSocketMessage};
+export type {OrderUpdate, PositionUpdate, PriceUpdate, WebSocketMessage};